@@ -0,0 +1,398 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+/* adhoc_schedules.go - one-shot and recurring job runs scheduled from chat,
+e.g. "run job deploy at 18:00" or "every weekday at 9 run job report".
+Schedules are stored in the brain, and registered with the cron runner
+whenever jobs are scheduled. Security checks are done when the schedule is
+created; when it runs, availability and authorization are checked again,
+since the user's access may have changed. */
+
+const adhocSchedulesKey = "bot:adhocSchedules"
+
+// adhocSchedule is a job run requested by a user
+type adhocSchedule struct {
+	ID      int
+	User    string
+	Channel string
+	Job     string
+	Args    []string
+	Spec    string    // cron spec for recurring runs
+	At      time.Time // time of a one-shot run
+	Created time.Time
+}
+
+type adhocScheduleList struct {
+	NextID    int
+	Schedules []adhocSchedule
+}
+
+var clockRe = regexp.MustCompile(`^(\d{1,2})(?::(\d\d))?\s*([ap]m)?$`)
+
+// adhocDays maps the days in "every <day> at ..." to cron day-of-week fields
+var adhocDays = map[string]string{
+	"day":       "*",
+	"weekday":   "1-5",
+	"weekend":   "0,6",
+	"sunday":    "0",
+	"monday":    "1",
+	"tuesday":   "2",
+	"wednesday": "3",
+	"thursday":  "4",
+	"friday":    "5",
+	"saturday":  "6",
+}
+
+// onceSchedule is a cron.Schedule that fires once
+type onceSchedule time.Time
+
+// Next returns the scheduled time, or the zero time (never) once it's past
+func (o onceSchedule) Next(t time.Time) time.Time {
+	at := time.Time(o)
+	if at.After(t) {
+		return at
+	}
+	return time.Time{}
+}
+
+// adhocJob is the cron.Job for an ad-hoc schedule
+type adhocJob struct {
+	id       int
+	tasks    taskList
+	repolist map[string]repository
+}
+
+// parseClock parses times like "9", "18:30" or "6pm"
+func parseClock(clock string) (hour, min int, err error) {
+	m := clockRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(clock)))
+	if m == nil {
+		return 0, 0, fmt.Errorf("invalid time '%s'", clock)
+	}
+	hour, _ = strconv.Atoi(m[1])
+	if len(m[2]) > 0 {
+		min, _ = strconv.Atoi(m[2])
+	}
+	if len(m[3]) > 0 {
+		if hour < 1 || hour > 12 {
+			return 0, 0, fmt.Errorf("invalid time '%s'", clock)
+		}
+		hour = hour % 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || min > 59 {
+		return 0, 0, fmt.Errorf("invalid time '%s'", clock)
+	}
+	return hour, min, nil
+}
+
+// scheduleLocation returns the configured TimeZone, or local time
+func scheduleLocation() *time.Location {
+	botCfg.RLock()
+	tz := botCfg.timeZone
+	botCfg.RUnlock()
+	if tz == nil {
+		return time.Local
+	}
+	return tz
+}
+
+// oneShotTime finds the time for a one-shot run at clock, on date if given,
+// otherwise the next time the clock reads that time.
+func oneShotTime(clock, date string) (time.Time, error) {
+	hour, min, err := parseClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := scheduleLocation()
+	now := time.Now().In(loc)
+	if len(date) > 0 {
+		d, err := time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date '%s', use YYYY-MM-DD", date)
+		}
+		at := time.Date(d.Year(), d.Month(), d.Day(), hour, min, 0, 0, loc)
+		if !at.After(now) {
+			return time.Time{}, fmt.Errorf("%s is in the past", at.Format("2006-01-02 15:04 MST"))
+		}
+		return at, nil
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), hour, min, 0, 0, loc)
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+// recurringSpec builds a cron spec for "every <day> at <clock>"
+func recurringSpec(day, clock string) (string, error) {
+	dow, ok := adhocDays[strings.ToLower(day)]
+	if !ok {
+		return "", fmt.Errorf("invalid day '%s'", day)
+	}
+	hour, min, err := parseClock(clock)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("0 %d %d * * %s", min, hour, dow), nil
+}
+
+// getAdhocSchedules reads the ad-hoc schedules from the brain
+func getAdhocSchedules() (adhocScheduleList, RetVal) {
+	var sl adhocScheduleList
+	_, _, ret := checkoutDatum(adhocSchedulesKey, &sl, false)
+	return sl, ret
+}
+
+// modifyAdhocSchedules updates the stored schedules with the modify func,
+// which returns false if nothing should be stored.
+func modifyAdhocSchedules(modify func(*adhocScheduleList) bool) error {
+	var sl adhocScheduleList
+	tok, _, ret := checkoutDatum(adhocSchedulesKey, &sl, true)
+	if ret != Ok {
+		return fmt.Errorf("checking out ad-hoc schedules: %s", ret)
+	}
+	if !modify(&sl) {
+		checkinDatum(adhocSchedulesKey, tok)
+		return nil
+	}
+	if ret := updateDatum(adhocSchedulesKey, tok, sl); ret != Ok {
+		return fmt.Errorf("updating ad-hoc schedules: %s", ret)
+	}
+	return nil
+}
+
+// addAdhocSchedule stores a new schedule and registers it with the cron
+// runner, returning the new schedule's ID.
+func addAdhocSchedule(s adhocSchedule, tasks taskList, repolist map[string]repository) (int, error) {
+	err := modifyAdhocSchedules(func(sl *adhocScheduleList) bool {
+		sl.NextID++
+		s.ID = sl.NextID
+		sl.Schedules = append(sl.Schedules, s)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	schedMutex.Lock()
+	err = registerAdhocSchedule(s, tasks, repolist)
+	schedMutex.Unlock()
+	return s.ID, err
+}
+
+// cancelAdhocSchedule removes a schedule; only the user that created it or
+// an administrator can cancel it.
+func cancelAdhocSchedule(id int, user string, admin bool) (bool, error) {
+	found := false
+	var ferr error
+	err := modifyAdhocSchedules(func(sl *adhocScheduleList) bool {
+		for i, s := range sl.Schedules {
+			if s.ID != id {
+				continue
+			}
+			if s.User != user && !admin {
+				ferr = fmt.Errorf("scheduled run %d belongs to user '%s'", id, s.User)
+				return false
+			}
+			found = true
+			sl.Schedules = append(sl.Schedules[:i], sl.Schedules[i+1:]...)
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return false, err
+	}
+	// Canceled schedules stay in the cron runner until the next reload, but
+	// won't run once they're gone from the brain.
+	return found, ferr
+}
+
+// registerAdhocSchedule adds a schedule to taskRunner; called with schedMutex
+// locked.
+func registerAdhocSchedule(s adhocSchedule, tasks taskList, repolist map[string]repository) error {
+	if taskRunner == nil {
+		return fmt.Errorf("job scheduler isn't running")
+	}
+	job := &adhocJob{s.ID, tasks, repolist}
+	if len(s.Spec) > 0 {
+		sched, err := cron.Parse(s.Spec)
+		if err != nil {
+			return fmt.Errorf("invalid schedule '%s': %v", s.Spec, err)
+		}
+		taskRunner.Schedule(sched, job)
+		return nil
+	}
+	taskRunner.Schedule(onceSchedule(s.At), job)
+	return nil
+}
+
+// registerAdhocSchedules loads schedules from the brain when jobs are
+// scheduled; one-shot runs missed while the robot was down are dropped, and
+// the user is notified. Called with schedMutex locked.
+func registerAdhocSchedules(tasks taskList, repolist map[string]repository) {
+	sl, ret := getAdhocSchedules()
+	if ret != Ok {
+		Log(Warn, "Unable to load ad-hoc schedules (%s), they'll be loaded when the brain is available", ret)
+		return
+	}
+	now := time.Now()
+	var missed []adhocSchedule
+	for _, s := range sl.Schedules {
+		if len(s.Spec) == 0 && !s.At.After(now) {
+			missed = append(missed, s)
+			continue
+		}
+		if err := registerAdhocSchedule(s, tasks, repolist); err != nil {
+			Log(Error, "Registering ad-hoc schedule %d for job '%s': %v", s.ID, s.Job, err)
+			continue
+		}
+		Log(Info, "Scheduled ad-hoc run %d of job '%s' for user '%s'", s.ID, s.Job, s.User)
+	}
+	if len(missed) == 0 {
+		return
+	}
+	// modifying the brain from here could deadlock on the checkout, since
+	// we hold schedMutex
+	go func() {
+		for _, s := range missed {
+			Log(Warn, "Dropping ad-hoc run %d of job '%s' missed at %s", s.ID, s.Job, s.At)
+			removeAdhocSchedule(s.ID)
+			c := &botContext{
+				User:        s.User,
+				Channel:     s.Channel,
+				environment: make(map[string]string),
+			}
+			c.registerActive(nil)
+			c.makeRobot().Reply(fmt.Sprintf("Sorry, I missed your scheduled run of '%s' at %s", s.Job, s.At.In(scheduleLocation()).Format("Mon Jan 2 15:04 MST")))
+			c.deregister()
+		}
+	}()
+}
+
+func removeAdhocSchedule(id int) {
+	err := modifyAdhocSchedules(func(sl *adhocScheduleList) bool {
+		for i, s := range sl.Schedules {
+			if s.ID == id {
+				sl.Schedules = append(sl.Schedules[:i], sl.Schedules[i+1:]...)
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		Log(Error, "Removing ad-hoc schedule %d: %v", id, err)
+	}
+}
+
+// Run is called by the cron runner for an ad-hoc schedule
+func (aj *adhocJob) Run() {
+	sl, ret := getAdhocSchedules()
+	if ret != Ok {
+		Log(Error, "Unable to load ad-hoc schedule %d: %s", aj.id, ret)
+		return
+	}
+	var s *adhocSchedule
+	for i := range sl.Schedules {
+		if sl.Schedules[i].ID == aj.id {
+			s = &sl.Schedules[i]
+			break
+		}
+	}
+	if s == nil {
+		Log(Debug, "Ad-hoc schedule %d was canceled, not running", aj.id)
+		return
+	}
+	if len(s.Spec) == 0 {
+		removeAdhocSchedule(s.ID)
+	}
+	c := &botContext{
+		User:         s.User,
+		Channel:      s.Channel,
+		tasks:        aj.tasks,
+		repositories: aj.repolist,
+		isCommand:    true,
+		environment:  make(map[string]string),
+	}
+	c.registerActive(nil)
+	t := c.jobAvailable(s.Job)
+	if t == nil {
+		c.deregister()
+		return
+	}
+	task, _, _ := getTask(t)
+	r := c.makeRobot()
+	if task.Disabled {
+		r.Say(fmt.Sprintf("Not running scheduled job '%s', it's disabled: %s", s.Job, task.reason))
+		c.deregister()
+		return
+	}
+	if c.checkAuthorization(t, "run") != Success {
+		Log(Audit, "Ad-hoc run %d of job '%s' for user '%s' failed authorization", s.ID, s.Job, s.User)
+		c.deregister()
+		return
+	}
+	c.deregister()
+	Log(Info, "Starting ad-hoc run %d of job '%s' for user '%s'", s.ID, s.Job, s.User)
+	c.verbose = true
+	c.startPipeline(nil, t, jobCmd, "run", s.Args...)
+}
+
+// describe returns a one-line summary of the schedule
+func (s adhocSchedule) describe() string {
+	args := ""
+	if len(s.Args) > 0 {
+		args = " " + strings.Join(s.Args, " ")
+	}
+	when := ""
+	if len(s.Spec) > 0 {
+		when = "cron: " + s.Spec
+	} else {
+		when = "at " + s.At.In(scheduleLocation()).Format("Mon Jan 2 15:04 MST")
+	}
+	return fmt.Sprintf("%d: %s%s (channel: %s) %s", s.ID, s.Job, args, s.Channel, when)
+}
+
+// checkAdhocJob does the same availability, security and argument checks as
+// an interactive 'run job', returning the job arguments if ok. Unlike 'run
+// job', missing arguments can't be prompted for.
+func (c *botContext) checkAdhocJob(jobName, argstr string) ([]string, bool) {
+	t := c.jobAvailable(jobName)
+	if t == nil {
+		return nil, false
+	}
+	r := c.makeRobot()
+	task, _, job := getTask(t)
+	if task.Disabled {
+		r.Say(fmt.Sprintf("Job '%s' is disabled: %s", jobName, task.reason))
+		return nil, false
+	}
+	if !c.jobSecurityCheck(t, "run") {
+		return nil, false
+	}
+	var args []string
+	if len(argstr) > 0 {
+		args = strings.Split(argstr, " ")
+	}
+	if len(args) != len(job.Arguments) {
+		r.Say(fmt.Sprintf("Wrong number of arguments for job '%s', %d configured but %d given", jobName, len(job.Arguments), len(args)))
+		return nil, false
+	}
+	for i, arg := range args {
+		if !job.Arguments[i].re.MatchString(arg) {
+			r.Say(fmt.Sprintf("'%s' doesn't match the pattern for argument '%s'", arg, job.Arguments[i].Label))
+			return nil, false
+		}
+	}
+	return args, true
+}
@@ -31,7 +31,8 @@ var random *rand.Rand
 var connectors = make(map[string]func(Handler, *log.Logger) Connector)
 
 // RegisterConnector should be called in an init function to register a type
-// of connector. Currently only Slack is implemented.
+// of connector; the robot runs the connector for the Protocol, and for any
+// SecondaryProtocols.
 func RegisterConnector(name string, connstarter func(Handler, *log.Logger) Connector) {
 	if stopRegistrations {
 		return
@@ -63,6 +64,9 @@ var botCfg struct {
 	brainProvider        string          // Type of Brain provider to use
 	brain                SimpleBrain     // Interface for robot to Store and Retrieve data
 	encryptionKey        string          // Key for encrypting data (unlocks "real" key in brain)
+	brainBackupDir       string          // Directory for brain backup archives
+	brainBackupSchedule  string          // Schedule for automatic brain backups
+	memoryVersions       int             // How many versions of each memory to keep
 	historyProvider      string          // Name of the history provider to use
 	history              HistoryProvider // Provider for storing and retrieving job / plugin histories
 	workSpace            string          // Read/Write directory where the robot does work
@@ -72,6 +76,12 @@ var botCfg struct {
 	externalJobs         []ExternalTask  // List of external jobs to load
 	externalTasks        []ExternalTask  // List of external tasks to load
 	ScheduledJobs        []ScheduledTask // List of scheduled tasks
+	calendars            calendarSet     // Blackout calendars for jobs
+	webhooks             webhookSet      // Configured Webhooks
+	webhookPort          string          // Address to listen on for Webhooks
+	webhookCertFile      string          // TLS certificate for Webhooks
+	webhookKeyFile       string          // TLS key for Webhooks
+	scheduleErrors       []string        // Invalid ScheduledJobs from the last config load
 	port                 string          // Localhost port to listen on
 	stop                 chan struct{}   // stop channel for stopping the connector
 	done                 chan struct{}   // channel closed when robot finishes shutting down
@@ -140,6 +150,9 @@ func initBot(cpath, epath string, logger *log.Logger) {
 			http.Handle("/json", h)
 			Log(Fatal, "error serving '/json': %s", http.ListenAndServe(botCfg.port, nil))
 		}()
+		if len(botCfg.webhookPort) > 0 {
+			go serveWebhooks(botCfg.webhookPort, botCfg.webhookCertFile, botCfg.webhookKeyFile)
+		}
 	}
 }
 
@@ -174,7 +187,9 @@ func run() <-chan struct{} {
 	for _, channel := range cl {
 		if _, ok := jc[channel]; !ok {
 			jc[channel] = true
-			botCfg.JoinChannel(channel)
+			// channels on secondary protocols are "protocol:channel"
+			conn, ch := connectorFor(channel)
+			conn.JoinChannel(ch)
 		}
 	}
 
@@ -208,11 +223,13 @@ func run() <-chan struct{} {
 		}
 	}()
 
-	// connector loop
+	// connector loops; the robot is done when they've all stopped
 	botCfg.RLock()
 	go func(conn Connector, stop <-chan struct{}, done chan<- struct{}) {
 		privCheck("connector loop")
+		secondary := runConnections(stop)
 		conn.Run(stop)
+		secondary.Wait()
 		close(done)
 	}(botCfg.Connector, botCfg.stop, botCfg.done)
 	botCfg.RUnlock()
@@ -82,7 +82,7 @@ func (c *botContext) registerActive(parent *botContext) {
 		if idRegex.MatchString(c.User) {
 			c.ProtocolUser = c.User
 		} else if ui, ok := c.maps.user[c.User]; ok {
-			c.ProtocolUser = bracket(ui.UserID)
+			c.ProtocolUser = ui.protocolID()
 			c.BotUser = ui.BotUser
 		} else {
 			c.ProtocolUser = c.User
@@ -92,7 +92,7 @@ func (c *botContext) registerActive(parent *botContext) {
 		if idRegex.MatchString(c.Channel) {
 			c.ProtocolChannel = c.Channel
 		} else if ci, ok := c.maps.channel[c.Channel]; ok {
-			c.ProtocolChannel = bracket(ci.ChannelID)
+			c.ProtocolChannel = ci.protocolID()
 		} else {
 			c.ProtocolChannel = c.Channel
 		}
@@ -142,10 +142,20 @@ func (c *botContext) makeRobot() *Robot {
 		Format:          c.Format,
 		Protocol:        c.Protocol,
 		Incoming:        c.Incoming,
+		ThreadID:        c.threadID(),
+		threadChannel:   c.Channel,
 		id:              c.id,
 	}
 }
 
+// threadID returns the thread the incoming message was posted in, if any
+func (c *botContext) threadID() string {
+	if c.Incoming == nil || c.directMsg {
+		return ""
+	}
+	return c.Incoming.ThreadID
+}
+
 // clone() is a convenience function to clone the current context before
 // starting a new goroutine for startPipeline. Used by e.g. triggered jobs,
 // SpawnJob(), and runPipeline for sub-jobs.
@@ -212,6 +222,7 @@ type botContext struct {
 	jobChannel     string       // channel where job updates are posted
 	nsExtension    string       // extended namespace
 	runIndex       int          // run number of a job
+	runStarted     chan<- int   // if set, receives the runIndex when the job starts
 	verbose        bool         // flag if initializing job was verbose
 	nextTasks      []TaskSpec   // tasks in the pipeline
 	finalTasks     []TaskSpec   // clean-up tasks that always run when the pipeline ends
@@ -219,6 +230,9 @@ type botContext struct {
 
 	failedTask, failedTaskDescription string // set when a task fails
 
+	reactTo *ConnectorMessage // command or trigger to react to with job status
+	notice  *jobNotice        // job start message, for updating in place
+
 	history  HistoryProvider // history provider for generating the logger
 	timeZone *time.Location  // for history timestamping
 	logger   HistoryLogger   // where to send stdout / stderr
@@ -25,6 +25,25 @@ type SimpleBrain interface {
 	Retrieve(key string) (blob *[]byte, exists bool, err error)
 }
 
+// ListingBrain is an optional interface for brains that can enumerate all
+// the keys they store. It's required for taking backups of the robot's
+// memories.
+type ListingBrain interface {
+	SimpleBrain
+	// List returns all the keys stored in the brain, or error if the
+	// brain malfunctions.
+	List() (keys []string, err error)
+}
+
+// DeletingBrain is an optional interface for brains that can remove a
+// memory, so expired memories don't linger in storage.
+type DeletingBrain interface {
+	SimpleBrain
+	// Delete removes a memory; deleting a non-existent memory isn't
+	// an error.
+	Delete(key string) error
+}
+
 // Map of registered brains
 var brains = make(map[string]func(Handler, *log.Logger) SimpleBrain)
 
@@ -50,7 +69,10 @@ var encryptBrain bool
 var cryptKey = struct {
 	protected                 *memguard.LockedBuffer
 	key                       []byte // the 'real' key; slice referring to protect buffer
+	previous                  *memguard.LockedBuffer
+	prevKey                   []byte // the previous 'real' key while rotating keys
 	initializing, initialized bool
+	rotating                  bool
 	sync.RWMutex
 }{}
 
@@ -69,6 +91,10 @@ type brainParams struct {
 // is supplied.
 const botEncryptionKey = "bot:encryptionKey"
 
+// The previous 'real' key, wrapped the same way, only kept until a key
+// rotation finishes re-encrypting memories.
+const prevEncryptionKey = "bot:previousEncryptionKey"
+
 const paramKey = "bot:parameters"
 const secretKey = "bot:secrets"
 
@@ -82,6 +108,10 @@ const (
 	checkOutBytes brainOpType = iota
 	checkInBytes
 	updateBytes
+	snapshotMemories
+	restoreMemories
+	reencryptBytes
+	casBytes
 	quit
 )
 
@@ -91,9 +121,11 @@ type brainOp struct {
 }
 
 type checkOutRequest struct {
-	key   string
-	rw    bool
-	reply chan checkOutReply
+	key     string
+	rw      bool
+	timeout time.Duration // lock timeout requested with CheckoutDatumTimeout
+	queued  time.Time     // when the request was made, for lock metrics
+	reply   chan checkOutReply
 }
 
 type checkInRequest struct {
@@ -105,6 +137,8 @@ type updateRequest struct {
 	key   string
 	token string
 	datum *[]byte
+	ttl   time.Duration
+	user  string // set for updates from UpdateDatum, for versioning
 	reply chan RetVal
 }
 
@@ -129,7 +163,8 @@ const (
 
 type memstatus struct {
 	state   memState
-	token   string // whoever has this token owns the lock for this memory
+	token   string    // whoever has this token owns the lock for this memory
+	expires time.Time // for locks with a requested timeout, otherwise zero
 	waiters []checkOutRequest
 }
 
@@ -142,9 +177,11 @@ const memCycle = time.Second
 func replyToWaiter(m *memstatus) {
 	creq := m.waiters[0]
 	m.waiters = m.waiters[1:]
-	lt, d, e, r := getDatum(creq.key, true)
+	recordLockWait(creq.key, creq.queued)
+	lt, d, e, r := retrieveDatum(creq.key, true)
 	m.state = newMemory
 	m.token = lt
+	m.expires = lockExpiry(creq.key, creq.timeout)
 	creq.reply <- checkOutReply{lt, d, e, r}
 }
 
@@ -184,7 +221,19 @@ func initializeEncryption(key string) bool {
 		return false
 	}
 	if exists {
+		// A previous key is only present when a key rotation was interrupted
+		_, pk, pexists, _ := getDatum(prevEncryptionKey, false)
 		cryptKey.Lock()
+		if pexists {
+			cryptKey.previous, err = memguard.NewImmutableFromBytes(*pk)
+			memguard.WipeBytes(*pk)
+			if err == nil {
+				cryptKey.prevKey = cryptKey.previous.Buffer()
+				Log(Warn, "Brain key rotation was interrupted, memories may be encrypted with the previous key; 'rotate brain key' should be run again")
+			} else {
+				Log(Error, "Failed to create protected memory for previous brain key: %v", err)
+			}
+		}
 		cryptKey.protected.Destroy()
 		cryptKey.protected, err = memguard.NewImmutableFromBytes(*rk)
 		memguard.WipeBytes(*rk)
@@ -231,6 +280,12 @@ func reKey(newkey string) bool {
 	return true
 }
 
+func newLockToken() string {
+	ltb := make([]byte, 8)
+	rand.Read(ltb)
+	return fmt.Sprintf("%x", ltb)
+}
+
 // getDatum retrieves a blob of bytes from the brain provider and optionally
 // decrypts it
 func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists bool, ret RetVal) {
@@ -246,9 +301,7 @@ func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists boo
 		return "", nil, false, BrainFailed
 	}
 	if rw { // checked out read/write, generate a lock token
-		ltb := make([]byte, 8)
-		rand.Read(ltb)
-		token = fmt.Sprintf("%x", ltb)
+		token = newLockToken()
 	} else {
 		token = ""
 	}
@@ -266,9 +319,10 @@ func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists boo
 		initialized := cryptKey.initialized
 		initializing := cryptKey.initializing
 		key := cryptKey.key
+		prevKey := cryptKey.prevKey
 		cryptKey.RUnlock()
 		if initializing {
-			if dkey != botEncryptionKey {
+			if dkey != botEncryptionKey && dkey != prevEncryptionKey {
 				Log(Warn, "Retrieve called with uninitialized brain for '%s'", dkey)
 				return "", nil, false, BrainFailed
 			}
@@ -282,6 +336,15 @@ func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists boo
 		}
 		if initialized {
 			decrypted, err = decrypt(*db, key)
+			if err != nil && prevKey != nil {
+				// dual-key reads during key rotation
+				if decrypted, err = decrypt(*db, prevKey); err == nil {
+					Log(Debug, "Re-encrypting '%s' with the new brain key", dkey)
+					db = &decrypted
+					storeDatum(dkey, db)
+					return token, db, true, Ok
+				}
+			}
 			if err != nil {
 				Log(Warn, "Decryption failed for '%s', assuming unencrypted and converting to encrypted", dkey)
 				// Calling storeDatum writes to storage without invalidating the lock token
@@ -345,6 +408,7 @@ func runBrain() {
 	// map key to status
 	memories := make(map[string]*memstatus)
 	processMemories := time.Tick(memCycle)
+	sweepMemories := time.Tick(sweepCycle)
 loop:
 	for {
 		select {
@@ -352,9 +416,12 @@ loop:
 			switch evt.opType {
 			case checkOutBytes:
 				creq := evt.opData.(checkOutRequest)
+				if creq.rw {
+					recordCheckout()
+				}
 				memStat, exists := memories[creq.key]
 				if !exists {
-					lt, d, e, r := getDatum(creq.key, creq.rw)
+					lt, d, e, r := retrieveDatum(creq.key, creq.rw)
 					if r != Ok {
 						creq.reply <- checkOutReply{lt, d, e, r}
 						break
@@ -363,6 +430,7 @@ loop:
 						m := &memstatus{
 							newMemory,
 							lt,
+							lockExpiry(creq.key, creq.timeout),
 							make([]checkOutRequest, 0, 2),
 						}
 						memories[creq.key] = m
@@ -371,15 +439,16 @@ loop:
 					break
 				}
 				if !creq.rw {
-					lt, d, e, r := getDatum(creq.key, creq.rw)
+					lt, d, e, r := retrieveDatum(creq.key, creq.rw)
 					creq.reply <- checkOutReply{lt, d, e, r}
 					break
 				} // read-write request below
 				// if state is available, there are no waiters
 				if memStat.state == available {
-					lt, d, e, r := getDatum(creq.key, creq.rw)
+					lt, d, e, r := retrieveDatum(creq.key, creq.rw)
 					memStat.state = newMemory
 					memStat.token = lt // this memory has a new owner now
+					memStat.expires = lockExpiry(creq.key, creq.timeout)
 					memories[creq.key] = memStat
 					creq.reply <- checkOutReply{lt, d, e, r}
 				} else {
@@ -412,17 +481,39 @@ loop:
 					ur.reply <- DatumLockExpired
 					break
 				}
-				ur.reply <- storeDatum(ur.key, ur.datum)
+				ret := storeDatum(ur.key, ur.datum)
+				if ret == Ok {
+					setExpiration(ur.key, ur.ttl)
+					if len(ur.user) > 0 {
+						recordVersion(ur.key, ur.user, ur.datum)
+					}
+				}
+				ur.reply <- ret
 				if len(m.waiters) > 0 {
 					replyToWaiter(m)
 					break
 				}
 				delete(memories, ur.key)
+			case snapshotMemories:
+				sr := evt.opData.(snapshotRequest)
+				a, err := takeSnapshot()
+				sr.reply <- snapshotReply{a, err}
+			case restoreMemories:
+				rr := evt.opData.(restoreRequest)
+				rr.reply <- restoreSnapshot(rr.archive)
+			case reencryptBytes:
+				rr := evt.opData.(reencryptRequest)
+				rr.reply <- reencryptDatum(rr.key)
+			case casBytes:
+				cr := evt.opData.(casRequest)
+				cr.reply <- compareAndSwap(memories, cr)
 			case quit:
 				qr := evt.opData.(quitRequest)
 				qr.reply <- struct{}{}
 				break loop
 			}
+		case <-sweepMemories:
+			sweepExpired(memories)
 		case <-processMemories:
 			now := time.Now()
 			shortTermMemories.Lock()
@@ -432,12 +523,26 @@ loop:
 				}
 			}
 			shortTermMemories.Unlock()
-			for _, m := range memories {
+			for k, m := range memories {
+				if !m.expires.IsZero() {
+					// lock with a requested timeout
+					if m.state == available || now.Before(m.expires) {
+						continue
+					}
+					if len(m.waiters) > 0 {
+						recordLockExpired(k, len(m.waiters))
+						replyToWaiter(m)
+						continue
+					}
+					m.state = available
+					continue
+				}
 				switch m.state {
 				case newMemory:
 					m.state = seen
 				case seen:
 					if len(m.waiters) > 0 {
+						recordLockExpired(k, len(m.waiters))
 						replyToWaiter(m)
 						break
 					}
@@ -461,12 +566,18 @@ var keyRe = regexp.MustCompile(keyRegex)
 // checkout returns the []byte from the brain, with a lock token granting
 // ownership for a limited time
 func checkout(d string, rw bool) (string, *[]byte, bool, RetVal) {
+	return checkoutTimeout(d, rw, 0)
+}
+
+// checkoutTimeout is checkout with a lock timeout; a timeout of 0 uses the
+// default memCycle expiration.
+func checkoutTimeout(d string, rw bool, timeout time.Duration) (string, *[]byte, bool, RetVal) {
 	if !keyRe.MatchString(d) {
 		Log(Error, "Invalid memory key, ':' disallowed: %s", d)
 		return "", nil, false, InvalidDatumKey
 	}
 	reply := make(chan checkOutReply)
-	creq := checkOutRequest{d, rw, reply}
+	creq := checkOutRequest{d, rw, timeout, time.Now(), reply}
 	brainChanEvents <- brainOp{checkOutBytes, creq}
 	rep := <-reply
 	Log(Trace, "Brain datum checkout for %s, rw: %t - token: %s, exists: %t, ret: %d",
@@ -475,13 +586,15 @@ func checkout(d string, rw bool) (string, *[]byte, bool, RetVal) {
 }
 
 // update sends updated []byte to the brain while holding the lock, or discards
-// the data and returns an error.
-func update(d, lt string, datum *[]byte) (ret RetVal) {
+// the data and returns an error. A positive ttl sets the memory to expire,
+// 0 makes it permanent, and a negative ttl leaves the expiration unchanged.
+// When user is set, the update is recorded in the memory's version history.
+func update(d, lt string, datum *[]byte, ttl time.Duration, user string) (ret RetVal) {
 	if lt == "" {
 		return Ok
 	}
 	reply := make(chan RetVal)
-	ur := updateRequest{d, lt, datum, reply}
+	ur := updateRequest{d, lt, datum, ttl, user, reply}
 	Log(Trace, "Updating datum %s, token: %s", d, lt)
 	brainChanEvents <- brainOp{updateBytes, ur}
 	return <-reply
@@ -500,8 +613,18 @@ func checkinDatum(key, locktoken string) {
 // checkoutDatum is the robot internal version of CheckoutDatum that uses
 // the provided key as-is.
 func checkoutDatum(key string, datum interface{}, rw bool) (locktoken string, exists bool, ret RetVal) {
+	locktoken, _, exists, ret = checkoutDatumFull(key, datum, rw, 0)
+	return
+}
+
+// checkoutDatumFull unmarshals a datum checked out with checkoutTimeout,
+// and also returns the version for UpdateDatumVersion.
+func checkoutDatumFull(key string, datum interface{}, rw bool, timeout time.Duration) (locktoken, version string, exists bool, ret RetVal) {
 	var dbytes *[]byte
-	locktoken, dbytes, exists, ret = checkout(key, rw)
+	locktoken, dbytes, exists, ret = checkoutTimeout(key, rw, timeout)
+	if ret == Ok {
+		version = datumVersion(dbytes)
+	}
 	if exists { // exists = true implies no error
 		err := json.Unmarshal(*dbytes, datum)
 		if err != nil {
@@ -515,12 +638,17 @@ func checkoutDatum(key string, datum interface{}, rw bool) (locktoken string, ex
 
 // updateDatum is the internal version of UpdateDatum that uses the key as-is
 func updateDatum(key, locktoken string, datum interface{}) (ret RetVal) {
+	return updateDatumTTL(key, locktoken, datum, -1, "")
+}
+
+// updateDatumTTL is the internal version of UpdateDatumTTL
+func updateDatumTTL(key, locktoken string, datum interface{}, ttl time.Duration, user string) (ret RetVal) {
 	dbytes, err := json.Marshal(datum)
 	if err != nil {
 		Log(Error, "Marshalling datum %s: %v", key, err)
 		return DataFormatError
 	}
-	return update(key, locktoken, &dbytes)
+	return update(key, locktoken, &dbytes, ttl, user)
 }
 
 // CheckoutDatum gets a datum from the robot's brain and unmarshals it into
@@ -543,6 +671,58 @@ func (r *Robot) CheckoutDatum(key string, datum interface{}, rw bool) (locktoken
 	return checkoutDatum(key, datum, rw)
 }
 
+// CheckoutDatumTimeout checks out a datum read-write like CheckoutDatum,
+// but the lock lasts for up to timeout (max 5 minutes), for plugins that
+// need more time before updating. Other tasks checking out the datum wait
+// until the lock is released or expires.
+func (r *Robot) CheckoutDatumTimeout(key string, datum interface{}, timeout time.Duration) (locktoken string, exists bool, ret RetVal) {
+	if strings.ContainsRune(key, ':') {
+		ret = InvalidDatumKey
+		Log(Error, "Invalid memory key, ':' disallowed: %s", key)
+		return
+	}
+	key = r.datumKey(key)
+	locktoken, _, exists, ret = checkoutDatumFull(key, datum, true, timeout)
+	return
+}
+
+// CheckoutDatumVersion gets a datum without locking it, along with a version
+// string for UpdateDatumVersion. This allows optimistic updates of memories
+// that are read often but rarely updated.
+func (r *Robot) CheckoutDatumVersion(key string, datum interface{}) (version string, exists bool, ret RetVal) {
+	if strings.ContainsRune(key, ':') {
+		ret = InvalidDatumKey
+		Log(Error, "Invalid memory key, ':' disallowed: %s", key)
+		return
+	}
+	key = r.datumKey(key)
+	_, version, exists, ret = checkoutDatumFull(key, datum, false, 0)
+	return
+}
+
+// UpdateDatumVersion stores a datum only if it hasn't changed since it was
+// read with CheckoutDatumVersion, and nobody has it checked out read-write;
+// otherwise it returns DatumChanged, and the plugin should check it out
+// again and retry. The version for a datum that doesn't exist is "".
+func (r *Robot) UpdateDatumVersion(key, version string, datum interface{}) (ret RetVal) {
+	if strings.ContainsRune(key, ':') {
+		Log(Error, "Invalid memory key, ':' disallowed: %s", key)
+		return InvalidDatumKey
+	}
+	key = r.datumKey(key)
+	return updateDatumVersion(key, version, datum, -1, r.User)
+}
+
+// datumKey adds the task namespace to a memory key
+func (r *Robot) datumKey(key string) string {
+	c := r.getContext()
+	task, _, _ := getTask(c.currentTask)
+	if len(c.nsExtension) > 0 {
+		return task.NameSpace + ":" + c.nsExtension + ":" + key
+	}
+	return task.NameSpace + ":" + key
+}
+
 // CheckinDatum unlocks a datum without updating it, it always succeeds
 func (r *Robot) CheckinDatum(key, locktoken string) {
 	if locktoken == "" {
@@ -563,7 +743,7 @@ func (r *Robot) CheckinDatum(key, locktoken string) {
 
 // UpdateDatum tries to update a piece of data in the robot's brain, providing
 // a struct to marshall and a (hopefully good) lock token. If err != nil, the
-// update failed.
+// update failed. A memory with a TTL keeps its expiration.
 func (r *Robot) UpdateDatum(key, locktoken string, datum interface{}) (ret RetVal) {
 	if strings.ContainsRune(key, ':') {
 		Log(Error, "Invalid memory key, ':' disallowed: %s", key)
@@ -576,7 +756,25 @@ func (r *Robot) UpdateDatum(key, locktoken string, datum interface{}) (ret RetVa
 	} else {
 		key = task.NameSpace + ":" + key
 	}
-	return updateDatum(key, locktoken, datum)
+	return updateDatumTTL(key, locktoken, datum, -1, r.User)
+}
+
+// UpdateDatumTTL is UpdateDatum for a memory that expires; after ttl the
+// robot forgets the datum, and CheckoutDatum reports that it doesn't exist.
+// Updating with a ttl of 0 makes the memory permanent again.
+func (r *Robot) UpdateDatumTTL(key, locktoken string, datum interface{}, ttl time.Duration) (ret RetVal) {
+	if strings.ContainsRune(key, ':') {
+		Log(Error, "Invalid memory key, ':' disallowed: %s", key)
+		return InvalidDatumKey
+	}
+	c := r.getContext()
+	task, _, _ := getTask(c.currentTask)
+	if len(c.nsExtension) > 0 {
+		key = task.NameSpace + ":" + c.nsExtension + ":" + key
+	} else {
+		key = task.NameSpace + ":" + key
+	}
+	return updateDatumTTL(key, locktoken, datum, ttl, r.User)
 }
 
 // Remember adds a short-term memory (with no backing store) to the robot's
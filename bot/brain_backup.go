@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* brain_backup.go - taking and restoring consistent snapshots of the robot's
+memories. Snapshots are taken and restored from inside runBrain, so no
+datum can be updated while the brain is being copied. */
+
+// brainArchiveVersion should be incremented whenever the archive format
+// changes in a way older robots can't read.
+const brainArchiveVersion = 1
+
+const backupPrefix = "brain-"
+const backupSuffix = ".json"
+const backupTimeFormat = "20060102-150405"
+
+// brainArchive is the format of a brain backup. Memories are copied exactly
+// as the brain provider stores them, already encrypted with the 'real' key,
+// and the wrapped key itself is included as bot:encryptionKey. An encrypted
+// archive can only be read by a robot with the same EncryptionKey. Backups
+// are only written for encrypted brains.
+type brainArchive struct {
+	Version   int               // brainArchiveVersion when written
+	Created   time.Time         // when the snapshot was taken
+	Encrypted bool              // whether the brain was encrypted when the snapshot was taken
+	Memories  map[string][]byte // raw memories as stored by the brain provider
+}
+
+type snapshotRequest struct {
+	reply chan snapshotReply
+}
+
+type snapshotReply struct {
+	archive *brainArchive
+	err     error
+}
+
+type restoreRequest struct {
+	archive *brainArchive
+	reply   chan error
+}
+
+// listingBrain returns the configured brain if it can enumerate memories
+func listingBrain() (ListingBrain, error) {
+	brain := botCfg.brain
+	if brain == nil {
+		return nil, errors.New("no brain configured")
+	}
+	lb, ok := brain.(ListingBrain)
+	if !ok {
+		return nil, errors.New("the configured brain doesn't support listing memories")
+	}
+	return lb, nil
+}
+
+// takeSnapshot copies every memory in the brain; only called from runBrain.
+func takeSnapshot() (*brainArchive, error) {
+	lb, err := listingBrain()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := lb.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing memories: %v", err)
+	}
+	a := &brainArchive{
+		Version:   brainArchiveVersion,
+		Created:   time.Now().UTC(),
+		Encrypted: encryptBrain,
+		Memories:  make(map[string][]byte),
+	}
+	for _, k := range keys {
+		db, exists, err := lb.Retrieve(k)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving memory '%s': %v", k, err)
+		}
+		if exists {
+			a.Memories[k] = *db
+		}
+	}
+	return a, nil
+}
+
+// restoreSnapshot loads an archive in to an empty brain; only called from
+// runBrain. When the archive replaces the wrapped brain key, the current key
+// is discarded and encryption has to be initialized again.
+func restoreSnapshot(a *brainArchive) error {
+	if a.Version > brainArchiveVersion {
+		return fmt.Errorf("archive version %d is newer than supported version %d", a.Version, brainArchiveVersion)
+	}
+	if a.Encrypted && !encryptBrain {
+		return errors.New("archive is encrypted, but EncryptBrain isn't set")
+	}
+	lb, err := listingBrain()
+	if err != nil {
+		return err
+	}
+	keys, err := lb.List()
+	if err != nil {
+		return fmt.Errorf("listing memories: %v", err)
+	}
+	for _, k := range keys {
+		// A new encrypted brain always has a key, it doesn't count
+		if k != botEncryptionKey {
+			return fmt.Errorf("brain isn't empty, found memory '%s'", k)
+		}
+	}
+	for k, m := range a.Memories {
+		if !keyRe.MatchString(k) {
+			return fmt.Errorf("invalid memory key in archive: '%s'", k)
+		}
+		datum := m
+		if err := lb.Store(k, &datum); err != nil {
+			return fmt.Errorf("storing memory '%s': %v", k, err)
+		}
+	}
+	// reload the restored expiration index on next use
+	expirationsLoaded = false
+	if _, ok := a.Memories[botEncryptionKey]; ok && encryptBrain {
+		resetEncryption()
+	}
+	return nil
+}
+
+// resetEncryption discards the brain key after the wrapped key stored in
+// the brain has been replaced.
+func resetEncryption() {
+	cryptKey.Lock()
+	if cryptKey.protected != nil {
+		cryptKey.protected.Destroy()
+	}
+	cryptKey.protected = nil
+	cryptKey.key = nil
+	if cryptKey.previous != nil {
+		cryptKey.previous.Destroy()
+	}
+	cryptKey.previous = nil
+	cryptKey.prevKey = nil
+	cryptKey.initialized = false
+	cryptKey.initializing = false
+	cryptKey.Unlock()
+}
+
+func backupDirectory() (string, error) {
+	botCfg.RLock()
+	dir := botCfg.brainBackupDir
+	botCfg.RUnlock()
+	if len(dir) == 0 {
+		return "", errors.New("BrainBackupDirectory not configured")
+	}
+	return dir, nil
+}
+
+// backupBrain writes a snapshot of the brain to the backup directory,
+// returning the archive file name. An unencrypted brain isn't backed up,
+// since the archive would hold every memory in plain text.
+func backupBrain() (string, error) {
+	dir, err := backupDirectory()
+	if err != nil {
+		return "", err
+	}
+	if !encryptBrain {
+		return "", errors.New("brain backups require EncryptBrain, so memories aren't archived in plain text")
+	}
+	reply := make(chan snapshotReply)
+	brainChanEvents <- brainOp{snapshotMemories, snapshotRequest{reply}}
+	sr := <-reply
+	if sr.err != nil {
+		return "", sr.err
+	}
+	ab, err := json.Marshal(sr.archive)
+	if err != nil {
+		return "", fmt.Errorf("marshalling brain archive: %v", err)
+	}
+	name := backupPrefix + sr.archive.Created.Format(backupTimeFormat) + backupSuffix
+	if err := ioutil.WriteFile(filepath.Join(dir, name), ab, 0600); err != nil {
+		return "", fmt.Errorf("writing brain archive: %v", err)
+	}
+	Log(Audit, "Wrote brain backup '%s' with %d memories", name, len(sr.archive.Memories))
+	return name, nil
+}
+
+// listBackups returns brain archives in the backup directory, newest first
+func listBackups() ([]string, error) {
+	dir, err := backupDirectory()
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup directory: %v", err)
+	}
+	backups := make([]string, 0, len(files))
+	for _, f := range files {
+		n := f.Name()
+		if f.Mode().IsRegular() && strings.HasPrefix(n, backupPrefix) && strings.HasSuffix(n, backupSuffix) {
+			backups = append(backups, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// restoreBrain loads the named archive from the backup directory. The
+// returned bool is true when the brain key was replaced.
+func restoreBrain(name string) (bool, error) {
+	dir, err := backupDirectory()
+	if err != nil {
+		return false, err
+	}
+	if name != filepath.Base(name) {
+		return false, fmt.Errorf("invalid archive name: %s", name)
+	}
+	ab, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, fmt.Errorf("archive not found: %s", name)
+		}
+		return false, fmt.Errorf("reading brain archive: %v", err)
+	}
+	var a brainArchive
+	if err := json.Unmarshal(ab, &a); err != nil {
+		return false, fmt.Errorf("unmarshalling brain archive: %v", err)
+	}
+	reply := make(chan error)
+	brainChanEvents <- brainOp{restoreMemories, restoreRequest{&a, reply}}
+	if err := <-reply; err != nil {
+		return false, err
+	}
+	_, rekeyed := a.Memories[botEncryptionKey]
+	Log(Audit, "Restored %d memories from brain backup '%s'", len(a.Memories), name)
+	return rekeyed && encryptBrain, nil
+}
@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* brain_locks.go - lock timeouts, lock-wait metrics and compare-and-swap
+updates for long-term memories. */
+
+// Longest lock a plugin can request with CheckoutDatumTimeout
+const maxLockTimeout = 5 * time.Minute
+
+// Lock waits longer than this are logged as warnings
+const slowLockWait = 5 * time.Second
+
+// lockStats are lock metrics collected by runBrain
+var lockStats = struct {
+	checkouts   int           // read/write checkouts
+	waits       int           // checkouts that had to wait for a lock
+	expired     int           // locks taken from a holder for a waiter
+	casFailures int           // compare-and-swap updates that failed
+	totalWait   time.Duration // total time spent waiting for locks
+	maxWait     time.Duration // longest wait for a lock
+	sync.Mutex
+}{}
+
+type casRequest struct {
+	key     string
+	version string
+	datum   *[]byte
+	ttl     time.Duration
+	user    string
+	reply   chan RetVal
+}
+
+// datumVersion identifies the contents of a datum for compare-and-swap; a
+// datum that doesn't exist has version "".
+func datumVersion(db *[]byte) string {
+	if db == nil {
+		return ""
+	}
+	sum := sha256.Sum256(*db)
+	return hex.EncodeToString(sum[:8])
+}
+
+// lockExpiry returns when a lock with the given timeout expires, or the zero
+// time for locks using the default memCycle expiration.
+func lockExpiry(key string, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	if timeout > maxLockTimeout {
+		Log(Warn, "Lock timeout of %v requested for '%s' exceeds maximum, using %v", timeout, key, maxLockTimeout)
+		timeout = maxLockTimeout
+	}
+	return time.Now().Add(timeout)
+}
+
+func recordCheckout() {
+	lockStats.Lock()
+	lockStats.checkouts++
+	lockStats.Unlock()
+}
+
+// recordLockWait updates metrics when a waiter finally gets a lock
+func recordLockWait(key string, queued time.Time) {
+	wait := time.Since(queued)
+	lockStats.Lock()
+	lockStats.waits++
+	lockStats.totalWait += wait
+	if wait > lockStats.maxWait {
+		lockStats.maxWait = wait
+	}
+	lockStats.Unlock()
+	if wait > slowLockWait {
+		Log(Warn, "Waited %v for lock on '%s'", wait, key)
+	} else {
+		Log(Debug, "Waited %v for lock on '%s'", wait, key)
+	}
+}
+
+// recordLockExpired is called when a lock expires with other tasks
+// waiting; the holder will get DatumLockExpired if it tries to update.
+func recordLockExpired(key string, waiters int) {
+	lockStats.Lock()
+	lockStats.expired++
+	lockStats.Unlock()
+	Log(Warn, "Lock on '%s' expired with %d waiter(s), plugins should check in memories promptly", key, waiters)
+}
+
+func lockReport() string {
+	lockStats.Lock()
+	defer lockStats.Unlock()
+	var avg time.Duration
+	if lockStats.waits > 0 {
+		avg = lockStats.totalWait / time.Duration(lockStats.waits)
+	}
+	r := []string{
+		"Memory lock statistics since start:",
+		"Read/write checkouts: " + strconv.Itoa(lockStats.checkouts),
+		"Checkouts that waited for a lock: " + strconv.Itoa(lockStats.waits),
+		"Average wait: " + avg.String() + ", longest wait: " + lockStats.maxWait.String(),
+		"Locks expired with waiters: " + strconv.Itoa(lockStats.expired),
+		"Failed compare-and-swap updates: " + strconv.Itoa(lockStats.casFailures),
+	}
+	return strings.Join(r, "\n")
+}
+
+// compareAndSwap stores a datum only if it hasn't changed since the caller
+// read it, and isn't currently locked; only called from runBrain.
+func compareAndSwap(memories map[string]*memstatus, cr casRequest) RetVal {
+	if m, ok := memories[cr.key]; ok && (m.state != available || len(m.waiters) > 0) {
+		lockStats.Lock()
+		lockStats.casFailures++
+		lockStats.Unlock()
+		return DatumChanged
+	}
+	_, db, _, ret := retrieveDatum(cr.key, false)
+	if ret != Ok {
+		return ret
+	}
+	if datumVersion(db) != cr.version {
+		lockStats.Lock()
+		lockStats.casFailures++
+		lockStats.Unlock()
+		return DatumChanged
+	}
+	ret = storeDatum(cr.key, cr.datum)
+	if ret == Ok {
+		setExpiration(cr.key, cr.ttl)
+		if len(cr.user) > 0 {
+			recordVersion(cr.key, cr.user, cr.datum)
+		}
+		// an abandoned lock is no longer valid
+		delete(memories, cr.key)
+	}
+	return ret
+}
+
+// updateVersion sends a compare-and-swap update to the brain
+func updateVersion(key, version string, datum *[]byte, ttl time.Duration, user string) RetVal {
+	reply := make(chan RetVal)
+	cr := casRequest{key, version, datum, ttl, user, reply}
+	Log(Trace, "Compare-and-swap update of datum %s, version: %s", key, version)
+	brainChanEvents <- brainOp{casBytes, cr}
+	return <-reply
+}
+
+// updateDatumVersion is the internal version of UpdateDatumVersion
+func updateDatumVersion(key, version string, datum interface{}, ttl time.Duration, user string) RetVal {
+	dbytes, err := json.Marshal(datum)
+	if err != nil {
+		Log(Error, "Marshalling datum %s: %v", key, err)
+		return DataFormatError
+	}
+	return updateVersion(key, version, &dbytes, ttl, user)
+}
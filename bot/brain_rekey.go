@@ -0,0 +1,175 @@
+package bot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/awnumar/memguard"
+)
+
+/* brain_rekey.go - rotating the 'real' brain encryption key. A new key is
+generated and wrapped with the user-supplied key, while the old key is kept
+wrapped under prevEncryptionKey until every memory has been re-encrypted.
+Until then getDatum reads memories with either key, so the robot keeps
+working during the rotation, and an interrupted rotation can be resumed. */
+
+type reencryptRequest struct {
+	key   string
+	reply chan RetVal
+}
+
+// reencryptDatum re-encrypts a single memory with the current key if it's
+// still encrypted with the previous key; only called from runBrain.
+func reencryptDatum(dkey string) RetVal {
+	cryptKey.RLock()
+	key := cryptKey.key
+	prevKey := cryptKey.prevKey
+	cryptKey.RUnlock()
+	db, exists, err := botCfg.brain.Retrieve(dkey)
+	if err != nil {
+		Log(Error, "Retrieving '%s' for re-encryption: %v", dkey, err)
+		return BrainFailed
+	}
+	if !exists {
+		return Ok
+	}
+	if _, err := decrypt(*db, key); err == nil {
+		return Ok
+	}
+	decrypted, err := decrypt(*db, prevKey)
+	if err != nil {
+		// Not encrypted with either key; getDatum converts unencrypted
+		// memories when they're read.
+		Log(Warn, "Memory '%s' isn't encrypted with the previous brain key, skipping", dkey)
+		return Ok
+	}
+	return storeDatum(dkey, &decrypted)
+}
+
+// wrapKey stores a 'real' key encrypted with the user-supplied key
+func wrapKey(brain SimpleBrain, dkey string, key, userKey []byte) error {
+	wrapped, err := encrypt(key, userKey)
+	if err != nil {
+		return fmt.Errorf("encrypting '%s': %v", dkey, err)
+	}
+	if err := brain.Store(dkey, &wrapped); err != nil {
+		return fmt.Errorf("storing '%s': %v", dkey, err)
+	}
+	return nil
+}
+
+// rotateBrainKey generates a new 'real' key and re-encrypts every memory with
+// it. The user-supplied key is needed to wrap the new key, and must unlock the
+// current one.
+func rotateBrainKey(user, supplied string) error {
+	if !encryptBrain {
+		return errors.New("brain encryption isn't enabled")
+	}
+	lb, err := listingBrain()
+	if err != nil {
+		return err
+	}
+	db, ok := botCfg.brain.(DeletingBrain)
+	if !ok {
+		return errors.New("the configured brain doesn't support deleting memories")
+	}
+	ukbytes := []byte(supplied)
+	if len(ukbytes) < 32 {
+		return errors.New("provided brain key < 32 bytes")
+	}
+	userKey, err := memguard.NewImmutableFromBytes(ukbytes[0:32])
+	memguard.WipeBytes(ukbytes)
+	if err != nil {
+		return fmt.Errorf("creating protected memory region for key: %v", err)
+	}
+	defer userKey.Destroy()
+	uk := userKey.Buffer()
+
+	cryptKey.Lock()
+	if !cryptKey.initialized {
+		cryptKey.Unlock()
+		return errors.New("brain encryption isn't initialized")
+	}
+	if cryptKey.rotating {
+		cryptKey.Unlock()
+		return errors.New("a key rotation is already in progress")
+	}
+	cryptKey.rotating = true
+	resume := cryptKey.prevKey != nil
+	current := cryptKey.key
+	cryptKey.Unlock()
+	defer func() {
+		cryptKey.Lock()
+		cryptKey.rotating = false
+		cryptKey.Unlock()
+	}()
+
+	// The supplied key has to unwrap the current key
+	wrapped, exists, err := lb.Retrieve(botEncryptionKey)
+	if err != nil || !exists {
+		return fmt.Errorf("retrieving the wrapped brain key: %v", err)
+	}
+	unwrapped, err := decrypt(*wrapped, uk)
+	if err != nil || !bytes.Equal(unwrapped, current) {
+		memguard.WipeBytes(unwrapped)
+		return errors.New("the supplied key doesn't unlock the brain key")
+	}
+	memguard.WipeBytes(unwrapped)
+
+	if resume {
+		Log(Info, "Resuming interrupted brain key rotation started by user '%s'", user)
+	} else {
+		newKey, err := memguard.NewImmutableRandom(32)
+		if err != nil {
+			return fmt.Errorf("generating new brain key: %v", err)
+		}
+		// Save the old key first, so memories stay readable if the robot
+		// stops during the rotation.
+		if err := wrapKey(lb, prevEncryptionKey, current, uk); err != nil {
+			newKey.Destroy()
+			return err
+		}
+		if err := wrapKey(lb, botEncryptionKey, newKey.Buffer(), uk); err != nil {
+			newKey.Destroy()
+			return err
+		}
+		cryptKey.Lock()
+		cryptKey.previous = cryptKey.protected
+		cryptKey.prevKey = cryptKey.key
+		cryptKey.protected = newKey
+		cryptKey.key = newKey.Buffer()
+		cryptKey.Unlock()
+		Log(Audit, "User '%s' started brain key rotation", user)
+	}
+
+	keys, err := lb.List()
+	if err != nil {
+		return fmt.Errorf("listing memories: %v", err)
+	}
+	reencrypted := 0
+	for _, k := range keys {
+		if k == botEncryptionKey || k == prevEncryptionKey {
+			continue
+		}
+		reply := make(chan RetVal)
+		brainChanEvents <- brainOp{reencryptBytes, reencryptRequest{k, reply}}
+		if ret := <-reply; ret != Ok {
+			return fmt.Errorf("re-encrypting '%s': %s; run the rotation again to resume", k, ret)
+		}
+		reencrypted++
+	}
+
+	if err := db.Delete(prevEncryptionKey); err != nil {
+		return fmt.Errorf("removing the previous brain key: %v", err)
+	}
+	cryptKey.Lock()
+	if cryptKey.previous != nil {
+		cryptKey.previous.Destroy()
+	}
+	cryptKey.previous = nil
+	cryptKey.prevKey = nil
+	cryptKey.Unlock()
+	Log(Audit, "User '%s' rotated the brain encryption key, %d memories checked for re-encryption", user, reencrypted)
+	return nil
+}
@@ -0,0 +1,177 @@
+package bot
+
+import (
+	"encoding/json"
+	"time"
+)
+
+/* brain_ttl.go - expiring long-term memories. Expiration times are kept in
+an index stored in the brain, which is only ever accessed from runBrain.
+Expired memories are discarded lazily when checked out, and swept from
+brains that can list and delete memories. */
+
+const expirationsKey = "bot:expirations"
+
+// how often runBrain sweeps expired memories
+const sweepCycle = time.Minute
+
+// expiration index, owned by runBrain
+var expirations map[string]time.Time
+var expirationsLoaded bool
+
+// loadExpirations reads the expiration index from the brain on first use;
+// it fails until an encrypted brain has been initialized.
+func loadExpirations() bool {
+	if expirationsLoaded {
+		return true
+	}
+	_, db, exists, ret := getDatum(expirationsKey, false)
+	if ret != Ok {
+		return false
+	}
+	expirations = make(map[string]time.Time)
+	if exists {
+		if err := json.Unmarshal(*db, &expirations); err != nil {
+			Log(Error, "Unmarshalling memory expirations, expirations lost: %v", err)
+			expirations = make(map[string]time.Time)
+		}
+	}
+	expirationsLoaded = true
+	return true
+}
+
+func saveExpirations() {
+	db, err := json.Marshal(expirations)
+	if err != nil {
+		Log(Error, "Marshalling memory expirations: %v", err)
+		return
+	}
+	if ret := storeDatum(expirationsKey, &db); ret != Ok {
+		Log(Error, "Storing memory expirations: %s", ret)
+	}
+}
+
+// setExpiration records when a memory expires after a successful update;
+// a ttl of 0 means the memory never expires, and a negative ttl leaves it
+// unchanged - unless it had already expired, since the update re-created
+// the memory without a TTL.
+func setExpiration(key string, ttl time.Duration) {
+	if !loadExpirations() {
+		if ttl > 0 {
+			Log(Error, "Unable to load memory expirations, '%s' won't expire", key)
+		}
+		return
+	}
+	exp, expiring := expirations[key]
+	if ttl < 0 {
+		if expiring && !time.Now().Before(exp) {
+			delete(expirations, key)
+			saveExpirations()
+		}
+		return
+	}
+	if ttl == 0 {
+		if expiring {
+			delete(expirations, key)
+			saveExpirations()
+		}
+		return
+	}
+	expirations[key] = time.Now().Add(ttl)
+	saveExpirations()
+}
+
+// forgetDatum removes a memory from brains that support it, returning true
+// if the memory is gone.
+func forgetDatum(key string) bool {
+	db, ok := botCfg.brain.(DeletingBrain)
+	if !ok {
+		return false
+	}
+	if err := db.Delete(key); err != nil {
+		Log(Error, "Deleting expired memory '%s': %v", key, err)
+		return false
+	}
+	if err := db.Delete(versionPrefix + key); err != nil {
+		Log(Error, "Deleting versions of expired memory '%s': %v", key, err)
+	}
+	return true
+}
+
+// datumExpired checks the expiration index for a key. Expired memories are
+// deleted if the brain allows it; otherwise they stay in the index so they
+// read as non-existent until updated.
+func datumExpired(key string) bool {
+	if key == expirationsKey || key == botEncryptionKey {
+		return false
+	}
+	if !loadExpirations() {
+		return false
+	}
+	exp, ok := expirations[key]
+	if !ok || time.Now().Before(exp) {
+		return false
+	}
+	Log(Debug, "Memory '%s' expired at %s", key, exp)
+	if forgetDatum(key) {
+		delete(expirations, key)
+		saveExpirations()
+	}
+	return true
+}
+
+// retrieveDatum is getDatum for runBrain, honoring expirations.
+func retrieveDatum(key string, rw bool) (string, *[]byte, bool, RetVal) {
+	if datumExpired(key) {
+		if rw {
+			return newLockToken(), nil, false, Ok
+		}
+		return "", nil, false, Ok
+	}
+	return getDatum(key, rw)
+}
+
+// sweepExpired deletes expired memories that aren't checked out, and drops
+// index entries for memories that no longer exist. It only runs for brains
+// that can list and delete memories.
+func sweepExpired(checkedOut map[string]*memstatus) {
+	lb, ok := botCfg.brain.(ListingBrain)
+	if !ok {
+		return
+	}
+	if _, ok := botCfg.brain.(DeletingBrain); !ok {
+		return
+	}
+	if !loadExpirations() || len(expirations) == 0 {
+		return
+	}
+	keys, err := lb.List()
+	if err != nil {
+		Log(Error, "Listing memories for expiration sweep: %v", err)
+		return
+	}
+	stored := make(map[string]struct{})
+	for _, k := range keys {
+		stored[k] = struct{}{}
+	}
+	now := time.Now()
+	changed := false
+	for k, exp := range expirations {
+		if _, ok := stored[k]; !ok {
+			delete(expirations, k)
+			changed = true
+			continue
+		}
+		if _, busy := checkedOut[k]; busy || now.Before(exp) {
+			continue
+		}
+		if forgetDatum(k) {
+			Log(Debug, "Swept expired memory '%s'", k)
+			delete(expirations, k)
+			changed = true
+		}
+	}
+	if changed {
+		saveExpirations()
+	}
+}
@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/* brain_versions.go - optional version history for memories updated with
+UpdateDatum. When MemoryVersions is set, every update is also appended to a
+history stored under versionPrefix+key, keeping the last MemoryVersions
+versions. */
+
+const versionPrefix = "bot:versions:"
+
+// memoryVersion records one update of a memory
+type memoryVersion struct {
+	Version int             // increasing version number
+	User    string          // who stored this version
+	Time    time.Time       // when it was stored
+	Datum   json.RawMessage // the memory as stored
+}
+
+// getVersions returns the version history for a key, oldest first.
+func getVersions(key string) ([]memoryVersion, RetVal) {
+	var versions []memoryVersion
+	_, db, exists, ret := getDatum(versionPrefix+key, false)
+	if ret != Ok || !exists {
+		return versions, ret
+	}
+	if err := json.Unmarshal(*db, &versions); err != nil {
+		Log(Error, "Unmarshalling versions of '%s': %v", key, err)
+		return nil, DataFormatError
+	}
+	return versions, Ok
+}
+
+// recordVersion appends an update to a memory's history; only called from
+// runBrain after the memory has been stored.
+func recordVersion(key, user string, datum *[]byte) {
+	botCfg.RLock()
+	keep := botCfg.memoryVersions
+	botCfg.RUnlock()
+	if keep <= 0 {
+		return
+	}
+	versions, ret := getVersions(key)
+	if ret != Ok {
+		Log(Error, "Unable to record new version of '%s': %s", key, ret)
+		return
+	}
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].Version + 1
+	}
+	versions = append(versions, memoryVersion{
+		Version: next,
+		User:    user,
+		Time:    time.Now().UTC(),
+		Datum:   json.RawMessage(*datum),
+	})
+	if len(versions) > keep {
+		versions = versions[len(versions)-keep:]
+	}
+	vb, err := json.Marshal(versions)
+	if err != nil {
+		Log(Error, "Marshalling versions of '%s': %v", key, err)
+		return
+	}
+	if ret := storeDatum(versionPrefix+key, &vb); ret != Ok {
+		Log(Error, "Storing versions of '%s': %s", key, ret)
+	}
+}
+
+// rollbackDatum replaces a memory with an earlier version, recording the
+// rollback as a new version.
+func rollbackDatum(key, user string, version int) error {
+	versions, ret := getVersions(key)
+	if ret != Ok {
+		return fmt.Errorf("retrieving versions: %s", ret)
+	}
+	var datum []byte
+	found := false
+	for _, v := range versions {
+		if v.Version == version {
+			datum = []byte(v.Datum)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("version %d of '%s' not found", version, key)
+	}
+	lt, _, _, ret := checkout(key, true)
+	if ret != Ok {
+		return fmt.Errorf("checking out '%s': %s", key, ret)
+	}
+	// a negative ttl leaves any expiration alone
+	if ret := update(key, lt, &datum, -1, user); ret != Ok {
+		return fmt.Errorf("updating '%s': %s", key, ret)
+	}
+	Log(Audit, "User '%s' rolled back memory '%s' to version %d", user, key, version)
+	return nil
+}
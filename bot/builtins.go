@@ -54,7 +54,13 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 		} else {
 			alias = string(aliasCh)
 		}
-		channelID, _ := ExtractID(r.ProtocolChannel)
+		protocol, pchannel := splitProtocol(r.ProtocolChannel)
+		if len(protocol) > 0 {
+			if ID = secondaryBotID(protocol); len(ID) == 0 {
+				ID = "(unknown)"
+			}
+		}
+		channelID, _ := ExtractID(pchannel)
 		msg := make([]string, 0, 7)
 		msg = append(msg, "Here's some information about me and my running environment:")
 		msg = append(msg, fmt.Sprintf("The hostname for the server I'm running on is: %s", hostName))
@@ -381,10 +387,98 @@ func encryptcfg(r *Robot, command string, args ...string) (retval TaskRetVal) {
 		if success {
 			r.Log(Info, "Encryption successfully initialized by user '%s'", r.User)
 			r.Say("Encryption successfully initialized - you should delete your message if possible")
+			// ad-hoc schedules are stored in the brain
+			scheduleTasks()
 		} else {
 			r.Log(Error, "User '%s' failed to initialize encryption", r.User)
 			r.Say("Failed to initialize encryption - check your passphrase?")
 		}
+	case "rotate":
+		key := args[0]
+		if len(key) == 0 {
+			botCfg.RLock()
+			key = botCfg.encryptionKey
+			botCfg.RUnlock()
+		}
+		if len(key) == 0 {
+			r.Say("No EncryptionKey is configured, you need to provide it: 'rotate brain key <key>'")
+			return
+		}
+		r.Say("Rotating the brain key and re-encrypting memories, this could take a while...")
+		if err := rotateBrainKey(r.User, key); err != nil {
+			r.Log(Error, "Brain key rotation by user '%s' failed: %v", r.User, err)
+			r.Say(fmt.Sprintf("Brain key rotation failed: %v", err))
+			return
+		}
+		r.Say("Brain key rotated - you should delete your message if you provided a key")
+	case "backup":
+		name, err := backupBrain()
+		if err != nil {
+			r.Log(Error, "Brain backup requested by user '%s' failed: %v", r.User, err)
+			r.Say(fmt.Sprintf("Brain backup failed: %v", err))
+			return
+		}
+		r.Say(fmt.Sprintf("Brain backed up to archive '%s'", name))
+	case "listbackups":
+		backups, err := listBackups()
+		if err != nil {
+			r.Say(fmt.Sprintf("Unable to list brain backups: %v", err))
+			return
+		}
+		if len(backups) == 0 {
+			r.Say("I don't have any brain backups")
+			return
+		}
+		r.Say(fmt.Sprintf("Here are my brain backups, newest first:\n%s", strings.Join(backups, "\n")))
+	case "restore":
+		rekeyed, err := restoreBrain(args[0])
+		if err != nil {
+			r.Log(Error, "Brain restore of '%s' requested by user '%s' failed: %v", args[0], r.User, err)
+			r.Say(fmt.Sprintf("Brain restore failed: %v", err))
+			return
+		}
+		if !rekeyed {
+			r.Say(fmt.Sprintf("Restored memories from '%s'", args[0]))
+			return
+		}
+		botCfg.RLock()
+		key := botCfg.encryptionKey
+		botCfg.RUnlock()
+		if len(key) > 0 && initializeEncryption(key) {
+			r.Say(fmt.Sprintf("Restored memories from '%s' and re-initialized encryption", args[0]))
+			return
+		}
+		r.Log(Warn, "Brain key replaced by restore of '%s', encryption needs to be initialized", args[0])
+		r.Say(fmt.Sprintf("Restored memories from '%s', but the brain key was replaced - use 'initialize encryption <key>' with the key for the restored brain", args[0]))
+	case "versions":
+		key := args[0]
+		versions, ret := getVersions(key)
+		if ret != Ok {
+			r.Say(fmt.Sprintf("I had a problem retrieving versions of '%s': %s", key, ret))
+			return
+		}
+		if len(versions) == 0 {
+			r.Say(fmt.Sprintf("I don't have any versions of '%s'", key))
+			return
+		}
+		vl := make([]string, 0, len(versions)+1)
+		vl = append(vl, fmt.Sprintf("Here are the versions of '%s', newest first:", key))
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			vl = append(vl, fmt.Sprintf("%d: %s by %s (%d bytes)", v.Version, v.Time.Format(time.RFC1123), v.User, len(v.Datum)))
+		}
+		r.Fixed().Say(strings.Join(vl, "\n"))
+	case "rollback":
+		key := args[0]
+		version, _ := strconv.Atoi(args[1])
+		if err := rollbackDatum(key, r.User, version); err != nil {
+			r.Log(Error, "User '%s' failed rolling back '%s' to version %d: %v", r.User, key, version, err)
+			r.Say(fmt.Sprintf("Rollback failed: %v", err))
+			return
+		}
+		r.Say(fmt.Sprintf("Ok, I rolled '%s' back to version %d", key, version))
+	case "lockstats":
+		r.Fixed().Say(lockReport())
 	}
 	return
 }
@@ -437,7 +531,14 @@ func admin(r *Robot, command string, args ...string) (retval TaskRetVal) {
 			Log(Error, "Reloading configuration, requested by %s: %v", r.User, err)
 			return
 		}
-		r.Reply("Configuration reloaded successfully")
+		schedMutex.Lock()
+		serrs := scheduleErrors
+		schedMutex.Unlock()
+		if len(serrs) > 0 {
+			r.Reply("Configuration reloaded, but some scheduled jobs weren't scheduled:\n" + strings.Join(serrs, "\n"))
+		} else {
+			r.Reply("Configuration reloaded successfully")
+		}
 		r.Log(Info, "Configuration successfully reloaded by a request from:", r.User)
 	case "abort":
 		buf := make([]byte, 32768)
@@ -487,6 +588,120 @@ func admin(r *Robot, command string, args ...string) (retval TaskRetVal) {
 		}
 		taskDebug.Unlock()
 		r.Say("Debugging disabled")
+	case "blackouts":
+		cl := calendarReport()
+		if len(cl) == 0 {
+			r.Say("There are no blackout calendars configured")
+			return
+		}
+		r.Say("Blackout calendars:\n" + strings.Join(cl, "\n"))
+	case "override", "endoverride":
+		name := args[0]
+		var duration time.Duration
+		if command == "override" {
+			duration = time.Hour
+			if len(args[1]) > 0 {
+				var err error
+				if duration, err = time.ParseDuration(args[1]); err != nil {
+					r.Say(fmt.Sprintf("Invalid duration '%s', try e.g. '2h' or '30m'", args[1]))
+					return
+				}
+			}
+		}
+		if err := overrideCalendar(name, duration); err != nil {
+			r.Say(fmt.Sprintf("Sorry: %v", err))
+			return
+		}
+		if command == "override" {
+			r.Log(Audit, "User '%s' overrode blackout calendar '%s' for %v", r.User, name, duration)
+			r.Say(fmt.Sprintf("Ok, ignoring blackout calendar '%s' for %v", name, duration))
+		} else {
+			r.Log(Audit, "User '%s' ended the override of blackout calendar '%s'", r.User, name)
+			r.Say(fmt.Sprintf("Ok, blackout calendar '%s' is back in effect", name))
+		}
+	case "schedules":
+		sl := listSchedules()
+		schedMutex.Lock()
+		serrs := scheduleErrors
+		schedMutex.Unlock()
+		if len(sl) == 0 && len(serrs) == 0 {
+			r.Say("There are no jobs scheduled")
+			return
+		}
+		if len(serrs) > 0 {
+			sl = append(sl, "", "Not scheduled:")
+			sl = append(sl, serrs...)
+		}
+		r.Fixed().Say("Scheduled jobs:\n" + strings.Join(sl, "\n"))
+	case "pause", "resume":
+		sj := findSchedule(args[0])
+		if sj == nil {
+			r.Say(fmt.Sprintf("Schedule '%s' not found, use 'list schedules' to see schedule names", args[0]))
+			return
+		}
+		id := sj.id
+		if err := setSchedulePaused(id, r.User, command == "pause"); err != nil {
+			r.Log(Error, "Failed to %s schedule '%s': %v", command, id, err)
+			r.Say(fmt.Sprintf("I wasn't able to %s that schedule, check the logs", command))
+			return
+		}
+		r.Log(Audit, "User '%s' %sd schedule '%s'", r.User, command, id)
+		r.Say(fmt.Sprintf("Ok, I %sd schedule '%s'", command, id))
+	case "link", "unlink":
+		id, err := parseIdentity(args[0])
+		if err != nil {
+			r.Say(err.Error())
+			return
+		}
+		c := r.getContext()
+		if ui, ok := c.maps.userID[id]; ok {
+			r.Say(fmt.Sprintf("Identity '%s' belongs to '%s' in the UserRoster, and can only be changed in configuration", args[0], ui.UserName))
+			return
+		}
+		var user string
+		if command == "link" {
+			user = args[1]
+			if _, ok := c.maps.user[user]; !ok {
+				r.Say(fmt.Sprintf("User '%s' isn't in the UserRoster, identities can only be linked to configured users", user))
+				return
+			}
+		}
+		prev, err := setLinkedIdentity(id, user)
+		if err != nil {
+			r.Log(Error, "Failed to %s identity '%s': %v", command, args[0], err)
+			r.Say(fmt.Sprintf("I wasn't able to %s that identity, check the logs", command))
+			return
+		}
+		if command == "unlink" {
+			if len(prev) == 0 {
+				r.Say(fmt.Sprintf("Identity '%s' wasn't linked", args[0]))
+				return
+			}
+			r.Log(Audit, "User '%s' unlinked identity '%s' from user '%s'", r.User, args[0], prev)
+			r.Say(fmt.Sprintf("Ok, identity '%s' is no longer linked to '%s'", args[0], prev))
+			return
+		}
+		r.Log(Audit, "User '%s' linked identity '%s' to user '%s'", r.User, args[0], user)
+		if len(prev) > 0 && prev != user {
+			r.Say(fmt.Sprintf("Ok, identity '%s' is now linked to '%s' instead of '%s'", args[0], user, prev))
+			return
+		}
+		r.Say(fmt.Sprintf("Ok, identity '%s' is linked to '%s'", args[0], user))
+	case "identities":
+		il := listIdentities(r.getContext().maps, args[0])
+		if len(il) == 0 {
+			r.Say("No identities are mapped or linked")
+			return
+		}
+		r.Fixed().Say("Identities:\n" + strings.Join(il, "\n"))
+	case "runschedule":
+		id := args[0]
+		if !runScheduleNow(id) {
+			r.Say(fmt.Sprintf("Schedule '%s' not found, use 'list schedules' to see schedule names", id))
+			return
+		}
+		r.Log(Audit, "User '%s' started an immediate run of schedule '%s'", r.User, id)
+		r.Say(fmt.Sprintf("Ok, starting '%s' now", id))
 	case "quit":
 		botCfg.Lock()
 		if botCfg.shuttingDown {
@@ -0,0 +1,441 @@
+package bot
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* calendars.go - named blackout calendars for release freezes and the like.
+Jobs list calendars in Blackouts (in conf/jobs/<job>.yaml), and scheduled
+jobs can list more in ScheduledJobs; while any of them is active the job
+doesn't run, or with BlackoutPolicy "defer", runs when the blackout ends.
+Deferred runs are lost if the robot restarts. */
+
+// Calendar describes blackout periods, configured in gopherbot.yaml
+type Calendar struct {
+	Ranges []CalendarRange // date ranges, e.g. release freezes
+	Weekly []WeeklyWindow  // recurring weekly windows
+	ICal   string          // iCalendar file of holidays, relative to the configuration directory
+}
+
+// CalendarRange is a blackout period; dates are "2006-01-02" or
+// "2006-01-02 15:04", and an End date without a time includes the whole day
+type CalendarRange struct {
+	Start, End string
+}
+
+// WeeklyWindow is a blackout every week on the given Days ("mon".."sun"),
+// from Start to End ("15:04"); an End before Start ends the next day, and
+// no Start or End means the whole day
+type WeeklyWindow struct {
+	Days       []string
+	Start, End string
+}
+
+// blackoutPeriod is a parsed CalendarRange or iCal event
+type blackoutPeriod struct {
+	start, end time.Time
+	summary    string
+}
+
+// weeklyBlackout is a parsed WeeklyWindow, times in minutes after midnight
+type weeklyBlackout struct {
+	days       [7]bool
+	start, end int
+}
+
+type calendar struct {
+	name    string
+	periods []blackoutPeriod
+	weekly  []weeklyBlackout
+}
+
+// calendarSet maps calendar names to calendars
+type calendarSet map[string]*calendar
+
+// Maximum number of chained blackout periods checked when finding the end
+const maxBlackoutChain = 100
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// calendarOverrides maps calendar names to when an administrator's override
+// expires; overrides aren't persisted.
+var calendarOverrides = struct {
+	m map[string]time.Time
+	sync.Mutex
+}{
+	m: make(map[string]time.Time),
+}
+
+func parseCalendarTime(s string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, loc); err == nil {
+		return t, true, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return t, false, fmt.Errorf("invalid date '%s', use YYYY-MM-DD or 'YYYY-MM-DD HH:MM'", s)
+	}
+	return t, false, nil
+}
+
+func parseMinutes(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', use HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// loadCalendar compiles a configured calendar
+func loadCalendar(name string, cfg Calendar, loc *time.Location) (*calendar, error) {
+	cal := &calendar{name: name}
+	for _, r := range cfg.Ranges {
+		start, _, err := parseCalendarTime(r.Start, loc)
+		if err != nil {
+			return nil, err
+		}
+		end, hasTime, err := parseCalendarTime(r.End, loc)
+		if err != nil {
+			return nil, err
+		}
+		if !hasTime {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("range %s - %s ends before it starts", r.Start, r.End)
+		}
+		cal.periods = append(cal.periods, blackoutPeriod{start, end, ""})
+	}
+	for _, w := range cfg.Weekly {
+		var wb weeklyBlackout
+		for _, d := range w.Days {
+			day := strings.ToLower(d)
+			if len(day) > 3 {
+				day = day[:3]
+			}
+			wd, ok := weekdays[day]
+			if !ok {
+				return nil, fmt.Errorf("invalid day '%s'", d)
+			}
+			wb.days[wd] = true
+		}
+		if len(w.Days) == 0 {
+			return nil, fmt.Errorf("weekly window with no Days")
+		}
+		wb.end = 24 * 60
+		var err error
+		if len(w.Start) > 0 {
+			if wb.start, err = parseMinutes(w.Start); err != nil {
+				return nil, err
+			}
+		}
+		if len(w.End) > 0 {
+			if wb.end, err = parseMinutes(w.End); err != nil {
+				return nil, err
+			}
+		}
+		cal.weekly = append(cal.weekly, wb)
+	}
+	if len(cfg.ICal) > 0 {
+		path := cfg.ICal
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(configPath, path)
+		}
+		events, err := loadICal(path, loc)
+		if err != nil {
+			return nil, err
+		}
+		cal.periods = append(cal.periods, events...)
+	}
+	return cal, nil
+}
+
+// parseICalTime handles the DATE and DATE-TIME forms of DTSTART/DTEND
+func parseICalTime(prop, value string, loc *time.Location) (time.Time, bool, error) {
+	if (strings.Contains(prop, "VALUE=DATE") && !strings.Contains(prop, "DATE-TIME")) || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	tl := loc
+	for _, param := range strings.Split(prop, ";")[1:] {
+		if strings.HasPrefix(param, "TZID=") {
+			if l, err := time.LoadLocation(strings.TrimPrefix(param, "TZID=")); err == nil {
+				tl = l
+			}
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, tl)
+	return t, false, err
+}
+
+// loadICal reads the VEVENTs from an iCalendar file; recurring events
+// (RRULE) aren't expanded, only the first occurrence is used.
+func loadICal(path string, loc *time.Location) ([]blackoutPeriod, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening iCal file: %v", err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// unfold continuation lines
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading iCal file: %v", err)
+	}
+	var events []blackoutPeriod
+	var ev blackoutPeriod
+	inEvent, allDay := false, false
+	for _, line := range lines {
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent = true
+			ev = blackoutPeriod{}
+			allDay = false
+		case line == "END:VEVENT":
+			inEvent = false
+			if ev.start.IsZero() {
+				continue
+			}
+			if ev.end.IsZero() {
+				if allDay {
+					ev.end = ev.start.AddDate(0, 0, 1)
+				} else {
+					ev.end = ev.start
+				}
+			}
+			if ev.end.After(ev.start) {
+				events = append(events, ev)
+			}
+		case inEvent:
+			i := strings.Index(line, ":")
+			if i < 0 {
+				continue
+			}
+			prop, value := line[:i], line[i+1:]
+			name := strings.ToUpper(strings.Split(prop, ";")[0])
+			switch name {
+			case "DTSTART", "DTEND":
+				t, date, err := parseICalTime(prop, value, loc)
+				if err != nil {
+					return nil, fmt.Errorf("parsing %s '%s': %v", name, value, err)
+				}
+				if name == "DTSTART" {
+					ev.start = t
+					allDay = date
+				} else {
+					ev.end = t
+				}
+			case "SUMMARY":
+				ev.summary = value
+			}
+		}
+	}
+	return events, nil
+}
+
+// activeAt returns whether the calendar is blacked out at t, and when the
+// active period ends
+func (cal *calendar) activeAt(t time.Time) (bool, time.Time, string) {
+	for _, p := range cal.periods {
+		if !t.Before(p.start) && t.Before(p.end) {
+			return true, p.end, p.summary
+		}
+	}
+	for _, w := range cal.weekly {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		m := t.Hour()*60 + t.Minute()
+		if w.end > w.start {
+			if w.days[t.Weekday()] && m >= w.start && m < w.end {
+				return true, day.Add(time.Duration(w.end) * time.Minute), ""
+			}
+			continue
+		}
+		// window wraps past midnight
+		if w.days[t.Weekday()] && m >= w.start {
+			return true, day.AddDate(0, 0, 1).Add(time.Duration(w.end) * time.Minute), ""
+		}
+		if w.days[(t.Weekday()+6)%7] && m < w.end {
+			return true, day.Add(time.Duration(w.end) * time.Minute), ""
+		}
+	}
+	return false, time.Time{}, ""
+}
+
+// blackoutUntil checks whether any of the named calendars is active now,
+// returning when the blackout ends and a description. Overridden calendars
+// are ignored.
+func blackoutUntil(names []string, now time.Time) (bool, time.Time, string) {
+	if len(names) == 0 {
+		return false, time.Time{}, ""
+	}
+	botCfg.RLock()
+	cals := botCfg.calendars
+	botCfg.RUnlock()
+	calendarOverrides.Lock()
+	active := make([]*calendar, 0, len(names))
+	for _, name := range names {
+		if until, ok := calendarOverrides.m[name]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(calendarOverrides.m, name)
+		}
+		cal, ok := cals[name]
+		if !ok {
+			Log(Error, "Blackout calendar '%s' not found in Calendars", name)
+			continue
+		}
+		active = append(active, cal)
+	}
+	calendarOverrides.Unlock()
+	t := now.In(scheduleLocation())
+	var desc string
+	blocked := false
+	for i := 0; i < maxBlackoutChain; i++ {
+		found := false
+		for _, cal := range active {
+			if on, end, summary := cal.activeAt(t); on {
+				if !blocked {
+					desc = cal.name
+					if len(summary) > 0 {
+						desc += " (" + summary + ")"
+					}
+				}
+				found, blocked = true, true
+				t = end
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return blocked, t, desc
+}
+
+// overrideCalendar suspends a calendar for duration
+func overrideCalendar(name string, duration time.Duration) error {
+	botCfg.RLock()
+	_, ok := botCfg.calendars[name]
+	botCfg.RUnlock()
+	if !ok {
+		return fmt.Errorf("calendar '%s' not found", name)
+	}
+	calendarOverrides.Lock()
+	if duration > 0 {
+		calendarOverrides.m[name] = time.Now().Add(duration)
+	} else {
+		delete(calendarOverrides.m, name)
+	}
+	calendarOverrides.Unlock()
+	return nil
+}
+
+// calendarReport lists calendars with their current status
+func calendarReport() []string {
+	botCfg.RLock()
+	cals := botCfg.calendars
+	botCfg.RUnlock()
+	names := make([]string, 0, len(cals))
+	for name := range cals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	const layout = "Mon Jan 2 15:04 MST"
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		status := "not active"
+		calendarOverrides.Lock()
+		until, overridden := calendarOverrides.m[name]
+		calendarOverrides.Unlock()
+		if overridden && now.Before(until) {
+			status = "overridden until " + until.In(scheduleLocation()).Format(layout)
+		} else if on, end, _ := blackoutUntil([]string{name}, now); on {
+			status = "ACTIVE until " + end.Format(layout)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", name, status))
+	}
+	return lines
+}
+
+// channelNotice posts a message in a channel outside of any pipeline
+func channelNotice(channel, msg string) {
+	c := &botContext{
+		Channel:     channel,
+		environment: make(map[string]string),
+	}
+	c.registerActive(nil)
+	c.makeRobot().Say(msg)
+	c.deregister()
+}
+
+// deferredRuns records the pending deferred runs, so a job or schedule
+// that is blocked again and again during a blackout runs only once when it
+// ends.
+var deferredRuns = struct {
+	m map[string]bool
+	sync.Mutex
+}{
+	m: make(map[string]bool),
+}
+
+// checkBlackout is called before a job starts; if the job is blacked out,
+// it posts a notice in the job channel, and with BlackoutPolicy "defer"
+// calls deferred when the blackout ends, at most once for a given key.
+// Returns true if the run should not go ahead.
+func checkBlackout(jobName, key, channel string, calendars []string, policy string, deferred func()) bool {
+	blocked, until, desc := blackoutUntil(calendars, time.Now())
+	if !blocked {
+		return false
+	}
+	const layout = "Mon Jan 2 15:04 MST"
+	if policy == "defer" && deferred != nil {
+		deferredRuns.Lock()
+		pending := deferredRuns.m[key]
+		deferredRuns.m[key] = true
+		deferredRuns.Unlock()
+		if pending {
+			Log(Info, "Job '%s' is blacked out by calendar '%s', a deferred run is already pending", jobName, desc)
+			return true
+		}
+		Log(Info, "Deferring job '%s' until %s, blackout calendar: %s", jobName, until, desc)
+		channelNotice(channel, fmt.Sprintf("Job '%s' is blacked out by calendar '%s', deferring the run until %s", jobName, desc, until.Format(layout)))
+		time.AfterFunc(time.Until(until)+time.Second, func() {
+			deferredRuns.Lock()
+			delete(deferredRuns.m, key)
+			deferredRuns.Unlock()
+			deferred()
+		})
+		return true
+	}
+	Log(Info, "Not running job '%s' during blackout, calendar: %s", jobName, desc)
+	channelNotice(channel, fmt.Sprintf("Not running job '%s', blacked out by calendar '%s' until %s", jobName, desc, until.Format(layout)))
+	return true
+}
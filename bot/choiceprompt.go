@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* choiceprompt.go - prompting the user to pick from a list of choices.
+Connectors that implement ChoiceConnector offer the choices as buttons or
+a menu, and send the pick as an incoming message with Choice set; for
+other connectors the prompt is followed by a numbered list, and the user
+replies with the number or the text of a choice. Either way the reply goes
+through the same waiters as PromptForReply. */
+
+// PromptForChoice prompts the user with a list of choices, and returns the
+// choice picked with RetVal = Ok. Other return values are the same as for
+// PromptForReply, and MissingArguments if no choices are given.
+func (r *Robot) PromptForChoice(prompt string, choices ...string) (string, RetVal) {
+	var rep string
+	var ret RetVal
+	for i := 0; i < 3; i++ {
+		rep, ret = r.promptChoice(r.User, r.Channel, r.thread(r.Channel), prompt, choices, choiceTimeout)
+		if ret == RetryPrompt {
+			continue
+		}
+		return rep, ret
+	}
+	if ret == RetryPrompt {
+		return rep, Interrupted
+	}
+	return rep, ret
+}
+
+// PromptUserForChoice is identical to PromptForChoice, but prompts a specific
+// user with a DM.
+func (r *Robot) PromptUserForChoice(user string, prompt string, choices ...string) (string, RetVal) {
+	var rep string
+	var ret RetVal
+	for i := 0; i < 3; i++ {
+		rep, ret = r.promptChoice(user, "", "", prompt, choices, choiceTimeout)
+		if ret == RetryPrompt {
+			continue
+		}
+		return rep, ret
+	}
+	if ret == RetryPrompt {
+		return rep, Interrupted
+	}
+	return rep, ret
+}
+
+// PromptUserChannelForChoice is identical to PromptForChoice, but prompts a
+// specific user in a given channel.
+func (r *Robot) PromptUserChannelForChoice(user string, channel string, prompt string, choices ...string) (string, RetVal) {
+	var rep string
+	var ret RetVal
+	for i := 0; i < 3; i++ {
+		rep, ret = r.promptChoice(user, channel, r.thread(channel), prompt, choices, choiceTimeout)
+		if ret == RetryPrompt {
+			continue
+		}
+		return rep, ret
+	}
+	if ret == RetryPrompt {
+		return rep, Interrupted
+	}
+	return rep, ret
+}
+
+// promptChoice waits up to timeout for a choice, and can return
+// 'RetryPrompt'
+func (r *Robot) promptChoice(user, channel, thread, prompt string, choices []string, timeout time.Duration) (string, RetVal) {
+	if len(choices) == 0 {
+		Log(Error, "Choice prompt \"%s\" called with no choices", prompt)
+		return "", MissingArguments
+	}
+	rep, ret := r.awaitReply(choiceRegex(choices), choices, user, channel, thread, prompt, timeout)
+	if ret != Ok {
+		return rep, ret
+	}
+	return pickChoice(rep, choices), Ok
+}
+
+// choiceRegex matches the number or text of any of the choices
+func choiceRegex(choices []string) *regexp.Regexp {
+	alts := make([]string, 0, 2*len(choices))
+	for i, choice := range choices {
+		alts = append(alts, strconv.Itoa(i+1), regexp.QuoteMeta(spaceRe.ReplaceAllString(choice, " ")))
+	}
+	return regexp.MustCompile(`^\s*(?i:` + strings.Join(alts, "|") + `)\s*$`)
+}
+
+// pickChoice returns the choice for a matched reply; text takes precedence
+// over numbers, in case the choices are numbers.
+func pickChoice(rep string, choices []string) string {
+	rep = strings.TrimSpace(rep)
+	for _, choice := range choices {
+		if strings.EqualFold(rep, spaceRe.ReplaceAllString(choice, " ")) {
+			return choice
+		}
+	}
+	n, _ := strconv.Atoi(rep)
+	return choices[n-1]
+}
+
+// choiceMenu appends a numbered list of choices to the prompt
+func choiceMenu(prompt string, choices []string) string {
+	menu := []string{prompt}
+	for i, choice := range choices {
+		menu = append(menu, fmt.Sprintf("%d: %s", i+1, choice))
+	}
+	return strings.Join(menu, "\n")
+}
+
+// sendPrompt sends a prompt to a user in a channel, or a DM when channel is
+// "", offering any choices as buttons or a menu if the connector can
+func sendPrompt(userid, username, channel, thread, prompt string, choices []string, f MessageFormat) RetVal {
+	if len(choices) > 0 {
+		var conn Connector
+		var uid, uname, ch string
+		if channel == "" {
+			conn, uid = userConnector(userid)
+		} else {
+			conn, ch = channelConnector(channel)
+			_, uid = splitProtocol(userid)
+		}
+		_, uname = splitProtocol(username)
+		if cc, ok := conn.(ChoiceConnector); ok {
+			var ret RetVal
+			if channel == "" {
+				ret = cc.SendProtocolUserChoices(uid, prompt, choices, f)
+			} else {
+				ret = cc.SendProtocolUserChannelChoices(uid, uname, ch, thread, prompt, choices, f)
+			}
+			if ret != Unsupported {
+				return ret
+			}
+		}
+		prompt = choiceMenu(prompt, choices)
+	}
+	if channel == "" {
+		return sendUserMessage(userid, prompt, f)
+	}
+	return sendUserChannelMessage(userid, username, channel, thread, prompt, f)
+}
@@ -0,0 +1,51 @@
+package bot
+
+import "testing"
+
+func TestChoiceRegex(t *testing.T) {
+	choices := []string{"Red", "light  blue", "a.b"}
+	tests := []struct {
+		rep   string
+		match bool
+	}{
+		{"1", true},
+		{" 3 ", true},
+		{"red", true},
+		{"RED", true},
+		{"Light Blue", true},
+		{"a.b", true},
+		{"4", false},
+		{"0", false},
+		{"12", false},
+		{"axb", false},
+		{"red blue", false},
+		{"", false},
+	}
+	re := choiceRegex(choices)
+	for _, tc := range tests {
+		if match := re.MatchString(tc.rep); match != tc.match {
+			t.Errorf("choiceRegex(%q).MatchString(%q) = %t, want %t", choices, tc.rep, match, tc.match)
+		}
+	}
+}
+
+func TestPickChoice(t *testing.T) {
+	tests := []struct {
+		rep     string
+		choices []string
+		choice  string
+	}{
+		{"1", []string{"red", "blue"}, "red"},
+		{" 2 ", []string{"red", "blue"}, "blue"},
+		{"BLUE", []string{"red", "blue"}, "blue"},
+		{"light blue", []string{"red", "Light  Blue"}, "Light  Blue"},
+		// text takes precedence over numbers
+		{"2", []string{"5", "2", "1"}, "2"},
+		{"3", []string{"5", "2", "1"}, "1"},
+	}
+	for _, tc := range tests {
+		if choice := pickChoice(tc.rep, tc.choices); choice != tc.choice {
+			t.Errorf("pickChoice(%q, %q) = %q, want %q", tc.rep, tc.choices, choice, tc.choice)
+		}
+	}
+}
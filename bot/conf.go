@@ -15,37 +15,46 @@ var protocolConfig, brainConfig, historyConfig json.RawMessage
 
 // BotConf defines 'bot configuration, and is read from conf/gopherbot.yaml
 type BotConf struct {
-	AdminContact         string                  // Contact info for whomever administers the robot
-	MailConfig           botMailer               // configuration for sending email
-	Protocol             string                  // Name of the connector protocol to use, e.g. "slack"
-	ProtocolConfig       json.RawMessage         // Protocol-specific configuration, type for unmarshalling arbitrary config
-	BotInfo              *UserInfo               // Information about the robot
-	UserRoster           []UserInfo              // List of users and related attributes
-	ChannelRoster        []ChannelInfo           // List of channels mapping names to IDs
-	Brain                string                  // Type of Brain to use
-	BrainConfig          json.RawMessage         // Brain-specific configuration, type for unmarshalling arbitrary config
-	EncryptBrain         bool                    // Whether the brain should be encrypted
-	EncryptionKey        string                  // used to decrypt the "real" encryption key
-	HistoryProvider      string                  // Name of provider to use for storing and retrieving job/plugin histories
-	HistoryConfig        json.RawMessage         // History provider specific configuration
-	WorkSpace            string                  // Read/Write area the robot uses to do work
-	DefaultElevator      string                  // Elevator plugin to use by default for ElevatedCommands and ElevateImmediateCommands
-	DefaultAuthorizer    string                  // Authorizer plugin to use by default for AuthorizedCommands, or when AuthorizeAllCommands = true
-	DefaultMessageFormat string                  // How the robot should format outgoing messages unless told otherwise; default: Raw
-	DefaultAllowDirect   bool                    // Whether plugins are available in a DM by default
-	DefaultChannels      []string                // Channels where plugins are active by default, e.g. [ "general", "random" ]
-	IgnoreUsers          []string                // Users the 'bot never talks to - like other bots
-	JoinChannels         []string                // Channels the 'bot should join when it logs in (not supported by all protocols)
-	DefaultJobChannel    string                  // Where job status is posted by default
-	TimeZone             string                  // For evaluating the hour in a job schedule
-	ExternalJobs         map[string]ExternalTask // list of available jobs; config in conf/jobs/<jobname>.yaml
-	ExternalPlugins      map[string]ExternalTask // List of non-Go plugins to load; config in conf/plugins/<plugname>.yaml
-	ExternalTasks        map[string]ExternalTask // List executables that can be added to a pipeline (but can't start one)
-	ScheduledJobs        []ScheduledTask         // see tasks.go
-	AdminUsers           []string                // List of users who can access administrative commands
-	Alias                string                  // One-character alias for commands directed at the 'bot, e.g. ';open the pod bay doors'
-	LocalPort            int                     // Port number for listening on localhost, for CLI plugins
-	LogLevel             string                  // Initial log level, can be modified by plugins. One of "trace" "debug" "info" "warn" "error"
+	AdminContact         string                     // Contact info for whomever administers the robot
+	MailConfig           botMailer                  // configuration for sending email
+	Protocol             string                     // Name of the connector protocol to use, e.g. "slack"
+	ProtocolConfig       json.RawMessage            // Protocol-specific configuration, type for unmarshalling arbitrary config
+	SecondaryProtocols   map[string]json.RawMessage // Other connectors to run at the same time, with the ProtocolConfig for each
+	BotInfo              *UserInfo                  // Information about the robot
+	UserRoster           []UserInfo                 // List of users and related attributes
+	ChannelRoster        []ChannelInfo              // List of channels mapping names to IDs
+	Brain                string                     // Type of Brain to use
+	BrainConfig          json.RawMessage            // Brain-specific configuration, type for unmarshalling arbitrary config
+	EncryptBrain         bool                       // Whether the brain should be encrypted
+	EncryptionKey        string                     // used to decrypt the "real" encryption key
+	BrainBackupDirectory string                     // Where brain backup archives are written to and restored from
+	BrainBackupSchedule  string                     // Optional cron-style schedule for automatic brain backups
+	MemoryVersions       int                        // Number of versions of each memory to keep, for rollback; 0 disables
+	HistoryProvider      string                     // Name of provider to use for storing and retrieving job/plugin histories
+	HistoryConfig        json.RawMessage            // History provider specific configuration
+	WorkSpace            string                     // Read/Write area the robot uses to do work
+	DefaultElevator      string                     // Elevator plugin to use by default for ElevatedCommands and ElevateImmediateCommands
+	DefaultAuthorizer    string                     // Authorizer plugin to use by default for AuthorizedCommands, or when AuthorizeAllCommands = true
+	DefaultMessageFormat string                     // How the robot should format outgoing messages unless told otherwise; default: Raw
+	DefaultAllowDirect   bool                       // Whether plugins are available in a DM by default
+	DefaultChannels      []string                   // Channels where plugins are active by default, e.g. [ "general", "random" ]
+	IgnoreUsers          []string                   // Users the 'bot never talks to - like other bots
+	JoinChannels         []string                   // Channels the 'bot should join when it logs in (not supported by all protocols)
+	DefaultJobChannel    string                     // Where job status is posted by default
+	TimeZone             string                     // For evaluating the hour in a job schedule
+	ExternalJobs         map[string]ExternalTask    // list of available jobs; config in conf/jobs/<jobname>.yaml
+	ExternalPlugins      map[string]ExternalTask    // List of non-Go plugins to load; config in conf/plugins/<plugname>.yaml
+	ExternalTasks        map[string]ExternalTask    // List executables that can be added to a pipeline (but can't start one)
+	ScheduledJobs        []ScheduledTask            // see tasks.go
+	Calendars            map[string]Calendar        // Blackout calendars for jobs, see calendars.go
+	WebhookPort          string                     // Address for receiving Webhooks, e.g. ":8443"; only read at start-up
+	WebhookCertFile      string                     // TLS certificate for serving Webhooks; without it, use a TLS proxy
+	WebhookKeyFile       string                     // TLS key for WebhookCertFile
+	Webhooks             map[string]Webhook         // Authenticated HTTP POSTs that start jobs, see webhooks.go
+	AdminUsers           []string                   // List of users who can access administrative commands
+	Alias                string                     // One-character alias for commands directed at the 'bot, e.g. ';open the pod bay doors'
+	LocalPort            int                        // Port number for listening on localhost, for CLI plugins
+	LogLevel             string                     // Initial log level, can be modified by plugins. One of "trace" "debug" "info" "warn" "error"
 }
 
 type repository struct {
@@ -56,28 +65,32 @@ type repository struct {
 // - Attributes and info that might not be provided by the connector:
 //   - Mapping of protocol internal ID to username
 //   - Additional user attributes such as first / last name, email, etc.
+//   - Other protocol accounts for the same user
 // - Additional information needed by bot internals
 //   - BotUser flag
 type UserInfo struct {
-	UserName            string // name that refers to the user in bot config files
-	UserID              string // unique/persistent ID given to the user by the connector
-	Email, Phone        string // for Get*Attribute()
-	FullName            string // for Get*Attribute()
-	FirstName, LastName string // for Get*Attribute()
-	protoMention        string // robot only, @(mention) string
-	BotUser             bool   // these users aren't checked against MessageMatchers / ambient messages, and never fall-through to "catchalls"
+	UserName            string     // name that refers to the user in bot config files
+	UserID              string     // unique/persistent ID given to the user by the connector
+	Protocol            string     // one of the SecondaryProtocols for the UserID; default is the primary Protocol
+	Identities          []Identity // other accounts for the same user, e.g. on SecondaryProtocols
+	Email, Phone        string     // for Get*Attribute(); Email is also the user's ID for the email connector
+	FullName            string     // for Get*Attribute()
+	FirstName, LastName string     // for Get*Attribute()
+	protoMention        string     // robot only, @(mention) string
+	BotUser             bool       // these users aren't checked against MessageMatchers / ambient messages, and never fall-through to "catchalls"
 }
 
 // ChannelInfo maps channel IDs to channel names when the connector doesn't
 // provide a sensible name for use in configuration files.
 type ChannelInfo struct {
 	ChannelName, ChannelID string // human-readable and protocol-internal channel representations
+	Protocol               string // one of the SecondaryProtocols for the ChannelID; default is the primary Protocol
 }
 
 type userChanMaps struct {
-	userID    map[string]*UserInfo    // Current map of userID to UserInfo struct
+	userID    map[string]*UserInfo    // Current map of userID, qualified for secondary protocols, to UserInfo struct
 	user      map[string]*UserInfo    // Current map of username to UserInfo struct
-	channelID map[string]*ChannelInfo // Current map of channel ID to ChannelInfo struct
+	channelID map[string]*ChannelInfo // Current map of channel ID, qualified for secondary protocols, to ChannelInfo struct
 	channel   map[string]*ChannelInfo // Current map of channel name to ChannelInfo struct
 }
 
@@ -131,14 +144,17 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		var bival *UserInfo
 		var crval []ChannelInfo
 		var tval map[string]ExternalTask
+		var pval map[string]json.RawMessage
 		var stval []ScheduledTask
+		var calval map[string]Calendar
+		var whval map[string]Webhook
 		var mailval botMailer
 		var boolval bool
 		var intval int
 		var val interface{}
 		skip := false
 		switch key {
-		case "AdminContact", "Email", "Protocol", "Brain", "EncryptionKey", "HistoryProvider", "WorkSpace", "DefaultJobChannel", "DefaultElevator", "DefaultAuthorizer", "DefaultMessageFormat", "Name", "Alias", "LogLevel", "TimeZone":
+		case "AdminContact", "Email", "Protocol", "Brain", "EncryptionKey", "BrainBackupDirectory", "BrainBackupSchedule", "HistoryProvider", "WorkSpace", "DefaultJobChannel", "DefaultElevator", "DefaultAuthorizer", "DefaultMessageFormat", "Name", "Alias", "LogLevel", "TimeZone", "WebhookPort", "WebhookCertFile", "WebhookKeyFile":
 			val = &strval
 		case "DefaultAllowDirect", "EncryptBrain":
 			val = &boolval
@@ -148,12 +164,18 @@ func (c *botContext) loadConfig(preConnect bool) error {
 			val = &urval
 		case "ChannelRoster":
 			val = &crval
-		case "LocalPort":
+		case "LocalPort", "MemoryVersions":
 			val = &intval
 		case "ExternalJobs", "ExternalPlugins", "ExternalTasks":
 			val = &tval
+		case "SecondaryProtocols":
+			val = &pval
 		case "ScheduledJobs":
 			val = &stval
+		case "Calendars":
+			val = &calval
+		case "Webhooks":
+			val = &whval
 		case "DefaultChannels", "IgnoreUsers", "JoinChannels", "AdminUsers":
 			val = &sarrval
 		case "MailConfig":
@@ -183,12 +205,20 @@ func (c *botContext) loadConfig(preConnect bool) error {
 			newconfig.Protocol = *(val.(*string))
 		case "ProtocolConfig":
 			newconfig.ProtocolConfig = value
+		case "SecondaryProtocols":
+			newconfig.SecondaryProtocols = *(val.(*map[string]json.RawMessage))
 		case "Brain":
 			newconfig.Brain = *(val.(*string))
 		case "EncryptionKey":
 			newconfig.EncryptionKey = *(val.(*string))
 		case "BrainConfig":
 			newconfig.BrainConfig = value
+		case "BrainBackupDirectory":
+			newconfig.BrainBackupDirectory = *(val.(*string))
+		case "BrainBackupSchedule":
+			newconfig.BrainBackupSchedule = *(val.(*string))
+		case "MemoryVersions":
+			newconfig.MemoryVersions = *(val.(*int))
 		case "HistoryProvider":
 			newconfig.HistoryProvider = *(val.(*string))
 		case "HistoryConfig":
@@ -226,6 +256,16 @@ func (c *botContext) loadConfig(preConnect bool) error {
 			newconfig.ExternalTasks = *(val.(*map[string]ExternalTask))
 		case "ScheduledJobs":
 			newconfig.ScheduledJobs = *(val.(*[]ScheduledTask))
+		case "Calendars":
+			newconfig.Calendars = *(val.(*map[string]Calendar))
+		case "WebhookPort":
+			newconfig.WebhookPort = *(val.(*string))
+		case "WebhookCertFile":
+			newconfig.WebhookCertFile = *(val.(*string))
+		case "WebhookKeyFile":
+			newconfig.WebhookKeyFile = *(val.(*string))
+		case "Webhooks":
+			newconfig.Webhooks = *(val.(*map[string]Webhook))
 		case "AdminUsers":
 			newconfig.AdminUsers = *(val.(*[]string))
 		case "Alias":
@@ -343,15 +383,41 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		}
 		botCfg.externalTasks = et
 	}
+	loc := botCfg.timeZone
+	if loc == nil {
+		loc = time.Local
+	}
 	st := make([]ScheduledTask, 0, len(newconfig.ScheduledJobs))
+	var schedErrs []string
 	for _, s := range newconfig.ScheduledJobs {
-		if len(s.Name) == 0 || len(s.Schedule) == 0 {
-			Log(Error, "Zero-length Name (%s) or Schedule (%s) in ScheduledTask, skipping", s.Name, s.Schedule)
-		} else {
-			st = append(st, s)
+		if err := validateSchedule(&s, loc); err != nil {
+			Log(Error, "Skipping scheduled job: %v", err)
+			schedErrs = append(schedErrs, err.Error())
+			continue
 		}
+		st = append(st, s)
 	}
 	botCfg.ScheduledJobs = st
+	botCfg.scheduleErrors = schedErrs
+	calendars := make(calendarSet)
+	for name, cfg := range newconfig.Calendars {
+		cal, err := loadCalendar(name, cfg, loc)
+		if err != nil {
+			Log(Error, "Loading blackout calendar '%s', jobs using it won't be blacked out: %v", name, err)
+			continue
+		}
+		calendars[name] = cal
+	}
+	botCfg.calendars = calendars
+	hooks := make(webhookSet)
+	for name, hook := range newconfig.Webhooks {
+		if err := validateWebhook(&hook); err != nil {
+			Log(Error, "Skipping webhook '%s': %v", name, err)
+			continue
+		}
+		hooks[name] = hook
+	}
+	botCfg.webhooks = hooks
 	if newconfig.IgnoreUsers != nil {
 		botCfg.ignoreUsers = newconfig.IgnoreUsers
 	}
@@ -365,30 +431,82 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		make(map[string]*ChannelInfo),
 		make(map[string]*ChannelInfo),
 	}
-	usermap := make(map[string]string)
+	// usermaps has the username to user ID map for each protocol
+	usermaps := make(map[string]map[string]string)
 	if len(newconfig.UserRoster) > 0 {
 		for i, user := range newconfig.UserRoster {
 			if len(user.UserName) == 0 || len(user.UserID) == 0 {
 				Log(Error, "one of Username/UserID empty (%s/%s), ignoring", user.UserName, user.UserID)
+			} else if !validRosterProtocol(user.Protocol) {
+				Log(Error, "Protocol '%s' for user '%s' isn't the Protocol or one of the SecondaryProtocols, ignoring", user.Protocol, user.UserName)
 			} else {
 				u := &newconfig.UserRoster[i]
+				if u.Protocol == botCfg.protocol {
+					u.Protocol = ""
+				}
 				ucmaps.user[u.UserName] = u
-				ucmaps.userID[u.UserID] = u
-				usermap[u.UserName] = u.UserID
+				ucmaps.userID[qualify(u.Protocol, u.UserID)] = u
+				if usermaps[u.Protocol] == nil {
+					usermaps[u.Protocol] = make(map[string]string)
+				}
+				usermaps[u.Protocol][u.UserName] = u.UserID
+				for _, id := range u.Identities {
+					if len(id.UserID) == 0 || !validRosterProtocol(id.Protocol) {
+						Log(Error, "Invalid identity '%s:%s' for user '%s', ignoring", id.Protocol, id.UserID, u.UserName)
+						continue
+					}
+					protocol := id.Protocol
+					if protocol == botCfg.protocol {
+						protocol = ""
+					}
+					ucmaps.userID[qualify(protocol, id.UserID)] = u
+					if usermaps[protocol] == nil {
+						usermaps[protocol] = make(map[string]string)
+					}
+					if _, ok := usermaps[protocol][u.UserName]; !ok {
+						usermaps[protocol][u.UserName] = id.UserID
+					}
+				}
+				// on the email protocol, users are known by their Email
+				if protocol, ok := emailProtocol(); ok && len(u.Email) > 0 {
+					id := qualify(protocol, strings.ToLower(u.Email))
+					if _, ok := ucmaps.userID[id]; !ok {
+						ucmaps.userID[id] = u
+					}
+					if usermaps[protocol] == nil {
+						usermaps[protocol] = make(map[string]string)
+					}
+					if _, ok := usermaps[protocol][u.UserName]; !ok {
+						usermaps[protocol][u.UserName] = strings.ToLower(u.Email)
+					}
+				}
 			}
 		}
-		if len(botCfg.botinfo.UserName) > 0 && len(botCfg.botinfo.UserID) > 0 {
-			usermap[botCfg.botinfo.UserName] = botCfg.botinfo.UserID
+		if len(botCfg.botinfo.UserName) > 0 {
+			for protocol, usermap := range usermaps {
+				botID := botCfg.botinfo.UserID
+				if len(protocol) > 0 {
+					botID = secondaryBotID(protocol)
+				}
+				if len(botID) > 0 {
+					usermap[botCfg.botinfo.UserName] = botID
+				}
+			}
 		}
 	}
 	if len(newconfig.ChannelRoster) > 0 {
 		for i, ch := range newconfig.ChannelRoster {
 			if len(ch.ChannelName) == 0 || len(ch.ChannelID) == 0 {
 				Log(Error, "one of ChannelName/ChannelID empty (%s/%s), ignoring", ch.ChannelName, ch.ChannelID)
+			} else if !validRosterProtocol(ch.Protocol) {
+				Log(Error, "Protocol '%s' for channel '%s' isn't the Protocol or one of the SecondaryProtocols, ignoring", ch.Protocol, ch.ChannelName)
 			} else {
 				c := &newconfig.ChannelRoster[i]
+				if c.Protocol == botCfg.protocol {
+					c.Protocol = ""
+				}
 				ucmaps.channel[c.ChannelName] = c
-				ucmaps.channelID[c.ChannelID] = c
+				ucmaps.channelID[qualify(c.Protocol, c.ChannelID)] = c
 			}
 		}
 	}
@@ -408,6 +526,18 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		botCfg.workSpace = configPath
 	}
 
+	botCfg.brainBackupDir = ""
+	if len(newconfig.BrainBackupDirectory) > 0 {
+		if respath, ok := checkDirectory(newconfig.BrainBackupDirectory); ok {
+			botCfg.brainBackupDir = respath
+			Log(Debug, "Setting brain backup directory to '%s'", respath)
+		} else {
+			Log(Error, "BrainBackupDirectory '%s' doesn't exist, brain backups disabled", newconfig.BrainBackupDirectory)
+		}
+	}
+	botCfg.brainBackupSchedule = newconfig.BrainBackupSchedule
+	botCfg.memoryVersions = newconfig.MemoryVersions
+
 	if newconfig.HistoryProvider != "" {
 		botCfg.historyProvider = newconfig.HistoryProvider
 	}
@@ -425,6 +555,7 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		if newconfig.ProtocolConfig != nil {
 			protocolConfig = newconfig.ProtocolConfig
 		}
+		secondaryConfigs = newconfig.SecondaryProtocols
 
 		if newconfig.EncryptBrain {
 			encryptBrain = true
@@ -444,10 +575,11 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		} else {
 			Log(Error, "LocalPort not defined, not exporting GOPHER_HTTP_POST and external tasks will be broken")
 		}
+		botCfg.webhookPort = newconfig.WebhookPort
+		botCfg.webhookCertFile = newconfig.WebhookCertFile
+		botCfg.webhookKeyFile = newconfig.WebhookKeyFile
 	} else {
-		if len(usermap) > 0 {
-			botCfg.SetUserMap(usermap)
-		}
+		setUserMaps(usermaps)
 		// We should never dump the brain key
 		newconfig.EncryptionKey = "XXXXXX"
 		// loadTaskConfig does it's own locking
@@ -480,7 +612,23 @@ func (c *botContext) loadConfig(preConnect bool) error {
 	if !preConnect {
 		updateRegexes()
 		scheduleTasks()
+		watchTasks()
 	}
 
 	return nil
 }
+
+// validRosterProtocol checks the Protocol of a UserRoster or ChannelRoster
+// entry
+func validRosterProtocol(protocol string) bool {
+	return len(protocol) == 0 || protocol == botCfg.protocol || isSecondary(protocol)
+}
+
+// emailProtocol returns the protocol for the email connector, "" when it's
+// the primary Protocol, if it's running
+func emailProtocol() (string, bool) {
+	if botCfg.protocol == "email" {
+		return "", true
+	}
+	return "email", isSecondary("email")
+}
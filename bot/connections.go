@@ -0,0 +1,232 @@
+package bot
+
+/* connections.go - running connectors for SecondaryProtocols alongside the
+primary Protocol. Users and channels on a secondary protocol are qualified
+with the protocol name, e.g. "rocket:general" or "rocket:<userid>", and
+the prefix is removed before calling the connector; names on the primary
+protocol aren't qualified. */
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+)
+
+// ProtocolConfig for each of the SecondaryProtocols, keyed by protocol name
+var secondaryConfigs map[string]json.RawMessage
+
+// connection is a connector for one of the SecondaryProtocols, with what
+// the connector told the robot about itself
+type connection struct {
+	Connector
+	botID   string // the robot's internal ID on this protocol
+	mention string // the robot's @(mention) ID on this protocol
+}
+
+// connections are the connectors for SecondaryProtocols, keyed by protocol
+// name; the primary connector is botCfg.Connector
+var connections = struct {
+	c map[string]*connection
+	sync.RWMutex
+}{
+	make(map[string]*connection),
+	sync.RWMutex{},
+}
+
+// initConnections initializes the connectors for SecondaryProtocols; the
+// primary connector should already be initialized.
+func initConnections(logger *log.Logger) {
+	botCfg.RLock()
+	primary := botCfg.protocol
+	botCfg.RUnlock()
+	for protocol := range secondaryConfigs {
+		if protocol == primary {
+			Log(Fatal, "Secondary protocol '%s' is the same as the primary Protocol", protocol)
+		}
+		initializeConnector, ok := connectors[protocol]
+		if !ok {
+			Log(Fatal, "No connector registered with name: %s", protocol)
+		}
+		conn := &connection{}
+		connections.Lock()
+		connections.c[protocol] = conn
+		connections.Unlock()
+		c := initializeConnector(handler{protocol: protocol}, logger)
+		if c == nil {
+			Log(Fatal, "Unable to initialize connector for secondary protocol '%s'", protocol)
+		}
+		connections.Lock()
+		conn.Connector = c
+		connections.Unlock()
+		Log(Info, "Initialized connector for secondary protocol '%s'", protocol)
+	}
+}
+
+// secondaryConnector returns the connector for a secondary protocol, or
+// nil if it isn't running
+func secondaryConnector(protocol string) Connector {
+	connections.RLock()
+	defer connections.RUnlock()
+	if conn, ok := connections.c[protocol]; ok {
+		return conn.Connector
+	}
+	return nil
+}
+
+// getConnector returns the connector for a protocol; "" is the primary
+func getConnector(protocol string) Connector {
+	if c := secondaryConnector(protocol); c != nil {
+		return c
+	}
+	botCfg.RLock()
+	c := botCfg.Connector
+	botCfg.RUnlock()
+	return c
+}
+
+// isSecondary reports whether a protocol is one of the SecondaryProtocols
+func isSecondary(protocol string) bool {
+	_, ok := secondaryConfigs[protocol]
+	return ok
+}
+
+// qualify returns the name the engine uses for a user or channel name, or
+// "<id>", on a protocol; names on the primary protocol aren't changed.
+func qualify(protocol, name string) string {
+	if len(protocol) == 0 || !isSecondary(protocol) {
+		return name
+	}
+	return protocol + ":" + name
+}
+
+// splitProtocol splits "rocket:general" into "rocket" and "general" when
+// rocket is one of the SecondaryProtocols; anything else belongs to the
+// primary protocol, "".
+func splitProtocol(name string) (protocol, local string) {
+	if i := strings.IndexByte(name, ':'); i > 0 && isSecondary(name[:i]) {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// connectorFor returns the connector for a qualified user or channel, and
+// the name or "<id>" to give it
+func connectorFor(name string) (Connector, string) {
+	protocol, local := splitProtocol(name)
+	return getConnector(protocol), local
+}
+
+// protocolID returns the qualified "<id>" for a user in the UserRoster
+func (u *UserInfo) protocolID() string {
+	return qualify(u.Protocol, bracket(u.UserID))
+}
+
+// protocolID returns the qualified "<id>" for a channel in the
+// ChannelRoster
+func (ch *ChannelInfo) protocolID() string {
+	return qualify(ch.Protocol, bracket(ch.ChannelID))
+}
+
+// secondaryBotID returns the robot's internal ID on a secondary protocol
+func secondaryBotID(protocol string) string {
+	connections.RLock()
+	defer connections.RUnlock()
+	if conn, ok := connections.c[protocol]; ok {
+		return conn.botID
+	}
+	return ""
+}
+
+// botMentions returns the robot's @(mention) IDs on all protocols
+func botMentions() []string {
+	var mentions []string
+	botCfg.RLock()
+	if len(botCfg.botinfo.protoMention) > 0 {
+		mentions = append(mentions, botCfg.botinfo.protoMention)
+	}
+	botCfg.RUnlock()
+	connections.RLock()
+	for _, conn := range connections.c {
+		if len(conn.mention) > 0 {
+			mentions = append(mentions, conn.mention)
+		}
+	}
+	connections.RUnlock()
+	return mentions
+}
+
+// setUserMaps gives each connector the username to user ID mappings for
+// its protocol
+func setUserMaps(usermaps map[string]map[string]string) {
+	for protocol, usermap := range usermaps {
+		if len(usermap) == 0 {
+			continue
+		}
+		if len(protocol) == 0 {
+			botCfg.SetUserMap(usermap)
+		} else if c := secondaryConnector(protocol); c != nil {
+			c.SetUserMap(usermap)
+		}
+	}
+}
+
+// runConnections starts the connector loops for the SecondaryProtocols,
+// returning a WaitGroup for when they've all stopped
+func runConnections(stop <-chan struct{}) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	connections.RLock()
+	for protocol, conn := range connections.c {
+		wg.Add(1)
+		go func(protocol string, conn Connector) {
+			privCheck("connector loop for " + protocol)
+			conn.Run(stop)
+			wg.Done()
+		}(protocol, conn.Connector)
+	}
+	connections.RUnlock()
+	return &wg
+}
+
+// userConnector returns the connector for a user, and the username or
+// "<id>" to give it; users in the UserRoster on a secondary protocol are
+// given by ID.
+func userConnector(user string) (Connector, string) {
+	currentUCMaps.Lock()
+	maps := currentUCMaps.ucmap
+	currentUCMaps.Unlock()
+	if maps != nil {
+		if ui, ok := maps.user[user]; ok && len(ui.Protocol) > 0 {
+			return getConnector(ui.Protocol), bracket(ui.UserID)
+		}
+	}
+	return connectorFor(user)
+}
+
+// channelConnector returns the connector for a channel, and the channel
+// name or "<id>" to give it; channels in the ChannelRoster on a secondary
+// protocol are given by ID.
+func channelConnector(channel string) (Connector, string) {
+	currentUCMaps.Lock()
+	maps := currentUCMaps.ucmap
+	currentUCMaps.Unlock()
+	if maps != nil {
+		if ci, ok := maps.channel[channel]; ok && len(ci.Protocol) > 0 {
+			return getConnector(ci.Protocol), bracket(ci.ChannelID)
+		}
+	}
+	return connectorFor(channel)
+}
+
+// sendUserMessage sends a direct message to a user on any protocol
+func sendUserMessage(user, msg string, f MessageFormat) RetVal {
+	conn, u := userConnector(user)
+	return conn.SendProtocolUserMessage(u, msg, f)
+}
+
+// getProtocolUserAttribute gets an attribute for a user from the user's
+// connector
+func getProtocolUserAttribute(user, attr string) (string, RetVal) {
+	conn, u := userConnector(user)
+	return conn.GetProtocolUserAttribute(u, attr)
+}
@@ -50,6 +50,8 @@ const (
 	spawnedTask
 	scheduled
 	jobCmd // i.e. run job xx
+	fileWatch
+	webhook
 )
 
 //go:generate stringer -type=Protocol constants.go
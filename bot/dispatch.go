@@ -168,7 +168,7 @@ func (c *botContext) checkPluginMatchersAndRun(pipelineType pipelineType) (messa
 		botCfg.RUnlock()
 		// Check to see if user issued a new command when a reply was being
 		// waited on
-		replyMatcher := replyMatcher{c.User, c.Channel}
+		replyMatcher := replyMatcher{c.User, c.Channel, c.threadID()}
 		replies.Lock()
 		waiters, waitingForReply := replies.m[replyMatcher]
 		if waitingForReply {
@@ -203,6 +203,20 @@ func (c *botContext) handleMessage() {
 		emit(BotDirectMessage)
 		Log(Trace, "Bot received a direct message from %s: %s", c.User, c.msg)
 	}
+	if c.Incoming.Choice {
+		// choices picked from buttons or menus only go to reply waiters
+		accepted := c.checkReplies()
+		if !accepted {
+			Log(Debug, "Ignoring choice '%s' from user '%s' with no prompt waiting", c.msg, c.User)
+		}
+		if c.Incoming.Accepted != nil {
+			select {
+			case c.Incoming.Accepted <- accepted:
+			default:
+			}
+		}
+		return
+	}
 	messageMatched := false
 	ts := time.Now()
 	lastMsgContext := memoryContext{"lastMsg", c.User, c.Channel}
@@ -227,33 +241,10 @@ func (c *botContext) handleMessage() {
 		messageMatched = c.checkPluginMatchersAndRun(plugCommand)
 	}
 	// See if the robot was waiting on a reply
-	var waiters []replyWaiter
-	waitingForReply := false
 	if !messageMatched {
-		matcher := replyMatcher{c.User, c.Channel}
-		Log(Trace, "Checking replies for matcher: %q", matcher)
-		replies.Lock()
-		waiters, waitingForReply = replies.m[matcher]
-		if !waitingForReply {
-			replies.Unlock()
-		} else {
-			delete(replies.m, matcher)
-			replies.Unlock()
-			// if the robot was waiting on a reply, we don't want to check for
-			// ambient message matches - the plugin will handle it.
-			messageMatched = true
-			for i, rep := range waiters {
-				if i == 0 {
-					cmsg := spaceRe.ReplaceAllString(c.msg, " ")
-					matched := rep.re.MatchString(cmsg)
-					Log(Debug, "Found replyWaiter for user '%s' in channel '%s', checking if message '%s' matches '%s': %t", c.User, c.Channel, cmsg, rep.re.String(), matched)
-					rep.replyChannel <- reply{matched, replied, cmsg}
-				} else {
-					Log(Debug, "Sending retry to next reply waiter")
-					rep.replyChannel <- reply{false, retryPrompt, ""}
-				}
-			}
-		}
+		// if the robot was waiting on a reply, we don't want to check for
+		// ambient message matches - the plugin will handle it.
+		messageMatched = c.checkReplies()
 	}
 	// Direct commands were checked above; if a direct command didn't match,
 	// and a there wasn't a reply being waited on, then we check ambient
@@ -310,3 +301,36 @@ func (c *botContext) handleMessage() {
 		shortTermMemories.Unlock()
 	}
 }
+
+// checkReplies sends the message to the plugin waiting for a reply from the
+// user in the channel / thread, if any, and tells any other waiters to retry.
+// A Choice only goes to a waiter it matches.
+func (c *botContext) checkReplies() bool {
+	matcher := replyMatcher{c.User, c.Channel, c.threadID()}
+	Log(Trace, "Checking replies for matcher: %q", matcher)
+	replies.Lock()
+	waiters, waitingForReply := replies.m[matcher]
+	if !waitingForReply {
+		replies.Unlock()
+		return false
+	}
+	if c.Incoming.Choice && !waiters[0].re.MatchString(spaceRe.ReplaceAllString(c.msg, " ")) {
+		// a choice from another prompt's buttons isn't a reply to this one
+		replies.Unlock()
+		return false
+	}
+	delete(replies.m, matcher)
+	replies.Unlock()
+	for i, rep := range waiters {
+		if i == 0 {
+			cmsg := spaceRe.ReplaceAllString(c.msg, " ")
+			matched := rep.re.MatchString(cmsg)
+			Log(Debug, "Found replyWaiter for user '%s' in channel '%s', checking if message '%s' matches '%s': %t", c.User, c.Channel, cmsg, rep.re.String(), matched)
+			rep.replyChannel <- reply{matched, replied, cmsg}
+		} else {
+			Log(Debug, "Sending retry to next reply waiter")
+			rep.replyChannel <- reply{false, retryPrompt, ""}
+		}
+	}
+	return true
+}
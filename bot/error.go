@@ -108,4 +108,11 @@ const (
 	CommandNotMatched
 	// TaskDisabled - a method call attempted to add a disabled task to a pipeline
 	TaskDisabled
+	// DatumChanged - UpdateDatumVersion failed because the datum was updated or locked
+	DatumChanged
+
+	/* Connector capabilities */
+
+	// Unsupported - the connector doesn't support the operation, e.g. file uploads
+	Unsupported
 )
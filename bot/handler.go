@@ -6,8 +6,11 @@ import (
 	"strings"
 )
 
-// an empty object type for passing a Handler to the connector.
-type handler struct{}
+// an object type for passing a Handler to the connector; protocol is set
+// for the connectors of SecondaryProtocols.
+type handler struct {
+	protocol string
+}
 
 /* Handle incoming messages and other callbacks from the connector. */
 
@@ -53,8 +56,21 @@ type ConnectorMessage struct {
 	DirectMessage bool
 	// MessageText - sanitized message text, with all protocol-added junk removed
 	MessageText string
+	// ThreadID - the thread the message was posted in, "" if not in a thread;
+	// MessageID - protocol ID of the message, for starting a new thread
+	ThreadID, MessageID string
+	// Choice - set when MessageText is a choice the user picked from a
+	// prompt's buttons or menu; see ChoiceConnector
+	Choice bool
+	// Accepted - optional for a Choice; the engine sends true if the
+	// choice went to a prompt waiting for it, false otherwise. Needs a
+	// buffer of one, the engine doesn't block sending.
+	Accepted chan<- bool
 	// MessageObject, Client - interfaces for the raw
 	MessageObject, Client interface{}
+	// protocol - set by the engine to the secondary protocol the message
+	// came from, "" for the primary
+	protocol string
 }
 
 // ChannelMessage accepts an incoming channel message from the connector.
@@ -73,26 +89,36 @@ func (h handler) IncomingMessage(inc *ConnectorMessage) {
 
 	/* Make sure some form of User and Channel are set
 	 */
-	ProtocolChannel = bracket(inc.ChannelID)
+	// Users and channels on secondary protocols are qualified with the
+	// protocol name, unless listed in the UserRoster / ChannelRoster
+	inc.protocol = h.protocol
+	ProtocolChannel = qualify(h.protocol, bracket(inc.ChannelID))
 	if !inc.DirectMessage {
-		if cn, ok := maps.channelID[inc.ChannelID]; ok {
+		if cn, ok := maps.channelID[qualify(h.protocol, inc.ChannelID)]; ok {
 			channelName = cn.ChannelName
 		} else if len(inc.ChannelName) > 0 {
-			channelName = inc.ChannelName
+			channelName = qualify(h.protocol, inc.ChannelName)
 		} else if len(inc.ChannelID) > 0 {
-			channelName = bracket(inc.ChannelID)
+			channelName = ProtocolChannel
 		}
 	} // ProtocolChannel / channelName should be "" for DM
-	ProtocolUser = bracket(inc.UserID)
+	ProtocolUser = qualify(h.protocol, bracket(inc.UserID))
 	listedUser := false
-	if un, ok := maps.userID[inc.UserID]; ok {
+	if un, ok := maps.userID[qualify(h.protocol, inc.UserID)]; ok {
 		userName = un.UserName
 		BotUser = un.BotUser
 		listedUser = true
+	} else if un, ok := linkedUser(qualify(h.protocol, inc.UserID)); ok && len(inc.UserID) > 0 {
+		// an identity linked to a username with 'link identity'
+		userName = un
+		if ui, ok := maps.user[un]; ok {
+			BotUser = ui.BotUser
+			listedUser = true
+		}
 	} else if len(inc.UserName) > 0 {
-		userName = inc.UserName
+		userName = qualify(h.protocol, inc.UserName)
 	} else {
-		userName = bracket(inc.UserID)
+		userName = ProtocolUser
 	}
 
 	messageFull := inc.MessageText
@@ -190,6 +216,9 @@ func (h handler) IncomingMessage(inc *ConnectorMessage) {
 
 // GetProtocolConfig unmarshals the connector's configuration data into a provided struct
 func (h handler) GetProtocolConfig(v interface{}) error {
+	if len(h.protocol) > 0 {
+		return json.Unmarshal(secondaryConfigs[h.protocol], v)
+	}
 	botCfg.RLock()
 	err := json.Unmarshal(protocolConfig, v)
 	botCfg.RUnlock()
@@ -219,6 +248,12 @@ func (h handler) Log(l LogLevel, m string, v ...interface{}) {
 
 // SetBotID let's the connector set the bot's internal ID
 func (h handler) SetBotID(id string) {
+	if len(h.protocol) > 0 {
+		connections.Lock()
+		connections.c[h.protocol].botID = id
+		connections.Unlock()
+		return
+	}
 	botCfg.Lock()
 	botCfg.botinfo.UserID = id
 	botCfg.Unlock()
@@ -229,6 +264,14 @@ func (h handler) SetBotMention(m string) {
 	if len(m) == 0 {
 		return
 	}
+	if len(h.protocol) > 0 {
+		Log(Info, "secondary protocol '%s' set bot mention string to: %s", h.protocol, m)
+		connections.Lock()
+		connections.c[h.protocol].mention = m
+		connections.Unlock()
+		updateRegexes()
+		return
+	}
 	Log(Info, "protocol set bot mention string to: %s", m)
 	botCfg.Lock()
 	botCfg.botinfo.protoMention = m
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type jsonFunction struct {
@@ -19,6 +21,7 @@ type jsonFunction struct {
 	Format   string
 	Protocol string
 	CallerID string
+	Threaded bool
 	FuncArgs json.RawMessage
 }
 
@@ -88,12 +91,16 @@ type memory struct {
 	Key   string
 	Token string
 	Datum json.RawMessage
+	TTL   *int // seconds until the memory expires, 0 for never; unset keeps the expiration
+	// Version from CheckoutDatum, for UpdateDatumVersion
+	Version string
 }
 
 // Something to be recalled from long term memory
 type recollection struct {
-	Key string
-	RW  bool
+	Key     string
+	RW      bool
+	Timeout int // seconds to hold a read-write lock, 0 for the default
 }
 
 // Request for exclusive execution
@@ -115,6 +122,17 @@ type userchannelmessage struct {
 	Base64  bool
 }
 
+// A file to upload; the Content is always base64, and Base64 means the
+// Filename and Title are too
+type filemessage struct {
+	User     string
+	Channel  string
+	Filename string
+	Title    string
+	Content  string
+	Base64   bool
+}
+
 type replyrequest struct {
 	RegexID string
 	User    string
@@ -123,6 +141,15 @@ type replyrequest struct {
 	Base64  bool
 }
 
+// A prompt with a list of choices
+type choicerequest struct {
+	User    string
+	Channel string
+	Prompt  string
+	Choices []string
+	Base64  bool
+}
+
 type extns struct {
 	Extend    string
 	Histories int
@@ -161,6 +188,7 @@ type botretvalresponse struct {
 
 type checkoutresponse struct {
 	LockToken string
+	Version   string
 	Exists    bool
 	Datum     interface{}
 	RetVal    int
@@ -238,8 +266,13 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		ProtocolChannel: c.ProtocolChannel,
 		Protocol:        setProtocol(f.Protocol),
 		Incoming:        c.Incoming,
+		ThreadID:        c.threadID(),
+		threadChannel:   c.Channel,
 		id:              c.id,
 	}
+	if f.Threaded {
+		r = *r.Threaded()
+	}
 	if len(f.Format) > 0 {
 		r.Format = setFormat(f.Format)
 	} else {
@@ -353,9 +386,16 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			return
 		}
 		var datum interface{}
-		l, e, brv := r.CheckoutDatum(rec.Key, &datum, rec.RW)
+		var l, v string
+		var e bool
+		brv := InvalidDatumKey
+		if !strings.ContainsRune(rec.Key, ':') {
+			timeout := time.Duration(rec.Timeout) * time.Second
+			l, v, e, brv = checkoutDatumFull(r.datumKey(rec.Key), &datum, rec.RW, timeout)
+		}
 		sendReturn(rw, checkoutresponse{
 			LockToken: l,
+			Version:   v,
 			Exists:    e,
 			Datum:     datum,
 			RetVal:    int(brv),
@@ -377,9 +417,25 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		var key string
 		task, _, _ := getTask(c.currentTask)
 		key = task.NameSpace + ":" + m.Key
+		ttl := time.Duration(-1)
+		if m.TTL != nil {
+			ttl = time.Duration(*m.TTL) * time.Second
+		}
 		// Since we're getting raw JSON (=[]byte), we call update directly.
 		// See brain.go
-		ret = update(key, m.Token, (*[]byte)(&m.Datum))
+		ret = update(key, m.Token, (*[]byte)(&m.Datum), ttl, r.User)
+		sendReturn(rw, &botretvalresponse{int(ret)})
+		return
+	case "UpdateDatumVersion":
+		var m memory
+		if !getArgs(rw, &f.FuncArgs, &m) {
+			return
+		}
+		if strings.ContainsRune(m.Key, ':') {
+			sendReturn(rw, &botretvalresponse{int(InvalidDatumKey)})
+			return
+		}
+		ret = updateVersion(r.datumKey(m.Key), m.Version, (*[]byte)(&m.Datum), -1, r.User)
 		sendReturn(rw, &botretvalresponse{int(ret)})
 		return
 	case "Remember":
@@ -489,6 +545,28 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			int(r.SendUserMessage(um.User, um.Message)),
 		})
 		return
+	case "SendChannelFile", "SendUserFile":
+		var fm filemessage
+		if !getArgs(rw, &f.FuncArgs, &fm) {
+			return
+		}
+		if fm.Base64 {
+			fm.Filename = decode(fm.Filename)
+			fm.Title = decode(fm.Title)
+		}
+		content, err := base64.StdEncoding.DecodeString(fm.Content)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			Log(Error, "Unable to decode base64 content for file '%s': %v", fm.Filename, err)
+			return
+		}
+		if f.FuncName == "SendChannelFile" {
+			ret = r.SendChannelFile(fm.Channel, fm.Filename, fm.Title, content)
+		} else {
+			ret = r.SendUserFile(fm.User, fm.Filename, fm.Title, content)
+		}
+		sendReturn(rw, &botretvalresponse{int(ret)})
+		return
 	case "PromptUserChannelForReply":
 		var rr replyrequest
 		if !getArgs(rw, &f.FuncArgs, &rr) {
@@ -497,10 +575,26 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		if rr.Base64 {
 			rr.Prompt = decode(rr.Prompt)
 		}
-		reply, ret = r.promptInternal(rr.RegexID, rr.User, rr.Channel, rr.Prompt)
+		reply, ret = r.promptInternal(rr.RegexID, rr.User, rr.Channel, r.thread(rr.Channel), rr.Prompt)
+		sendReturn(rw, &replyresponse{reply, int(ret)})
+		return
+	case "PromptUserChannelForChoice":
+		var cr choicerequest
+		if !getArgs(rw, &f.FuncArgs, &cr) {
+			return
+		}
+		if cr.Base64 {
+			cr.Prompt = decode(cr.Prompt)
+			for i := range cr.Choices {
+				cr.Choices[i] = decode(cr.Choices[i])
+			}
+		}
+		// scripts time out waiting on the http request after a minute
+		reply, ret = r.promptChoice(cr.User, cr.Channel, r.thread(cr.Channel), cr.Prompt, cr.Choices, replyTimeout)
 		sendReturn(rw, &replyresponse{reply, int(ret)})
 		return
-	// NOTE: "Say", "Reply", PromptForReply and PromptUserForReply are implemented
+	// NOTE: "Say", "Reply", "SendFile", PromptForReply, PromptUserForReply,
+	// PromptForChoice and PromptUserForChoice are implemented
 	// in the scripting libraries
 	default:
 		Log(Error, "Bad function name: %s", f.FuncName)
@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/* identities.go - mapping several protocol accounts to one username, so
+groups, admin rights, elevation and brain data keyed by username follow the
+person rather than the account. Identities come from the UserRoster, or are
+linked by an administrator and stored in the brain. Identities are
+"<id>" for the primary protocol, or "protocol:<id>" for one of the
+SecondaryProtocols. */
+
+const identitiesKey = "bot:identities"
+
+// Identity is another protocol account for a user in the UserRoster
+type Identity struct {
+	Protocol string // one of the SecondaryProtocols; default is the primary Protocol
+	UserID   string // unique/persistent ID given to the user by the connector
+}
+
+// linkedIdentities maps qualified user IDs to usernames; loaded from the
+// brain on first use, since the brain may not be ready (encrypted) when the
+// first message arrives.
+var linkedIdentities = struct {
+	m      map[string]string
+	loaded bool
+	sync.Mutex
+}{}
+
+// loadLinkedIdentities must be called with linkedIdentities locked
+func loadLinkedIdentities() bool {
+	if linkedIdentities.loaded {
+		return true
+	}
+	var linked map[string]string
+	_, _, ret := checkoutDatum(identitiesKey, &linked, false)
+	if ret != Ok {
+		Log(Debug, "Unable to load linked identities: %s", ret)
+		return false
+	}
+	if linked == nil {
+		linked = make(map[string]string)
+	}
+	linkedIdentities.m = linked
+	linkedIdentities.loaded = true
+	return true
+}
+
+// linkedUser returns the username linked to a qualified user ID, if any
+func linkedUser(id string) (string, bool) {
+	linkedIdentities.Lock()
+	defer linkedIdentities.Unlock()
+	if !loadLinkedIdentities() {
+		return "", false
+	}
+	user, ok := linkedIdentities.m[id]
+	return user, ok
+}
+
+// parseIdentity turns "<id>" or "protocol:<id>" into the qualified user ID
+// used as a key in the user maps.
+func parseIdentity(identity string) (string, error) {
+	protocol, local := splitProtocol(identity)
+	id, ok := ExtractID(local)
+	if !ok || len(id) == 0 {
+		return "", fmt.Errorf("identity '%s' should be '<id>' or 'protocol:<id>'", identity)
+	}
+	return qualify(protocol, id), nil
+}
+
+// formatIdentity is the reverse of parseIdentity
+func formatIdentity(id string) string {
+	protocol, local := splitProtocol(id)
+	return qualify(protocol, bracket(local))
+}
+
+// setLinkedIdentity links (or unlinks, when user is "") a qualified user
+// ID, recording the change in the brain; it returns the username the ID
+// was linked to before.
+func setLinkedIdentity(id, user string) (string, error) {
+	linkedIdentities.Lock()
+	defer linkedIdentities.Unlock()
+	var linked map[string]string
+	tok, _, ret := checkoutDatum(identitiesKey, &linked, true)
+	if ret != Ok {
+		return "", fmt.Errorf("checking out linked identities: %s", ret)
+	}
+	if linked == nil {
+		linked = make(map[string]string)
+	}
+	prev := linked[id]
+	if len(user) > 0 {
+		linked[id] = user
+	} else {
+		delete(linked, id)
+	}
+	if ret := updateDatum(identitiesKey, tok, linked); ret != Ok {
+		return "", fmt.Errorf("updating linked identities: %s", ret)
+	}
+	linkedIdentities.m = linked
+	linkedIdentities.loaded = true
+	return prev, nil
+}
+
+// listIdentities lists the identities for a user, or all users when user
+// is "", marking the ones from the UserRoster.
+func listIdentities(maps *userChanMaps, user string) []string {
+	var il []string
+	for id, ui := range maps.userID {
+		if len(user) == 0 || ui.UserName == user {
+			il = append(il, fmt.Sprintf("%s: %s (UserRoster)", ui.UserName, formatIdentity(id)))
+		}
+	}
+	linkedIdentities.Lock()
+	if loadLinkedIdentities() {
+		for id, u := range linkedIdentities.m {
+			if len(user) == 0 || u == user {
+				il = append(il, fmt.Sprintf("%s: %s", u, formatIdentity(id)))
+			}
+		}
+	}
+	linkedIdentities.Unlock()
+	sort.Strings(il)
+	return il
+}
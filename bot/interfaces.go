@@ -81,3 +81,63 @@ type Connector interface {
 	// The Run method starts the main loop and takes a channel for stopping it.
 	Run(stopchannel <-chan struct{})
 }
+
+// ReactionConnector is optionally implemented by connectors for protocols
+// with emoji reactions. The reaction is a name like "white_check_mark",
+// and messageID comes from a ConnectorMessage.
+type ReactionConnector interface {
+	// AddProtocolReaction adds a reaction to a message in a channel
+	AddProtocolReaction(channelname, messageID, reaction string) RetVal
+}
+
+// ReactionRemover is optionally implemented by ReactionConnectors that can
+// also take a reaction back off a message.
+type ReactionRemover interface {
+	// RemoveProtocolReaction removes a reaction the robot added to a message
+	RemoveProtocolReaction(channelname, messageID, reaction string) RetVal
+}
+
+// EditConnector is optionally implemented by connectors for protocols that
+// allow editing messages after they're sent.
+type EditConnector interface {
+	// SendProtocolChannelMessageHandle sends a message to a channel, or
+	// thread if not "", and returns a handle for updating it
+	SendProtocolChannelMessageHandle(channelname, thread, msg string, format MessageFormat) (handle string, ret RetVal)
+	// UpdateProtocolMessage replaces the text of a message sent with
+	// SendProtocolChannelMessageHandle
+	UpdateProtocolMessage(channelname, handle, msg string, format MessageFormat) RetVal
+}
+
+// ThreadedConnector is optionally implemented by connectors for protocols
+// with threaded conversations. The thread is the ThreadID or MessageID from
+// a ConnectorMessage.
+type ThreadedConnector interface {
+	// SendProtocolChannelThreadMessage sends a message to a thread in a channel
+	SendProtocolChannelThreadMessage(channelname, thread, msg string, format MessageFormat) RetVal
+	// SendProtocolUserChannelThreadMessage directs a message to a user in a
+	// thread
+	SendProtocolUserChannelThreadMessage(userid, username, channelname, thread, msg string, format MessageFormat) RetVal
+}
+
+// FileConnector is optionally implemented by connectors that can upload
+// files; filename is the name shown to users, and title is optional.
+type FileConnector interface {
+	// SendProtocolChannelFile uploads a file to a channel, in a thread if
+	// thread isn't ""
+	SendProtocolChannelFile(channelname, thread, filename, title string, content []byte) RetVal
+	// SendProtocolUserFile uploads a file to a user in a direct message
+	SendProtocolUserFile(username, filename, title string, content []byte) RetVal
+}
+
+// ChoiceConnector is optionally implemented by connectors that can offer a
+// prompt's choices as buttons or a menu. When the user picks one, the
+// connector sends an incoming message from the user with Choice set, and
+// the choice in MessageText. The methods return Unsupported if interactive
+// messages aren't configured, and the robot sends a numbered list instead.
+type ChoiceConnector interface {
+	// SendProtocolUserChannelChoices prompts a user in a channel, in a
+	// thread if thread isn't ""
+	SendProtocolUserChannelChoices(userid, username, channelname, thread, prompt string, choices []string, format MessageFormat) RetVal
+	// SendProtocolUserChoices prompts a user in a direct message
+	SendProtocolUserChoices(username, prompt string, choices []string, format MessageFormat) RetVal
+}
@@ -0,0 +1,103 @@
+package bot
+
+/* job_notices.go - job start and finish notifications. When the connector
+can edit messages, the start message is updated with the finish status
+instead of posting a second message; when it supports reactions, the
+command or trigger that started the job gets a reaction for the status.
+The running reaction is only added when the connector can remove it again
+at the finish; otherwise only the finish reaction is added. */
+
+// Reactions for job status
+const (
+	reactJobRunning = "hourglass_flowing_sand"
+	reactJobSuccess = "white_check_mark"
+	reactJobFailed  = "x"
+)
+
+// jobNotice is a job start message that can be updated in place
+type jobNotice struct {
+	conn                  EditConnector
+	channel, handle, text string
+}
+
+// jobChannelID returns the protocol channel for the job channel
+func (c *botContext) jobChannelID() string {
+	if ci, ok := c.maps.channel[c.jobChannel]; ok {
+		return ci.protocolID()
+	}
+	return c.jobChannel
+}
+
+// reactionConnector returns the connector for the message that started the
+// job, if any, and whether it can remove reactions
+func (c *botContext) reactionConnector() (rc ReactionConnector, removes bool) {
+	if c.reactTo == nil {
+		return nil, false
+	}
+	conn := getConnector(c.reactTo.protocol)
+	rc, ok := conn.(ReactionConnector)
+	if !ok {
+		return nil, false
+	}
+	_, removes = conn.(ReactionRemover)
+	return rc, removes
+}
+
+// react adds a reaction to the message that started the job, if any
+func (c *botContext) react(reaction string) {
+	rc, _ := c.reactionConnector()
+	if rc == nil {
+		return
+	}
+	if ret := rc.AddProtocolReaction(bracket(c.reactTo.ChannelID), c.reactTo.MessageID, reaction); ret != Ok {
+		Log(Debug, "Unable to add reaction '%s' for job '%s': %s", reaction, c.jobName, ret)
+	}
+}
+
+// unreact removes a reaction added with react, if the connector can
+func (c *botContext) unreact(reaction string) {
+	rc, removes := c.reactionConnector()
+	if !removes {
+		return
+	}
+	rr := rc.(ReactionRemover)
+	if ret := rr.RemoveProtocolReaction(bracket(c.reactTo.ChannelID), c.reactTo.MessageID, reaction); ret != Ok {
+		Log(Debug, "Unable to remove reaction '%s' for job '%s': %s", reaction, c.jobName, ret)
+	}
+}
+
+// jobStartNotice posts the job start message
+func (c *botContext) jobStartNotice(r *Robot, msg string) {
+	if _, removes := c.reactionConnector(); removes {
+		c.react(reactJobRunning)
+	}
+	conn, channel := channelConnector(c.jobChannelID())
+	if ec, ok := conn.(EditConnector); ok {
+		handle, ret := ec.SendProtocolChannelMessageHandle(channel, r.thread(c.jobChannel), msg, r.Format)
+		if ret == Ok {
+			c.notice = &jobNotice{ec, channel, handle, msg}
+			return
+		}
+		Log(Debug, "Unable to send updatable start message for job '%s': %s", c.jobName, ret)
+	}
+	r.SendChannelMessage(c.jobChannel, msg)
+}
+
+// jobFinishNotice updates the start message with the finish message if
+// possible, otherwise posts it
+func (c *botContext) jobFinishNotice(r *Robot, success bool, msg string) {
+	c.unreact(reactJobRunning)
+	if success {
+		c.react(reactJobSuccess)
+	} else {
+		c.react(reactJobFailed)
+	}
+	if c.notice != nil {
+		n := c.notice
+		c.notice = nil
+		if ret := n.conn.UpdateProtocolMessage(n.channel, n.handle, n.text+"\n"+msg, r.Format); ret == Ok {
+			return
+		}
+	}
+	r.SendChannelMessage(c.jobChannel, msg)
+}
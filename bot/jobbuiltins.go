@@ -66,6 +66,83 @@ func jobcommands(r *Robot, command string, args ...string) (retval TaskRetVal) {
 			return
 		}
 		r.Say(strings.Join(jl, "\n"))
+	case "runat", "runevery":
+		var jobName, argstr string
+		s := adhocSchedule{
+			User:    r.User,
+			Channel: r.Channel,
+			Created: time.Now().UTC(),
+		}
+		var err error
+		if command == "runat" {
+			jobName, argstr = args[0], args[1]
+			s.At, err = oneShotTime(args[2], args[3])
+		} else {
+			jobName, argstr = args[2], args[3]
+			s.Spec, err = recurringSpec(args[0], args[1])
+		}
+		if err != nil {
+			r.Say(fmt.Sprintf("Sorry, I couldn't schedule that: %v", err))
+			return
+		}
+		c := r.getContext()
+		jobArgs, ok := c.checkAdhocJob(jobName, strings.TrimSpace(argstr))
+		if !ok {
+			return
+		}
+		s.Job = jobName
+		s.Args = jobArgs
+		id, err := addAdhocSchedule(s, c.tasks, c.repositories)
+		if err != nil {
+			r.Log(Error, "Adding ad-hoc schedule for job '%s' by user '%s': %v", jobName, r.User, err)
+			if id == 0 {
+				r.Say("Sorry, there was a problem storing that schedule, check with an administrator")
+				return
+			}
+		}
+		r.Log(Audit, "User '%s' scheduled job '%s' in channel '%s', id %d", r.User, jobName, r.Channel, id)
+		if command == "runat" {
+			r.Say(fmt.Sprintf("Ok, I'll run '%s' at %s (scheduled run %d)", jobName, s.At.Format("Mon Jan 2 15:04 MST"), id))
+		} else {
+			r.Say(fmt.Sprintf("Ok, I'll run '%s' every %s at %s (scheduled run %d)", jobName, strings.ToLower(args[0]), args[1], id))
+		}
+	case "listruns":
+		all := len(args[0]) > 0
+		if all && !r.CheckAdmin() {
+			r.Say("Sorry, only administrators can list everyone's scheduled runs")
+			return
+		}
+		sl, ret := getAdhocSchedules()
+		if ret != Ok {
+			r.Say("Sorry, I had a problem retrieving scheduled runs, check with an administrator")
+			return
+		}
+		var lines []string
+		for _, s := range sl.Schedules {
+			if all {
+				lines = append(lines, s.describe()+" - user: "+s.User)
+			} else if s.User == r.User {
+				lines = append(lines, s.describe())
+			}
+		}
+		if len(lines) == 0 {
+			r.Say("There are no scheduled runs")
+			return
+		}
+		r.Fixed().Say("Scheduled runs:\n" + strings.Join(lines, "\n"))
+	case "cancelrun":
+		id, _ := strconv.Atoi(args[0])
+		found, err := cancelAdhocSchedule(id, r.User, r.CheckAdmin())
+		if err != nil {
+			r.Say(fmt.Sprintf("Sorry, I couldn't cancel that: %v", err))
+			return
+		}
+		if !found {
+			r.Say(fmt.Sprintf("I don't have a scheduled run %d", id))
+			return
+		}
+		r.Log(Audit, "User '%s' canceled scheduled run %d", r.User, id)
+		r.Say(fmt.Sprintf("Ok, I canceled scheduled run %d", id))
 	}
 	return
 }
@@ -28,6 +28,19 @@ func (mb *memBrain) Retrieve(k string) (*[]byte, bool, error) {
 	return datum, false, nil
 }
 
+func (mb *memBrain) List() ([]string, error) {
+	keys := make([]string, 0, len(mb.memories))
+	for k := range mb.memories {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (mb *memBrain) Delete(k string) error {
+	delete(mb.memories, k)
+	return nil
+}
+
 // The file brain doesn't need the logger, but other brains might
 func provider(r Handler, _ *log.Logger) SimpleBrain {
 	mb := &memBrain{
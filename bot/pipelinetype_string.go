@@ -16,11 +16,13 @@ func _() {
 	_ = x[spawnedTask-5]
 	_ = x[scheduled-6]
 	_ = x[jobCmd-7]
+	_ = x[fileWatch-8]
+	_ = x[webhook-9]
 }
 
-const _pipelineType_name = "unsetplugCommandplugMessagecatchAlljobTriggerspawnedTaskscheduledjobCmd"
+const _pipelineType_name = "unsetplugCommandplugMessagecatchAlljobTriggerspawnedTaskscheduledjobCmdfileWatchwebhook"
 
-var _pipelineType_index = [...]uint8{0, 5, 16, 27, 35, 45, 56, 65, 71}
+var _pipelineType_index = [...]uint8{0, 5, 16, 27, 35, 45, 56, 65, 71, 80, 87}
 
 func (i pipelineType) String() string {
 	if i < 0 || i >= pipelineType(len(_pipelineType_index)-1) {
@@ -31,6 +31,11 @@ and think hard before doing things any differently.
 
 const replyTimeout = 45 * time.Second
 
+// choiceTimeout is how long Go plugins wait for a choice; picking from
+// buttons or a menu can take longer than typing a reply, and Go plugins
+// aren't waiting on an http request. Scripts still use replyTimeout.
+const choiceTimeout = 3 * time.Minute
+
 type replyDisposition int
 
 const (
@@ -47,7 +52,7 @@ type replyWaiter struct {
 
 // a reply matcher is used as the key in the replys map
 type replyMatcher struct {
-	user, channel string // Only one reply at a time can be requested for a given user/channel combination
+	user, channel, thread string // Only one reply at a time can be requested for a given user/channel/thread combination
 }
 
 // a reply is sent over the replyWaiter channel when a user replies
@@ -120,7 +125,7 @@ func (r *Robot) PromptForReply(regexID string, prompt string) (string, RetVal) {
 	var rep string
 	var ret RetVal
 	for i := 0; i < 3; i++ {
-		rep, ret = r.promptInternal(regexID, r.User, r.Channel, prompt)
+		rep, ret = r.promptInternal(regexID, r.User, r.Channel, r.thread(r.Channel), prompt)
 		if ret == RetryPrompt {
 			continue
 		}
@@ -138,7 +143,7 @@ func (r *Robot) PromptUserForReply(regexID string, user string, prompt string) (
 	var rep string
 	var ret RetVal
 	for i := 0; i < 3; i++ {
-		rep, ret = r.promptInternal(regexID, user, "", prompt)
+		rep, ret = r.promptInternal(regexID, user, "", "", prompt)
 		if ret == RetryPrompt {
 			continue
 		}
@@ -156,7 +161,7 @@ func (r *Robot) PromptUserChannelForReply(regexID string, user string, channel s
 	var rep string
 	var ret RetVal
 	for i := 0; i < 3; i++ {
-		rep, ret = r.promptInternal(regexID, user, channel, prompt)
+		rep, ret = r.promptInternal(regexID, user, channel, r.thread(channel), prompt)
 		if ret == RetryPrompt {
 			continue
 		}
@@ -169,11 +174,7 @@ func (r *Robot) PromptUserChannelForReply(regexID string, user string, channel s
 }
 
 // promptInternal can return 'RetryPrompt'
-func (r *Robot) promptInternal(regexID string, user string, channel string, prompt string) (string, RetVal) {
-	matcher := replyMatcher{
-		user:    user,
-		channel: channel,
-	}
+func (r *Robot) promptInternal(regexID string, user string, channel string, thread string, prompt string) (string, RetVal) {
 	var rep replyWaiter
 	task, _, job := getTask(r.getContext().currentTask)
 	isJob := job != nil
@@ -200,6 +201,18 @@ func (r *Robot) promptInternal(regexID string, user string, channel string, prom
 		Log(Error, "Unable to resolve a reply matcher for plugin %s, regexID %s", task.name, regexID)
 		return "", MatcherNotFound
 	}
+	return r.awaitReply(rep.re, nil, user, channel, thread, prompt, replyTimeout)
+}
+
+// awaitReply sends the prompt, with any choices, and waits up to timeout
+// for a reply matching re from the user; it can return 'RetryPrompt'
+func (r *Robot) awaitReply(re *regexp.Regexp, choices []string, user, channel, thread, prompt string, timeout time.Duration) (string, RetVal) {
+	matcher := replyMatcher{
+		user:    user,
+		channel: channel,
+		thread:  thread,
+	}
+	rep := replyWaiter{re: re}
 	rep.replyChannel = make(chan reply)
 
 	replies.Lock()
@@ -216,16 +229,11 @@ func (r *Robot) promptInternal(regexID string, user string, channel string, prom
 		c := r.getContext()
 		var puser string
 		if ui, ok := c.maps.user[user]; ok {
-			puser = bracket(ui.UserID)
+			puser = ui.protocolID()
 		} else {
 			puser = user
 		}
-		var ret RetVal
-		if channel == "" {
-			ret = botCfg.SendProtocolUserMessage(puser, prompt, r.Format)
-		} else {
-			ret = botCfg.SendProtocolUserChannelMessage(puser, user, channel, prompt, r.Format)
-		}
+		ret := sendPrompt(puser, user, channel, thread, prompt, choices, r.Format)
 		if ret != Ok {
 			replies.Unlock()
 			return "", ret
@@ -237,8 +245,8 @@ func (r *Robot) promptInternal(regexID string, user string, channel string, prom
 	}
 	var replied reply
 	select {
-	case <-time.After(replyTimeout):
-		Log(Warn, "Timed out waiting for a reply to regex \"%s\" in channel: %s", regexID, r.Channel)
+	case <-time.After(timeout):
+		Log(Warn, "Timed out waiting for a reply to regex \"%s\" in channel: %s", re.String(), r.Channel)
 		replies.Lock()
 		waitlist, found := replies.m[matcher]
 		if found {
@@ -29,6 +29,8 @@ type Robot struct {
 	Protocol        Protocol          // slack, terminal, test, others; used for interpreting rawmsg or sending messages with Format = 'Raw'
 	Incoming        *ConnectorMessage // raw struct of message sent by connector; interpret based on protocol. For Slack this is a *slack.MessageEvent
 	Format          MessageFormat     // The outgoing message format, one of Raw, Fixed, or Variable
+	ThreadID        string            // The thread the message was received in, if any; Say, Reply and prompts in the same channel go to the thread
+	threadChannel   string            // The channel the ThreadID belongs to
 	id              int               // For looking up the botContext
 }
 
@@ -237,6 +239,32 @@ func (r *Robot) MessageFormat(f MessageFormat) *Robot {
 	return &nr
 }
 
+// Threaded returns a Robot that sends messages in a thread; if the
+// incoming message wasn't already in a thread, Say, Reply and prompts start
+// a new thread from it. Protocols without threads ignore this.
+func (r *Robot) Threaded() *Robot {
+	nr := *r
+	if len(nr.ThreadID) == 0 && nr.Incoming != nil {
+		// replies on protocols without threads never carry the ThreadID,
+		// so a prompt waiting in the thread would never hear them
+		if conn, _ := channelConnector(nr.Channel); conn != nil {
+			if _, ok := conn.(ThreadedConnector); ok {
+				nr.ThreadID = nr.Incoming.MessageID
+			}
+		}
+	}
+	nr.threadChannel = nr.Channel
+	return &nr
+}
+
+// thread returns the ThreadID for messages sent to channel
+func (r *Robot) thread(channel string) string {
+	if len(channel) == 0 || channel != r.threadChannel {
+		return ""
+	}
+	return r.ThreadID
+}
+
 // Direct is a convenience function for initiating a DM conversation with a
 // user. Created initially so a plugin could prompt for a password in a DM.
 func (r *Robot) Direct() *Robot {
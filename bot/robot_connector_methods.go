@@ -16,7 +16,7 @@ func (r *Robot) GetUserAttribute(u, a string) *AttrRet {
 	var ui *UserInfo
 	var ok bool
 	if ui, ok = c.maps.user[u]; ok {
-		user = "<" + ui.UserID + ">"
+		user = ui.protocolID()
 	} else {
 		user = u
 	}
@@ -42,7 +42,7 @@ func (r *Robot) GetUserAttribute(u, a string) *AttrRet {
 			return &AttrRet{attr, Ok}
 		}
 	}
-	attr, ret := botCfg.GetProtocolUserAttribute(user, a)
+	attr, ret := getProtocolUserAttribute(user, a)
 	return &AttrRet{attr, ret}
 }
 
@@ -56,7 +56,9 @@ func (c *botContext) messageHeard() {
 	if len(channel) == 0 {
 		channel = c.Channel
 	}
-	botCfg.MessageHeard(user, channel)
+	conn, user := userConnector(user)
+	_, channel = splitProtocol(channel)
+	conn.MessageHeard(user, channel)
 }
 
 // GetSenderAttribute returns a AttrRet with
@@ -98,13 +100,13 @@ func (r *Robot) GetSenderAttribute(a string) *AttrRet {
 	if len(user) == 0 {
 		user = r.User
 	}
-	attr, ret := botCfg.GetProtocolUserAttribute(user, a)
+	attr, ret := getProtocolUserAttribute(user, a)
 	return &AttrRet{attr, ret}
 }
 
 // SendChannelMessage lets a plugin easily send a message to an arbitrary
 // channel. Use Robot.Fixed().SendChannelMessage(...) for fixed-width
-// font.
+// font. Messages to the channel the Robot is in go to it's thread, if any.
 func (r *Robot) SendChannelMessage(ch, msg string) RetVal {
 	if len(msg) == 0 {
 		r.Log(Warn, "Ignoring zero-length message in SendChannelMessage")
@@ -113,11 +115,11 @@ func (r *Robot) SendChannelMessage(ch, msg string) RetVal {
 	c := r.getContext()
 	var channel string
 	if ci, ok := c.maps.channel[ch]; ok {
-		channel = bracket(ci.ChannelID)
+		channel = ci.protocolID()
 	} else {
 		channel = ch
 	}
-	return botCfg.SendProtocolChannelMessage(channel, msg, r.Format)
+	return sendChannelMessage(channel, r.thread(ch), msg, r.Format)
 }
 
 // SendUserChannelMessage lets a plugin easily send a message directed to
@@ -133,17 +135,17 @@ func (r *Robot) SendUserChannelMessage(u, ch, msg string) RetVal {
 	c := r.getContext()
 	var user string
 	if ui, ok := c.maps.user[u]; ok {
-		user = bracket(ui.UserID)
+		user = ui.protocolID()
 	} else {
 		user = u
 	}
 	var channel string
 	if ci, ok := c.maps.channel[ch]; ok {
-		channel = bracket(ci.ChannelID)
+		channel = ci.protocolID()
 	} else {
 		channel = ch
 	}
-	return botCfg.SendProtocolUserChannelMessage(user, u, channel, msg, r.Format)
+	return sendUserChannelMessage(user, u, channel, r.thread(ch), msg, r.Format)
 }
 
 // SendUserMessage lets a plugin easily send a DM to a user. If a DM
@@ -157,11 +159,11 @@ func (r *Robot) SendUserMessage(u, msg string) RetVal {
 	c := r.getContext()
 	var user string
 	if ui, ok := c.maps.user[u]; ok {
-		user = bracket(ui.UserID)
+		user = ui.protocolID()
 	} else {
 		user = u
 	}
-	return botCfg.SendProtocolUserMessage(user, msg, r.Format)
+	return sendUserMessage(user, msg, r.Format)
 }
 
 // Reply directs a message to the user
@@ -176,17 +178,18 @@ func (r *Robot) Reply(msg string) RetVal {
 	}
 	// Support for Direct()
 	if r.Channel == "" {
-		return botCfg.SendProtocolUserMessage(user, msg, r.Format)
+		return sendUserMessage(user, msg, r.Format)
 	}
 	channel := r.ProtocolChannel
 	if len(channel) == 0 {
 		channel = r.Channel
 	}
+	thread := r.thread(r.Channel)
 	c := r.getContext()
 	if c != nil && c.BotUser {
-		return botCfg.SendProtocolChannelMessage(r.Channel, r.User+": "+msg, r.Format)
+		return sendChannelMessage(r.Channel, thread, r.User+": "+msg, r.Format)
 	}
-	return botCfg.SendProtocolUserChannelMessage(user, r.User, r.Channel, msg, r.Format)
+	return sendUserChannelMessage(user, r.User, r.Channel, thread, msg, r.Format)
 }
 
 // Say just sends a message to the user or channel
@@ -201,11 +204,92 @@ func (r *Robot) Say(msg string) RetVal {
 		if len(user) == 0 {
 			user = r.User
 		}
-		return botCfg.SendProtocolUserMessage(user, msg, r.Format)
+		return sendUserMessage(user, msg, r.Format)
 	}
 	channel := r.ProtocolChannel
 	if len(channel) == 0 {
 		channel = r.Channel
 	}
-	return botCfg.SendProtocolChannelMessage(channel, msg, r.Format)
+	return sendChannelMessage(channel, r.thread(r.Channel), msg, r.Format)
+}
+
+// SendChannelFile uploads a file to a channel, or the Robot's thread for
+// the channel it's in; title is optional. Returns Unsupported if the
+// connector can't upload files.
+func (r *Robot) SendChannelFile(ch, filename, title string, content []byte) RetVal {
+	c := r.getContext()
+	var channel string
+	if ci, ok := c.maps.channel[ch]; ok {
+		channel = ci.protocolID()
+	} else {
+		channel = ch
+	}
+	conn, channel := connectorFor(channel)
+	fc, ok := conn.(FileConnector)
+	if !ok {
+		return Unsupported
+	}
+	if len(filename) == 0 {
+		r.Log(Warn, "Ignoring file with zero-length filename in SendChannelFile")
+		return FailedMessageSend
+	}
+	return fc.SendProtocolChannelFile(channel, r.thread(ch), filename, title, content)
+}
+
+// SendUserFile uploads a file to a user in a direct message. Returns
+// Unsupported if the connector can't upload files.
+func (r *Robot) SendUserFile(u, filename, title string, content []byte) RetVal {
+	c := r.getContext()
+	var user string
+	if ui, ok := c.maps.user[u]; ok {
+		user = ui.protocolID()
+	} else {
+		user = u
+	}
+	conn, user := connectorFor(user)
+	fc, ok := conn.(FileConnector)
+	if !ok {
+		return Unsupported
+	}
+	if len(filename) == 0 {
+		r.Log(Warn, "Ignoring file with zero-length filename in SendUserFile")
+		return FailedMessageSend
+	}
+	return fc.SendProtocolUserFile(user, filename, title, content)
+}
+
+// SendFile uploads a file to the current channel or thread, or to the user
+// for Direct()
+func (r *Robot) SendFile(filename, title string, content []byte) RetVal {
+	if r.Channel == "" {
+		return r.SendUserFile(r.User, filename, title, content)
+	}
+	return r.SendChannelFile(r.Channel, filename, title, content)
+}
+
+// sendChannelMessage sends to a thread if given and the connector supports
+// threads, otherwise to the channel; the channel's protocol determines the
+// connector.
+func sendChannelMessage(channel, thread, msg string, f MessageFormat) RetVal {
+	conn, channel := channelConnector(channel)
+	if len(thread) > 0 {
+		if tc, ok := conn.(ThreadedConnector); ok {
+			return tc.SendProtocolChannelThreadMessage(channel, thread, msg, f)
+		}
+	}
+	return conn.SendProtocolChannelMessage(channel, msg, f)
+}
+
+// sendUserChannelMessage is the threaded version of
+// SendProtocolUserChannelMessage
+func sendUserChannelMessage(userid, username, channel, thread, msg string, f MessageFormat) RetVal {
+	conn, channel := channelConnector(channel)
+	_, userid = splitProtocol(userid)
+	_, username = splitProtocol(username)
+	if len(thread) > 0 {
+		if tc, ok := conn.(ThreadedConnector); ok {
+			return tc.SendProtocolUserChannelThreadMessage(userid, username, channel, thread, msg, f)
+		}
+	}
+	return conn.SendProtocolUserChannelMessage(userid, username, channel, msg, f)
 }
@@ -25,6 +25,18 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 	task, _, job := getTask(t)
 	privCheck(fmt.Sprintf("task %s / %s", task.name, command))
 	isJob := job != nil
+	if isJob && len(job.Blackouts) > 0 {
+		var deferred func()
+		// only top-level pipelines can be deferred; c is finished by then,
+		// so the deferred run gets a clone
+		if parent == nil {
+			dc := c.clone()
+			deferred = func() { dc.startPipeline(nil, t, ptype, command, args...) }
+		}
+		if checkBlackout(task.name, "job:"+task.name, task.Channel, job.Blackouts, job.BlackoutPolicy, deferred) {
+			return Fail
+		}
+	}
 	ppipeName := c.pipeName
 	ppipeDesc := c.pipeDesc
 	c.pipeName = task.name
@@ -113,6 +125,10 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 				}
 			}
 		}
+		if c.runStarted != nil {
+			c.runStarted <- c.runIndex
+			c.runStarted = nil
+		}
 		for _, p := range task.Parameters {
 			_, exists := c.environment[p.Name]
 			if !exists {
@@ -121,6 +137,10 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 		}
 		if !job.Quiet || c.verbose {
 			r := c.makeRobot()
+			// react to the command or trigger that started the job
+			if parent == nil && (ptype == jobCmd || ptype == jobTrigger) && c.Incoming != nil && len(c.Incoming.MessageID) > 0 {
+				c.reactTo = c.Incoming
+			}
 			iChannel := c.Channel // channel where job was triggered / run
 			taskinfo := task.name
 			if len(args) > 0 {
@@ -134,15 +154,19 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 			}
 			switch ptype {
 			case jobTrigger:
-				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Starting job '%s', run %d%s - triggered by app '%s' in channel '%s'", taskinfo, c.runIndex, link, c.User, iChannel))
+				c.jobStartNotice(r, fmt.Sprintf("Starting job '%s', run %d%s - triggered by app '%s' in channel '%s'", taskinfo, c.runIndex, link, c.User, iChannel))
 			case jobCmd:
-				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Starting job '%s', run %d%s - requested by user '%s' in channel '%s'", taskinfo, c.runIndex, link, c.User, iChannel))
+				c.jobStartNotice(r, fmt.Sprintf("Starting job '%s', run %d%s - requested by user '%s' in channel '%s'", taskinfo, c.runIndex, link, c.User, iChannel))
 			case spawnedTask:
-				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Starting job '%s', run %d%s - spawned by pipeline '%s': %s", taskinfo, c.runIndex, link, ppipeName, ppipeDesc))
+				c.jobStartNotice(r, fmt.Sprintf("Starting job '%s', run %d%s - spawned by pipeline '%s': %s", taskinfo, c.runIndex, link, ppipeName, ppipeDesc))
 			case scheduled:
-				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Starting scheduled job '%s', run %d%s", taskinfo, c.runIndex, link))
+				c.jobStartNotice(r, fmt.Sprintf("Starting scheduled job '%s', run %d%s", taskinfo, c.runIndex, link))
+			case fileWatch:
+				c.jobStartNotice(r, fmt.Sprintf("Starting job '%s', run %d%s - triggered by a file change", taskinfo, c.runIndex, link))
+			case webhook:
+				c.jobStartNotice(r, fmt.Sprintf("Starting job '%s', run %d%s - triggered by webhook '%s'", taskinfo, c.runIndex, link, c.environment["GOPHER_WEBHOOK"]))
 			default:
-				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Starting job '%s', run %d%s", taskinfo, c.runIndex, link))
+				c.jobStartNotice(r, fmt.Sprintf("Starting job '%s', run %d%s", taskinfo, c.runIndex, link))
 			}
 			c.verbose = true
 		}
@@ -177,7 +201,7 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 	if isJob && (!job.Quiet || ret != Normal) {
 		r := c.makeRobot()
 		if ret == Normal {
-			r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Finished job '%s', run %d, final task '%s', status: %s", c.pipeName, c.runIndex, c.taskName, ret))
+			c.jobFinishNotice(r, true, fmt.Sprintf("Finished job '%s', run %d, final task '%s', status: %s", c.pipeName, c.runIndex, c.taskName, ret))
 		} else {
 			var td string
 			if len(c.failedTaskDescription) > 0 {
@@ -188,9 +212,9 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 				jobName += ":" + c.nsExtension
 			}
 			if ret == PipelineAborted {
-				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Job '%s', run number %d aborted, job '%s' already in progress", jobName, c.runIndex, c.exclusiveTag))
+				c.jobFinishNotice(r, false, fmt.Sprintf("Job '%s', run number %d aborted, job '%s' already in progress", jobName, c.runIndex, c.exclusiveTag))
 			} else {
-				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Job '%s', run number %d failed in task: '%s'%s, exit code: %s", jobName, c.runIndex, c.failedTask, td, ret))
+				c.jobFinishNotice(r, false, fmt.Sprintf("Job '%s', run number %d failed in task: '%s'%s, exit code: %s", jobName, c.runIndex, c.failedTask, td, ret))
 			}
 		}
 	}
@@ -293,7 +317,7 @@ func (c *botContext) runPipeline(ptype pipelineType, initialRun bool) (ret TaskR
 				emit(AmbientTaskRan)
 			case catchAll:
 				emit(CatchAllTaskRan)
-			case jobTrigger:
+			case jobTrigger, fileWatch, webhook:
 				emit(TriggeredTaskRan)
 			case spawnedTask:
 				emit(SpawnedTaskRan)
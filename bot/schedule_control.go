@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* schedule_control.go - listing, pausing and resuming ScheduledJobs, and
+running a scheduled job immediately. Paused schedules are stored in the
+brain, so they stay paused across restarts and reloads. */
+
+const pausedSchedulesKey = "bot:pausedSchedules"
+
+// pausedSchedules maps schedule IDs to the user that paused them; loaded
+// from the brain on first use, since the brain may not be ready (encrypted)
+// when jobs are first scheduled.
+var pausedSchedules = struct {
+	m      map[string]string
+	loaded bool
+	sync.Mutex
+}{}
+
+// loadPausedSchedules must be called with pausedSchedules locked
+func loadPausedSchedules() bool {
+	if pausedSchedules.loaded {
+		return true
+	}
+	var paused map[string]string
+	_, _, ret := checkoutDatum(pausedSchedulesKey, &paused, false)
+	if ret != Ok {
+		Log(Error, "Unable to load paused schedules: %s", ret)
+		return false
+	}
+	if paused == nil {
+		paused = make(map[string]string)
+	}
+	pausedSchedules.m = paused
+	pausedSchedules.loaded = true
+	return true
+}
+
+// schedulePaused reports whether a schedule is paused; when paused
+// schedules can't be loaded from the brain, e.g. an encrypted brain that
+// hasn't been unlocked, schedules run, so jobs like brain backups don't
+// silently stop.
+func schedulePaused(id string) bool {
+	pausedSchedules.Lock()
+	defer pausedSchedules.Unlock()
+	if !loadPausedSchedules() {
+		Log(Error, "Running schedule '%s', unable to check whether it's paused", id)
+		return false
+	}
+	_, paused := pausedSchedules.m[id]
+	return paused
+}
+
+// setSchedulePaused pauses or resumes a schedule, recording the change in
+// the brain.
+func setSchedulePaused(id, user string, pause bool) error {
+	pausedSchedules.Lock()
+	defer pausedSchedules.Unlock()
+	var paused map[string]string
+	tok, _, ret := checkoutDatum(pausedSchedulesKey, &paused, true)
+	if ret != Ok {
+		return fmt.Errorf("checking out paused schedules: %s", ret)
+	}
+	if paused == nil {
+		paused = make(map[string]string)
+	}
+	if pause {
+		paused[id] = user
+	} else {
+		delete(paused, id)
+	}
+	if ret := updateDatum(pausedSchedulesKey, tok, paused); ret != Ok {
+		return fmt.Errorf("updating paused schedules: %s", ret)
+	}
+	pausedSchedules.m = paused
+	pausedSchedules.loaded = true
+	return nil
+}
+
+// findSchedule looks up a schedule by ID, or by job name when the job has
+// only one schedule
+func findSchedule(id string) *scheduledJob {
+	schedMutex.Lock()
+	defer schedMutex.Unlock()
+	var named []*scheduledJob
+	for _, sj := range schedules {
+		if sj.id == id {
+			return sj
+		}
+		if sj.ts.Name == id {
+			named = append(named, sj)
+		}
+	}
+	if len(named) == 1 {
+		return named[0]
+	}
+	return nil
+}
+
+// listSchedules reports each schedule with its next and previous run times,
+// in the configured TimeZone
+func listSchedules() []string {
+	schedMutex.Lock()
+	if taskRunner == nil || len(schedules) == 0 {
+		schedMutex.Unlock()
+		return nil
+	}
+	entries := taskRunner.Entries()
+	loc := taskRunner.Location()
+	sl := make([]*scheduledJob, len(schedules))
+	copy(sl, schedules)
+	schedMutex.Unlock()
+
+	pausedSchedules.Lock()
+	loaded := loadPausedSchedules()
+	paused := make(map[string]string)
+	for id, user := range pausedSchedules.m {
+		paused[id] = user
+	}
+	pausedSchedules.Unlock()
+
+	const layout = "Mon Jan 2 15:04 MST"
+	lines := make([]string, 0, len(sl)+1)
+	if !loaded {
+		lines = append(lines, "(unable to load paused schedules from the brain; all schedules are running)")
+	}
+	for _, sj := range sl {
+		sloc := loc
+		if sj.st.location != nil {
+			sloc = sj.st.location
+		}
+		fmtTime := func(t time.Time) string {
+			if t.IsZero() {
+				return "never"
+			}
+			return t.In(sloc).Format(layout)
+		}
+		var next, prev time.Time
+		for _, e := range entries {
+			if e.Job == sj {
+				next, prev = e.Next, e.Prev
+				break
+			}
+		}
+		args := ""
+		if len(sj.ts.Arguments) > 0 {
+			args = " " + strings.Join(sj.ts.Arguments, " ")
+		}
+		policy := ""
+		if len(sj.st.CatchUp) > 0 {
+			policy += ", catch up: " + sj.st.CatchUp
+		}
+		if len(sj.st.Overlap) > 0 {
+			policy += ", overlap: " + sj.st.Overlap
+		}
+		if len(sj.st.TimeZone) > 0 {
+			policy += ", timezone: " + sj.st.TimeZone
+		}
+		if len(sj.st.Jitter) > 0 {
+			policy += ", jitter: " + sj.st.Jitter
+		}
+		status := "next: " + fmtTime(next)
+		if user, ok := paused[sj.id]; ok {
+			status = "PAUSED by " + user
+		}
+		lines = append(lines, fmt.Sprintf("%s%s (%s%s) - %s, last: %s", sj.id, args, sj.schedule, policy, status, fmtTime(prev)))
+	}
+	return lines
+}
+
+// runScheduleNow starts a scheduled job immediately, even when paused
+func runScheduleNow(id string) bool {
+	sj := findSchedule(id)
+	if sj == nil {
+		return false
+	}
+	go sj.runWithPolicy()
+	return true
+}
@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+/* schedule_policy.go - catching up runs of ScheduledJobs missed while the
+robot was down, and the Overlap policy for when a scheduled run is still
+going when the next one fires. The last fire time of each schedule is stored
+in the brain for catching up. */
+
+const scheduleLastRunKey = "bot:scheduleLastRun"
+
+const defaultCatchUpWindow = 24 * time.Hour
+
+// Never catch up more than this many runs of a single schedule
+const maxCatchUpRuns = 100
+
+// scheduleRuns tracks running and queued runs for each schedule ID
+var scheduleRuns = struct {
+	running map[string]int
+	queued  map[string]bool
+	sync.Mutex
+}{
+	running: make(map[string]int),
+	queued:  make(map[string]bool),
+}
+
+// runWithPolicy runs a scheduled job, applying the schedule's Overlap policy
+// if a previous run hasn't finished.
+func (sj *scheduledJob) runWithPolicy() {
+	scheduleRuns.Lock()
+	if scheduleRuns.running[sj.id] > 0 {
+		switch sj.st.Overlap {
+		case "skip":
+			scheduleRuns.Unlock()
+			Log(Warn, "Skipping run of schedule '%s', the previous run is still going", sj.id)
+			return
+		case "queue":
+			if scheduleRuns.queued[sj.id] {
+				scheduleRuns.Unlock()
+				Log(Warn, "Skipping run of schedule '%s', a run is already queued", sj.id)
+				return
+			}
+			scheduleRuns.queued[sj.id] = true
+			scheduleRuns.Unlock()
+			Log(Info, "Queueing run of schedule '%s' until the previous run finishes", sj.id)
+			return
+		}
+	}
+	scheduleRuns.running[sj.id]++
+	scheduleRuns.Unlock()
+	for {
+		runScheduledTask(sj.t, sj.ts, sj.tasks, sj.repolist)
+		scheduleRuns.Lock()
+		if scheduleRuns.queued[sj.id] {
+			delete(scheduleRuns.queued, sj.id)
+			scheduleRuns.Unlock()
+			Log(Info, "Starting queued run of schedule '%s'", sj.id)
+			continue
+		}
+		scheduleRuns.running[sj.id]--
+		scheduleRuns.Unlock()
+		return
+	}
+}
+
+// recordScheduleRun stores the time a schedule fired
+func recordScheduleRun(id string, fired time.Time) {
+	var last map[string]time.Time
+	tok, _, ret := checkoutDatum(scheduleLastRunKey, &last, true)
+	if ret != Ok {
+		Log(Error, "Unable to record last run of schedule '%s': %s", id, ret)
+		return
+	}
+	if last == nil {
+		last = make(map[string]time.Time)
+	}
+	last[id] = fired.UTC()
+	if ret := updateDatum(scheduleLastRunKey, tok, last); ret != Ok {
+		Log(Error, "Unable to record last run of schedule '%s': %s", id, ret)
+	}
+}
+
+// missedRuns counts the times sched should have fired after prev, looking
+// back no further than window, and returns the latest.
+func missedRuns(sched cron.Schedule, prev, now time.Time, window time.Duration) (int, time.Time) {
+	start := prev
+	if earliest := now.Add(-window); start.Before(earliest) {
+		start = earliest
+	}
+	missed := 0
+	var latest time.Time
+	for next := sched.Next(start); !next.IsZero() && !next.After(now); next = sched.Next(next) {
+		missed++
+		latest = next
+		if missed == maxCatchUpRuns {
+			break
+		}
+	}
+	return missed, latest
+}
+
+// catchUpSchedules runs schedules with CatchUp set that were missed since
+// they last fired. Schedules that have never fired are recorded, so runs
+// missed in the future can be caught up.
+func catchUpSchedules(sl []*scheduledJob, loc *time.Location) {
+	var last map[string]time.Time
+	tok, _, ret := checkoutDatum(scheduleLastRunKey, &last, true)
+	if ret != Ok {
+		Log(Warn, "Unable to check for missed scheduled runs (%s), they'll be checked when the brain is available", ret)
+		return
+	}
+	if last == nil {
+		last = make(map[string]time.Time)
+	}
+	now := time.Now().In(loc)
+	type catchUp struct {
+		sj    *scheduledJob
+		count int
+	}
+	var runs []catchUp
+	for _, sj := range sl {
+		prev, ok := last[sj.id]
+		if !ok {
+			last[sj.id] = now.UTC()
+			continue
+		}
+		if len(sj.st.CatchUp) == 0 {
+			continue
+		}
+		missed, latest := missedRuns(sj.st.sched, prev.In(loc), now, sj.st.catchUpWindow)
+		if missed == 0 {
+			continue
+		}
+		last[sj.id] = latest.UTC()
+		if sj.st.CatchUp == "once" {
+			missed = 1
+		}
+		runs = append(runs, catchUp{sj, missed})
+	}
+	if ret := updateDatum(scheduleLastRunKey, tok, last); ret != Ok {
+		Log(Error, "Unable to record last run of schedules: %s", ret)
+	}
+	for _, cu := range runs {
+		if schedulePaused(cu.sj.id) {
+			Log(Info, "Not catching up missed runs of paused schedule '%s'", cu.sj.id)
+			continue
+		}
+		go func(cu catchUp) {
+			for i := 1; i <= cu.count; i++ {
+				Log(Info, "Catching up missed run %d of %d for schedule '%s'", i, cu.count, cu.sj.id)
+				cu.sj.runWithPolicy()
+			}
+		}(cu)
+	}
+}
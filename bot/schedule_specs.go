@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+/* schedule_specs.go - parsing and validating ScheduledJobs specs, with
+per-schedule timezones and jitter. */
+
+// zoneSchedule computes activation times in a schedule's own TimeZone,
+// regardless of the location of the cron runner.
+type zoneSchedule struct {
+	cron.Schedule
+	loc *time.Location
+}
+
+// Next returns the next activation time in the schedule's TimeZone
+func (z zoneSchedule) Next(t time.Time) time.Time {
+	return z.Schedule.Next(t.In(z.loc))
+}
+
+// jitterSchedule delays each activation by a random amount up to max, to
+// spread out jobs scheduled for the same time.
+type jitterSchedule struct {
+	cron.Schedule
+	max time.Duration
+}
+
+// Next returns the next activation time plus jitter
+func (j jitterSchedule) Next(t time.Time) time.Time {
+	next := j.Schedule.Next(t)
+	if next.IsZero() {
+		return next
+	}
+	return next.Add(time.Duration(rand.Int63n(int64(j.max))))
+}
+
+// parseSchedule parses a cron spec, or a descriptor like "@hourly" or
+// "@every 90s", evaluated in loc. Specs start with seconds, "sec min hour
+// dom month [dow]"; only the day-of-week is optional, so a 5-field spec
+// like "0 30 * * *" runs every hour at half past, not every day at 00:30.
+func parseSchedule(spec string, loc *time.Location) (cron.Schedule, error) {
+	sched, err := cron.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	// "@every" schedules are relative to the last run, and don't need a zone
+	if _, ok := sched.(cron.ConstantDelaySchedule); ok {
+		return sched, nil
+	}
+	return zoneSchedule{sched, loc}, nil
+}
+
+// validateSchedule checks a ScheduledTask and fills in the parsed schedule,
+// location, jitter and catch-up window; defloc is the robot's TimeZone.
+func validateSchedule(s *ScheduledTask, defloc *time.Location) error {
+	if len(s.Name) == 0 || len(s.Schedule) == 0 {
+		return fmt.Errorf("zero-length Name (%s) or Schedule (%s) in ScheduledTask", s.Name, s.Schedule)
+	}
+	switch s.CatchUp {
+	case "", "once", "all":
+	default:
+		return fmt.Errorf("invalid CatchUp '%s' for scheduled job '%s', must be 'once' or 'all'", s.CatchUp, s.Name)
+	}
+	switch s.Overlap {
+	case "", "skip", "queue", "concurrent":
+	default:
+		return fmt.Errorf("invalid Overlap '%s' for scheduled job '%s', must be 'skip', 'queue' or 'concurrent'", s.Overlap, s.Name)
+	}
+	s.catchUpWindow = defaultCatchUpWindow
+	if len(s.CatchUpWindow) > 0 {
+		w, err := time.ParseDuration(s.CatchUpWindow)
+		if err != nil || w <= 0 {
+			return fmt.Errorf("invalid CatchUpWindow '%s' for scheduled job '%s'", s.CatchUpWindow, s.Name)
+		}
+		s.catchUpWindow = w
+	}
+	s.location = defloc
+	if len(s.TimeZone) > 0 {
+		loc, err := time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return fmt.Errorf("invalid TimeZone '%s' for scheduled job '%s': %v", s.TimeZone, s.Name, err)
+		}
+		s.location = loc
+	}
+	if len(s.Jitter) > 0 {
+		j, err := time.ParseDuration(s.Jitter)
+		if err != nil || j <= 0 {
+			return fmt.Errorf("invalid Jitter '%s' for scheduled job '%s'", s.Jitter, s.Name)
+		}
+		s.jitter = j
+	}
+	sched, err := parseSchedule(s.Schedule, s.location)
+	if err != nil {
+		return fmt.Errorf("invalid Schedule '%s' for scheduled job '%s': %v", s.Schedule, s.Name, err)
+	}
+	s.sched = sched
+	if s.jitter > 0 {
+		// Jitter at least as long as the schedule would push runs past
+		// the next activation
+		if interval := minInterval(sched, time.Now()); interval > 0 && s.jitter >= interval {
+			Log(Warn, "Jitter '%s' for scheduled job '%s' isn't shorter than the schedule interval, limiting it to %v", s.Jitter, s.Name, interval/2)
+			s.jitter = interval / 2
+		}
+	}
+	return nil
+}
+
+// intervalSamples is how many activations minInterval checks
+const intervalSamples = 32
+
+// minInterval returns the shortest time between the next activations of a
+// schedule, or 0 if it never runs again.
+func minInterval(sched cron.Schedule, from time.Time) time.Duration {
+	var min time.Duration
+	prev := sched.Next(from)
+	if prev.IsZero() {
+		return 0
+	}
+	for i := 0; i < intervalSamples; i++ {
+		next := sched.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if d := next.Sub(prev); min == 0 || d < min {
+			min = d
+		}
+		prev = next
+	}
+	return min
+}
@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2019, 3, 4, 10, 0, 0, 0, time.UTC) // a Monday
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		// seconds come first; a 5-field spec leaves out the day-of-week
+		{"0 30 * * *", time.Date(2019, 3, 4, 10, 30, 0, 0, time.UTC)},
+		{"0 30 2 * * *", time.Date(2019, 3, 5, 2, 30, 0, 0, time.UTC)},
+		{"0 0 9 * * SAT", time.Date(2019, 3, 9, 9, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2019, 3, 4, 11, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range tests {
+		sched, err := parseSchedule(tc.spec, time.UTC)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", tc.spec, err)
+			continue
+		}
+		if next := sched.Next(from); !next.Equal(tc.next) {
+			t.Errorf("parseSchedule(%q).Next() = %v, want %v", tc.spec, next, tc.next)
+		}
+	}
+	if _, err := parseSchedule("30 2 * *", time.UTC); err == nil {
+		t.Error("parseSchedule accepted a 4-field spec")
+	}
+}
+
+func TestMinInterval(t *testing.T) {
+	from := time.Date(2019, 3, 4, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec     string
+		interval time.Duration
+	}{
+		{"0 */15 * * * *", 15 * time.Minute},
+		{"0 0 2 * * *", 24 * time.Hour},
+		{"0 0 9 * * MON-FRI", 24 * time.Hour},
+		{"0 0 8,20 * * *", 12 * time.Hour},
+		{"0 0 0 1 * *", 28 * 24 * time.Hour},
+		{"@every 90s", 90 * time.Second},
+	}
+	for _, tc := range tests {
+		sched, err := parseSchedule(tc.spec, time.UTC)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", tc.spec, err)
+			continue
+		}
+		if interval := minInterval(sched, from); interval != tc.interval {
+			t.Errorf("minInterval(%q) = %v, want %v", tc.spec, interval, tc.interval)
+		}
+	}
+}
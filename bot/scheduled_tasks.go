@@ -1,7 +1,10 @@
 package bot
 
 import (
+	"fmt"
+	"hash/fnv"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron"
 )
@@ -9,6 +12,68 @@ import (
 var taskRunner *cron.Cron
 var schedMutex sync.Mutex
 
+// schedules lists the ScheduledJobs loaded in to taskRunner, protected by
+// schedMutex
+var schedules []*scheduledJob
+
+// scheduleErrors lists problems with ScheduledJobs found the last time
+// tasks were scheduled, protected by schedMutex
+var scheduleErrors []string
+
+// scheduledJob is a cron.Job for an entry in ScheduledJobs
+type scheduledJob struct {
+	id       string // job name and a hash of the schedule, see scheduleID
+	schedule string
+	st       ScheduledTask
+	t        interface{}
+	ts       TaskSpec
+	tasks    taskList
+	repolist map[string]repository
+}
+
+// Run is called by the cron runner, and skips paused schedules
+func (sj *scheduledJob) Run() {
+	recordScheduleRun(sj.id, time.Now())
+	if schedulePaused(sj.id) {
+		Log(Info, "Skipping run of paused schedule '%s'", sj.id)
+		return
+	}
+	if len(sj.st.Blackouts) > 0 {
+		task, _, job := getTask(sj.t)
+		policy := ""
+		if job != nil {
+			policy = job.BlackoutPolicy
+		}
+		if checkBlackout(sj.id, "schedule:"+sj.id, task.Channel, sj.st.Blackouts, policy, sj.runDeferred) {
+			return
+		}
+	}
+	sj.runWithPolicy()
+}
+
+// runDeferred runs a schedule deferred by a blackout, unless it was paused
+// in the meantime
+func (sj *scheduledJob) runDeferred() {
+	if schedulePaused(sj.id) {
+		Log(Info, "Skipping deferred run of paused schedule '%s'", sj.id)
+		return
+	}
+	sj.runWithPolicy()
+}
+
+// scheduleID names a schedule by its job and a short hash of the schedule,
+// TimeZone and arguments, e.g. "updatecfg@1f3a9c"; the ID stays the same
+// when ScheduledJobs are reordered, so paused schedules and catch-up times
+// stored in the brain still apply.
+func scheduleID(st ScheduledTask) string {
+	h := fnv.New32a()
+	h.Write([]byte(st.Schedule + "\x00" + st.TimeZone))
+	for _, arg := range st.Arguments {
+		h.Write([]byte("\x00" + arg))
+	}
+	return fmt.Sprintf("%s@%06x", st.Name, h.Sum32()&0xffffff)
+}
+
 func scheduleTasks() {
 	schedMutex.Lock()
 	if taskRunner != nil {
@@ -17,7 +82,13 @@ func scheduleTasks() {
 	botCfg.RLock()
 	scheduled := botCfg.ScheduledJobs
 	tz := botCfg.timeZone
+	scheduleErrors = append([]string{}, botCfg.scheduleErrors...)
 	botCfg.RUnlock()
+	schedError := func(format string, v ...interface{}) {
+		msg := fmt.Sprintf(format, v...)
+		Log(Error, msg)
+		scheduleErrors = append(scheduleErrors, msg)
+	}
 	if tz != nil {
 		Log(Info, "Scheduling tasks in TimeZone: %s", tz)
 		taskRunner = cron.NewWithLocation(tz)
@@ -36,30 +107,55 @@ func scheduleTasks() {
 	confLock.RLock()
 	repolist := repositories
 	confLock.RUnlock()
+	schedules = make([]*scheduledJob, 0, len(scheduled))
+	ids := make(map[string]bool)
 	for _, st := range scheduled {
 		t := tasks.getTaskByName(st.Name)
 		if t == nil {
-			Log(Error, "Task not found when scheduling task: %s", st.Name)
+			schedError("Task not found when scheduling task: %s", st.Name)
 			continue
 		}
 		task, _, job := getTask(t)
 		if job == nil {
-			Log(Error, "Ignoring '%s' in ScheduledJobs: not a job", st.Name)
+			schedError("Ignoring '%s' in ScheduledJobs: not a job", st.Name)
 			continue
 		}
 		if task.Disabled {
-			Log(Error, "Not scheduling disabled job '%s'; reason: %s", st.Name, task.reason)
+			schedError("Not scheduling disabled job '%s'; reason: %s", st.Name, task.reason)
 			continue
 		}
 		if len(task.Channel) == 0 {
-			Log(Error, "Not scheduling job '%s'; zero-length Channel", st.Name)
+			schedError("Not scheduling job '%s'; zero-length Channel", st.Name)
 			continue
 		}
 		ts := st.TaskSpec
-		Log(Info, "Scheduling job '%s', args '%v' with schedule: %s", ts.Name, ts.Arguments, st.Schedule)
-		taskRunner.AddFunc(st.Schedule, func() { runScheduledTask(t, ts, tasks, repolist) })
+		id := scheduleID(st)
+		if ids[id] {
+			schedError("Ignoring duplicate schedule '%s' for job '%s'", st.Schedule, st.Name)
+			continue
+		}
+		ids[id] = true
+		Log(Info, "Scheduling job '%s', args '%v' with schedule: %s (%s)", ts.Name, ts.Arguments, st.Schedule, st.location)
+		sj := &scheduledJob{id, st.Schedule, st, t, ts, tasks, repolist}
+		sched := st.sched
+		if st.jitter > 0 {
+			sched = jitterSchedule{sched, st.jitter}
+		}
+		taskRunner.Schedule(sched, sj)
+		schedules = append(schedules, sj)
+	}
+	registerAdhocSchedules(tasks, repolist)
+	botCfg.RLock()
+	backupSchedule := botCfg.brainBackupSchedule
+	botCfg.RUnlock()
+	if len(backupSchedule) > 0 {
+		Log(Info, "Scheduling brain backups with schedule: %s", backupSchedule)
+		if err := taskRunner.AddFunc(backupSchedule, scheduledBackup); err != nil {
+			Log(Error, "Invalid BrainBackupSchedule '%s': %v", backupSchedule, err)
+		}
 	}
 	taskRunner.Start()
+	go catchUpSchedules(schedules, taskRunner.Location())
 	schedMutex.Unlock()
 }
 
@@ -93,3 +189,12 @@ func runScheduledTask(t interface{}, ts TaskSpec, tasks taskList, repolist map[s
 	Log(Info, "Starting scheduled task: %s", task.name)
 	c.startPipeline(nil, t, scheduled, command, ts.Arguments...)
 }
+
+func scheduledBackup() {
+	name, err := backupBrain()
+	if err != nil {
+		Log(Error, "Scheduled brain backup failed: %v", err)
+		return
+	}
+	Log(Info, "Scheduled brain backup written to '%s'", name)
+}
@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestScheduleID(t *testing.T) {
+	st := func(schedule, tz string, args ...string) ScheduledTask {
+		s := ScheduledTask{Schedule: schedule, TimeZone: tz}
+		s.Name = "updatecfg"
+		s.Arguments = args
+		return s
+	}
+	id := scheduleID(st("0 0 2 * * *", ""))
+	if !regexp.MustCompile(`^updatecfg@[0-9a-f]{6}$`).MatchString(id) {
+		t.Errorf("scheduleID() = %q, want updatecfg@<6 hex digits>", id)
+	}
+	// the builtin commands have to match the ID
+	if !regexp.MustCompile(`^[\w-.@]+$`).MatchString(id) {
+		t.Errorf("scheduleID() = %q doesn't match the schedule command regexes", id)
+	}
+	if again := scheduleID(st("0 0 2 * * *", "")); again != id {
+		t.Errorf("scheduleID() isn't stable: %q, then %q", id, again)
+	}
+	others := []ScheduledTask{
+		st("0 0 3 * * *", ""),
+		st("0 0 2 * * *", "America/New_York"),
+		st("0 0 2 * * *", "", "full"),
+		st("0 0 2 * * *", "", "fu", "ll"),
+	}
+	seen := map[string]bool{id: true}
+	for _, o := range others {
+		oid := scheduleID(o)
+		if seen[oid] {
+			t.Errorf("scheduleID(%q, %q, %q) = %q, already used", o.Schedule, o.TimeZone, o.Arguments, oid)
+		}
+		seen[oid] = true
+	}
+}
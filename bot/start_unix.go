@@ -125,6 +125,7 @@ func Start(v VersionInfo) {
 	// NOTE: we use setConnector instead of passing the connector to run()
 	// because of the way Windows services run. See 'start_win.go'.
 	setConnector(conn)
+	initConnections(botLogger)
 
 	// Start the robot
 	stopped := run()
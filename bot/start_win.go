@@ -168,6 +168,7 @@ func Start(v VersionInfo) {
 	h := handler{}
 	conn := initializeConnector(h, log.New(ioutil.Discard, "", 0))
 	setConnector(conn)
+	initConnections(log.New(ioutil.Discard, "", 0))
 
 	if isIntSess {
 		// Start the connector's main loop for interactive sessions
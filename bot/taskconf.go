@@ -285,16 +285,17 @@ LoadLoop:
 			var hval []PluginHelp
 			var mval []InputMatcher
 			var tval []JobTrigger
+			var wval []JobWatch
 			var val interface{}
 			skip := false
 			switch key {
-			case "Elevator", "Authorizer", "AuthRequire", "NameSpace", "Channel":
+			case "Elevator", "Authorizer", "AuthRequire", "NameSpace", "Channel", "BlackoutPolicy":
 				val = &strval
 			case "HistoryLogs":
 				val = &intval
 			case "Disabled", "AllowDirect", "DirectOnly", "DenyDirect", "AllChannels", "RequireAdmin", "Protected", "AuthorizeAllCommands", "CatchAll", "MatchUnlisted", "Quiet":
 				val = &boolval
-			case "Channels", "ElevatedCommands", "ElevateImmediateCommands", "Users", "AuthorizedCommands", "AdminCommands":
+			case "Channels", "ElevatedCommands", "ElevateImmediateCommands", "Users", "AuthorizedCommands", "AdminCommands", "Blackouts":
 				val = &sarrval
 			case "Help":
 				val = &hval
@@ -302,6 +303,8 @@ LoadLoop:
 				val = &mval
 			case "Triggers":
 				val = &tval
+			case "Watches":
+				val = &wval
 			case "Config":
 				skip = true
 			default:
@@ -465,6 +468,31 @@ LoadLoop:
 				} else {
 					job.Triggers = *(val.(*[]JobTrigger))
 				}
+			case "Watches":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.Watches = *(val.(*[]JobWatch))
+				}
+			case "Blackouts":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.Blackouts = *(val.(*[]string))
+				}
+			case "BlackoutPolicy":
+				if isPlugin {
+					mismatch = true
+				} else {
+					switch bp := *(val.(*string)); bp {
+					case "skip", "defer":
+						job.BlackoutPolicy = bp
+					default:
+						msg := fmt.Sprintf("Invalid BlackoutPolicy '%s' for job '%s', using 'skip'", bp, task.name)
+						Log(Error, msg)
+						c.debugTask(task, msg, false)
+					}
+				}
 			case "Config":
 				task.Config = value
 			}
@@ -654,6 +682,17 @@ LoadLoop:
 					argument.re = re
 				}
 			}
+			for i := range job.Watches {
+				watch := &job.Watches[i]
+				if err := validateWatch(watch); err != nil {
+					msg := fmt.Sprintf("Disabling '%s', invalid watch #%d: %v", task.name, i+1, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
+			}
 		}
 		for i := range task.ReplyMatchers {
 			reply := &task.ReplyMatchers[i]
@@ -7,6 +7,9 @@ import (
 	"log"
 	"regexp"
 	"sync"
+	"time"
+
+	"github.com/robfig/cron"
 )
 
 // Regex for task/job/plugin/NameSpace names. NOTE: if this changes,
@@ -87,7 +90,17 @@ type ExternalTask struct {
 
 // ScheduledTask items defined in gopherbot.yaml, mostly for scheduled jobs
 type ScheduledTask struct {
-	Schedule string // timespec for https://godoc.org/github.com/robfig/cron
+	Schedule      string   // timespec for https://godoc.org/github.com/robfig/cron
+	CatchUp       string   // "once" or "all" to run schedules missed while the robot was down
+	CatchUpWindow string   // how far back to catch up missed runs, e.g. "6h"; default 24h
+	Overlap       string   // when the last run is still going: "skip", "queue" or "concurrent" (default)
+	Blackouts     []string // Calendars when this schedule shouldn't run, in addition to the job's
+	TimeZone      string   // e.g. "America/New_York", overrides the robot's TimeZone for this schedule
+	Jitter        string   // delay each run by a random amount up to this, e.g. "5m"
+	catchUpWindow time.Duration
+	location      *time.Location
+	jitter        time.Duration
+	sched         cron.Schedule
 	TaskSpec
 }
 
@@ -114,6 +127,15 @@ type JobTrigger struct {
 	re      *regexp.Regexp // The compiled regular expression. If the regex doesn't compile, the 'bot will log an error
 }
 
+// JobWatch starts a job when files are written to or moved in to a
+// directory; the job gets the path of the file as it's first argument
+type JobWatch struct {
+	Path     string // directory (or file) to watch; relative paths are relative to the WorkSpace
+	Pattern  string // shell glob for file names, e.g. "*.tar.gz"; defaults to "*"
+	Debounce string // how long a file must be quiet before the job runs, default "2s"
+	debounce time.Duration
+}
+
 // BotTask configuration is common to tasks, plugins or jobs. Any task, plugin or job can call bot methods. Note that tasks are only defined
 // in gopherbot.yaml, and no external configuration is read in.
 type BotTask struct {
@@ -144,10 +166,13 @@ type BotTask struct {
 
 // BotJob - configuration only applicable to jobs. Read in from conf/jobs/<job>.yaml, which can also include anything from a BotTask.
 type BotJob struct {
-	Quiet       bool           // whether to quash "job started/ended" messages
-	HistoryLogs int            // how many runs of this job/plugin to keep history for
-	Triggers    []JobTrigger   // user/regex that triggers a job, e.g. a git-activated webhook or integration
-	Arguments   []InputMatcher // list of arguments to prompt the user for
+	Quiet          bool           // whether to quash "job started/ended" messages
+	HistoryLogs    int            // how many runs of this job/plugin to keep history for
+	Triggers       []JobTrigger   // user/regex that triggers a job, e.g. a git-activated webhook or integration
+	Watches        []JobWatch     // files and directories that trigger the job when written to
+	Arguments      []InputMatcher // list of arguments to prompt the user for
+	Blackouts      []string       // Calendars when the job shouldn't run
+	BlackoutPolicy string         // "skip" (default) drops runs during a blackout, "defer" runs when it ends
 	*BotTask
 }
 
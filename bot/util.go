@@ -108,9 +108,15 @@ func setProtocol(proto string) Protocol {
 func updateRegexes() {
 	botCfg.RLock()
 	name := botCfg.botinfo.UserName
-	protoMention := botCfg.botinfo.protoMention
 	alias := botCfg.alias
 	botCfg.RUnlock()
+	// with SecondaryProtocols, the robot answers to the mention for each
+	var protoMention string
+	if mentions := botMentions(); len(mentions) == 1 {
+		protoMention = mentions[0]
+	} else if len(mentions) > 1 {
+		protoMention = `(?:` + strings.Join(mentions, "|") + `)`
+	}
 	pre, post, bare, errpre, errpost, errbare := updateRegexesWrapped(name, protoMention, alias)
 	if errpre != nil {
 		Log(Error, "Error compiling pre regex: %s", errpre)
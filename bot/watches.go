@@ -0,0 +1,215 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/* watches.go - starting jobs when files change in watched directories. The
+platform watcher (inotify on Linux) calls fileChanged for files written to
+or moved in to a directory; changes are debounced per file, and changes
+made while a watch-triggered run of the job is in progress, or within the
+debounce period after it finishes, are ignored, so jobs can write files in
+the directories they watch. */
+
+const defaultWatchDebounce = 2 * time.Second
+
+// watchTarget is a JobWatch for a loaded job
+type watchTarget struct {
+	job      string
+	dir      string
+	pattern  string
+	debounce time.Duration
+	t        interface{}
+	tasks    taskList
+	repolist map[string]repository
+	sync.Mutex
+	timers     map[string]*time.Timer // pending runs, by path
+	running    int                    // watch-triggered runs in progress
+	quietUntil time.Time              // ignore changes until then, after a run
+	stopped    bool                   // replaced by a reload; start no more runs
+}
+
+var watchMutex sync.Mutex
+
+// watching lists the current watch targets, and stopWatcher stops the
+// platform watcher; both protected by watchMutex
+var watching []*watchTarget
+var stopWatcher func()
+
+// validateWatch checks a JobWatch from a job's configuration
+func validateWatch(w *JobWatch) error {
+	if len(w.Path) == 0 {
+		return fmt.Errorf("zero-length Path")
+	}
+	if len(w.Pattern) > 0 {
+		if _, err := filepath.Match(w.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid Pattern '%s': %v", w.Pattern, err)
+		}
+	}
+	w.debounce = defaultWatchDebounce
+	if len(w.Debounce) > 0 {
+		d, err := time.ParseDuration(w.Debounce)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid Debounce '%s'", w.Debounce)
+		}
+		w.debounce = d
+	}
+	return nil
+}
+
+// watchTasks (re-)starts watching files for jobs with Watches
+func watchTasks() {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	if stopWatcher != nil {
+		stopWatcher()
+		stopWatcher = nil
+	}
+	for _, wt := range watching {
+		wt.Lock()
+		for path, timer := range wt.timers {
+			timer.Stop()
+			delete(wt.timers, path)
+		}
+		wt.stopped = true
+		wt.Unlock()
+	}
+	watching = nil
+
+	currentTasks.Lock()
+	tasks := taskList{
+		currentTasks.t,
+		currentTasks.nameMap,
+		currentTasks.idMap,
+		currentTasks.nameSpaces,
+	}
+	currentTasks.Unlock()
+	confLock.RLock()
+	repolist := repositories
+	confLock.RUnlock()
+	botCfg.RLock()
+	workSpace := botCfg.workSpace
+	botCfg.RUnlock()
+
+	var targets []*watchTarget
+	for _, t := range tasks.t {
+		task, _, job := getTask(t)
+		if job == nil || len(job.Watches) == 0 {
+			continue
+		}
+		if task.Disabled {
+			Log(Error, "Not watching files for disabled job '%s'; reason: %s", task.name, task.reason)
+			continue
+		}
+		if len(task.Channel) == 0 {
+			Log(Error, "Not watching files for job '%s'; zero-length Channel", task.name)
+			continue
+		}
+		for _, w := range job.Watches {
+			dir := w.Path
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(workSpace, dir)
+			}
+			pattern := w.Pattern
+			info, err := os.Stat(dir)
+			if err != nil {
+				Log(Error, "Not watching '%s' for job '%s': %v", dir, task.name, err)
+				continue
+			}
+			if !info.IsDir() {
+				// a single file; watch it's directory for just that name
+				pattern = filepath.Base(dir)
+				dir = filepath.Dir(dir)
+			}
+			if len(pattern) == 0 {
+				pattern = "*"
+			}
+			Log(Info, "Watching '%s' for files matching '%s' for job '%s'", dir, pattern, task.name)
+			targets = append(targets, &watchTarget{
+				job:      task.name,
+				dir:      dir,
+				pattern:  pattern,
+				debounce: w.debounce,
+				t:        t,
+				tasks:    tasks,
+				repolist: repolist,
+				timers:   make(map[string]*time.Timer),
+			})
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	stop, err := startWatcher(targets)
+	if err != nil {
+		Log(Error, "Unable to start watching files for jobs: %v", err)
+		return
+	}
+	watching = targets
+	stopWatcher = stop
+}
+
+// fileChanged is called by the platform watcher for every change in the
+// target directory
+func (wt *watchTarget) fileChanged(path string) {
+	if ok, _ := filepath.Match(wt.pattern, filepath.Base(path)); !ok {
+		return
+	}
+	wt.Lock()
+	defer wt.Unlock()
+	if wt.stopped {
+		return
+	}
+	if wt.running > 0 || time.Now().Before(wt.quietUntil) {
+		Log(Debug, "Ignoring change to '%s' written while job '%s' was running", path, wt.job)
+		return
+	}
+	if timer, ok := wt.timers[path]; ok {
+		timer.Reset(wt.debounce)
+		return
+	}
+	wt.timers[path] = time.AfterFunc(wt.debounce, func() { wt.run(path) })
+}
+
+// run starts the job for a changed file once the debounce period passes
+func (wt *watchTarget) run(path string) {
+	wt.Lock()
+	delete(wt.timers, path)
+	if wt.stopped {
+		// the timer fired while the configuration was reloading
+		wt.Unlock()
+		return
+	}
+	wt.running++
+	wt.Unlock()
+	defer func() {
+		wt.Lock()
+		wt.running--
+		wt.quietUntil = time.Now().Add(wt.debounce)
+		wt.Unlock()
+	}()
+
+	task, _, _ := getTask(wt.t)
+	botCfg.RLock()
+	if botCfg.shuttingDown || botCfg.paused {
+		botCfg.RUnlock()
+		Log(Warn, "Not starting job '%s' for file '%s': shutting down or paused", wt.job, path)
+		return
+	}
+	// Create the botContext to carry state through the pipeline.
+	// startPipeline will take care of registerActive()
+	c := &botContext{
+		Channel:       task.Channel,
+		tasks:         wt.tasks,
+		repositories:  wt.repolist,
+		automaticTask: true,
+		environment:   make(map[string]string),
+	}
+	botCfg.RUnlock()
+	Log(Info, "Starting job '%s' for changed file '%s'", wt.job, path)
+	c.startPipeline(nil, wt.t, fileWatch, "run", path)
+}
@@ -0,0 +1,61 @@
+// +build linux
+
+package bot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// startWatcher watches target directories with inotify; the returned
+// function stops watching.
+func startWatcher(targets []*watchTarget) (func(), error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	wds := make(map[int32][]*watchTarget)
+	for _, wt := range targets {
+		wd, err := unix.InotifyAddWatch(fd, wt.dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO)
+		if err != nil {
+			Log(Error, "Unable to watch '%s' for job '%s': %v", wt.dir, wt.job, err)
+			continue
+		}
+		wds[int32(wd)] = append(wds[int32(wd)], wt)
+	}
+	// a non-blocking file uses the runtime poller, so Close stops the Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	go readInotify(f, wds)
+	return func() { f.Close() }, nil
+}
+
+func readInotify(f *os.File, wds map[int32][]*watchTarget) {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			Log(Debug, "Stopped watching files: %v", err)
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + unix.SizeofInotifyEvent
+			off = start + int(ev.Len)
+			if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+				Log(Warn, "Inotify event queue overflowed, some file changes were missed")
+				continue
+			}
+			if ev.Mask&unix.IN_ISDIR != 0 || ev.Len == 0 || off > n {
+				continue
+			}
+			name := strings.TrimRight(string(buf[start:off]), "\x00")
+			for _, wt := range wds[ev.Wd] {
+				wt.fileChanged(filepath.Join(wt.dir, name))
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package bot
+
+import "errors"
+
+// startWatcher isn't implemented on platforms without inotify
+func startWatcher(targets []*watchTarget) (func(), error) {
+	return nil, errors.New("file watches are only supported on Linux")
+}
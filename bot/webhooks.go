@@ -0,0 +1,307 @@
+package bot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* webhooks.go - authenticated HTTP POSTs to /webhook/<name> that start a
+job, with arguments taken from the JSON body. Callers get back the run
+index (and history link, when available) for checking the status of the
+run. */
+
+// Webhook maps a named endpoint to a job, configured in gopherbot.yaml
+type Webhook struct {
+	Job        string   // the job to run
+	Secret     string   // shared secret for authenticating requests
+	HMACHeader string   // header with the hex HMAC-SHA256 of the body, e.g. "X-Hub-Signature-256"; when empty, the Secret is sent in the X-Gopherbot-Secret header
+	Arguments  []string // JSONPath-style expressions for job arguments, e.g. "$.alert.labels[0]"
+}
+
+// webhookSet maps endpoint names to Webhooks
+type webhookSet map[string]Webhook
+
+// secretHeader carries the shared secret for webhooks without an HMACHeader
+const secretHeader = "X-Gopherbot-Secret"
+
+// Largest request body accepted for a webhook
+const maxWebhookBody = 1 << 20
+
+// How long to wait for the job to get a run index
+const webhookStartTimeout = 30 * time.Second
+
+// webhookResponse is returned as JSON to the caller
+type webhookResponse struct {
+	Job        string `json:",omitempty"`
+	RunIndex   int    `json:",omitempty"`
+	HistoryURL string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+func validateWebhook(w *Webhook) error {
+	if len(w.Job) == 0 {
+		return fmt.Errorf("zero-length Job")
+	}
+	if len(w.Secret) == 0 {
+		return fmt.Errorf("zero-length Secret")
+	}
+	for _, expr := range w.Arguments {
+		if _, err := parseJSONPath(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseJSONPath splits an expression like "$.a.b[2].c" into the keys and
+// indexes "a", "b", "2", "c"; the leading "$" is optional.
+func parseJSONPath(expr string) ([]string, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+	if len(p) == 0 {
+		return nil, fmt.Errorf("empty JSONPath expression '%s'", expr)
+	}
+	var path []string
+	for _, part := range strings.Split(p, ".") {
+		name := part
+		var idx []string
+		if i := strings.Index(part, "["); i >= 0 {
+			name = part[:i]
+			rest := part[i:]
+			for len(rest) > 0 {
+				end := strings.Index(rest, "]")
+				if rest[0] != '[' || end < 0 {
+					return nil, fmt.Errorf("invalid index in JSONPath expression '%s'", expr)
+				}
+				n := rest[1:end]
+				if _, err := strconv.Atoi(n); err != nil {
+					return nil, fmt.Errorf("invalid index '%s' in JSONPath expression '%s'", n, expr)
+				}
+				idx = append(idx, n)
+				rest = rest[end+1:]
+			}
+		}
+		if len(name) == 0 && len(idx) == 0 {
+			return nil, fmt.Errorf("empty element in JSONPath expression '%s'", expr)
+		}
+		if len(name) > 0 {
+			path = append(path, name)
+		}
+		path = append(path, idx...)
+	}
+	return path, nil
+}
+
+// jsonPathValue looks up an expression in a decoded JSON body; strings
+// are returned as-is, other values as JSON.
+func jsonPathValue(body interface{}, expr string) (string, error) {
+	path, err := parseJSONPath(expr)
+	if err != nil {
+		return "", err
+	}
+	v := body
+	for _, elem := range path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[elem]; !ok {
+				return "", fmt.Errorf("'%s' not found for '%s'", elem, expr)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(elem)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("no index '%s' for '%s'", elem, expr)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("can't look up '%s' for '%s'", elem, expr)
+		}
+	}
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case json.Number:
+		return val.String(), nil
+	case nil:
+		return "", fmt.Errorf("null value for '%s'", expr)
+	}
+	b, _ := json.Marshal(v)
+	return string(b), nil
+}
+
+// authenticate checks the shared secret or HMAC signature for a request
+func (w Webhook) authenticate(r *http.Request, body []byte) bool {
+	if len(w.HMACHeader) == 0 {
+		got := r.Header.Get(secretHeader)
+		return subtle.ConstantTimeCompare([]byte(got), []byte(w.Secret)) == 1
+	}
+	sig := r.Header.Get(w.HMACHeader)
+	sig = strings.TrimPrefix(sig, "sha256=")
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// serveWebhooks listens for webhooks, with TLS when a certificate and key
+// are configured. Secrets are sent with every request, so without TLS the
+// robot should only be reached through a proxy that terminates TLS.
+func serveWebhooks(addr, certFile, keyFile string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook/", webhookHandler)
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		if len(certFile) == 0 || len(keyFile) == 0 {
+			Log(Error, "Webhooks need both WebhookCertFile and WebhookKeyFile for TLS, not listening on '%s'", addr)
+			return
+		}
+		Log(Info, "Listening for webhooks with TLS on '%s'", addr)
+		Log(Error, "error serving webhooks: %s", http.ListenAndServeTLS(addr, certFile, keyFile, mux))
+		return
+	}
+	Log(Warn, "Listening for webhooks without TLS on '%s'; webhook secrets are only protected by a TLS proxy", addr)
+	Log(Error, "error serving webhooks: %s", http.ListenAndServe(addr, mux))
+}
+
+func webhookReply(rw http.ResponseWriter, status int, wr webhookResponse) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	b, _ := json.Marshal(wr)
+	rw.Write(b)
+}
+
+func webhookHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		webhookReply(rw, http.StatusMethodNotAllowed, webhookResponse{Error: "webhooks must be POSTed"})
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/webhook/")
+	botCfg.RLock()
+	hook, ok := botCfg.webhooks[name]
+	shuttingDown := botCfg.shuttingDown || botCfg.paused
+	botCfg.RUnlock()
+	if !ok {
+		webhookReply(rw, http.StatusNotFound, webhookResponse{Error: "no such webhook"})
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, maxWebhookBody))
+	if err != nil {
+		webhookReply(rw, http.StatusBadRequest, webhookResponse{Error: "reading request body"})
+		return
+	}
+	if !hook.authenticate(r, body) {
+		Log(Warn, "Authentication failed for webhook '%s' from %s", name, r.RemoteAddr)
+		webhookReply(rw, http.StatusUnauthorized, webhookResponse{Error: "authentication failed"})
+		return
+	}
+	if shuttingDown {
+		webhookReply(rw, http.StatusServiceUnavailable, webhookResponse{Error: "robot is shutting down or paused"})
+		return
+	}
+
+	var args []string
+	if len(hook.Arguments) > 0 {
+		var data interface{}
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		if err := d.Decode(&data); err != nil {
+			webhookReply(rw, http.StatusBadRequest, webhookResponse{Error: "invalid JSON body"})
+			return
+		}
+		for _, expr := range hook.Arguments {
+			arg, err := jsonPathValue(data, expr)
+			if err != nil {
+				webhookReply(rw, http.StatusBadRequest, webhookResponse{Error: err.Error()})
+				return
+			}
+			args = append(args, arg)
+		}
+	}
+
+	currentTasks.Lock()
+	tasks := taskList{
+		currentTasks.t,
+		currentTasks.nameMap,
+		currentTasks.idMap,
+		currentTasks.nameSpaces,
+	}
+	currentTasks.Unlock()
+	t := tasks.getTaskByName(hook.Job)
+	if t == nil {
+		Log(Error, "Job '%s' not found for webhook '%s'", hook.Job, name)
+		webhookReply(rw, http.StatusInternalServerError, webhookResponse{Error: "job not found"})
+		return
+	}
+	task, _, job := getTask(t)
+	if job == nil || task.Disabled || len(task.Channel) == 0 {
+		Log(Error, "Job '%s' for webhook '%s' isn't a job, is disabled, or has no Channel", hook.Job, name)
+		webhookReply(rw, http.StatusInternalServerError, webhookResponse{Error: "job not available"})
+		return
+	}
+	if len(job.Arguments) > 0 {
+		if len(args) != len(job.Arguments) {
+			webhookReply(rw, http.StatusBadRequest, webhookResponse{Error: fmt.Sprintf("job takes %d arguments, webhook supplied %d", len(job.Arguments), len(args))})
+			return
+		}
+		for i, arg := range args {
+			if !job.Arguments[i].re.MatchString(arg) {
+				webhookReply(rw, http.StatusBadRequest, webhookResponse{Error: fmt.Sprintf("argument '%s' doesn't match the pattern for '%s'", arg, job.Arguments[i].Label)})
+				return
+			}
+		}
+	}
+	confLock.RLock()
+	repolist := repositories
+	confLock.RUnlock()
+
+	started := make(chan int, 1)
+	done := make(chan TaskRetVal, 1)
+	c := &botContext{
+		Channel:       task.Channel,
+		tasks:         tasks,
+		repositories:  repolist,
+		automaticTask: true,
+		runStarted:    started,
+		environment:   map[string]string{"GOPHER_WEBHOOK": name},
+	}
+	Log(Info, "Starting job '%s' for webhook '%s' from %s", hook.Job, name, r.RemoteAddr)
+	go func() {
+		done <- c.startPipeline(nil, t, webhook, "run", args...)
+	}()
+	var idx int
+	select {
+	case idx = <-started:
+	case ret := <-done:
+		// a quick job may finish before we read the run index
+		select {
+		case idx = <-started:
+		default:
+			webhookReply(rw, http.StatusServiceUnavailable, webhookResponse{Job: hook.Job, Error: "job didn't start: " + ret.String()})
+			return
+		}
+	case <-time.After(webhookStartTimeout):
+		webhookReply(rw, http.StatusAccepted, webhookResponse{Job: hook.Job, Error: "timed out waiting for the run index"})
+		return
+	}
+	wr := webhookResponse{Job: hook.Job, RunIndex: idx}
+	botCfg.RLock()
+	history := botCfg.history
+	botCfg.RUnlock()
+	if history != nil {
+		if url, ok := history.GetHistoryURL(hook.Job, idx); ok {
+			wr.HistoryURL = url
+		}
+	}
+	webhookReply(rw, http.StatusAccepted, wr)
+}
@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		expr string
+		path []string
+		err  bool
+	}{
+		{"$.alert.host", []string{"alert", "host"}, false},
+		{"alert.host", []string{"alert", "host"}, false},
+		{".alert", []string{"alert"}, false},
+		{"$.alert.checks[0].name", []string{"alert", "checks", "0", "name"}, false},
+		{"$.matrix[1][2]", []string{"matrix", "1", "2"}, false},
+		{"$[3].id", []string{"3", "id"}, false},
+		{"$", nil, true},
+		{"", nil, true},
+		{"$.alert..host", nil, true},
+		{"$.checks[x]", nil, true},
+		{"$.checks[0", nil, true},
+		{"$.checks[0]x", nil, true},
+	}
+	for _, tc := range tests {
+		path, err := parseJSONPath(tc.expr)
+		if (err != nil) != tc.err {
+			t.Errorf("parseJSONPath(%q) error = %v, want error %t", tc.expr, err, tc.err)
+			continue
+		}
+		if !reflect.DeepEqual(path, tc.path) {
+			t.Errorf("parseJSONPath(%q) = %q, want %q", tc.expr, path, tc.path)
+		}
+	}
+}
+
+func TestJSONPathValue(t *testing.T) {
+	body := `{
+		"alert": {
+			"host": "web1",
+			"count": 3,
+			"ratio": 0.25,
+			"critical": true,
+			"owner": null,
+			"labels": {"team": "ops"},
+			"checks": [{"name": "disk"}, {"name": "load"}]
+		},
+		"matrix": [[1, 2], [3, 4]]
+	}`
+	var data interface{}
+	d := json.NewDecoder(strings.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr, value string
+		err         bool
+	}{
+		{"$.alert.host", "web1", false},
+		{"$.alert.count", "3", false},
+		{"$.alert.ratio", "0.25", false},
+		{"$.alert.critical", "true", false},
+		{"$.alert.labels", `{"team":"ops"}`, false},
+		{"$.alert.checks[1].name", "load", false},
+		{"$.matrix[1][0]", "3", false},
+		{"$.alert.owner", "", true},
+		{"$.alert.missing", "", true},
+		{"$.alert.checks[2].name", "", true},
+		{"$.alert.checks.name", "", true},
+		{"$.alert.host.name", "", true},
+		{"$.alert.host[0]", "", true},
+		{"$.alert[", "", true},
+	}
+	for _, tc := range tests {
+		value, err := jsonPathValue(data, tc.expr)
+		if (err != nil) != tc.err {
+			t.Errorf("jsonPathValue(%q) error = %v, want error %t", tc.expr, err, tc.err)
+			continue
+		}
+		if value != tc.value {
+			t.Errorf("jsonPathValue(%q) = %q, want %q", tc.expr, value, tc.value)
+		}
+	}
+}
+
+func TestWebhookAuthenticate(t *testing.T) {
+	body := []byte(`{"alert": {"host": "web1"}}`)
+	// hex HMAC-SHA256 of body with the key "secret"
+	const sig = "51160928e89649b52e0d2108793ff2be4d867e474b6410cebca93ce93c954520"
+	shared := Webhook{Job: "remediate", Secret: "secret"}
+	signed := Webhook{Job: "remediate", Secret: "secret", HMACHeader: "X-Hub-Signature-256"}
+	tests := []struct {
+		name    string
+		hook    Webhook
+		headers map[string]string
+		ok      bool
+	}{
+		{"Secret", shared, map[string]string{secretHeader: "secret"}, true},
+		{"WrongSecret", shared, map[string]string{secretHeader: "secrets"}, false},
+		{"NoSecret", shared, nil, false},
+		{"SignatureInSecretHeader", shared, map[string]string{"X-Hub-Signature-256": "sha256=" + sig}, false},
+		{"HMAC", signed, map[string]string{"X-Hub-Signature-256": "sha256=" + sig}, true},
+		{"HMACNoPrefix", signed, map[string]string{"X-Hub-Signature-256": sig}, true},
+		{"HMACUpperCase", signed, map[string]string{"X-Hub-Signature-256": "sha256=" + strings.ToUpper(sig)}, true},
+		{"WrongHMAC", signed, map[string]string{"X-Hub-Signature-256": "sha256=" + strings.Repeat("0", 64)}, false},
+		{"InvalidHex", signed, map[string]string{"X-Hub-Signature-256": "sha256=xyz"}, false},
+		{"SecretForHMAC", signed, map[string]string{secretHeader: "secret"}, false},
+		{"NoSignature", signed, nil, false},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("POST", "/webhook/alerts", bytes.NewReader(body))
+		for h, v := range tc.headers {
+			r.Header.Set(h, v)
+		}
+		if ok := tc.hook.authenticate(r, body); ok != tc.ok {
+			t.Errorf("%s: authenticate() = %t, want %t", tc.name, ok, tc.ok)
+		}
+	}
+	// the signature covers the whole body
+	r := httptest.NewRequest("POST", "/webhook/alerts", nil)
+	r.Header.Set("X-Hub-Signature-256", "sha256="+sig)
+	if signed.authenticate(r, append(body, ' ')) {
+		t.Error("authenticate() accepted a modified body")
+	}
+}
@@ -118,6 +118,44 @@ func (db *brainConfig) Retrieve(k string) (datum *[]byte, exists bool, err error
 	return &m.Content, true, nil
 }
 
+func (db *brainConfig) List() ([]string, error) {
+	keys := make([]string, 0)
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(dynamocfg.TableName),
+		ProjectionExpression: aws.String("Memory"),
+		ConsistentRead:       aws.Bool(true),
+	}
+	err := svc.ScanPages(input, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if m, ok := item["Memory"]; ok && m.S != nil {
+				keys = append(keys, *m.S)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		robot.Log(bot.Error, "Error listing memories: %v", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (db *brainConfig) Delete(k string) error {
+	_, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(dynamocfg.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Memory": {
+				S: aws.String(k),
+			},
+		},
+	})
+	if err != nil {
+		robot.Log(bot.Error, "Error deleting memory: %v", err)
+		return err
+	}
+	return nil
+}
+
 func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
 	robot = r
 	robot.GetBrainConfig(&dynamocfg)
@@ -48,6 +48,30 @@ func (fb *brainConfig) Retrieve(k string) (*[]byte, bool, error) {
 	return nil, false, nil
 }
 
+func (fb *brainConfig) List() ([]string, error) {
+	files, err := ioutil.ReadDir(brainPath)
+	if err != nil {
+		return nil, fmt.Errorf("Reading brain directory \"%s\": %v", brainPath, err)
+	}
+	keys := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.Mode().IsRegular() {
+			keys = append(keys, f.Name())
+		}
+	}
+	return keys, nil
+}
+
+func (fb *brainConfig) Delete(k string) error {
+	k = strings.Replace(k, `/`, ":", -1)
+	k = strings.Replace(k, `\`, ":", -1)
+	datumPath := brainPath + "/" + k
+	if err := os.Remove(datumPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Removing datum \"%s\": %v", datumPath, err)
+	}
+	return nil
+}
+
 // The file brain doesn't need the logger, but other brains might
 func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
 	robot = r
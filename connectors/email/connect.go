@@ -0,0 +1,254 @@
+// Package email implements the bot.Connector interface for talking to the
+// robot by mail. Incoming mail is read by polling an IMAP mailbox, or by
+// an embedded SMTP listener that a local MTA delivers to; mail from the
+// Email address of a user in the UserRoster is a direct message command,
+// and replies are mailed back in the same thread. Mail has no channels, so
+// messages for a user in a channel are mailed to the user.
+package email
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+type config struct {
+	Address    string // the robot's address, e.g. "robot@example.com"
+	Name       string // display name for the robot's mail, default "Gopherbot"
+	BatchDelay string // wait this long to send several messages as one mail, e.g. "5s"
+	AuthServID string // only accept mail that passed DMARC or DKIM checks on the server with this authserv-id
+	// AllowUnauthenticated accepts mail without checking AuthServID; anyone
+	// who can forge a user's From address can then run commands as the user
+	AllowUnauthenticated bool
+	// Receiving with IMAP
+	IMAPServer        string // host:port of the IMAP server
+	IMAPUser          string // defaults to Address
+	IMAPPassword      string
+	IMAPTLS           bool   // connect with TLS, normally port 993
+	IMAPTLSSkipVerify bool   //
+	Mailbox           string // default "INBOX"
+	PollInterval      string // default "1m"
+	// Receiving with SMTP, from a local MTA. The listener trusts the
+	// Authentication-Results headers in the mail it gets, so ListenAddr must
+	// only be reachable by the MTA, never by untrusted senders.
+	ListenAddr string // address for the SMTP listener, e.g. "127.0.0.1:2525"
+	Hostname   string // name in the SMTP greeting, default os.Hostname()
+	MaxSize    int    // largest message accepted, default 10MB
+	// Sending
+	Mailhost string // host(:port) to send mail through
+	Authtype string // none, plain
+	User     string // optional username for authenticated mail
+	Password string // optional password for authenticated mail
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+const (
+	defaultPollInterval = time.Minute
+	defaultMaxSize      = 10 * 1024 * 1024
+)
+
+// emailConnector holds all the relevant data about the connector
+type emailConnector struct {
+	cfg          config
+	name         string // the robot's display name
+	address      string // the robot's lower-case address
+	batchDelay   time.Duration
+	pollInterval time.Duration
+	listener     net.Listener
+	running      bool                       // set on call to Run
+	incoming     chan *bot.ConnectorMessage // messages heard, for Run
+	bot.Handler                             // bot API for connectors
+	sync.RWMutex                            // shared mutex for locking connector data structures
+	userMap      map[string]string          // usernames to addresses
+	userIDMap    map[string]string          // addresses to usernames
+	threads      map[string]thread          // last message from each address
+	outboxes     map[string]*outbox         // messages waiting for the BatchDelay
+}
+
+func init() {
+	bot.RegisterConnector("email", Initialize)
+}
+
+// Initialize starts the SMTP listener if configured and returns the
+// connector object
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	return bot.Connector(newConnector(robot))
+}
+
+// newConnector checks the configuration and starts the SMTP listener; tests
+// call it for a fresh connector with its own listener.
+func newConnector(robot bot.Handler) *emailConnector {
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, "unable to retrieve email protocol configuration: %v", err)
+	}
+	if len(c.Address) == 0 {
+		robot.Log(bot.Fatal, "no email Address found in config")
+	}
+	if len(c.Mailhost) == 0 {
+		robot.Log(bot.Fatal, "no email Mailhost found in config")
+	}
+	if len(c.IMAPServer) == 0 && len(c.ListenAddr) == 0 {
+		robot.Log(bot.Fatal, "email config needs an IMAPServer or a ListenAddr for receiving mail")
+	}
+	if len(c.AuthServID) == 0 && !c.AllowUnauthenticated {
+		robot.Log(bot.Fatal, "no email AuthServID found in config; set AllowUnauthenticated to accept mail without checking who sent it")
+	}
+	if len(c.Name) == 0 {
+		c.Name = "Gopherbot"
+	}
+	if len(c.IMAPUser) == 0 {
+		c.IMAPUser = c.Address
+	}
+	if len(c.Mailbox) == 0 {
+		c.Mailbox = "INBOX"
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = defaultMaxSize
+	}
+	if len(c.Hostname) == 0 {
+		c.Hostname, _ = os.Hostname()
+	}
+
+	ec := &emailConnector{
+		cfg:          c,
+		name:         c.Name,
+		address:      strings.ToLower(c.Address),
+		pollInterval: defaultPollInterval,
+		incoming:     make(chan *bot.ConnectorMessage, 100),
+		userMap:      make(map[string]string),
+		userIDMap:    make(map[string]string),
+		threads:      make(map[string]thread),
+		outboxes:     make(map[string]*outbox),
+	}
+	ec.Handler = robot
+	if len(c.BatchDelay) > 0 {
+		if ec.batchDelay, err = time.ParseDuration(c.BatchDelay); err != nil {
+			robot.Log(bot.Fatal, "invalid email BatchDelay '%s': %v", c.BatchDelay, err)
+		}
+	}
+	if len(c.PollInterval) > 0 {
+		if ec.pollInterval, err = time.ParseDuration(c.PollInterval); err != nil {
+			robot.Log(bot.Fatal, "invalid email PollInterval '%s': %v", c.PollInterval, err)
+		}
+	}
+
+	if len(c.ListenAddr) > 0 {
+		if ec.listener, err = net.Listen("tcp", c.ListenAddr); err != nil {
+			robot.Log(bot.Fatal, "unable to listen on %s for email connector: %v", c.ListenAddr, err)
+		}
+		s := &smtpServer{
+			hostname: c.Hostname,
+			maxSize:  c.MaxSize,
+			rcptOK: func(rcpt string) bool {
+				return strings.EqualFold(rcpt, ec.address)
+			},
+			deliver: func(from string, data []byte) {
+				ec.deliver(data)
+			},
+		}
+		go s.serve(ec.listener)
+		ec.Log(bot.Info, "email connector accepting mail for %s on %s", ec.address, ec.listener.Addr())
+	}
+
+	ec.SetBotID(ec.address)
+	return ec
+}
+
+// Run polls the IMAP mailbox, if configured, and forwards messages heard
+// to the engine until stopped, then sends any queued mail
+func (ec *emailConnector) Run(stop <-chan struct{}) {
+	ec.Lock()
+	// This should never happen, just a bit of defensive coding
+	if ec.running {
+		ec.Unlock()
+		return
+	}
+	ec.running = true
+	ec.Unlock()
+	if len(ec.cfg.IMAPServer) > 0 {
+		go ec.pollLoop(stop)
+	}
+loop:
+	for {
+		select {
+		case <-stop:
+			ec.Log(bot.Debug, "Received stop in connector")
+			break loop
+		case msg := <-ec.incoming:
+			ec.IncomingMessage(msg)
+		}
+	}
+	if ec.listener != nil {
+		ec.listener.Close()
+	}
+	ec.flushAll()
+}
+
+// deliver queues a command from a known user's mail for the engine, and
+// remembers the message for threading replies
+func (ec *emailConnector) deliver(data []byte) {
+	m, err := parseMail(data)
+	if err != nil {
+		ec.Log(bot.Warn, "Ignoring unreadable email: %v", err)
+		return
+	}
+	if m.from == ec.address || m.automatic {
+		ec.Log(bot.Debug, "Ignoring email from %s, sent by the robot or automatically", m.from)
+		return
+	}
+	if !ec.cfg.AllowUnauthenticated && !authenticated(data, ec.cfg.AuthServID) {
+		ec.Log(bot.Warn, "Ignoring email from %s without passing DMARC or DKIM results from %s", m.from, ec.cfg.AuthServID)
+		return
+	}
+	ec.Lock()
+	userName, known := ec.userIDMap[m.from]
+	if known {
+		ec.threads[m.from] = thread{
+			messageID:  m.messageID,
+			references: m.references,
+			subject:    m.subject,
+		}
+	}
+	ec.Unlock()
+	if !known {
+		ec.Log(bot.Warn, "Ignoring email from unknown address %s", m.from)
+		return
+	}
+	command := m.command()
+	if len(command) == 0 {
+		ec.Log(bot.Debug, "Ignoring email from %s with no command", m.from)
+		return
+	}
+	botMsg := &bot.ConnectorMessage{
+		Protocol:      "Email",
+		UserName:      userName,
+		UserID:        m.from,
+		DirectMessage: true,
+		MessageText:   command,
+		MessageID:     m.messageID,
+		MessageObject: m,
+	}
+	select {
+	case ec.incoming <- botMsg:
+	default:
+		ec.Log(bot.Warn, "email incoming queue full, dropping message from %s", m.from)
+	}
+}
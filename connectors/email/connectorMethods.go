@@ -0,0 +1,87 @@
+package email
+
+import (
+	"strings"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// getAddress returns the address for "<address>" or a known username
+func (ec *emailConnector) getAddress(u string) (string, bool) {
+	if addr, ok := bot.ExtractID(u); ok {
+		return strings.ToLower(addr), true
+	}
+	ec.RLock()
+	addr, ok := ec.userMap[u]
+	ec.RUnlock()
+	return addr, ok
+}
+
+// SetUserMap lets Gopherbot provide a mapping of usernames to user IDs,
+// which are addresses; mail from other addresses is ignored
+func (ec *emailConnector) SetUserMap(m map[string]string) {
+	userMap := make(map[string]string)
+	userIDMap := make(map[string]string)
+	for name, addr := range m {
+		addr = strings.ToLower(addr)
+		userMap[name] = addr
+		userIDMap[addr] = name
+	}
+	ec.Lock()
+	ec.userMap = userMap
+	ec.userIDMap = userIDMap
+	ec.Unlock()
+}
+
+// GetProtocolUserAttribute returns a string attribute or nil if email
+// doesn't have that information; all we know is the address
+func (ec *emailConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	addr, ok := ec.getAddress(u)
+	if !ok {
+		return "", bot.UserNotFound
+	}
+	switch attr {
+	case "email", "internalid":
+		return addr, bot.Ok
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// MessageHeard is a noop for email
+func (ec *emailConnector) MessageHeard(u, c string) {
+	return
+}
+
+// JoinChannel is a noop; email has no channels
+func (ec *emailConnector) JoinChannel(c string) (ret bot.RetVal) {
+	return bot.Ok
+}
+
+// SendProtocolChannelMessage fails; email has no channels
+func (ec *emailConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	ec.Log(bot.Error, "Unable to send email to channel '%s', email has no channels", ch)
+	return bot.ChannelNotFound
+}
+
+// SendProtocolUserChannelMessage mails the message to the user, since
+// email has no channels
+func (ec *emailConnector) SendProtocolUserChannelMessage(uid, uname, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return ec.SendProtocolUserMessage(bracket(uid), msg, f)
+}
+
+// SendProtocolUserMessage mails a message to a user, in the thread of the
+// user's last message
+func (ec *emailConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	addr, ok := ec.getAddress(u)
+	if !ok {
+		ec.Log(bot.Error, "No email address for user '%s'", u)
+		return bot.UserNotFound
+	}
+	return ec.queue(addr, msg)
+}
+
+// bracket returns "<id>", for a bare user ID
+func bracket(id string) string {
+	return "<" + id + ">"
+}
@@ -0,0 +1,314 @@
+package email
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/internal/testbot"
+)
+
+// mailSink is an smtpServer standing in for the Mailhost, recording the
+// mail the robot sends
+type mailSink struct {
+	net.Listener
+	mail chan *mail.Message
+}
+
+func newMailSink() (*mailSink, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	ms := &mailSink{Listener: l, mail: make(chan *mail.Message, 10)}
+	s := &smtpServer{
+		hostname: "mail.example.com",
+		maxSize:  defaultMaxSize,
+		rcptOK:   func(string) bool { return true },
+		deliver: func(from string, data []byte) {
+			// unreadable mail is dropped, and the test times out waiting
+			if msg, err := mail.ReadMessage(strings.NewReader(string(data))); err == nil {
+				ms.mail <- msg
+			}
+		},
+	}
+	go s.serve(l)
+	return ms, nil
+}
+
+func (ms *mailSink) wait(t *testing.T) *mail.Message {
+	select {
+	case msg := <-ms.mail:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for sent mail")
+		return nil
+	}
+}
+
+// fakeIMAP serves a mailbox of messages to the robot's polls
+type fakeIMAP struct {
+	net.Listener
+	sync.Mutex
+	messages map[string][]byte // by UID
+	seen     map[string]bool
+}
+
+func newFakeIMAP() (*fakeIMAP, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	fi := &fakeIMAP{Listener: l, messages: make(map[string][]byte), seen: make(map[string]bool)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fi.serve(c)
+		}
+	}()
+	return fi, nil
+}
+
+func (fi *fakeIMAP) add(uid, msg string) {
+	fi.Lock()
+	fi.messages[uid] = []byte(strings.Replace(msg, "\n", "\r\n", -1))
+	fi.Unlock()
+}
+
+func (fi *fakeIMAP) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	fmt.Fprintf(c, "* OK IMAP4rev1 ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		tag, cmd := f[0], strings.Join(f[1:], " ")
+		fi.Lock()
+		switch {
+		case strings.HasPrefix(cmd, "LOGIN"):
+			if cmd != `LOGIN "Robot@example.com" "secret"` {
+				fmt.Fprintf(c, "%s NO bad login\r\n", tag)
+				fi.Unlock()
+				continue
+			}
+		case cmd == "UID SEARCH UNSEEN":
+			var uids []string
+			for uid := range fi.messages {
+				if !fi.seen[uid] {
+					uids = append(uids, uid)
+				}
+			}
+			fmt.Fprintf(c, "* SEARCH %s\r\n", strings.Join(uids, " "))
+		case strings.HasPrefix(cmd, "UID FETCH"):
+			uid := f[3]
+			msg := fi.messages[uid]
+			fmt.Fprintf(c, "* 1 FETCH (UID %s BODY[] {%d}\r\n%s)\r\n", uid, len(msg), msg)
+		case strings.HasPrefix(cmd, "UID STORE"):
+			fi.seen[f[3]] = true
+		case cmd == "LOGOUT":
+			fmt.Fprintf(c, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+			fi.Unlock()
+			return
+		}
+		fmt.Fprintf(c, "%s OK done\r\n", tag)
+		fi.Unlock()
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		name, msg, command string
+	}{
+		{"Plain", "From: Alice <Alice@Example.com>\nSubject: hi\n\nping\n", "ping"},
+		{"Reply", "From: alice@example.com\nSubject: Re: Message from Floyd\n\n\n  approve 42  \n\nOn Mon, Floyd wrote:\n> Approve deploy 42?\n", "approve 42"},
+		{"Subject", "From: alice@example.com\nSubject: Re: Fwd: weather\n\n-- \nAlice\n", "weather"},
+		{"Multipart", "From: alice@example.com\nSubject: x\nMIME-Version: 1.0\nContent-Type: multipart/alternative; boundary=b1\n\n" +
+			"--b1\nContent-Type: text/html\n\n<p>html</p>\n" +
+			"--b1\nContent-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: quoted-printable\n\nhello caf=C3=A9\n" +
+			"--b1--\n", "hello café"},
+	}
+	for _, tc := range tests {
+		m, err := parseMail([]byte(tc.msg))
+		if assert.NoError(t, err, tc.name) {
+			assert.Equal(t, tc.command, m.command(), tc.name)
+			assert.Equal(t, "alice@example.com", m.from, tc.name)
+		}
+	}
+	m, _ := parseMail([]byte("From: alice@example.com\nAuto-Submitted: auto-replied\nSubject: Out of office\n\naway\n"))
+	assert.True(t, m.automatic)
+}
+
+func TestAuthenticated(t *testing.T) {
+	tests := []struct {
+		name, headers string
+		ok            bool
+	}{
+		{"DMARC", "Authentication-Results: mx.example.com; dmarc=pass (p=reject) header.from=example.com\n", true},
+		{"DKIM", "Authentication-Results: mx.example.com; spf=fail; dkim=pass (good signature) header.d=Example.com header.s=sel\n", true},
+		{"DKIMOtherDomain", "Authentication-Results: mx.example.com; dkim=pass header.d=attacker.com\n", false},
+		{"BareDKIM", "Authentication-Results: mx.example.com; dkim=pass\n", false},
+		{"SPFOnly", "Authentication-Results: mx.example.com; spf=pass smtp.mailfrom=example.com\n", false},
+		{"OtherServer", "Authentication-Results: mx.other.com; dmarc=pass\n", false},
+		{"Failed", "Authentication-Results: mx.example.com; spf=fail; dkim=none\n", false},
+		{"OtherServerFirst", "Authentication-Results: mx.other.com; dmarc=fail\nAuthentication-Results: mx.example.com; dmarc=pass\n", true},
+		{"AddedBySender", "Authentication-Results: mx.example.com; dmarc=fail\nAuthentication-Results: mx.example.com; dmarc=pass\n", false},
+		{"None", "", false},
+	}
+	for _, tc := range tests {
+		msg := tc.headers + "From: alice@example.com\n\nping\n"
+		assert.Equal(t, tc.ok, authenticated([]byte(msg), "mx.example.com"), tc.name)
+	}
+}
+
+// testConnector is a running connector for one test, with the servers it
+// sends and receives mail with
+type testConnector struct {
+	*emailConnector
+	h        *testbot.Handler
+	sink     *mailSink
+	imap     *fakeIMAP
+	smtpAddr string // the connector's SMTP listener
+}
+
+// passed is the header from the MTA for mail that passed DKIM
+const passed = "Authentication-Results: mx.example.com; dkim=pass header.d=example.com\r\n"
+
+// newTestConnector starts a connector with alice as a user, and fake
+// servers for sending and polling; all are stopped when the test ends
+func newTestConnector(t *testing.T) *testConnector {
+	sink, err := newMailSink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imap, err := newFakeIMAP()
+	if err != nil {
+		sink.Close()
+		t.Fatal(err)
+	}
+	h := testbot.NewHandler(config{
+		Address:      "Robot@example.com",
+		Name:         "Floyd",
+		ListenAddr:   "127.0.0.1:0",
+		IMAPServer:   imap.Addr().String(),
+		IMAPPassword: "secret",
+		PollInterval: "50ms",
+		Mailhost:     sink.Addr().String(),
+		AuthServID:   "mx.example.com",
+	})
+	ec := newConnector(h)
+	ec.SetUserMap(map[string]string{"alice": "Alice@Example.com"})
+	stop := make(chan struct{})
+	go ec.Run(stop)
+	t.Cleanup(func() {
+		close(stop)
+		sink.Close()
+		imap.Close()
+	})
+	return &testConnector{
+		emailConnector: ec,
+		h:              h,
+		sink:           sink,
+		imap:           imap,
+		smtpAddr:       ec.listener.Addr().String(),
+	}
+}
+
+// sendMail delivers a message from alice to the connector's SMTP listener
+func (tc *testConnector) sendMail(t *testing.T, msg string) {
+	t.Helper()
+	assert.NoError(t, smtp.SendMail(tc.smtpAddr, nil, "alice@example.com", []string{"Robot@Example.com"}, []byte(msg)))
+}
+
+func TestInitialize(t *testing.T) {
+	tc := newTestConnector(t)
+	assert.Equal(t, "robot@example.com", tc.h.BotID())
+}
+
+func TestSMTPRecipient(t *testing.T) {
+	tc := newTestConnector(t)
+	err := smtp.SendMail(tc.smtpAddr, nil, "carol@example.com", []string{"nobody@example.com"}, []byte("Subject: x\r\n\r\nping\r\n"))
+	assert.Error(t, err, "mail for other recipients is refused")
+}
+
+func TestSMTPUnknownUser(t *testing.T) {
+	tc := newTestConnector(t)
+	unknown := passed + "From: carol@example.com\r\nSubject: ping\r\n\r\nping\r\n"
+	assert.NoError(t, smtp.SendMail(tc.smtpAddr, nil, "carol@example.com", []string{"robot@example.com"}, []byte(unknown)))
+	tc.h.NoMessage(t, 200*time.Millisecond)
+}
+
+func TestSMTPForged(t *testing.T) {
+	tc := newTestConnector(t)
+	tc.sendMail(t, "From: Alice <alice@example.com>\r\nSubject: shutdown\r\n\r\nshutdown\r\n")
+	tc.h.NoMessage(t, 200*time.Millisecond)
+}
+
+func TestSMTPMessage(t *testing.T) {
+	tc := newTestConnector(t)
+	tc.sendMail(t, passed+"From: Alice <alice@example.com>\r\nSubject: status\r\nMessage-ID: <m1@example.com>\r\n\r\nstatus\r\n")
+	m := tc.h.WaitMessage(t)
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "status", m.MessageText)
+		assert.Equal(t, "alice", m.UserName)
+		assert.Equal(t, "alice@example.com", m.UserID)
+		assert.Equal(t, "<m1@example.com>", m.MessageID)
+		assert.True(t, m.DirectMessage)
+	}
+}
+
+func TestReply(t *testing.T) {
+	tc := newTestConnector(t)
+	tc.sendMail(t, passed+"From: Alice <alice@example.com>\r\nSubject: uptime\r\nMessage-ID: <r1@example.com>\r\n\r\nuptime\r\n")
+	tc.h.WaitMessage(t)
+	assert.Equal(t, bot.Ok, tc.SendProtocolUserMessage("alice", "All systems go", bot.Variable))
+	sent := tc.sink.wait(t)
+	if assert.NotNil(t, sent) {
+		assert.Equal(t, "Re: uptime", sent.Header.Get("Subject"))
+		assert.Equal(t, "<r1@example.com>", sent.Header.Get("In-Reply-To"))
+		assert.Equal(t, "<r1@example.com>", sent.Header.Get("References"))
+		assert.Contains(t, sent.Header.Get("From"), "robot@example.com")
+		assert.Equal(t, "alice@example.com", sent.Header.Get("To"))
+	}
+	assert.Equal(t, bot.UserNotFound, tc.SendProtocolUserMessage("carol", "hi", bot.Variable))
+	assert.Equal(t, bot.ChannelNotFound, tc.SendProtocolChannelMessage("general", "hi", bot.Variable))
+}
+
+func TestIMAP(t *testing.T) {
+	tc := newTestConnector(t)
+	tc.imap.add("7", "Authentication-Results: mx.example.com; dmarc=pass\nFrom: alice@example.com\nSubject: Re: status\nMessage-ID: <m2@example.com>\nReferences: <m1@example.com>\n\napprove\n\nOn Tue, Floyd wrote:\n> All systems go\n")
+	m := tc.h.WaitMessage(t)
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "approve", m.MessageText)
+		assert.Equal(t, "alice", m.UserName)
+	}
+	// the message is marked seen, and isn't delivered again
+	tc.h.NoMessage(t, 200*time.Millisecond)
+	tc.imap.Lock()
+	assert.True(t, tc.imap.seen["7"])
+	tc.imap.Unlock()
+}
+
+func TestUserAttributes(t *testing.T) {
+	tc := newTestConnector(t)
+	addr, ret := tc.GetProtocolUserAttribute("alice", "email")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "alice@example.com", addr)
+	_, ret = tc.GetProtocolUserAttribute("alice", "phone")
+	assert.Equal(t, bot.AttributeNotFound, ret)
+	_, ret = tc.GetProtocolUserAttribute("carol", "email")
+	assert.Equal(t, bot.UserNotFound, ret)
+}
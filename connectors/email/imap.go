@@ -0,0 +1,181 @@
+package email
+
+/* imap.go - a minimal IMAP client for polling the robot's mailbox. Each
+poll logs in, fetches unseen messages, marks them seen and logs out; that's
+simpler and more robust than keeping an IDLE connection up, and commands
+by mail aren't in a hurry. */
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+const imapTimeout = time.Minute
+
+// imapClient is a connection to the IMAP server
+type imapClient struct {
+	net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+// imapResponse is an untagged response line, with the contents of any
+// {n} literals in it
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+// dialIMAP connects to the server and reads the greeting
+func (ec *emailConnector) dialIMAP() (*imapClient, error) {
+	dialer := &net.Dialer{Timeout: imapTimeout}
+	var nc net.Conn
+	var err error
+	if ec.cfg.IMAPTLS {
+		nc, err = tls.DialWithDialer(dialer, "tcp", ec.cfg.IMAPServer, &tls.Config{
+			InsecureSkipVerify: ec.cfg.IMAPTLSSkipVerify,
+		})
+	} else {
+		nc, err = dialer.Dial("tcp", ec.cfg.IMAPServer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{Conn: nc, reader: bufio.NewReader(nc)}
+	c.SetDeadline(time.Now().Add(imapTimeout))
+	greeting, err := c.reader.ReadString('\n')
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		nc.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting: %s", strings.TrimSpace(greeting))
+	}
+	return c, nil
+}
+
+// imapQuote returns s as an IMAP quoted string
+func imapQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// command sends a tagged command and returns the untagged responses, or an
+// error if the server doesn't answer OK
+func (c *imapClient) command(format string, v ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := "g" + strconv.Itoa(c.tag)
+	c.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := fmt.Fprintf(c.Conn, "%s %s\r\n", tag, fmt.Sprintf(format, v...)); err != nil {
+		return nil, err
+	}
+	var responses []imapResponse
+	for {
+		r, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(r.line, tag+" ") {
+			status := strings.TrimPrefix(r.line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, fmt.Errorf("IMAP %s failed: %s", strings.Fields(format)[0], status)
+			}
+			return responses, nil
+		}
+		responses = append(responses, r)
+	}
+}
+
+// readResponse reads a response line, reading literals into the response
+// and continuing with the rest of the line after each
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var r imapResponse
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return r, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		r.line += line
+		if !strings.HasSuffix(line, "}") {
+			return r, nil
+		}
+		start := strings.LastIndexByte(line, '{')
+		if start < 0 {
+			return r, nil
+		}
+		size, err := strconv.Atoi(line[start+1 : len(line)-1])
+		if err != nil {
+			return r, nil
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, literal); err != nil {
+			return r, err
+		}
+		r.literals = append(r.literals, literal)
+	}
+}
+
+// pollLoop checks the mailbox every PollInterval until stopped
+func (ec *emailConnector) pollLoop(stop <-chan struct{}) {
+	for {
+		if err := ec.poll(); err != nil {
+			ec.Log(bot.Error, "Checking mail on %s: %v", ec.cfg.IMAPServer, err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(ec.pollInterval):
+		}
+	}
+}
+
+// poll delivers the unseen messages in the mailbox, marking each one seen
+func (ec *emailConnector) poll() error {
+	c, err := ec.dialIMAP()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.command("LOGIN %s %s", imapQuote(ec.cfg.IMAPUser), imapQuote(ec.cfg.IMAPPassword)); err != nil {
+		return err
+	}
+	defer c.command("LOGOUT")
+	if _, err := c.command("SELECT %s", imapQuote(ec.cfg.Mailbox)); err != nil {
+		return err
+	}
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return err
+	}
+	var uids []string
+	for _, r := range responses {
+		if strings.HasPrefix(r.line, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(r.line, "* SEARCH"))...)
+		}
+	}
+	for _, uid := range uids {
+		responses, err := c.command("UID FETCH %s BODY.PEEK[]", uid)
+		if err != nil {
+			return err
+		}
+		for _, r := range responses {
+			if strings.Contains(r.line, "FETCH") && len(r.literals) > 0 {
+				ec.deliver(r.literals[0])
+			}
+		}
+		if _, err := c.command(`UID STORE %s +FLAGS.SILENT (\Seen)`, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package email
+
+/* message.go - reading commands from incoming mail, and sending replies.
+The command is the first line of new text in the body, above any quoted
+reply, or the Subject when the body has none. Replies to a user go in the
+thread of the user's last message; messages the robot sends within the
+BatchDelay are sent as one mail. */
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// inMail is what the robot needs from an incoming message
+type inMail struct {
+	from       string // lower-case address
+	name       string // display name, if any
+	subject    string
+	messageID  string
+	references string
+	text       string // plain-text body
+	automatic  bool   // an auto-reply or list mail, never a command
+}
+
+// thread is the last message from a user, for threading replies
+type thread struct {
+	messageID, references, subject string
+}
+
+// outbox collects messages for a user until the BatchDelay passes
+type outbox struct {
+	messages []string
+	timer    *time.Timer
+}
+
+// sigMarker is "-- " with the space trimmed, the start of a signature
+const sigMarker = "--"
+
+var (
+	wroteRe = regexp.MustCompile(`^On .*wrote:$`)
+	replyRe = regexp.MustCompile(`(?i)^((re|fwd?|aw|sv):\s*)+`)
+	// comments in headers, e.g. "dkim=pass (good signature)"
+	commentRe = regexp.MustCompile(`\([^()]*\)`)
+	decoder   = new(mime.WordDecoder)
+)
+
+// parseMail reads the headers and plain-text body of a message
+func parseMail(data []byte) (*inMail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid From: %v", err)
+	}
+	m := &inMail{
+		from:       strings.ToLower(from.Address),
+		name:       from.Name,
+		messageID:  strings.TrimSpace(msg.Header.Get("Message-Id")),
+		references: strings.TrimSpace(msg.Header.Get("References")),
+		automatic:  automatic(msg.Header),
+	}
+	m.subject, err = decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		m.subject = msg.Header.Get("Subject")
+	}
+	m.text, err = plainText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// automatic reports whether a message was sent by software, e.g. a vacation
+// reply or bounce; answering those could start a mail loop
+func automatic(h mail.Header) bool {
+	if as := strings.ToLower(h.Get("Auto-Submitted")); len(as) > 0 && as != "no" {
+		return true
+	}
+	switch strings.ToLower(h.Get("Precedence")) {
+	case "bulk", "junk", "list":
+		return true
+	}
+	return len(h.Get("List-Id")) > 0
+}
+
+// plainText returns the first text/plain part of a body
+func plainText(contentType, encoding string, body io.Reader) (string, error) {
+	if len(contentType) == 0 {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid Content-Type: %v", err)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			// NextPart decodes quoted-printable parts itself
+			text, err := plainText(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p)
+			if err != nil {
+				return "", err
+			}
+			if len(text) > 0 {
+				return text, nil
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+	switch strings.ToLower(encoding) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	text, err := ioutil.ReadAll(body)
+	return string(text), err
+}
+
+// command returns the first line of new text, or the Subject
+func (m *inMail) command() string {
+	for _, line := range strings.Split(m.text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ">") || wroteRe.MatchString(line) || line == sigMarker || strings.HasPrefix(line, "-----Original Message") {
+			break
+		}
+		if len(line) > 0 {
+			return line
+		}
+	}
+	return strings.TrimSpace(replyRe.ReplaceAllString(m.subject, ""))
+}
+
+// authenticated checks the first Authentication-Results header with the
+// trusted authserv-id, skipping headers from other servers. Mail servers
+// add their headers above the ones already there, so the first is the one
+// the robot's own mail server added; any further down with the same
+// authserv-id could have come from the sender. The message must pass
+// DMARC, or DKIM with a signing domain that matches the From domain. SPF
+// alone isn't enough, since it checks the envelope sender, not From.
+func authenticated(data []byte, authServID string) bool {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return false
+	}
+	fromDomain := strings.ToLower(from.Address[strings.LastIndexByte(from.Address, '@')+1:])
+	for _, ar := range msg.Header["Authentication-Results"] {
+		ar = commentRe.ReplaceAllString(ar, "")
+		fields := strings.Split(ar, ";")
+		if id := strings.Fields(fields[0]); len(id) == 0 || !strings.EqualFold(id[0], authServID) {
+			continue
+		}
+		for _, result := range fields[1:] {
+			props := strings.Fields(strings.ToLower(result))
+			if len(props) == 0 {
+				continue
+			}
+			switch props[0] {
+			case "dmarc=pass":
+				return true
+			case "dkim=pass":
+				for _, prop := range props[1:] {
+					if prop == "header.d="+fromDomain {
+						return true
+					}
+				}
+			}
+		}
+		// only the first header from the trusted server counts
+		return false
+	}
+	return false
+}
+
+// queue adds a message for a user, sending it now when there's no
+// BatchDelay
+func (ec *emailConnector) queue(addr, msg string) bot.RetVal {
+	if ec.batchDelay == 0 {
+		return ec.send(addr, []string{msg})
+	}
+	ec.Lock()
+	defer ec.Unlock()
+	ob, ok := ec.outboxes[addr]
+	if !ok {
+		ob = &outbox{}
+		ec.outboxes[addr] = ob
+		ob.timer = time.AfterFunc(ec.batchDelay, func() { ec.flush(addr) })
+	}
+	ob.messages = append(ob.messages, msg)
+	return bot.Ok
+}
+
+// flush sends the messages queued for a user
+func (ec *emailConnector) flush(addr string) {
+	ec.Lock()
+	ob, ok := ec.outboxes[addr]
+	delete(ec.outboxes, addr)
+	ec.Unlock()
+	if ok {
+		ob.timer.Stop()
+		ec.send(addr, ob.messages)
+	}
+}
+
+// flushAll sends all queued messages, when the robot is stopping
+func (ec *emailConnector) flushAll() {
+	ec.RLock()
+	addrs := make([]string, 0, len(ec.outboxes))
+	for addr := range ec.outboxes {
+		addrs = append(addrs, addr)
+	}
+	ec.RUnlock()
+	for _, addr := range addrs {
+		ec.flush(addr)
+	}
+}
+
+// send mails messages to a user, in the thread of the user's last message
+func (ec *emailConnector) send(addr string, messages []string) bot.RetVal {
+	ec.RLock()
+	t, threaded := ec.threads[addr]
+	ec.RUnlock()
+	e := email.NewEmail()
+	e.From = fmt.Sprintf("%s <%s>", ec.name, ec.address)
+	e.To = []string{addr}
+	e.Text = []byte(strings.Join(messages, "\n\n") + "\n")
+	if threaded {
+		e.Subject = t.subject
+		if !replyRe.MatchString(e.Subject) {
+			e.Subject = "Re: " + e.Subject
+		}
+		e.Headers.Set("In-Reply-To", t.messageID)
+		e.Headers.Set("References", strings.TrimSpace(t.references+" "+t.messageID))
+	} else {
+		e.Subject = "Message from " + ec.name
+	}
+	var a smtp.Auth
+	if ec.cfg.Authtype == "plain" {
+		host := strings.Split(ec.cfg.Mailhost, ":")[0]
+		a = smtp.PlainAuth("", ec.cfg.User, ec.cfg.Password, host)
+	}
+	if err := e.Send(ec.cfg.Mailhost, a); err != nil {
+		ec.Log(bot.Error, "Sending email to %s via %s: %v", addr, ec.cfg.Mailhost, err)
+		return bot.FailedMessageSend
+	}
+	ec.Log(bot.Debug, "Sent email with %d message(s) to %s", len(messages), addr)
+	return bot.Ok
+}
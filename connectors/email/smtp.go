@@ -0,0 +1,145 @@
+package email
+
+/* smtp.go - a minimal SMTP server for mail delivered by a local MTA, e.g.
+with a Postfix transport map entry for the robot's address. It only does
+what delivery needs: no AUTH, STARTTLS or relaying. */
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const smtpTimeout = 5 * time.Minute
+
+// smtpServer accepts mail for the recipients rcptOK allows, and hands each
+// message to deliver with the envelope sender
+type smtpServer struct {
+	hostname string
+	maxSize  int
+	rcptOK   func(rcpt string) bool
+	deliver  func(from string, data []byte)
+}
+
+// serve accepts connections until the listener is closed
+func (s *smtpServer) serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(c)
+	}
+}
+
+// smtpPath returns the address in "FROM:<alice@example.com> SIZE=100"
+func smtpPath(arg, prefix string) (string, bool) {
+	if !strings.HasPrefix(strings.ToUpper(arg), prefix) {
+		return "", false
+	}
+	arg = arg[len(prefix):]
+	start := strings.IndexByte(arg, '<')
+	end := strings.IndexByte(arg, '>')
+	if start < 0 || end < start {
+		return "", false
+	}
+	return arg[start+1 : end], true
+}
+
+// session handles one SMTP connection
+func (s *smtpServer) session(c net.Conn) {
+	defer c.Close()
+	tp := textproto.NewConn(c)
+	reply := func(format string, v ...interface{}) bool {
+		c.SetDeadline(time.Now().Add(smtpTimeout))
+		return tp.PrintfLine(format, v...) == nil
+	}
+	if !reply("220 %s ESMTP gopherbot", s.hostname) {
+		return
+	}
+	var from string
+	var rcpts []string
+	mailStarted := false
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		var ok bool
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			ok = reply("250 %s", s.hostname)
+		case "EHLO":
+			ok = reply("250-%s", s.hostname) && reply("250-SIZE %d", s.maxSize) && reply("250 8BITMIME")
+		case "MAIL":
+			if from, ok = smtpPath(arg, "FROM:"); !ok {
+				ok = reply("501 syntax: MAIL FROM:<address>")
+				break
+			}
+			mailStarted = true
+			rcpts = nil
+			ok = reply("250 OK")
+		case "RCPT":
+			if !mailStarted {
+				ok = reply("503 need MAIL first")
+				break
+			}
+			var rcpt string
+			if rcpt, ok = smtpPath(arg, "TO:"); !ok {
+				ok = reply("501 syntax: RCPT TO:<address>")
+				break
+			}
+			if !s.rcptOK(rcpt) {
+				ok = reply("550 no such user here")
+				break
+			}
+			rcpts = append(rcpts, rcpt)
+			ok = reply("250 OK")
+		case "DATA":
+			if len(rcpts) == 0 {
+				ok = reply("503 need RCPT first")
+				break
+			}
+			if !reply("354 end data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			dr := tp.DotReader()
+			data, err := ioutil.ReadAll(io.LimitReader(dr, int64(s.maxSize)+1))
+			if err != nil {
+				return
+			}
+			if len(data) > s.maxSize {
+				io.Copy(ioutil.Discard, dr)
+				ok = reply("552 message too large")
+			} else {
+				s.deliver(from, data)
+				ok = reply("250 OK")
+			}
+			mailStarted = false
+			rcpts = nil
+		case "RSET":
+			mailStarted = false
+			rcpts = nil
+			ok = reply("250 OK")
+		case "NOOP":
+			ok = reply("250 OK")
+		case "VRFY":
+			ok = reply("252 send some mail, I'll try my best")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			ok = reply("502 command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package testbot provides a fake bot.Handler for connector tests. The
+// protocol configuration is given as the connector's own config struct, and
+// messages the connector hears can be waited for with WaitMessage.
+package testbot
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// How long WaitMessage waits for the connector
+const waitTimeout = 5 * time.Second
+
+// Handler provides the parts of the bot.Handler connectors use; calling any
+// other method panics.
+type Handler struct {
+	bot.Handler
+	config   interface{}
+	incoming chan *bot.ConnectorMessage
+	sync.Mutex
+	botID, mention string
+}
+
+// NewHandler returns a Handler that gives the connector cfg as the protocol
+// configuration
+func NewHandler(cfg interface{}) *Handler {
+	return &Handler{
+		config:   cfg,
+		incoming: make(chan *bot.ConnectorMessage, 10),
+	}
+}
+
+// GetProtocolConfig copies the configuration in to v, the way the engine
+// does from gopherbot.yaml
+func (h *Handler) GetProtocolConfig(v interface{}) error {
+	data, err := json.Marshal(h.config)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Log discards log messages
+func (h *Handler) Log(l bot.LogLevel, m string, v ...interface{}) {}
+
+// SetBotID records the ID set by the connector
+func (h *Handler) SetBotID(id string) {
+	h.Lock()
+	h.botID = id
+	h.Unlock()
+}
+
+// SetBotMention records the mention name set by the connector
+func (h *Handler) SetBotMention(m string) {
+	h.Lock()
+	h.mention = m
+	h.Unlock()
+}
+
+// BotID returns the ID set by the connector
+func (h *Handler) BotID() string {
+	h.Lock()
+	defer h.Unlock()
+	return h.botID
+}
+
+// BotMention returns the mention name set by the connector
+func (h *Handler) BotMention() string {
+	h.Lock()
+	defer h.Unlock()
+	return h.mention
+}
+
+// IncomingMessage queues a message from the connector for WaitMessage
+func (h *Handler) IncomingMessage(m *bot.ConnectorMessage) {
+	h.incoming <- m
+}
+
+// WaitMessage returns the next message the connector heard, or fails the
+// test and returns nil if none arrives in time
+func (h *Handler) WaitMessage(t *testing.T) *bot.ConnectorMessage {
+	t.Helper()
+	select {
+	case msg := <-h.incoming:
+		return msg
+	case <-time.After(waitTimeout):
+		t.Error("timed out waiting for incoming message")
+		return nil
+	}
+}
+
+// NoMessage fails the test if the connector heard a message within d
+func (h *Handler) NoMessage(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case msg := <-h.incoming:
+		t.Errorf("unexpected incoming message: %q", msg.MessageText)
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,32 @@
+// Package upload saves files sent by the robot for connectors with no way
+// to upload them, like the terminal and test connectors; the file is
+// written to a directory, and the connector reports the path instead.
+package upload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes an uploaded file to dir, returning the path
+func WriteFile(dir, filename string, content []byte) (string, error) {
+	name := filepath.Base(filename)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid filename '%s'", filename)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	return path, ioutil.WriteFile(path, content, 0644)
+}
+
+// Message is the text sent in place of the file
+func Message(path, title string) string {
+	if len(title) > 0 {
+		return fmt.Sprintf("(uploaded file '%s': %s)", title, path)
+	}
+	return fmt.Sprintf("(uploaded file: %s)", path)
+}
@@ -0,0 +1,339 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+/* conn.go - connecting to and registering with the IRC server, reading
+messages, and reconnecting with backoff when the connection drops. */
+
+// Reconnect backoff and timeouts
+const (
+	minBackoff   = time.Second
+	maxBackoff   = 5 * time.Minute
+	dialTimeout  = 30 * time.Second
+	pingInterval = 2 * time.Minute // ping the server if it's quiet this long
+)
+
+// Maximum lines queued for sending on one connection
+const sendQueueLength = 200
+
+// ircConn is a single connection to the server
+type ircConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	send    chan string   // lines to send, paced by the send loop
+	done    chan struct{} // closed when the connection is finished
+	closing sync.Once
+}
+
+// newConn dials the server
+func (ic *ircConnector) newConn() (*ircConn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var nc net.Conn
+	var err error
+	if ic.dial != nil {
+		nc, err = ic.dial()
+	} else if ic.cfg.TLS {
+		nc, err = tls.DialWithDialer(dialer, "tcp", ic.cfg.Server, &tls.Config{
+			InsecureSkipVerify: ic.cfg.TLSSkipVerify,
+		})
+	} else {
+		nc, err = dialer.Dial("tcp", ic.cfg.Server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ircConn{
+		Conn:   nc,
+		reader: bufio.NewReader(nc),
+		send:   make(chan string, sendQueueLength),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// close shuts down the connection, ending the send and read loops
+func (c *ircConn) close() {
+	c.closing.Do(func() {
+		close(c.done)
+		c.Conn.Close()
+	})
+}
+
+// quit sends QUIT directly, bypassing the send queue, and closes
+func (c *ircConn) quit(reason string) {
+	c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c.Conn, "QUIT :%s\r\n", reason)
+	c.close()
+}
+
+// queue queues a line for sending, failing if the queue is full
+func (c *ircConn) queue(line string) bool {
+	select {
+	case c.send <- line:
+		return true
+	case <-c.done:
+		return false
+	default:
+		return false
+	}
+}
+
+// writeLine writes a line to the server immediately
+func (c *ircConn) writeLine(line string) error {
+	c.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := c.Conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// sendLoop writes queued lines, pausing between lines so the server doesn't
+// disconnect the robot for flooding
+func (ic *ircConnector) sendLoop(c *ircConn) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case line := <-c.send:
+			if err := c.writeLine(line); err != nil {
+				ic.Log(bot.Error, "Writing to irc server: %v", err)
+				c.close()
+				return
+			}
+			select {
+			case <-c.done:
+				return
+			case <-time.After(ic.lineDelay):
+			}
+		}
+	}
+}
+
+// send queues a line on the current connection
+func (ic *ircConnector) send(line string) bot.RetVal {
+	ic.RLock()
+	c := ic.conn
+	ic.RUnlock()
+	if c == nil {
+		ic.Log(bot.Error, "Not connected to irc server, dropping: %s", line)
+		return bot.FailedMessageSend
+	}
+	if !c.queue(line) {
+		ic.Log(bot.Error, "irc send queue full or connection closed, dropping: %s", line)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// manageConnection keeps the robot connected, reconnecting with exponential
+// backoff; registered is closed after the first successful registration.
+func (ic *ircConnector) manageConnection(registered chan<- struct{}) {
+	backoff := minBackoff
+	first := true
+	for {
+		wasRegistered, err := ic.session(func() {
+			if first {
+				first = false
+				close(registered)
+			}
+		})
+		ic.Lock()
+		ic.conn = nil
+		stopping := ic.stopping
+		ic.Unlock()
+		if stopping {
+			return
+		}
+		if wasRegistered {
+			backoff = minBackoff
+		}
+		ic.Log(bot.Error, "Disconnected from irc server %s: %v; reconnecting in %v", ic.cfg.Server, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session connects, registers and handles messages until the connection
+// fails, returning whether registration succeeded
+func (ic *ircConnector) session(onRegistered func()) (registered bool, err error) {
+	c, err := ic.newConn()
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+	ic.Log(bot.Info, "Connected to irc server %s", ic.cfg.Server)
+
+	sasl := len(ic.cfg.SASLUser) > 0
+	nick := ic.cfg.Nick
+	// account-tag identifies users logged in to services
+	register := []string{"CAP REQ :account-tag"}
+	if sasl {
+		register = append(register, "CAP REQ :sasl")
+	}
+	if len(ic.cfg.Password) > 0 {
+		register = append(register, "PASS "+ic.cfg.Password)
+	}
+	register = append(register,
+		"NICK "+nick,
+		fmt.Sprintf("USER %s 0 * :%s", ic.cfg.User, ic.cfg.RealName),
+	)
+	for _, line := range register {
+		if err := c.writeLine(line); err != nil {
+			return false, err
+		}
+	}
+
+	awaitingPong := false
+	for {
+		c.SetReadDeadline(time.Now().Add(pingInterval))
+		raw, err := c.reader.ReadString('\n')
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && !awaitingPong {
+				awaitingPong = true
+				if err := c.writeLine("PING :" + ic.cfg.Server); err != nil {
+					return registered, err
+				}
+				continue
+			}
+			return registered, err
+		}
+		awaitingPong = false
+		m, ok := parseMessage(raw)
+		if !ok {
+			continue
+		}
+		switch m.command {
+		case "PING":
+			c.writeLine("PONG :" + m.trailing())
+		case "ERROR":
+			return registered, errors.New(m.trailing())
+		case "CAP":
+			if len(m.params) < 3 {
+				continue
+			}
+			ack := m.params[1] == "ACK"
+			for _, capability := range strings.Fields(m.trailing()) {
+				switch capability {
+				case "sasl":
+					if !ack {
+						return registered, errors.New("server doesn't support SASL")
+					}
+					c.writeLine("AUTHENTICATE PLAIN")
+				case "account-tag":
+					if !ack {
+						ic.Log(bot.Warn, "irc server doesn't support account-tag; users can't be identified, and none will be mapped to the UserRoster")
+					}
+					if !sasl {
+						c.writeLine("CAP END")
+					}
+				}
+			}
+		case "AUTHENTICATE":
+			if m.trailing() == "+" {
+				creds := ic.cfg.SASLUser + "\x00" + ic.cfg.SASLUser + "\x00" + ic.cfg.SASLPassword
+				c.writeLine("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(creds)))
+			}
+		case "903": // RPL_SASLSUCCESS
+			c.writeLine("CAP END")
+		case "902", "904", "905", "906": // SASL failed
+			return registered, fmt.Errorf("SASL authentication failed: %s", m.trailing())
+		case "432", "433", "436": // nick unusable or in use
+			if registered {
+				continue
+			}
+			nick += "_"
+			ic.Log(bot.Warn, "irc nick in use or invalid, trying '%s'", nick)
+			c.writeLine("NICK " + nick)
+		case "001": // RPL_WELCOME
+			registered = true
+			if len(m.params) > 0 {
+				nick = m.params[0]
+			}
+			ic.Lock()
+			ic.nick = nick
+			ic.conn = c
+			channels := make([]string, 0, len(ic.channels))
+			for ch := range ic.channels {
+				channels = append(channels, ch)
+			}
+			ic.Unlock()
+			ic.Log(bot.Info, "Registered with irc server %s as '%s'", ic.cfg.Server, nick)
+			go ic.sendLoop(c)
+			if len(ic.cfg.NickServPassword) > 0 {
+				c.queue("PRIVMSG NickServ :IDENTIFY " + ic.cfg.NickServPassword)
+			}
+			for _, ch := range channels {
+				c.queue("JOIN " + ch)
+			}
+			onRegistered()
+		case "NICK":
+			if strings.EqualFold(m.nick(), nick) {
+				nick = m.trailing()
+				ic.Lock()
+				ic.nick = nick
+				ic.Unlock()
+			}
+		case "PRIVMSG":
+			if registered {
+				ic.heard(m, nick)
+			}
+		}
+	}
+}
+
+// heard queues a PRIVMSG for the engine; messages to the robot's nick are
+// direct messages. Only senders logged in to services have their account as
+// the UserID; anybody can use any nick, so other senders get the full
+// "nick!user@host" prefix, which can't match an account name.
+func (ic *ircConnector) heard(m *message, nick string) {
+	if len(m.params) < 2 {
+		return
+	}
+	sender := m.nick()
+	if len(sender) == 0 || strings.EqualFold(sender, nick) {
+		return
+	}
+	text := m.trailing()
+	if strings.HasPrefix(text, "\x01") {
+		// CTCP, e.g. VERSION or ACTION
+		return
+	}
+	target := m.params[0]
+	botMsg := &bot.ConnectorMessage{
+		Protocol:      "IRC",
+		MessageText:   text,
+		MessageObject: m,
+	}
+	if account := m.account(); len(account) > 0 {
+		botMsg.UserName = account
+		botMsg.UserID = account
+		ic.Lock()
+		ic.accounts[account] = sender
+		ic.Unlock()
+	} else {
+		botMsg.UserID = m.prefix
+	}
+	if strings.EqualFold(target, nick) {
+		botMsg.DirectMessage = true
+	} else {
+		botMsg.ChannelID = target
+		botMsg.ChannelName = strings.TrimLeft(target, "#&")
+	}
+	select {
+	case ic.incoming <- botMsg:
+	default:
+		ic.Log(bot.Warn, "irc incoming queue full, dropping message from %s", sender)
+	}
+}
@@ -0,0 +1,75 @@
+package irc
+
+import (
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// MessageHeard is a noop for IRC
+func (ic *ircConnector) MessageHeard(u, c string) {
+	return
+}
+
+// SetUserMap lets Gopherbot provide a mapping of usernames to user IDs,
+// which are services account names for IRC
+func (ic *ircConnector) SetUserMap(m map[string]string) {
+	ic.Lock()
+	ic.userMap = m
+	ic.Unlock()
+}
+
+// GetProtocolUserAttribute returns a string attribute or nil if IRC doesn't
+// have that information; the only things IRC knows about a user are the
+// account and nick
+func (ic *ircConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	switch attr {
+	case "internalid":
+		return ic.userIDFor(u), bot.Ok
+	case "nick":
+		return ic.nickFor(u), bot.Ok
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// JoinChannel joins a channel given it's human-readable name, e.g. "general";
+// the robot re-joins channels when it reconnects
+func (ic *ircConnector) JoinChannel(c string) (ret bot.RetVal) {
+	channel := channelTarget(c)
+	ic.Lock()
+	ic.channels[channel] = struct{}{}
+	connected := ic.conn != nil
+	ic.Unlock()
+	if !connected {
+		return bot.Ok
+	}
+	return ic.send("JOIN " + channel)
+}
+
+// SendProtocolChannelMessage sends a message to a channel
+func (ic *ircConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return ic.sendMessage(channelTarget(ch), "", msg)
+}
+
+// SendProtocolUserChannelMessage sends a message to a channel, addressed to
+// the user
+func (ic *ircConnector) SendProtocolUserChannelMessage(uid, uname, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	nick := ic.nickFor(uid)
+	return ic.sendMessage(channelTarget(ch), nick+": ", msg)
+}
+
+// SendProtocolUserMessage sends a direct message to a user
+func (ic *ircConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return ic.sendMessage(ic.nickFor(u), "", msg)
+}
+
+// sendMessage sends a message as one or more PRIVMSG lines, with the prefix
+// on the first line
+func (ic *ircConnector) sendMessage(target, prefix, msg string) bot.RetVal {
+	lines := splitMessage(prefix+msg, ic.cfg.MaxLineLength)
+	for _, line := range lines {
+		if ret := ic.send("PRIVMSG " + target + " :" + line); ret != bot.Ok {
+			return ret
+		}
+	}
+	return bot.Ok
+}
@@ -0,0 +1,199 @@
+// Package irc implements a connector for IRC networks, with optional TLS
+// and SASL or NickServ authentication. Nicks aren't authenticated, so the
+// connector requests the IRCv3 account-tag capability, and the UserID of a
+// user logged in to services is their account name; list account names as
+// the UserID in the UserRoster to map them to configured usernames. Users
+// who aren't logged in, or all users on servers without account-tag, get
+// "nick!user@host" as their UserID and no username, so they never map to
+// a configured user.
+package irc
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+type config struct {
+	Server           string // host:port of the IRC server
+	TLS              bool   // connect with TLS
+	TLSSkipVerify    bool   // don't verify the server's certificate, e.g. for a self-signed ircd
+	Nick             string // the robot's nick
+	User             string // user name for registration; defaults to Nick
+	RealName         string // real name for registration; defaults to Nick
+	Password         string // server password, if required
+	SASLUser         string // account for SASL PLAIN authentication
+	SASLPassword     string // password for SASL PLAIN authentication
+	NickServPassword string // password to IDENTIFY with NickServ, for networks without SASL
+	MaxLineLength    int    // maximum bytes of message text per line; longer lines are split
+	LineDelay        string // delay between lines sent, for flood protection; default "500ms"
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+// Defaults for config values
+const (
+	defaultMaxLineLength = 400
+	defaultLineDelay     = 500 * time.Millisecond
+)
+
+type ircConnector struct {
+	cfg          config
+	lineDelay    time.Duration
+	running      bool
+	stopping     bool                       // set when Run is stopped, so the robot doesn't reconnect
+	nick         string                     // the robot's current nick
+	conn         *ircConn                   // the current connection, nil when disconnected
+	channels     map[string]struct{}        // channels to join, e.g. "#general"; re-joined on reconnect
+	userMap      map[string]string          // configured username to account, from SetUserMap
+	accounts     map[string]string          // account to the nick last heard using it
+	incoming     chan *bot.ConnectorMessage // messages heard, for Run
+	dial         func() (net.Conn, error)   // replaces dialing the server, for tests
+	bot.Handler                             // bot API for connectors
+	sync.RWMutex                            // shared mutex for locking connector data structures
+}
+
+func init() {
+	bot.RegisterConnector("irc", Initialize)
+}
+
+// Initialize connects to the IRC server, and returns the connector object
+// once the robot has registered
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, "Unable to retrieve irc protocol configuration: %v", err)
+	}
+	if len(c.Server) == 0 {
+		robot.Log(bot.Fatal, "No irc Server configured")
+	}
+	if len(c.Nick) == 0 {
+		robot.Log(bot.Fatal, "No irc Nick configured")
+	}
+	if len(c.User) == 0 {
+		c.User = c.Nick
+	}
+	if len(c.RealName) == 0 {
+		c.RealName = c.Nick
+	}
+	if c.MaxLineLength == 0 {
+		c.MaxLineLength = defaultMaxLineLength
+	}
+	if c.MaxLineLength < 0 {
+		robot.Log(bot.Fatal, "Invalid irc MaxLineLength %d, must be positive", c.MaxLineLength)
+	}
+	lineDelay := defaultLineDelay
+	if len(c.LineDelay) > 0 {
+		if lineDelay, err = time.ParseDuration(c.LineDelay); err != nil {
+			robot.Log(bot.Fatal, "Invalid irc LineDelay '%s': %v", c.LineDelay, err)
+		}
+	}
+
+	ic := &ircConnector{
+		cfg:       c,
+		lineDelay: lineDelay,
+		nick:      c.Nick,
+		channels:  make(map[string]struct{}),
+		userMap:   make(map[string]string),
+		accounts:  make(map[string]string),
+		incoming:  make(chan *bot.ConnectorMessage, 100),
+	}
+	ic.Handler = robot
+
+	registered := make(chan struct{})
+	go ic.manageConnection(registered)
+	<-registered
+
+	ic.RLock()
+	nick := ic.nick
+	ic.RUnlock()
+	robot.SetBotID(nick)
+	robot.SetBotMention(nick)
+	return bot.Connector(ic)
+}
+
+// Run forwards messages heard to the engine until stopped
+func (ic *ircConnector) Run(stop <-chan struct{}) {
+	ic.Lock()
+	// This should never happen, just a bit of defensive coding
+	if ic.running {
+		ic.Unlock()
+		return
+	}
+	ic.running = true
+	ic.Unlock()
+loop:
+	for {
+		select {
+		case <-stop:
+			ic.Log(bot.Debug, "Received stop in connector")
+			break loop
+		case msg := <-ic.incoming:
+			ic.IncomingMessage(msg)
+		}
+	}
+	ic.Lock()
+	ic.stopping = true
+	conn := ic.conn
+	ic.Unlock()
+	if conn != nil {
+		conn.quit("shutting down")
+	}
+}
+
+// channelTarget returns the IRC channel for a channel name or "<#chan>"
+func channelTarget(ch string) string {
+	if id, ok := bot.ExtractID(ch); ok {
+		ch = id
+	}
+	if strings.HasPrefix(ch, "#") || strings.HasPrefix(ch, "&") {
+		return ch
+	}
+	return "#" + ch
+}
+
+// userIDFor returns the UserID for "<id>" or a configured username
+func (ic *ircConnector) userIDFor(u string) string {
+	if id, ok := bot.ExtractID(u); ok {
+		return id
+	}
+	ic.RLock()
+	id, ok := ic.userMap[u]
+	ic.RUnlock()
+	if ok {
+		return id
+	}
+	return u
+}
+
+// nickFor returns the nick to send to for "<id>", a configured username,
+// or a UserID; for accounts, the nick last heard using the account, or the
+// account name if it hasn't been heard from
+func (ic *ircConnector) nickFor(u string) string {
+	id := ic.userIDFor(u)
+	if i := strings.IndexByte(id, '!'); i >= 0 {
+		return id[:i]
+	}
+	ic.RLock()
+	nick, ok := ic.accounts[id]
+	ic.RUnlock()
+	if ok {
+		return nick
+	}
+	return id
+}
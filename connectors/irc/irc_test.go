@@ -0,0 +1,388 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/internal/testbot"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name, line     string
+		ok             bool
+		prefix, cmd    string
+		params         []string
+		nick, trailing string
+	}{
+		{"Privmsg", ":alice!a@host PRIVMSG #general :hello there\r\n", true,
+			"alice!a@host", "PRIVMSG", []string{"#general", "hello there"}, "alice", "hello there"},
+		{"Ping", "PING :irc.example.com\r\n", true,
+			"", "PING", []string{"irc.example.com"}, "", "irc.example.com"},
+		{"NoTrailing", ":irc.example.com 001 floyd\n", true,
+			"irc.example.com", "001", []string{"floyd"}, "irc.example.com", "floyd"},
+		{"Tags", "@time=2019-01-01T00:00:00Z;msgid=x :bob@host privmsg floyd :hi\r\n", true,
+			"bob@host", "PRIVMSG", []string{"floyd", "hi"}, "bob", "hi"},
+		{"ExtraSpaces", ":alice  PRIVMSG   #general  :a  b\r\n", true,
+			"alice", "PRIVMSG", []string{"#general", "a  b"}, "alice", "a  b"},
+		{"EmptyTrailing", ":alice PRIVMSG #general :\r\n", true,
+			"alice", "PRIVMSG", []string{"#general", ""}, "alice", ""},
+		{"UTF8", ":josé!j@host PRIVMSG #café :¿qué tal? 👋\r\n", true,
+			"josé!j@host", "PRIVMSG", []string{"#café", "¿qué tal? 👋"}, "josé", "¿qué tal? 👋"},
+		{"CommandOnly", "QUIT\r\n", true, "", "QUIT", []string{}, "", ""},
+		{"Empty", "\r\n", false, "", "", nil, "", ""},
+		{"OnlyPrefix", ":irc.example.com\r\n", false, "", "", nil, "", ""},
+		{"OnlyTags", "@time=x\r\n", false, "", "", nil, "", ""},
+	}
+	for _, tc := range tests {
+		m, ok := parseMessage(tc.line)
+		if !assert.Equal(t, tc.ok, ok, tc.name) || !ok {
+			continue
+		}
+		assert.Equal(t, tc.prefix, m.prefix, tc.name)
+		assert.Equal(t, tc.cmd, m.command, tc.name)
+		assert.Equal(t, tc.params, m.params, tc.name)
+		assert.Equal(t, tc.nick, m.nick(), tc.name)
+		assert.Equal(t, tc.trailing, m.trailing(), tc.name)
+	}
+}
+
+func TestMessageTags(t *testing.T) {
+	tests := []struct {
+		name, line string
+		tags       map[string]string
+		account    string
+	}{
+		{"NoTags", ":alice!a@host PRIVMSG #general :hi", nil, ""},
+		{"Account", "@account=alice :alice!a@host PRIVMSG #general :hi",
+			map[string]string{"account": "alice"}, "alice"},
+		{"Several", "@time=2019-01-01T00:00:00Z;account=carol;+draft/reply :cjones PRIVMSG floyd :hi",
+			map[string]string{"time": "2019-01-01T00:00:00Z", "account": "carol", "+draft/reply": ""}, "carol"},
+		{"Escaped", `@label=a\:b\sc\\d :alice PRIVMSG floyd :hi`,
+			map[string]string{"label": `a;b c\d`}, ""},
+		{"NotLoggedIn", "@account=* :alice PRIVMSG floyd :hi", map[string]string{"account": "*"}, ""},
+	}
+	for _, tc := range tests {
+		m, ok := parseMessage(tc.line)
+		if !assert.True(t, ok, tc.name) {
+			continue
+		}
+		assert.Equal(t, tc.tags, m.tags, tc.name)
+		assert.Equal(t, tc.account, m.account(), tc.name)
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name, msg string
+		max       int
+		lines     []string
+	}{
+		{"Short", "hello", 10, []string{"hello"}},
+		{"Exact", "0123456789", 10, []string{"0123456789"}},
+		{"Empty", "", 10, nil},
+		{"Newlines", "one\ntwo\r\n\r\nthree\n", 10, []string{"one", "two", "three"}},
+		{"CarriageReturns", "a\rb\r\n", 10, []string{"ab"}},
+		{"AtSpace", "the quick brown fox", 10, []string{"the quick", "brown fox"}},
+		{"NoSpace", "abcdefghijklmnopqrstuvwxyz", 10, []string{"abcdefghij", "klmnopqrst", "uvwxyz"}},
+		{"MultiByte", "ééééé", 5, []string{"éé", "éé", "é"}},
+		{"MultiByteAtSpace", "café au lait", 9, []string{"café au", "lait"}},
+		{"Emoji", "👋👋👋", 6, []string{"👋", "👋", "👋"}},
+		{"MaxShorterThanRune", "👋é", 2, []string{"👋", "é"}},
+		{"LongLines", strings.Repeat("word ", 100) + "\n" + strings.Repeat("x", 450), 400, []string{
+			strings.Repeat("word ", 79) + "word",
+			strings.Repeat("word ", 20),
+			strings.Repeat("x", 400),
+			strings.Repeat("x", 50),
+		}},
+	}
+	for _, tc := range tests {
+		lines := splitMessage(tc.msg, tc.max)
+		assert.Equal(t, tc.lines, lines, tc.name)
+		for _, line := range lines {
+			assert.True(t, len(line) <= tc.max || len([]rune(line)) == 1, "%s: line too long: %q", tc.name, line)
+		}
+	}
+}
+
+// fakeServer hands out the server ends of net.Pipe connections dialed by
+// the connector
+type fakeServer struct {
+	conns chan net.Conn
+}
+
+func (fs *fakeServer) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	fs.conns <- server
+	return client, nil
+}
+
+// serverConn is the server's end of a connection
+type serverConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// accept waits for the connector to connect
+func (fs *fakeServer) accept(t *testing.T) *serverConn {
+	t.Helper()
+	select {
+	case c := <-fs.conns:
+		return &serverConn{Conn: c, reader: bufio.NewReader(c)}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the connector to connect")
+		return nil
+	}
+}
+
+// expect reads a line from the connector
+func (sc *serverConn) expect(t *testing.T, line string) {
+	t.Helper()
+	sc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := sc.reader.ReadString('\n')
+	if assert.NoError(t, err, "waiting for %q", line) {
+		assert.Equal(t, line+"\r\n", got)
+	}
+}
+
+// send writes a line to the connector
+func (sc *serverConn) send(t *testing.T, line string) {
+	t.Helper()
+	sc.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := sc.Write([]byte(line + "\r\n"))
+	assert.NoError(t, err, "sending %q", line)
+}
+
+// newTestConnector returns a connector that dials the fake server; the
+// connection is managed until the test ends
+func newTestConnector(t *testing.T, cfg config, fs *fakeServer) (*ircConnector, <-chan struct{}) {
+	if len(cfg.User) == 0 {
+		cfg.User = cfg.Nick
+	}
+	if len(cfg.RealName) == 0 {
+		cfg.RealName = cfg.Nick
+	}
+	cfg.MaxLineLength = defaultMaxLineLength
+	ic := &ircConnector{
+		cfg:       cfg,
+		lineDelay: time.Millisecond,
+		nick:      cfg.Nick,
+		channels:  map[string]struct{}{"#general": {}},
+		userMap:   make(map[string]string),
+		accounts:  make(map[string]string),
+		incoming:  make(chan *bot.ConnectorMessage, 10),
+		dial:      fs.dial,
+		Handler:   testbot.NewHandler(nil),
+	}
+	registered := make(chan struct{})
+	go ic.manageConnection(registered)
+	t.Cleanup(func() {
+		ic.Lock()
+		ic.stopping = true
+		c := ic.conn
+		ic.Unlock()
+		if c != nil {
+			c.close()
+		}
+	})
+	return ic, registered
+}
+
+func waitRegistered(t *testing.T, registered <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for registration")
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	fs := &fakeServer{conns: make(chan net.Conn, 1)}
+	ic, registered := newTestConnector(t, config{
+		Server:   "irc.example.com:6667",
+		Nick:     "floyd",
+		RealName: "Floyd Gopherbot",
+		Password: "serverpass",
+	}, fs)
+	sc := fs.accept(t)
+	defer sc.Close()
+	sc.expect(t, "CAP REQ :account-tag")
+	sc.expect(t, "PASS serverpass")
+	sc.expect(t, "NICK floyd")
+	sc.expect(t, "USER floyd 0 * :Floyd Gopherbot")
+
+	// messages before registration are ignored
+	sc.send(t, ":alice!a@host PRIVMSG floyd :too soon")
+	sc.send(t, ":irc.example.com CAP * ACK :account-tag")
+	sc.expect(t, "CAP END")
+	// the nick is taken
+	sc.send(t, ":irc.example.com 433 * floyd :Nickname is already in use")
+	sc.expect(t, "NICK floyd_")
+	sc.send(t, ":irc.example.com 001 floyd_ :Welcome to the network")
+	waitRegistered(t, registered)
+	sc.expect(t, "JOIN #general")
+	ic.RLock()
+	assert.Equal(t, "floyd_", ic.nick)
+	ic.RUnlock()
+
+	sc.send(t, "PING :irc.example.com")
+	sc.expect(t, "PONG :irc.example.com")
+
+	sc.send(t, "@account=alice :alice!a@host PRIVMSG #general :floyd_, ping")
+	select {
+	case msg := <-ic.incoming:
+		assert.Equal(t, "alice", msg.UserID)
+		assert.Equal(t, "alice", msg.UserName)
+		assert.Equal(t, "general", msg.ChannelName)
+		assert.Equal(t, "floyd_, ping", msg.MessageText)
+		assert.False(t, msg.DirectMessage)
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for incoming message")
+	}
+	// a sender logged in to an account with a different nick
+	sc.send(t, "@time=2019-01-01T00:00:00Z;account=carol :cjones!c@host PRIVMSG floyd_ :help")
+	select {
+	case msg := <-ic.incoming:
+		assert.Equal(t, "carol", msg.UserID)
+		assert.Equal(t, "help", msg.MessageText)
+		assert.True(t, msg.DirectMessage)
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for incoming message")
+	}
+	// a sender that isn't logged in is never identified by nick
+	sc.send(t, ":alice!m@elsewhere PRIVMSG floyd_ :help")
+	select {
+	case msg := <-ic.incoming:
+		assert.Equal(t, "alice!m@elsewhere", msg.UserID)
+		assert.Empty(t, msg.UserName)
+		assert.True(t, msg.DirectMessage)
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for incoming message")
+	}
+
+	// a collision after registration doesn't change the nick
+	sc.send(t, ":irc.example.com 433 floyd_ floyd :Nickname is already in use")
+	assert.Equal(t, bot.Ok, ic.SendProtocolUserChannelMessage("alice", "alice", "general", "pong\nagain", bot.Variable))
+	sc.expect(t, "PRIVMSG #general :alice: pong")
+	sc.expect(t, "PRIVMSG #general :again")
+	// messages to accounts go to the nick last heard using them
+	ic.SetUserMap(map[string]string{"carol": "carol"})
+	assert.Equal(t, bot.Ok, ic.SendProtocolUserMessage("carol", "hi", bot.Variable))
+	sc.expect(t, "PRIVMSG cjones :hi")
+	assert.Equal(t, bot.Ok, ic.SendProtocolUserMessage("<alice!m@elsewhere>", "who are you?", bot.Variable))
+	sc.expect(t, "PRIVMSG alice :who are you?")
+	id, _ := ic.GetProtocolUserAttribute("carol", "internalid")
+	assert.Equal(t, "carol", id)
+	nick, _ := ic.GetProtocolUserAttribute("carol", "nick")
+	assert.Equal(t, "cjones", nick)
+}
+
+func TestSASL(t *testing.T) {
+	fs := &fakeServer{conns: make(chan net.Conn, 1)}
+	ic, registered := newTestConnector(t, config{
+		Server:       "irc.example.com:6667",
+		Nick:         "floyd",
+		SASLUser:     "floyd",
+		SASLPassword: "secret",
+	}, fs)
+	sc := fs.accept(t)
+	defer sc.Close()
+	sc.expect(t, "CAP REQ :account-tag")
+	sc.expect(t, "CAP REQ :sasl")
+	sc.expect(t, "NICK floyd")
+	sc.expect(t, "USER floyd 0 * :floyd")
+	// CAP END waits for SASL
+	sc.send(t, ":irc.example.com CAP * ACK :account-tag")
+	sc.send(t, ":irc.example.com CAP * ACK :sasl")
+	sc.expect(t, "AUTHENTICATE PLAIN")
+	sc.send(t, "AUTHENTICATE +")
+	// base64 of "floyd\x00floyd\x00secret"
+	sc.expect(t, "AUTHENTICATE ZmxveWQAZmxveWQAc2VjcmV0")
+	sc.send(t, ":irc.example.com 903 floyd :SASL authentication successful")
+	sc.expect(t, "CAP END")
+	sc.send(t, ":irc.example.com 001 floyd :Welcome to the network")
+	waitRegistered(t, registered)
+	sc.expect(t, "JOIN #general")
+	ic.RLock()
+	assert.Equal(t, "floyd", ic.nick)
+	ic.RUnlock()
+}
+
+func TestSASLFailure(t *testing.T) {
+	fs := &fakeServer{conns: make(chan net.Conn, 1)}
+	_, registered := newTestConnector(t, config{
+		Server:       "irc.example.com:6667",
+		Nick:         "floyd",
+		SASLUser:     "floyd",
+		SASLPassword: "wrong",
+	}, fs)
+	sc := fs.accept(t)
+	defer sc.Close()
+	sc.expect(t, "CAP REQ :account-tag")
+	sc.expect(t, "CAP REQ :sasl")
+	sc.expect(t, "NICK floyd")
+	sc.expect(t, "USER floyd 0 * :floyd")
+	sc.send(t, ":irc.example.com CAP * ACK :sasl")
+	sc.expect(t, "AUTHENTICATE PLAIN")
+	sc.send(t, "AUTHENTICATE +")
+	sc.expect(t, "AUTHENTICATE ZmxveWQAZmxveWQAd3Jvbmc=")
+	sc.send(t, ":irc.example.com 904 floyd :SASL authentication failed")
+	// the connector hangs up
+	sc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := sc.reader.ReadString('\n')
+	assert.Error(t, err)
+	select {
+	case <-registered:
+		t.Error("registered after SASL failure")
+	default:
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	fs := &fakeServer{conns: make(chan net.Conn, 1)}
+	ic, registered := newTestConnector(t, config{
+		Server: "irc.example.com:6667",
+		Nick:   "floyd",
+	}, fs)
+	sc := fs.accept(t)
+	sc.expect(t, "CAP REQ :account-tag")
+	sc.expect(t, "NICK floyd")
+	sc.expect(t, "USER floyd 0 * :floyd")
+	// servers without account-tag still work, without identifying users
+	sc.send(t, ":irc.example.com CAP * NAK :account-tag")
+	sc.expect(t, "CAP END")
+	sc.send(t, ":irc.example.com 001 floyd :Welcome to the network")
+	waitRegistered(t, registered)
+	sc.expect(t, "JOIN #general")
+	assert.Equal(t, bot.Ok, ic.JoinChannel("ops"))
+	sc.expect(t, "JOIN #ops")
+
+	// the server drops the connection
+	sc.send(t, "ERROR :Closing link")
+	sc.Close()
+	sc = fs.accept(t)
+	defer sc.Close()
+	sc.expect(t, "CAP REQ :account-tag")
+	sc.expect(t, "NICK floyd")
+	sc.expect(t, "USER floyd 0 * :floyd")
+	sc.send(t, ":irc.example.com 001 floyd :Welcome back")
+	// both channels are joined again, in any order
+	var joins []string
+	for i := 0; i < 2; i++ {
+		sc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := sc.reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			break
+		}
+		joins = append(joins, strings.TrimRight(line, "\r\n"))
+	}
+	assert.ElementsMatch(t, []string{"JOIN #general", "JOIN #ops"}, joins)
+	assert.Equal(t, bot.Ok, ic.SendProtocolChannelMessage("ops", "I'm back", bot.Variable))
+	sc.expect(t, "PRIVMSG #ops :I'm back")
+}
@@ -0,0 +1,125 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// message is a parsed IRC protocol line
+type message struct {
+	tags    map[string]string // IRCv3 message tags, e.g. "account"
+	prefix  string            // e.g. "nick!user@host"
+	command string
+	params  []string // the last param is the trailing param, if present
+}
+
+// tagEscapes unescapes IRCv3 tag values
+var tagEscapes = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+// parseMessage parses a line from the server
+func parseMessage(line string) (*message, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	m := &message{}
+	if strings.HasPrefix(line, "@") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return nil, false
+		}
+		m.tags = make(map[string]string)
+		for _, tag := range strings.Split(line[1:i], ";") {
+			if len(tag) == 0 {
+				continue
+			}
+			if eq := strings.IndexByte(tag, '='); eq >= 0 {
+				m.tags[tag[:eq]] = tagEscapes.Replace(tag[eq+1:])
+			} else {
+				m.tags[tag] = ""
+			}
+		}
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return nil, false
+		}
+		m.prefix = line[1:i]
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+	for len(line) > 0 {
+		if strings.HasPrefix(line, ":") {
+			m.params = append(m.params, line[1:])
+			break
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			m.params = append(m.params, line)
+			break
+		}
+		m.params = append(m.params, line[:i])
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+	if len(m.params) == 0 {
+		return nil, false
+	}
+	m.command = strings.ToUpper(m.params[0])
+	m.params = m.params[1:]
+	return m, true
+}
+
+// nick returns the nick from the message prefix
+func (m *message) nick() string {
+	if i := strings.IndexAny(m.prefix, "!@"); i >= 0 {
+		return m.prefix[:i]
+	}
+	return m.prefix
+}
+
+// account returns the services account of the sender from the IRCv3
+// account-tag, or "" if the sender isn't logged in
+func (m *message) account() string {
+	if account := m.tags["account"]; account != "*" {
+		return account
+	}
+	return ""
+}
+
+// trailing returns the last parameter
+func (m *message) trailing() string {
+	if len(m.params) == 0 {
+		return ""
+	}
+	return m.params[len(m.params)-1]
+}
+
+// splitMessage splits a message in to lines of at most max bytes; IRC has no
+// multi-line messages, and servers truncate long lines. Long lines are split
+// at the last space when possible, and never in the middle of a UTF-8
+// character.
+func splitMessage(msg string, max int) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.Replace(msg, "\r", "", -1), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		for len(line) > max {
+			cut := max
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if sp := strings.LastIndexByte(line[:cut], ' '); sp > 0 {
+				cut = sp
+			}
+			if cut == 0 {
+				// max is shorter than the first character
+				_, cut = utf8.DecodeRuneInString(line)
+			}
+			lines = append(lines, line[:cut])
+			line = strings.TrimLeft(line[cut:], " ")
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,240 @@
+package matrix
+
+/* api.go - a minimal client for the Matrix client-server API, with just the
+calls the connector needs. */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const apiPrefix = "/_matrix/client/r0"
+
+// event is a room or account data event
+type event struct {
+	Type     string          `json:"type"`
+	Sender   string          `json:"sender"`
+	EventID  string          `json:"event_id"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type eventList struct {
+	Events []event `json:"events"`
+}
+
+type syncResponse struct {
+	NextBatch   string    `json:"next_batch"`
+	AccountData eventList `json:"account_data"`
+	Rooms       struct {
+		Join map[string]struct {
+			State    eventList `json:"state"`
+			Timeline eventList `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState eventList `json:"invite_state"`
+		} `json:"invite"`
+		Leave map[string]json.RawMessage `json:"leave"`
+	} `json:"rooms"`
+}
+
+// relation is the m.relates_to of a message, for threads, edits and replies
+type relation struct {
+	RelType   string `json:"rel_type,omitempty"`
+	EventID   string `json:"event_id,omitempty"`
+	InReplyTo *struct {
+		EventID string `json:"event_id"`
+	} `json:"m.in_reply_to,omitempty"`
+}
+
+type messageContent struct {
+	MsgType       string    `json:"msgtype"`
+	Body          string    `json:"body"`
+	Format        string    `json:"format,omitempty"`
+	FormattedBody string    `json:"formatted_body,omitempty"`
+	RelatesTo     *relation `json:"m.relates_to,omitempty"`
+}
+
+type memberContent struct {
+	Membership  string `json:"membership"`
+	DisplayName string `json:"displayname"`
+	IsDirect    bool   `json:"is_direct"`
+}
+
+// apiError is the body of a failed API call
+type apiError struct {
+	ErrCode    string `json:"errcode"`
+	Message    string `json:"error"`
+	StatusCode int    `json:"-"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("matrix API error %d (%s): %s", e.StatusCode, e.ErrCode, e.Message)
+}
+
+type apiClient struct {
+	homeserver string // e.g. https://matrix.example.com
+	token      string
+	userID     string // the robot's Matrix ID, e.g. @gopher:example.com
+	http       *http.Client
+	txnBase    string // prefix for transaction IDs, unique per run
+	txnCount   int64
+}
+
+func newAPIClient(homeserver, token string) *apiClient {
+	return &apiClient{
+		homeserver: strings.TrimRight(homeserver, "/"),
+		token:      token,
+		// long enough for sync requests, which wait up to syncTimeout
+		http:    &http.Client{Timeout: syncTimeout + optimeout},
+		txnBase: fmt.Sprintf("gopherbot%d", time.Now().UnixNano()),
+	}
+}
+
+// do calls the API, JSON-encoding body if non-nil, and decoding the response
+// in to result if non-nil
+func (c *apiClient) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.homeserver+apiPrefix+path, reader)
+	if err != nil {
+		return err
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// login gets an access token with a password
+func (c *apiClient) login(user, password string) error {
+	req := map[string]interface{}{
+		"type": "m.login.password",
+		"identifier": map[string]string{
+			"type": "m.id.user",
+			"user": user,
+		},
+		"password":                    password,
+		"initial_device_display_name": "gopherbot",
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		UserID      string `json:"user_id"`
+	}
+	if err := c.do("POST", "/login", req, &resp); err != nil {
+		return err
+	}
+	c.token = resp.AccessToken
+	c.userID = resp.UserID
+	return nil
+}
+
+func (c *apiClient) whoami() error {
+	var resp struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.do("GET", "/account/whoami", nil, &resp); err != nil {
+		return err
+	}
+	c.userID = resp.UserID
+	return nil
+}
+
+// sync gets events since the last batch, waiting up to timeout for new ones
+func (c *apiClient) sync(since string, timeout time.Duration) (*syncResponse, error) {
+	q := url.Values{}
+	q.Set("timeout", fmt.Sprint(int64(timeout/time.Millisecond)))
+	if len(since) > 0 {
+		q.Set("since", since)
+	}
+	var resp syncResponse
+	return &resp, c.do("GET", "/sync?"+q.Encode(), nil, &resp)
+}
+
+// joinRoom joins a room by ID or alias, returning the room ID
+func (c *apiClient) joinRoom(room string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	err := c.do("POST", "/join/"+url.PathEscape(room), struct{}{}, &resp)
+	return resp.RoomID, err
+}
+
+func (c *apiClient) leaveRoom(roomID string) error {
+	return c.do("POST", "/rooms/"+url.PathEscape(roomID)+"/leave", struct{}{}, nil)
+}
+
+// createDirectRoom creates a direct chat room with a user
+func (c *apiClient) createDirectRoom(userID string) (string, error) {
+	req := map[string]interface{}{
+		"is_direct": true,
+		"invite":    []string{userID},
+		"preset":    "trusted_private_chat",
+	}
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	err := c.do("POST", "/createRoom", req, &resp)
+	return resp.RoomID, err
+}
+
+// setDirectRooms replaces the robot's m.direct account data, a map of user
+// IDs to direct room IDs
+func (c *apiClient) setDirectRooms(direct map[string][]string) error {
+	return c.do("PUT", "/user/"+url.PathEscape(c.userID)+"/account_data/m.direct", direct, nil)
+}
+
+func (c *apiClient) sendMessage(roomID string, content *messageContent) (string, error) {
+	txnID := fmt.Sprintf("%s-%d", c.txnBase, atomic.AddInt64(&c.txnCount, 1))
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	err := c.do("PUT", "/rooms/"+url.PathEscape(roomID)+"/send/m.room.message/"+txnID, content, &resp)
+	return resp.EventID, err
+}
+
+func (c *apiClient) setTyping(roomID string, timeout time.Duration) error {
+	req := map[string]interface{}{
+		"typing":  true,
+		"timeout": int64(timeout / time.Millisecond),
+	}
+	return c.do("PUT", "/rooms/"+url.PathEscape(roomID)+"/typing/"+url.PathEscape(c.userID), req, nil)
+}
+
+// getDisplayName returns a user's display name
+func (c *apiClient) getDisplayName(userID string) (string, error) {
+	var resp struct {
+		DisplayName string `json:"displayname"`
+	}
+	err := c.do("GET", "/profile/"+url.PathEscape(userID)+"/displayname", nil, &resp)
+	return resp.DisplayName, err
+}
@@ -0,0 +1,171 @@
+// Package matrix implements the bot.Connector interface for the Matrix
+// client-server API. Matrix IDs like "@alice:example.com" are the UserIDs
+// for the UserRoster, and rooms are named by their alias, e.g.
+// "#general:example.com" is the "general" channel. The robot doesn't do
+// end-to-end encryption, and won't send messages to encrypted rooms.
+package matrix
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+type config struct {
+	Homeserver      string // the base URL of the homeserver, e.g. https://matrix.example.com
+	AccessToken     string // access token for the robot's account; or use User and Password
+	User            string // user for password login, e.g. "gopher"
+	Password        string // password for password login
+	UnencryptedOnly bool   // leave rooms that turn on encryption, and decline encrypted DM invites
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+const optimeout = 1 * time.Minute
+const syncTimeout = 30 * time.Second
+
+// Reconnect backoff for failed syncs
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// room is what the robot knows about a joined room
+type room struct {
+	id        string
+	alias     string // canonical alias, e.g. #general:example.com
+	name      string // room name from m.room.name
+	encrypted bool
+}
+
+// matrixConnector holds all the relevant data about a connection
+type matrixConnector struct {
+	api             *apiClient
+	unencryptedOnly bool
+	running         bool                       // set on call to Run
+	botID           string                     // the robot's Matrix ID
+	domain          string                     // the robot's server name, e.g. example.com
+	since           string                     // next_batch from the last sync
+	incoming        chan *bot.ConnectorMessage // messages heard, for Run
+	bot.Handler                                // bot API for connectors
+	sync.RWMutex                               // shared mutex for locking connector data structures
+	rooms           map[string]*room           // joined rooms by ID
+	channelToID     map[string]string          // map from channel names to room IDs
+	botUserMap      map[string]string          // gopherbot-engine provided mappings of username to Matrix ID
+	direct          map[string][]string        // m.direct account data, Matrix ID to direct room IDs
+	directRooms     map[string]string          // direct room ID to Matrix ID
+	displayNames    map[string]string          // Matrix ID to display name
+}
+
+func init() {
+	bot.RegisterConnector("matrix", Initialize)
+}
+
+// Initialize logs in, does the initial sync, and returns the connector object
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	return bot.Connector(newConnector(robot))
+}
+
+// newConnector logs in and does the initial sync; separate from Initialize
+// so tests can start a connector for each fake homeserver.
+func newConnector(robot bot.Handler) *matrixConnector {
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, "unable to retrieve matrix protocol configuration: %v", err)
+	}
+	if len(c.Homeserver) == 0 {
+		robot.Log(bot.Fatal, "no matrix Homeserver found in config")
+	}
+
+	mc := &matrixConnector{
+		api:             newAPIClient(c.Homeserver, c.AccessToken),
+		unencryptedOnly: c.UnencryptedOnly,
+		incoming:        make(chan *bot.ConnectorMessage, 100),
+		rooms:           make(map[string]*room),
+		channelToID:     make(map[string]string),
+		direct:          make(map[string][]string),
+		directRooms:     make(map[string]string),
+		displayNames:    make(map[string]string),
+	}
+	mc.Handler = robot
+
+	switch {
+	case len(c.AccessToken) > 0:
+		err = mc.api.whoami()
+	case len(c.User) > 0 && len(c.Password) > 0:
+		err = mc.api.login(c.User, c.Password)
+	default:
+		robot.Log(bot.Fatal, "no matrix AccessToken or User and Password found in config")
+	}
+	if err != nil {
+		robot.Log(bot.Fatal, "unable to log in to matrix homeserver %s: %v", c.Homeserver, err)
+	}
+	mc.botID = mc.api.userID
+	mc.domain = serverName(mc.botID)
+	mc.Log(bot.Info, "matrix setting bot internal ID to: %s", mc.botID)
+	mc.SetBotID(mc.botID)
+	mc.SetBotMention(localpart(mc.botID))
+
+	// The initial sync gets room state; old messages are skipped
+	resp, err := mc.api.sync("", 0)
+	if err != nil {
+		robot.Log(bot.Fatal, "initial matrix sync failed: %v", err)
+	}
+	mc.processSync(resp, true)
+	go mc.syncLoop()
+
+	return mc
+}
+
+// Run forwards messages heard to the engine until stopped
+func (mc *matrixConnector) Run(stop <-chan struct{}) {
+	mc.Lock()
+	// This should never happen, just a bit of defensive coding
+	if mc.running {
+		mc.Unlock()
+		return
+	}
+	mc.running = true
+	mc.Unlock()
+loop:
+	for {
+		select {
+		case <-stop:
+			mc.Log(bot.Debug, "Received stop in connector")
+			break loop
+		case msg := <-mc.incoming:
+			mc.IncomingMessage(msg)
+		}
+	}
+}
+
+// localpart returns "alice" for "@alice:example.com"
+func localpart(id string) string {
+	id = strings.TrimLeft(id, "@#!")
+	if i := strings.IndexByte(id, ':'); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// serverName returns "example.com" for "@alice:example.com"
+func serverName(id string) string {
+	if i := strings.IndexByte(id, ':'); i >= 0 {
+		return id[i+1:]
+	}
+	return ""
+}
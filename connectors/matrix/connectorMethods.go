@@ -0,0 +1,222 @@
+package matrix
+
+import (
+	"html"
+	"strings"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// How long typing notifications last
+const typingTimeout = 5 * time.Second
+
+// GetProtocolUserAttribute returns a string attribute or "" if matrix
+// doesn't have that information
+func (mc *matrixConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	userID := mc.userID(u)
+	switch attr {
+	case "internalid":
+		return userID, bot.Ok
+	case "realname", "fullname", "real name", "full name", "displayname", "display name":
+		mc.RLock()
+		name, ok := mc.displayNames[userID]
+		mc.RUnlock()
+		if ok {
+			return name, bot.Ok
+		}
+		name, err := mc.api.getDisplayName(userID)
+		if err != nil {
+			mc.Log(bot.Debug, "Looking up matrix display name for %s: %v", userID, err)
+			return "", bot.UserNotFound
+		}
+		mc.Lock()
+		mc.displayNames[userID] = name
+		mc.Unlock()
+		return name, bot.Ok
+	// that's all the attributes we can currently get from matrix
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a typing notification letting the user know the
+// message has been heard by the robot.
+func (mc *matrixConnector) MessageHeard(user, channel string) {
+	if roomID, ok := bot.ExtractID(channel); ok {
+		go func() {
+			if err := mc.api.setTyping(roomID, typingTimeout); err != nil {
+				mc.Log(bot.Debug, "Sending matrix typing notification: %v", err)
+			}
+		}()
+	}
+}
+
+// SetUserMap takes a map of username to Matrix ID mappings, built from the
+// UserRoster of gopherbot.yaml
+func (mc *matrixConnector) SetUserMap(umap map[string]string) {
+	mc.Lock()
+	mc.botUserMap = umap
+	mc.Unlock()
+}
+
+// userID returns the Matrix ID for "<@alice:example.com>", a username from
+// the UserRoster, a Matrix ID, or "alice" on the robot's server.
+func (mc *matrixConnector) userID(u string) string {
+	if id, ok := bot.ExtractID(u); ok {
+		return id
+	}
+	mc.RLock()
+	id, ok := mc.botUserMap[u]
+	mc.RUnlock()
+	if ok {
+		return id
+	}
+	if strings.HasPrefix(u, "@") {
+		return u
+	}
+	if strings.Contains(u, ":") {
+		return "@" + u
+	}
+	return "@" + u + ":" + mc.domain
+}
+
+// roomID returns the room ID for "<!room:example.com>" or a channel name
+func (mc *matrixConnector) roomID(ch string) (string, bool) {
+	if id, ok := bot.ExtractID(ch); ok {
+		return id, true
+	}
+	mc.RLock()
+	id, ok := mc.channelToID[ch]
+	mc.RUnlock()
+	return id, ok
+}
+
+// SendProtocolChannelMessage sends a message to a channel
+func (mc *matrixConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return mc.SendProtocolChannelThreadMessage(ch, "", msg, f)
+}
+
+// SendProtocolUserChannelMessage sends a message to a channel, addressed to
+// the user
+func (mc *matrixConnector) SendProtocolUserChannelMessage(uid, u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return mc.SendProtocolUserChannelThreadMessage(uid, u, ch, "", msg, f)
+}
+
+// SendProtocolChannelThreadMessage sends a message to a thread in a channel
+func (mc *matrixConnector) SendProtocolChannelThreadMessage(ch, thread, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	roomID, ok := mc.roomID(ch)
+	if !ok {
+		mc.Log(bot.Error, "matrix room not found for channel: %s", ch)
+		return bot.ChannelNotFound
+	}
+	return mc.sendMessage(roomID, thread, "", msg, f)
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a user in a thread
+func (mc *matrixConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thread, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	roomID, ok := mc.roomID(ch)
+	if !ok {
+		mc.Log(bot.Error, "matrix room not found for channel: %s", ch)
+		return bot.ChannelNotFound
+	}
+	return mc.sendMessage(roomID, thread, localpart(mc.userID(uid))+": ", msg, f)
+}
+
+// SendProtocolUserMessage sends a message in a direct room with the user,
+// creating the room if needed
+func (mc *matrixConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	var roomID string
+	if roomID, ret = mc.directRoom(mc.userID(u)); ret != bot.Ok {
+		return
+	}
+	return mc.sendMessage(roomID, "", "", msg, f)
+}
+
+// directRoom returns a joined direct room for a user, or creates one
+func (mc *matrixConnector) directRoom(userID string) (string, bot.RetVal) {
+	mc.RLock()
+	for _, roomID := range mc.direct[userID] {
+		if _, joined := mc.rooms[roomID]; joined {
+			mc.RUnlock()
+			return roomID, bot.Ok
+		}
+	}
+	mc.RUnlock()
+	roomID, err := mc.api.createDirectRoom(userID)
+	if err != nil {
+		mc.Log(bot.Error, "Unable to create matrix direct room with %s: %v", userID, err)
+		if apiErr, ok := err.(*apiError); ok && apiErr.StatusCode == 400 {
+			return "", bot.UserNotFound
+		}
+		return "", bot.FailedMessageSend
+	}
+	mc.Lock()
+	mc.rooms[roomID] = &room{id: roomID}
+	mc.Unlock()
+	mc.addDirect(userID, roomID)
+	return roomID, bot.Ok
+}
+
+// sendMessage sends a message, with HTML formatting for Fixed
+func (mc *matrixConnector) sendMessage(roomID, thread, prefix, msg string, f bot.MessageFormat) bot.RetVal {
+	mc.RLock()
+	r, ok := mc.rooms[roomID]
+	encrypted := ok && r.encrypted
+	mc.RUnlock()
+	if encrypted {
+		mc.Log(bot.Error, "Not sending unencrypted message to encrypted matrix room %s", roomID)
+		return bot.FailedMessageSend
+	}
+	content := &messageContent{
+		MsgType: "m.notice",
+		Body:    prefix + msg,
+	}
+	if f == bot.Fixed {
+		content.Format = "org.matrix.custom.html"
+		content.FormattedBody = html.EscapeString(prefix) + "<pre><code>" + html.EscapeString(msg) + "</code></pre>"
+	}
+	if len(thread) > 0 {
+		content.RelatesTo = &relation{RelType: "m.thread", EventID: thread}
+	}
+	if _, err := mc.api.sendMessage(roomID, content); err != nil {
+		mc.Log(bot.Error, "sending matrix message to room %s: %v", roomID, err)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// JoinChannel joins a room given its alias without the "#" and server name,
+// e.g. "general" for #general:example.com; full aliases and room IDs also
+// work.
+func (mc *matrixConnector) JoinChannel(c string) (ret bot.RetVal) {
+	if _, ok := mc.roomID(c); ok {
+		return bot.Ok
+	}
+	alias := c
+	if !strings.HasPrefix(c, "#") && !strings.HasPrefix(c, "!") {
+		alias = "#" + c
+		if !strings.Contains(c, ":") {
+			alias += ":" + mc.domain
+		}
+	}
+	roomID, err := mc.api.joinRoom(alias)
+	if err != nil {
+		mc.Log(bot.Error, "Unable to join matrix room '%s': %v", alias, err)
+		if apiErr, ok := err.(*apiError); ok && apiErr.StatusCode == 404 {
+			return bot.ChannelNotFound
+		}
+		return bot.FailedChannelJoin
+	}
+	mc.Lock()
+	if _, ok := mc.rooms[roomID]; !ok {
+		r := &room{id: roomID}
+		if strings.HasPrefix(alias, "#") {
+			r.alias = alias
+		}
+		mc.rooms[roomID] = r
+	}
+	mc.Unlock()
+	mc.updateChannelMaps()
+	return bot.Ok
+}
@@ -0,0 +1,335 @@
+package matrix
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/internal/testbot"
+)
+
+// request is a call to the fake homeserver
+type request struct {
+	method, path string
+	body         map[string]interface{}
+}
+
+// fakeHomeserver serves the initial sync, then responses queued on syncs,
+// and records other requests.
+type fakeHomeserver struct {
+	*httptest.Server
+	sync.Mutex
+	requests []request
+	syncs    chan string
+}
+
+func stateEvent(typ, sender, key string, content interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      typ,
+		"sender":    sender,
+		"state_key": key,
+		"content":   content,
+	}
+}
+
+func messageEvent(id, sender string, content interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "m.room.message",
+		"event_id": id,
+		"sender":   sender,
+		"content":  content,
+	}
+}
+
+func syncJSON(batch string, join, invite map[string]interface{}, accountData ...interface{}) string {
+	resp := map[string]interface{}{
+		"next_batch":   batch,
+		"account_data": map[string]interface{}{"events": accountData},
+		"rooms": map[string]interface{}{
+			"join":   join,
+			"invite": invite,
+		},
+	}
+	data, _ := json.Marshal(resp)
+	return string(data)
+}
+
+func timeline(events ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"timeline": map[string]interface{}{"events": events}}
+}
+
+var initialSync = syncJSON("s1", map[string]interface{}{
+	"!town:example.org": map[string]interface{}{
+		"state": map[string]interface{}{"events": []interface{}{
+			stateEvent("m.room.canonical_alias", "@admin:example.org", "", map[string]string{"alias": "#general:example.org"}),
+			stateEvent("m.room.member", "@alice:example.org", "@alice:example.org", map[string]string{"membership": "join", "displayname": "Alice User"}),
+		}},
+		// old messages are skipped
+		"timeline": map[string]interface{}{"events": []interface{}{
+			messageEvent("$old", "@alice:example.org", map[string]string{"msgtype": "m.text", "body": "old message"}),
+		}},
+	},
+	"!dm:example.org": map[string]interface{}{},
+	"!secret:example.org": map[string]interface{}{
+		"state": map[string]interface{}{"events": []interface{}{
+			stateEvent("m.room.canonical_alias", "@admin:example.org", "", map[string]string{"alias": "#secret:example.org"}),
+			stateEvent("m.room.encryption", "@admin:example.org", "", map[string]string{"algorithm": "m.megolm.v1.aes-sha2"}),
+		}},
+	},
+}, nil, map[string]interface{}{
+	"type":    "m.direct",
+	"content": map[string][]string{"@alice:example.org": {"!dm:example.org"}},
+})
+
+func newFakeHomeserver() *fakeHomeserver {
+	fh := &fakeHomeserver{syncs: make(chan string, 10)}
+	fh.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.EscapedPath(), apiPrefix)
+		rw.Header().Set("Content-Type", "application/json")
+		if path != "/login" && r.Header.Get("Authorization") != "Bearer testtoken" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			rw.Write([]byte(`{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token"}`))
+			return
+		}
+		if r.Method == "GET" && path == "/sync" {
+			if r.URL.Query().Get("since") == "" {
+				rw.Write([]byte(initialSync))
+				return
+			}
+			select {
+			case resp := <-fh.syncs:
+				rw.Write([]byte(resp))
+			case <-time.After(100 * time.Millisecond):
+				rw.Write([]byte(syncJSON(r.URL.Query().Get("since"), nil, nil)))
+			}
+			return
+		}
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		fh.Lock()
+		fh.requests = append(fh.requests, request{r.Method, path, body})
+		fh.Unlock()
+		switch {
+		case path == "/login":
+			rw.Write([]byte(`{"access_token": "testtoken", "user_id": "@gopher:example.org"}`))
+		case path == "/join/%23random:example.org", path == "/join/%21invite:example.org":
+			rw.Write([]byte(`{"room_id": "` + strings.Replace(strings.TrimPrefix(path, "/join/"), "%23random", "!random", 1) + `"}`))
+		case strings.HasPrefix(path, "/join/"):
+			rw.WriteHeader(http.StatusNotFound)
+			rw.Write([]byte(`{"errcode": "M_NOT_FOUND", "error": "Room alias not found"}`))
+		case path == "/createRoom":
+			rw.Write([]byte(`{"room_id": "!newdm:example.org"}`))
+		case strings.HasPrefix(path, "/profile/"):
+			rw.Write([]byte(`{"displayname": "Bob"}`))
+		case strings.Contains(path, "/send/"):
+			rw.Write([]byte(`{"event_id": "$sent"}`))
+		default:
+			rw.Write([]byte(`{}`))
+		}
+	}))
+	return fh
+}
+
+// sent returns and clears the recorded requests
+func (fh *fakeHomeserver) sent() []request {
+	fh.Lock()
+	defer fh.Unlock()
+	requests := fh.requests
+	fh.requests = nil
+	return requests
+}
+
+// newTestConnector returns a running connector logged in to a new fake
+// homeserver, closed when the test ends
+func newTestConnector(t *testing.T) (*fakeHomeserver, *testbot.Handler, *matrixConnector) {
+	fh := newFakeHomeserver()
+	h := testbot.NewHandler(config{
+		Homeserver: fh.URL,
+		User:       "gopher",
+		Password:   "secret",
+	})
+	mc := newConnector(h)
+	// only requests made by the test are checked
+	fh.sent()
+	stop := make(chan struct{})
+	go mc.Run(stop)
+	t.Cleanup(func() {
+		close(stop)
+		fh.Close()
+	})
+	return fh, h, mc
+}
+
+func TestInitialize(t *testing.T) {
+	_, h, _ := newTestConnector(t)
+	assert.Equal(t, "@gopher:example.org", h.BotID())
+	assert.Equal(t, "gopher", h.BotMention())
+}
+
+func TestMessages(t *testing.T) {
+	fh, h, _ := newTestConnector(t)
+	fh.syncs <- syncJSON("s2", map[string]interface{}{
+		"!town:example.org": timeline(
+			messageEvent("$self", "@gopher:example.org", map[string]string{"msgtype": "m.text", "body": "from self"}),
+			messageEvent("$notice", "@otherbot:example.org", map[string]string{"msgtype": "m.notice", "body": "from a bot"}),
+			messageEvent("$1", "@alice:example.org", map[string]string{"msgtype": "m.text", "body": "gopher: ping"}),
+			messageEvent("$edit", "@alice:example.org", map[string]interface{}{"msgtype": "m.text", "body": "* ping",
+				"m.relates_to": map[string]string{"rel_type": "m.replace", "event_id": "$1"}}),
+			messageEvent("$2", "@bob:other.org", map[string]interface{}{"msgtype": "m.text", "body": "> <@gopher:example.org> pong\n\nthanks",
+				"m.relates_to": map[string]interface{}{"rel_type": "m.thread", "event_id": "$1", "m.in_reply_to": map[string]string{"event_id": "$sent"}}}),
+		),
+		"!dm:example.org": timeline(
+			messageEvent("$3", "@alice:example.org", map[string]string{"msgtype": "m.text", "body": "help"}),
+		),
+	}, nil)
+	// rooms are processed in any order
+	msgs := make(map[string]*bot.ConnectorMessage)
+	for i := 0; i < 3; i++ {
+		msg := h.WaitMessage(t)
+		if msg == nil {
+			return
+		}
+		msgs[msg.MessageID] = msg
+	}
+	msg, ok := msgs["$1"]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "@alice:example.org", msg.UserID)
+	assert.Equal(t, "alice", msg.UserName)
+	assert.Equal(t, "!town:example.org", msg.ChannelID)
+	assert.Equal(t, "general", msg.ChannelName)
+	assert.Equal(t, "gopher: ping", msg.MessageText)
+	assert.False(t, msg.DirectMessage)
+
+	msg, ok = msgs["$2"]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "bob:other.org", msg.UserName)
+	assert.Equal(t, "thanks", msg.MessageText)
+	assert.Equal(t, "$1", msg.ThreadID)
+
+	msg, ok = msgs["$3"]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "help", msg.MessageText)
+	assert.True(t, msg.DirectMessage)
+	assert.Equal(t, "", msg.ChannelName)
+}
+
+func TestSendMessages(t *testing.T) {
+	fh, _, mc := newTestConnector(t)
+	assert.Equal(t, bot.Ok, mc.SendProtocolChannelMessage("general", "ls <dir>", bot.Fixed))
+	assert.Equal(t, bot.Ok, mc.SendProtocolUserChannelMessage("<@alice:example.org>", "alice", "<!town:example.org>", "hi", bot.Variable))
+	assert.Equal(t, bot.Ok, mc.SendProtocolChannelThreadMessage("general", "$1", "threaded", bot.Raw))
+	assert.Equal(t, bot.ChannelNotFound, mc.SendProtocolChannelMessage("nowhere", "hello", bot.Raw))
+	assert.Equal(t, bot.FailedMessageSend, mc.SendProtocolChannelMessage("secret", "hello", bot.Raw))
+	reqs := fh.sent()
+	if !assert.Len(t, reqs, 3) {
+		return
+	}
+	for _, req := range reqs {
+		assert.Equal(t, "PUT", req.method)
+		assert.True(t, strings.HasPrefix(req.path, "/rooms/%21town:example.org/send/m.room.message/"), req.path)
+		assert.Equal(t, "m.notice", req.body["msgtype"])
+	}
+	assert.Equal(t, "ls <dir>", reqs[0].body["body"])
+	assert.Equal(t, "<pre><code>ls &lt;dir&gt;</code></pre>", reqs[0].body["formatted_body"])
+	assert.Equal(t, "alice: hi", reqs[1].body["body"])
+	assert.Nil(t, reqs[1].body["formatted_body"])
+	assert.Equal(t, map[string]interface{}{"rel_type": "m.thread", "event_id": "$1"}, reqs[2].body["m.relates_to"])
+}
+
+func TestDirectMessages(t *testing.T) {
+	fh, _, mc := newTestConnector(t)
+	assert.Equal(t, bot.Ok, mc.SendProtocolUserMessage("alice", "existing room", bot.Raw))
+	reqs := fh.sent()
+	if assert.Len(t, reqs, 1) {
+		assert.True(t, strings.HasPrefix(reqs[0].path, "/rooms/%21dm:example.org/send/"), reqs[0].path)
+	}
+	// the robot has never messaged bob
+	assert.Equal(t, bot.Ok, mc.SendProtocolUserMessage("<@bob:other.org>", "new room", bot.Raw))
+	reqs = fh.sent()
+	if assert.Len(t, reqs, 3) {
+		assert.Equal(t, "/createRoom", reqs[0].path)
+		assert.Equal(t, true, reqs[0].body["is_direct"])
+		assert.Equal(t, []interface{}{"@bob:other.org"}, reqs[0].body["invite"])
+		assert.Equal(t, "/user/@gopher:example.org/account_data/m.direct", reqs[1].path)
+		assert.Equal(t, []interface{}{"!newdm:example.org"}, reqs[1].body["@bob:other.org"])
+		assert.True(t, strings.HasPrefix(reqs[2].path, "/rooms/%21newdm:example.org/send/"), reqs[2].path)
+	}
+}
+
+func TestDirectInvite(t *testing.T) {
+	fh, _, _ := newTestConnector(t)
+	fh.syncs <- syncJSON("s3", nil, map[string]interface{}{
+		"!invite:example.org": map[string]interface{}{
+			"invite_state": map[string]interface{}{"events": []interface{}{
+				stateEvent("m.room.member", "@carol:example.org", "@gopher:example.org", map[string]interface{}{"membership": "invite", "is_direct": true}),
+			}},
+		},
+	})
+	time.Sleep(500 * time.Millisecond)
+	reqs := fh.sent()
+	if assert.Len(t, reqs, 2) {
+		assert.Equal(t, "/join/%21invite:example.org", reqs[0].path)
+		assert.Equal(t, "/user/@gopher:example.org/account_data/m.direct", reqs[1].path)
+		assert.Equal(t, []interface{}{"!invite:example.org"}, reqs[1].body["@carol:example.org"])
+	}
+}
+
+func TestJoinChannel(t *testing.T) {
+	fh, _, mc := newTestConnector(t)
+	assert.Equal(t, bot.Ok, mc.JoinChannel("general"))
+	assert.Equal(t, bot.Ok, mc.JoinChannel("random"))
+	assert.Equal(t, bot.ChannelNotFound, mc.JoinChannel("nowhere"))
+	assert.Equal(t, bot.Ok, mc.SendProtocolChannelMessage("random", "hello", bot.Raw))
+	reqs := fh.sent()
+	if assert.Len(t, reqs, 3) {
+		assert.Equal(t, "/join/%23random:example.org", reqs[0].path)
+		assert.Equal(t, "/join/%23nowhere:example.org", reqs[1].path)
+		assert.True(t, strings.HasPrefix(reqs[2].path, "/rooms/%21random:example.org/send/"), reqs[2].path)
+	}
+}
+
+func TestMessageHeard(t *testing.T) {
+	fh, _, mc := newTestConnector(t)
+	mc.MessageHeard("<@alice:example.org>", "<!town:example.org>")
+	time.Sleep(200 * time.Millisecond)
+	reqs := fh.sent()
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, "/rooms/%21town:example.org/typing/@gopher:example.org", reqs[0].path)
+		assert.Equal(t, true, reqs[0].body["typing"])
+	}
+}
+
+func TestUserAttributes(t *testing.T) {
+	_, _, mc := newTestConnector(t)
+	value, ret := mc.GetProtocolUserAttribute("alice", "fullname")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "Alice User", value)
+	value, ret = mc.GetProtocolUserAttribute("bob:other.org", "realname")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "Bob", value)
+	value, ret = mc.GetProtocolUserAttribute("alice", "internalid")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "@alice:example.org", value)
+	_, ret = mc.GetProtocolUserAttribute("alice", "email")
+	assert.Equal(t, bot.AttributeNotFound, ret)
+}
+
+func TestStripReplyFallback(t *testing.T) {
+	assert.Equal(t, "thanks", stripReplyFallback("> <@gopher:example.org> pong\n> more\n\nthanks"))
+	assert.Equal(t, "> quoted by the user", stripReplyFallback("> quoted by the user"))
+	assert.Equal(t, "no quote", stripReplyFallback("no quote"))
+}
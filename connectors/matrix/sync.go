@@ -0,0 +1,304 @@
+package matrix
+
+/* sync.go - the sync loop, keeping room state up to date and handing
+messages to the engine. */
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// syncLoop syncs until the process exits, backing off when the homeserver
+// can't be reached
+func (mc *matrixConnector) syncLoop() {
+	backoff := minBackoff
+	for {
+		resp, err := mc.api.sync(mc.since, syncTimeout)
+		if err != nil {
+			mc.Log(bot.Error, "matrix sync failed, retrying in %v: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+		mc.processSync(resp, false)
+	}
+}
+
+// processSync updates room state from a sync response, and queues messages;
+// for the initial sync, old messages are skipped.
+func (mc *matrixConnector) processSync(resp *syncResponse, initial bool) {
+	for _, ev := range resp.AccountData.Events {
+		if ev.Type == "m.direct" {
+			var direct map[string][]string
+			if err := json.Unmarshal(ev.Content, &direct); err != nil {
+				mc.Log(bot.Error, "Unable to decode matrix m.direct account data: %v", err)
+				continue
+			}
+			mc.setDirect(direct)
+		}
+	}
+	for roomID := range resp.Rooms.Leave {
+		mc.removeRoom(roomID)
+	}
+	for roomID, jr := range resp.Rooms.Join {
+		mc.Lock()
+		if _, ok := mc.rooms[roomID]; !ok {
+			mc.rooms[roomID] = &room{id: roomID}
+		}
+		mc.Unlock()
+		for i := range jr.State.Events {
+			mc.processState(roomID, &jr.State.Events[i])
+		}
+		for i := range jr.Timeline.Events {
+			ev := &jr.Timeline.Events[i]
+			switch {
+			case ev.StateKey != nil:
+				mc.processState(roomID, ev)
+			case initial:
+			case ev.Type == "m.room.message":
+				mc.processMessage(roomID, ev)
+			case ev.Type == "m.room.encrypted":
+				mc.Log(bot.Debug, "Ignoring encrypted message from %s in room %s", ev.Sender, roomID)
+			}
+		}
+		mc.RLock()
+		encrypted := mc.rooms[roomID].encrypted
+		mc.RUnlock()
+		if encrypted && mc.unencryptedOnly {
+			mc.Log(bot.Warn, "Leaving matrix room %s, which has encryption enabled", roomID)
+			if err := mc.api.leaveRoom(roomID); err != nil {
+				mc.Log(bot.Error, "Leaving matrix room %s: %v", roomID, err)
+			}
+			mc.removeRoom(roomID)
+		}
+	}
+	for roomID, inv := range resp.Rooms.Invite {
+		mc.processInvite(roomID, inv.InviteState.Events)
+	}
+	mc.updateChannelMaps()
+	mc.since = resp.NextBatch
+}
+
+// processState updates what the robot knows about a room
+func (mc *matrixConnector) processState(roomID string, ev *event) {
+	mc.Lock()
+	defer mc.Unlock()
+	r, ok := mc.rooms[roomID]
+	if !ok {
+		return
+	}
+	switch ev.Type {
+	case "m.room.canonical_alias":
+		var c struct {
+			Alias string `json:"alias"`
+		}
+		json.Unmarshal(ev.Content, &c)
+		r.alias = c.Alias
+	case "m.room.name":
+		var c struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal(ev.Content, &c)
+		r.name = c.Name
+	case "m.room.encryption":
+		r.encrypted = true
+	case "m.room.member":
+		var c memberContent
+		json.Unmarshal(ev.Content, &c)
+		if len(c.DisplayName) > 0 && ev.StateKey != nil {
+			mc.displayNames[*ev.StateKey] = c.DisplayName
+		}
+	}
+}
+
+// processInvite joins direct rooms the robot is invited to; the robot only
+// joins other rooms listed in the robot's channels.
+func (mc *matrixConnector) processInvite(roomID string, events []event) {
+	var inviter string
+	var isDirect, encrypted bool
+	for _, ev := range events {
+		switch ev.Type {
+		case "m.room.member":
+			if ev.StateKey == nil || *ev.StateKey != mc.botID {
+				continue
+			}
+			var c memberContent
+			json.Unmarshal(ev.Content, &c)
+			if c.Membership == "invite" {
+				inviter = ev.Sender
+				isDirect = c.IsDirect
+			}
+		case "m.room.encryption":
+			encrypted = true
+		}
+	}
+	if !isDirect {
+		mc.Log(bot.Info, "Ignoring invitation from %s to matrix room %s", inviter, roomID)
+		return
+	}
+	if encrypted && mc.unencryptedOnly {
+		mc.Log(bot.Warn, "Declining encrypted direct chat with %s", inviter)
+		if err := mc.api.leaveRoom(roomID); err != nil {
+			mc.Log(bot.Error, "Declining matrix invite to room %s: %v", roomID, err)
+		}
+		return
+	}
+	if _, err := mc.api.joinRoom(roomID); err != nil {
+		mc.Log(bot.Error, "Joining direct chat with %s: %v", inviter, err)
+		return
+	}
+	mc.Log(bot.Info, "Joined direct chat with %s", inviter)
+	mc.Lock()
+	mc.rooms[roomID] = &room{id: roomID, encrypted: encrypted}
+	mc.Unlock()
+	mc.addDirect(inviter, roomID)
+}
+
+// stripReplyFallback removes the quoted message and blank line clients put
+// at the start of replies
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], "> ") {
+		i++
+	}
+	if i == 0 || i == len(lines) || len(lines[i]) != 0 {
+		return body
+	}
+	return strings.Join(lines[i+1:], "\n")
+}
+
+// processMessage queues a message from a room for the engine
+func (mc *matrixConnector) processMessage(roomID string, ev *event) {
+	if ev.Sender == mc.botID {
+		return
+	}
+	var c messageContent
+	if err := json.Unmarshal(ev.Content, &c); err != nil {
+		mc.Log(bot.Error, "Unable to decode matrix message: %v", err)
+		return
+	}
+	// m.notice is for automated messages; ignore those and other types
+	if c.MsgType != "m.text" {
+		mc.Log(bot.Debug, "Ignoring matrix '%s' message from %s", c.MsgType, ev.Sender)
+		return
+	}
+	text := c.Body
+	var thread string
+	if c.RelatesTo != nil {
+		switch c.RelatesTo.RelType {
+		case "m.replace":
+			mc.Log(bot.Debug, "Ignoring edited message from %s", ev.Sender)
+			return
+		case "m.thread":
+			thread = c.RelatesTo.EventID
+		}
+		if c.RelatesTo.InReplyTo != nil {
+			text = stripReplyFallback(text)
+		}
+	}
+	mc.RLock()
+	_, isDM := mc.directRooms[roomID]
+	channel := channelName(mc.rooms[roomID], mc.domain)
+	mc.RUnlock()
+	botMsg := &bot.ConnectorMessage{
+		Protocol:      "Matrix",
+		UserID:        ev.Sender,
+		UserName:      mc.userName(ev.Sender),
+		ChannelID:     roomID,
+		DirectMessage: isDM,
+		MessageText:   text,
+		ThreadID:      thread,
+		MessageID:     ev.EventID,
+		MessageObject: ev,
+		Client:        mc.api,
+	}
+	if !isDM {
+		botMsg.ChannelName = channel
+	}
+	mc.incoming <- botMsg
+}
+
+// userName returns the localpart for users on the robot's server, or the
+// full Matrix ID without the "@"
+func (mc *matrixConnector) userName(id string) string {
+	if serverName(id) == mc.domain {
+		return localpart(id)
+	}
+	return strings.TrimPrefix(id, "@")
+}
+
+// channelName returns "general" for #general:example.com on the robot's
+// server, "general:other.org" for other servers, or the room name for rooms
+// without an alias.
+func channelName(r *room, domain string) string {
+	if r == nil {
+		return ""
+	}
+	if len(r.alias) > 0 {
+		if serverName(r.alias) == domain {
+			return localpart(r.alias)
+		}
+		return strings.TrimPrefix(r.alias, "#")
+	}
+	return r.name
+}
+
+// updateChannelMaps rebuilds the map of channel names to room IDs
+func (mc *matrixConnector) updateChannelMaps() {
+	mc.Lock()
+	defer mc.Unlock()
+	chanMap := make(map[string]string)
+	for id, r := range mc.rooms {
+		if _, ok := mc.directRooms[id]; ok {
+			continue
+		}
+		if name := channelName(r, mc.domain); len(name) > 0 {
+			chanMap[name] = id
+		}
+	}
+	mc.channelToID = chanMap
+}
+
+func (mc *matrixConnector) removeRoom(roomID string) {
+	mc.Lock()
+	delete(mc.rooms, roomID)
+	mc.Unlock()
+}
+
+// setDirect replaces the direct room maps from m.direct account data
+func (mc *matrixConnector) setDirect(direct map[string][]string) {
+	directRooms := make(map[string]string)
+	for userID, rooms := range direct {
+		for _, roomID := range rooms {
+			directRooms[roomID] = userID
+		}
+	}
+	mc.Lock()
+	mc.direct = direct
+	mc.directRooms = directRooms
+	mc.Unlock()
+}
+
+// addDirect records a new direct room in the robot's m.direct account data
+func (mc *matrixConnector) addDirect(userID, roomID string) {
+	mc.Lock()
+	direct := make(map[string][]string)
+	for u, rooms := range mc.direct {
+		direct[u] = rooms
+	}
+	direct[userID] = append([]string{roomID}, direct[userID]...)
+	mc.direct = direct
+	mc.directRooms[roomID] = userID
+	mc.Unlock()
+	if err := mc.api.setDirectRooms(direct); err != nil {
+		mc.Log(bot.Error, "Updating matrix m.direct account data: %v", err)
+	}
+}
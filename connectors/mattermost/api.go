@@ -0,0 +1,183 @@
+package mattermost
+
+/* api.go - a minimal client for the Mattermost v4 REST API, with just the
+calls the connector needs. */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Maximum page size for user listings
+const usersPerPage = 200
+
+type mmUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	Email     string `json:"email"`
+	IsBot     bool   `json:"is_bot"`
+	DeleteAt  int64  `json:"delete_at"`
+}
+
+type mmTeam struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// mmChannel types
+const (
+	openChannel    = "O"
+	privateChannel = "P"
+	directChannel  = "D"
+	groupChannel   = "G"
+)
+
+type mmChannel struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+type mmPost struct {
+	ID        string `json:"id,omitempty"`
+	ChannelID string `json:"channel_id"`
+	UserID    string `json:"user_id,omitempty"`
+	RootID    string `json:"root_id,omitempty"`
+	Message   string `json:"message"`
+	Type      string `json:"type,omitempty"`
+}
+
+// apiError is the body of a failed API call
+type apiError struct {
+	ID         string `json:"id"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"status_code"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("mattermost API error %d (%s): %s", e.StatusCode, e.ID, e.Message)
+}
+
+type apiClient struct {
+	server string // e.g. https://chat.example.com
+	token  string
+	http   *http.Client
+}
+
+func newAPIClient(server, token string) *apiClient {
+	return &apiClient{
+		server: strings.TrimRight(server, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: optimeout},
+	}
+}
+
+// do calls the API, JSON-encoding body if non-nil, and decoding the response
+// in to result if non-nil
+func (c *apiClient) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.server+"/api/v4"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (c *apiClient) getMe() (*mmUser, error) {
+	var u mmUser
+	return &u, c.do("GET", "/users/me", nil, &u)
+}
+
+func (c *apiClient) getTeamByName(name string) (*mmTeam, error) {
+	var t mmTeam
+	return &t, c.do("GET", "/teams/name/"+url.PathEscape(name), nil, &t)
+}
+
+// getTeamUsers returns all the active users in a team
+func (c *apiClient) getTeamUsers(teamID string) ([]mmUser, error) {
+	var users []mmUser
+	for page := 0; ; page++ {
+		var pageUsers []mmUser
+		path := fmt.Sprintf("/users?in_team=%s&page=%d&per_page=%d", url.QueryEscape(teamID), page, usersPerPage)
+		if err := c.do("GET", path, nil, &pageUsers); err != nil {
+			return users, err
+		}
+		for _, u := range pageUsers {
+			if u.DeleteAt == 0 {
+				users = append(users, u)
+			}
+		}
+		if len(pageUsers) < usersPerPage {
+			return users, nil
+		}
+	}
+}
+
+// getUserChannels returns the channels, DMs and group messages a user
+// belongs to in a team
+func (c *apiClient) getUserChannels(userID, teamID string) ([]mmChannel, error) {
+	var channels []mmChannel
+	err := c.do("GET", "/users/"+userID+"/teams/"+teamID+"/channels", nil, &channels)
+	return channels, err
+}
+
+func (c *apiClient) getChannelByName(teamID, name string) (*mmChannel, error) {
+	var ch mmChannel
+	return &ch, c.do("GET", "/teams/"+teamID+"/channels/name/"+url.PathEscape(name), nil, &ch)
+}
+
+func (c *apiClient) addChannelMember(channelID, userID string) error {
+	return c.do("POST", "/channels/"+channelID+"/members", map[string]string{"user_id": userID}, nil)
+}
+
+// createDirectChannel returns the DM channel between two users, creating
+// it if needed
+func (c *apiClient) createDirectChannel(userID, otherID string) (*mmChannel, error) {
+	var ch mmChannel
+	return &ch, c.do("POST", "/channels/direct", []string{userID, otherID}, &ch)
+}
+
+func (c *apiClient) createPost(p *mmPost) (*mmPost, error) {
+	var created mmPost
+	return &created, c.do("POST", "/posts", p, &created)
+}
+
+// websocketURL returns the URL of the event stream
+func (c *apiClient) websocketURL() string {
+	// http -> ws, https -> wss
+	return "ws" + strings.TrimPrefix(c.server, "http") + "/api/v4/websocket"
+}
@@ -0,0 +1,133 @@
+// Package mattermost implements the bot.Connector interface for Mattermost,
+// using the v4 REST API for sending messages and the websocket event stream
+// for receiving them.
+package mattermost
+
+import (
+	"log"
+	"sync"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+type config struct {
+	Server          string // the base URL of the Mattermost server, e.g. https://chat.example.com
+	Token           string // access token for the robot's bot account
+	Team            string // name of the team the robot belongs to
+	MaxMessageSplit int    // the maximum # of ~16k messages to split a large message into
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+func init() {
+	bot.RegisterConnector("mattermost", Initialize)
+}
+
+// Initialize starts the connection, sets up and returns the connector object
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	return bot.Connector(newConnector(robot))
+}
+
+// newConnector reads the protocol configuration, connects to the server and
+// returns the connector; Initialize makes sure it only happens once per
+// robot, while tests create one for each fake server.
+func newConnector(robot bot.Handler) *mmConnector {
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, "unable to retrieve mattermost protocol configuration: %v", err)
+	}
+	if len(c.Server) == 0 {
+		robot.Log(bot.Fatal, "no mattermost Server found in config")
+	}
+	if len(c.Token) == 0 {
+		robot.Log(bot.Fatal, "no mattermost Token found in config")
+	}
+	if len(c.Team) == 0 {
+		robot.Log(bot.Fatal, "no mattermost Team found in config")
+	}
+	if c.MaxMessageSplit == 0 {
+		c.MaxMessageSplit = 1
+	}
+
+	mc := &mmConnector{
+		api:             newAPIClient(c.Server, c.Token),
+		maxMessageSplit: c.MaxMessageSplit,
+		events:          make(chan *wsEvent, 100),
+		channelInfo:     make(map[string]*mmChannel),
+		userIDToDM:      make(map[string]string),
+		dmToUserID:      make(map[string]string),
+	}
+	mc.Handler = robot
+
+	me, err := mc.api.getMe()
+	if err != nil {
+		robot.Log(bot.Fatal, "unable to get mattermost bot user: %v", err)
+	}
+	team, err := mc.api.getTeamByName(c.Team)
+	if err != nil {
+		robot.Log(bot.Fatal, "unable to get mattermost team '%s': %v", c.Team, err)
+	}
+	mc.botName = me.Username
+	mc.botID = me.ID
+	mc.teamID = team.ID
+	mc.Log(bot.Info, "mattermost setting bot internal ID to: %s", mc.botID)
+	mc.SetBotID(mc.botID)
+	mc.SetBotMention(mc.botName)
+
+	connected := make(chan struct{})
+	go mc.manageConnection(connected)
+	<-connected
+
+	mc.updateChannelMaps("")
+	mc.updateUserList("")
+
+	return mc
+}
+
+func (m *mmConnector) Run(stop <-chan struct{}) {
+	m.Lock()
+	// This should never happen, just a bit of defensive coding
+	if m.running {
+		m.Unlock()
+		return
+	}
+	m.running = true
+	m.Unlock()
+loop:
+	for {
+		select {
+		case <-stop:
+			m.Log(bot.Debug, "Received stop in connector")
+			break loop
+		case ev := <-m.events:
+			m.Log(bot.Trace, "Event Received (event, data): %s; %v", ev.Event, ev.Data)
+			switch ev.Event {
+			case "posted":
+				// Message processing is done concurrently
+				go m.processPost(ev)
+
+			case "channel_created", "channel_updated", "channel_deleted",
+				"channel_converted", "direct_added", "group_added",
+				"user_added", "user_removed":
+				m.updateChannelMaps("")
+
+			case "new_user", "user_updated":
+				m.updateUserList("")
+
+			default:
+				// Ignore other events..
+			}
+		}
+	}
+}
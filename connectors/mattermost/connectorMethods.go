@@ -0,0 +1,182 @@
+package mattermost
+
+import (
+	"strings"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// GetProtocolUserAttribute returns a string attribute or "" if mattermost
+// doesn't have that information
+func (m *mmConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	var userID string
+	var ok bool
+	var user *mmUser
+	if userID, ok = bot.ExtractID(u); !ok {
+		userID, ok = m.userID(u)
+	}
+	if ok {
+		m.RLock()
+		user, ok = m.userIDInfo[userID]
+		m.RUnlock()
+	}
+	if !ok {
+		return "", bot.UserNotFound
+	}
+	switch attr {
+	case "email":
+		return user.Email, bot.Ok
+	case "internalid":
+		return user.ID, bot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return strings.TrimSpace(user.FirstName + " " + user.LastName), bot.Ok
+	case "firstname", "first name":
+		return user.FirstName, bot.Ok
+	case "lastname", "last name":
+		return user.LastName, bot.Ok
+	case "nickname", "nick":
+		return user.Nickname, bot.Ok
+	// that's all the attributes we can currently get from mattermost
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a typing notifier letting the user know the message has
+// been heard by the robot.
+func (m *mmConnector) MessageHeard(user, channel string) {
+	if chanID, ok := bot.ExtractID(channel); ok {
+		m.sendAction("user_typing", map[string]string{"channel_id": chanID})
+	}
+}
+
+// SetUserMap takes a map of username to userID mappings, built from the UserRoster
+// of gopherbot.yaml
+func (m *mmConnector) SetUserMap(umap map[string]string) {
+	m.Lock()
+	m.botUserMap = umap
+	m.Unlock()
+	m.updateUserList("")
+}
+
+// channelID returns the channel ID for "<ID>" or a channel name
+func (m *mmConnector) channelID(ch string) (string, bool) {
+	if chanID, ok := bot.ExtractID(ch); ok {
+		return chanID, true
+	}
+	return m.chanID(ch)
+}
+
+// mention returns the "@user: " prefix for directing a message to a user
+func (m *mmConnector) mention(uid, u string) string {
+	if userID, ok := bot.ExtractID(uid); ok {
+		if name, ok := m.userName(userID); ok {
+			u = name
+		}
+	}
+	return "@" + u + ": "
+}
+
+// SendProtocolChannelMessage sends a message to a channel
+func (m *mmConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return m.SendProtocolChannelThreadMessage(ch, "", msg, f)
+}
+
+// SendProtocolUserChannelMessage sends a message to a channel, addressed to
+// the user
+func (m *mmConnector) SendProtocolUserChannelMessage(uid, u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return m.SendProtocolUserChannelThreadMessage(uid, u, ch, "", msg, f)
+}
+
+// SendProtocolChannelThreadMessage sends a message to a thread in a channel
+func (m *mmConnector) SendProtocolChannelThreadMessage(ch, thread, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	chanID, ok := m.channelID(ch)
+	if !ok {
+		m.Log(bot.Error, "mattermost channel ID not found for: %s", ch)
+		return bot.ChannelNotFound
+	}
+	return m.sendPosts(chanID, thread, m.mmFormatMessage("", msg, f))
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a user in a thread
+func (m *mmConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thread, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	chanID, ok := m.channelID(ch)
+	if !ok {
+		m.Log(bot.Error, "mattermost channel ID not found for: %s", ch)
+		return bot.ChannelNotFound
+	}
+	return m.sendPosts(chanID, thread, m.mmFormatMessage(m.mention(uid, u), msg, f))
+}
+
+// SendProtocolUserMessage sends a direct message to a user, creating the
+// DM channel if the robot has never messaged them
+func (m *mmConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	var dmChannel string
+	if dmChannel, ret = m.userDMChannel(u); ret != bot.Ok {
+		return
+	}
+	return m.sendPosts(dmChannel, "", m.mmFormatMessage("", msg, f))
+}
+
+// userDMChannel returns the DM channel ID for "<userID>" or a username
+func (m *mmConnector) userDMChannel(u string) (string, bot.RetVal) {
+	var userID string
+	var ok bool
+	if userID, ok = bot.ExtractID(u); !ok {
+		userID, ok = m.userID(u)
+	}
+	if !ok {
+		m.Log(bot.Error, "No user ID found for user: %s", u)
+		return "", bot.UserNotFound
+	}
+	if dmChannel, ok := m.userDMID(userID); ok {
+		return dmChannel, bot.Ok
+	}
+	ch, err := m.api.createDirectChannel(m.botID, userID)
+	if err != nil {
+		m.Log(bot.Error, "Unable to open mattermost DM with user '%s': %v", u, err)
+		return "", bot.FailedMessageSend
+	}
+	m.Lock()
+	m.userIDToDM[userID] = ch.ID
+	m.dmToUserID[ch.ID] = userID
+	m.channelInfo[ch.ID] = ch
+	m.Unlock()
+	return ch.ID, bot.Ok
+}
+
+func (m *mmConnector) sendPosts(chanID, thread string, msgs []string) bot.RetVal {
+	for _, msg := range msgs {
+		post := &mmPost{
+			ChannelID: chanID,
+			RootID:    thread,
+			Message:   msg,
+		}
+		if _, err := m.api.createPost(post); err != nil {
+			m.Log(bot.Error, "sending mattermost message to channel %s: %v", chanID, err)
+			return bot.FailedMessageSend
+		}
+	}
+	return bot.Ok
+}
+
+// JoinChannel joins a channel given it's human-readable name, e.g. "town-square"
+func (m *mmConnector) JoinChannel(c string) (ret bot.RetVal) {
+	m.RLock()
+	_, ok := m.channelToID[c]
+	m.RUnlock()
+	if ok {
+		return bot.Ok
+	}
+	ch, err := m.api.getChannelByName(m.teamID, c)
+	if err != nil {
+		m.Log(bot.Error, "Unable to find mattermost channel '%s': %v", c, err)
+		return bot.ChannelNotFound
+	}
+	if err := m.api.addChannelMember(ch.ID, m.botID); err != nil {
+		m.Log(bot.Error, "Unable to join mattermost channel '%s': %v", c, err)
+		return bot.FailedChannelJoin
+	}
+	m.updateChannelMaps("")
+	return bot.Ok
+}
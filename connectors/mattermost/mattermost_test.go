@@ -0,0 +1,305 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/internal/testbot"
+)
+
+// fakeServer is a minimal Mattermost server, with a bot user "gopher",
+// users "alice" and "bob", a "town-square" channel and a DM with alice.
+type fakeServer struct {
+	*httptest.Server
+	sync.Mutex
+	posts   []mmPost
+	joined  []string
+	events  chan interface{} // sent to the websocket client
+	actions chan wsAction    // received from the websocket client
+}
+
+var (
+	fakeUsers = []mmUser{
+		{ID: "botid", Username: "gopher", IsBot: true},
+		{ID: "aliceid", Username: "alice", FirstName: "Alice", LastName: "User", Email: "alice@example.com"},
+		{ID: "bobid", Username: "bob", FirstName: "Bob"},
+	}
+	fakeChannels = []mmChannel{
+		{ID: "townid", TeamID: "teamid", Type: openChannel, Name: "town-square"},
+		{ID: "dmaliceid", Type: directChannel, Name: "aliceid__botid"},
+	}
+)
+
+func newFakeServer() *fakeServer {
+	fs := &fakeServer{
+		events:  make(chan interface{}, 10),
+		actions: make(chan wsAction, 10),
+	}
+	mux := http.NewServeMux()
+	reply := func(rw http.ResponseWriter, v interface{}) {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(v)
+	}
+	mux.HandleFunc("/api/v4/users/me", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer testtoken" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reply(rw, fakeUsers[0])
+	})
+	mux.HandleFunc("/api/v4/teams/name/testteam", func(rw http.ResponseWriter, r *http.Request) {
+		reply(rw, mmTeam{ID: "teamid", Name: "testteam"})
+	})
+	mux.HandleFunc("/api/v4/users", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("in_team") != "teamid" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reply(rw, fakeUsers)
+	})
+	mux.HandleFunc("/api/v4/users/botid/teams/teamid/channels", func(rw http.ResponseWriter, r *http.Request) {
+		fs.Lock()
+		channels := append([]mmChannel{}, fakeChannels...)
+		for _, name := range fs.joined {
+			channels = append(channels, mmChannel{ID: name + "id", Type: openChannel, Name: name})
+		}
+		fs.Unlock()
+		reply(rw, channels)
+	})
+	mux.HandleFunc("/api/v4/teams/teamid/channels/name/", func(rw http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/api/v4/teams/teamid/channels/name/")
+		if name != "random" {
+			rw.WriteHeader(http.StatusNotFound)
+			reply(rw, apiError{ID: "store.sql_channel.get_by_name.missing", Message: "not found", StatusCode: 404})
+			return
+		}
+		reply(rw, mmChannel{ID: "randomid", Type: openChannel, Name: "random"})
+	})
+	mux.HandleFunc("/api/v4/channels/randomid/members", func(rw http.ResponseWriter, r *http.Request) {
+		var member map[string]string
+		json.NewDecoder(r.Body).Decode(&member)
+		if member["user_id"] != "botid" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fs.Lock()
+		fs.joined = append(fs.joined, "random")
+		fs.Unlock()
+		rw.WriteHeader(http.StatusCreated)
+		reply(rw, member)
+	})
+	mux.HandleFunc("/api/v4/channels/direct", func(rw http.ResponseWriter, r *http.Request) {
+		var ids []string
+		json.NewDecoder(r.Body).Decode(&ids)
+		reply(rw, mmChannel{ID: "dm" + ids[1], Type: directChannel, Name: ids[0] + "__" + ids[1]})
+	})
+	mux.HandleFunc("/api/v4/posts", func(rw http.ResponseWriter, r *http.Request) {
+		var p mmPost
+		json.NewDecoder(r.Body).Decode(&p)
+		fs.Lock()
+		fs.posts = append(fs.posts, p)
+		fs.Unlock()
+		p.ID = "postid"
+		rw.WriteHeader(http.StatusCreated)
+		reply(rw, p)
+	})
+	mux.HandleFunc("/api/v4/websocket", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer testtoken" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(rw, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for {
+				var a wsAction
+				if err := conn.ReadJSON(&a); err != nil {
+					return
+				}
+				fs.actions <- a
+			}
+		}()
+		for ev := range fs.events {
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		}
+	})
+	fs.Server = httptest.NewServer(mux)
+	return fs
+}
+
+// sent returns and clears the posts sent to the fake server
+func (fs *fakeServer) sent() []mmPost {
+	fs.Lock()
+	defer fs.Unlock()
+	posts := fs.posts
+	fs.posts = nil
+	return posts
+}
+
+func postedEvent(post mmPost, channelType string) map[string]interface{} {
+	data, _ := json.Marshal(post)
+	return map[string]interface{}{
+		"event": "posted",
+		"data": map[string]interface{}{
+			"channel_type": channelType,
+			"post":         string(data),
+		},
+		"broadcast": map[string]string{"channel_id": post.ChannelID},
+	}
+}
+
+// newTestConnector returns a running connector for a new fake server;
+// both are stopped when the test ends
+func newTestConnector(t *testing.T) (*fakeServer, *testbot.Handler, *mmConnector) {
+	fs := newFakeServer()
+	h := testbot.NewHandler(config{
+		Server: fs.URL,
+		Token:  "testtoken",
+		Team:   "testteam",
+	})
+	mc := newConnector(h)
+	stop := make(chan struct{})
+	go mc.Run(stop)
+	t.Cleanup(func() {
+		close(stop)
+		close(fs.events)
+		fs.Close()
+	})
+	return fs, h, mc
+}
+
+func TestInitialize(t *testing.T) {
+	_, h, _ := newTestConnector(t)
+	assert.Equal(t, "botid", h.BotID())
+	assert.Equal(t, "gopher", h.BotMention())
+}
+
+func TestChannelMessage(t *testing.T) {
+	fs, h, _ := newTestConnector(t)
+	fs.events <- postedEvent(mmPost{ID: "p1", ChannelID: "townid", UserID: "aliceid", Message: "@gopher ping"}, openChannel)
+	msg := h.WaitMessage(t)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, "aliceid", msg.UserID)
+	assert.Equal(t, "alice", msg.UserName)
+	assert.Equal(t, "townid", msg.ChannelID)
+	assert.Equal(t, "town-square", msg.ChannelName)
+	assert.Equal(t, "p1", msg.MessageID)
+	assert.False(t, msg.DirectMessage)
+}
+
+func TestThreadDirectMessage(t *testing.T) {
+	fs, h, _ := newTestConnector(t)
+	// messages from self and system messages are ignored
+	fs.events <- postedEvent(mmPost{ID: "p2", ChannelID: "townid", UserID: "botid", Message: "pong"}, openChannel)
+	fs.events <- postedEvent(mmPost{ID: "p3", ChannelID: "townid", UserID: "bobid", Type: "system_join_channel"}, openChannel)
+	fs.events <- postedEvent(mmPost{ID: "p4", ChannelID: "dmaliceid", UserID: "aliceid", RootID: "p0", Message: "help"}, directChannel)
+	msg := h.WaitMessage(t)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, "p4", msg.MessageID)
+	assert.Equal(t, "p0", msg.ThreadID)
+	assert.True(t, msg.DirectMessage)
+	assert.Equal(t, "", msg.ChannelName)
+}
+
+func TestMessageHeard(t *testing.T) {
+	fs, _, mc := newTestConnector(t)
+	mc.MessageHeard("<aliceid>", "<townid>")
+	select {
+	case a := <-fs.actions:
+		assert.Equal(t, "user_typing", a.Action)
+		assert.Equal(t, "townid", a.Data["channel_id"])
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for typing notification")
+	}
+}
+
+func TestSendMessages(t *testing.T) {
+	fs, _, mc := newTestConnector(t)
+	assert.Equal(t, bot.Ok, mc.SendProtocolChannelMessage("town-square", "ls -l", bot.Fixed))
+	assert.Equal(t, bot.Ok, mc.SendProtocolUserChannelMessage("<aliceid>", "alice", "<townid>", "*hi*", bot.Variable))
+	assert.Equal(t, bot.Ok, mc.SendProtocolChannelThreadMessage("town-square", "p1", "threaded", bot.Raw))
+	assert.Equal(t, bot.ChannelNotFound, mc.SendProtocolChannelMessage("nowhere", "hello", bot.Raw))
+	posts := fs.sent()
+	if assert.Len(t, posts, 3) {
+		assert.Equal(t, mmPost{ChannelID: "townid", Message: "```\nls -l\n```"}, posts[0])
+		assert.Equal(t, mmPost{ChannelID: "townid", Message: `@alice: \*hi\*`}, posts[1])
+		assert.Equal(t, mmPost{ChannelID: "townid", RootID: "p1", Message: "threaded"}, posts[2])
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	fs, _, mc := newTestConnector(t)
+	assert.Equal(t, bot.Ok, mc.SendProtocolUserMessage("alice", "existing DM", bot.Raw))
+	// the robot has never messaged bob
+	assert.Equal(t, bot.Ok, mc.SendProtocolUserMessage("<bobid>", "new DM", bot.Raw))
+	assert.Equal(t, bot.UserNotFound, mc.SendProtocolUserMessage("carol", "nobody", bot.Raw))
+	posts := fs.sent()
+	if assert.Len(t, posts, 2) {
+		assert.Equal(t, "dmaliceid", posts[0].ChannelID)
+		assert.Equal(t, "dmbobid", posts[1].ChannelID)
+	}
+}
+
+func TestJoinChannel(t *testing.T) {
+	fs, _, mc := newTestConnector(t)
+	assert.Equal(t, bot.Ok, mc.JoinChannel("town-square"))
+	assert.Equal(t, bot.Ok, mc.JoinChannel("random"))
+	assert.Equal(t, bot.ChannelNotFound, mc.JoinChannel("nowhere"))
+	assert.Equal(t, bot.Ok, mc.SendProtocolChannelMessage("random", "hello", bot.Raw))
+	posts := fs.sent()
+	if assert.Len(t, posts, 1) {
+		assert.Equal(t, "randomid", posts[0].ChannelID)
+	}
+}
+
+func TestUserAttributes(t *testing.T) {
+	_, _, mc := newTestConnector(t)
+	value, ret := mc.GetProtocolUserAttribute("alice", "email")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "alice@example.com", value)
+	value, ret = mc.GetProtocolUserAttribute("<aliceid>", "fullname")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "Alice User", value)
+	_, ret = mc.GetProtocolUserAttribute("alice", "phone")
+	assert.Equal(t, bot.AttributeNotFound, ret)
+	_, ret = mc.GetProtocolUserAttribute("carol", "email")
+	assert.Equal(t, bot.UserNotFound, ret)
+}
+
+func TestMessageSplit(t *testing.T) {
+	mc := &mmConnector{maxMessageSplit: 2, Handler: testbot.NewHandler(nil)}
+	line := strings.Repeat("x", 80) + "\n"
+	long := strings.Repeat(line, 2*maxMessageLength/len(line)+10)
+	msgs := mc.mmFormatMessage("", long, bot.Fixed)
+	if assert.Len(t, msgs, 3) {
+		for _, msg := range msgs[:2] {
+			assert.True(t, strings.HasPrefix(msg, "```\n"))
+			assert.True(t, strings.HasSuffix(msg, "\n```"))
+			assert.True(t, len(msg) <= maxMessageLength+8)
+		}
+		assert.Equal(t, "(message too long, truncated)", msgs[2])
+	}
+	// don't split in the middle of a character
+	msgs = mc.mmFormatMessage("", strings.Repeat("é", maxMessageLength), bot.Raw)
+	for _, msg := range msgs[:2] {
+		assert.True(t, utf8.ValidString(msg))
+	}
+}
@@ -0,0 +1,133 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// Mattermost's default maximum post size is 16383 characters; leave room for
+// formatting.
+const maxMessageLength = 16383 - 100
+
+// markdownEscaper escapes characters with special meaning in Mattermost's
+// markdown, for the Variable format
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"#", `\#`,
+	"[", `\[`,
+	"]", `\]`,
+	">", `\>`,
+	"|", `\|`,
+)
+
+func formatMessage(msg string, f bot.MessageFormat) string {
+	switch f {
+	case bot.Fixed:
+		return "```\n" + msg + "\n```"
+	case bot.Variable:
+		return markdownEscaper.Replace(msg)
+	}
+	return msg
+}
+
+// mmFormatMessage formats a message, and splits it in to at most
+// maxMessageSplit posts, plus "(message truncated)" if it's still too long.
+func (m *mmConnector) mmFormatMessage(prefix, msg string, f bot.MessageFormat) []string {
+	if f == bot.Variable {
+		msg = formatMessage(msg, f)
+	}
+	msg = prefix + msg
+	if len(msg) <= maxMessageLength {
+		return []string{m.optQuote(msg, f)}
+	}
+	msgs := make([]string, 0, m.maxMessageSplit+1)
+	m.Log(bot.Info, "Message too long, segmenting: %d bytes", len(msg))
+	for len(msg) > maxMessageLength && len(msgs) < m.maxMessageSplit {
+		lineEnd := strings.LastIndexByte(msg[:maxMessageLength], '\n')
+		if lineEnd == -1 { // no newline in this chunk
+			cut := maxMessageLength
+			for cut > 0 && !utf8.RuneStart(msg[cut]) {
+				cut--
+			}
+			msgs = append(msgs, m.optQuote(msg[:cut], f))
+			msg = msg[cut:]
+		} else {
+			msgs = append(msgs, m.optQuote(msg[:lineEnd], f))
+			msg = msg[lineEnd+1:] // skip over the newline
+		}
+	}
+	if len(msgs) == m.maxMessageSplit { // we've maxed out
+		if len(msg) > 0 { // if there's anything left, we've truncated
+			msgs = append(msgs, "(message too long, truncated)")
+		}
+	} else { // the last chunk fits
+		msgs = append(msgs, m.optQuote(msg, f))
+	}
+	return msgs
+}
+
+// optQuote wraps Fixed messages in a code block
+func (m *mmConnector) optQuote(msg string, f bot.MessageFormat) string {
+	if f == bot.Fixed {
+		return formatMessage(msg, f)
+	}
+	return msg
+}
+
+// processPost examines "posted" events and routes them to the engine.
+func (m *mmConnector) processPost(ev *wsEvent) {
+	postJSON, _ := ev.Data["post"].(string)
+	var post mmPost
+	if err := json.Unmarshal([]byte(postJSON), &post); err != nil {
+		m.Log(bot.Error, "Unable to decode mattermost post: %v", err)
+		return
+	}
+	m.Log(bot.Trace, "Message received: %v", post)
+	if len(post.Type) > 0 {
+		// system messages, e.g. "system_join_channel"
+		m.Log(bot.Debug, "Ignoring mattermost '%s' post in channel '%s'", post.Type, post.ChannelID)
+		return
+	}
+	if len(post.UserID) == 0 {
+		m.Log(bot.Debug, "Zero-length userID, ignoring message")
+		return
+	}
+	if post.UserID == m.botID {
+		m.Log(bot.Debug, "Ignoring message from self")
+		return
+	}
+	ci, ok := m.getChannelInfo(post.ChannelID)
+	if !ok {
+		m.Log(bot.Error, "Couldn't find channel info for channel ID %s", post.ChannelID)
+		return
+	}
+	isDM := ci.Type == directChannel
+	botMsg := &bot.ConnectorMessage{
+		Protocol:      "Mattermost",
+		UserID:        post.UserID,
+		ChannelID:     post.ChannelID,
+		DirectMessage: isDM,
+		MessageText:   post.Message,
+		ThreadID:      post.RootID,
+		MessageID:     post.ID,
+		MessageObject: &post,
+		Client:        m.api,
+	}
+	userName, ok := m.userName(post.UserID)
+	if !ok {
+		m.Log(bot.Debug, "Couldn't find user name for user ID %s", post.UserID)
+	} else {
+		botMsg.UserName = userName
+	}
+	if !isDM {
+		botMsg.ChannelName = ci.Name
+	}
+	m.IncomingMessage(botMsg)
+}
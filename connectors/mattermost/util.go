@@ -0,0 +1,261 @@
+package mattermost
+
+/* util has most of the struct, type, and const definitions, as well as
+most of the internal methods. */
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+const optimeout = 1 * time.Minute
+
+// mmConnector holds all the relevant data about a connection
+type mmConnector struct {
+	api             *apiClient
+	maxMessageSplit int                   // The maximum # of ~16k messages to send before truncating
+	running         bool                  // set on call to Run
+	botName         string                // mattermost username of the bot
+	botID           string                // mattermost internal bot ID
+	teamID          string                // ID of the team the robot works in
+	events          chan *wsEvent         // events from the websocket, for Run
+	ws              *websocket.Conn       // current websocket connection, nil when disconnected
+	wsLock          sync.Mutex            // for writing to the websocket
+	seq             int64                 // websocket action sequence number
+	bot.Handler                           // bot API for connectors
+	sync.RWMutex                          // shared mutex for locking connector data structures
+	channelInfo     map[string]*mmChannel // info about all the channels the robot belongs to
+	channelToID     map[string]string     // map from channel names to channel IDs
+	idToChannel     map[string]string     // map from channel ID to channel name
+	userIDInfo      map[string]*mmUser    // map from user ID to mmUser
+	botUserMap      map[string]string     // gopherbot-engine provided mappings of username to userID
+	userMap         map[string]string     // map from user name to user ID
+	userIDToDM      map[string]string     // map from user ID to DM channel ID
+	dmToUserID      map[string]string     // map from DM channel ID to user ID
+}
+
+// updateUserList gets an updated list of team users from Mattermost and
+// creates maps from userID to *mmUser and from user name to userID. It can
+// also be called from userID and userName lookup functions; "u:ID" means
+// return the user name for the given ID, "i:user" means return the ID for
+// the given user name.
+func (m *mmConnector) updateUserList(want string) (ret string) {
+	deadline := time.Now().Add(optimeout)
+	var (
+		err      error
+		userlist []mmUser
+	)
+
+	userMap := make(map[string]string)
+	m.RLock()
+	for name, id := range m.botUserMap {
+		userMap[name] = id
+	}
+	m.RUnlock()
+	userIDInfo := make(map[string]*mmUser)
+	for time.Now().Before(deadline) {
+		userlist, err = m.api.getTeamUsers(m.teamID)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		m.Log(bot.Error, "Protocol timeout updating users: %v", err)
+		return ""
+	}
+	for i, user := range userlist {
+		userIDInfo[user.ID] = &userlist[i]
+		if _, ok := userMap[user.Username]; !ok {
+			userMap[user.Username] = user.ID
+		}
+	}
+	w := strings.Split(want, ":")
+	switch w[0] {
+	case "i": // want user ID
+		if r, ok := userMap[w[1]]; ok {
+			ret = r
+		} else {
+			return ""
+		}
+	case "u": // want user name
+		if r, ok := userIDInfo[w[1]]; ok {
+			ret = r.Username
+		} else {
+			// Don't update maps on failed lookup, to avoid thrashing
+			// locks on repeated lookups of non-users
+			return ""
+		}
+	}
+	m.Lock()
+	m.userIDInfo = userIDInfo
+	m.userMap = userMap
+	m.Unlock()
+	m.Log(bot.Debug, "User maps updated")
+	return
+}
+
+func (m *mmConnector) userID(u string) (i string, ok bool) {
+	m.RLock()
+	i, ok = m.userMap[u]
+	m.RUnlock()
+	if !ok {
+		i = m.updateUserList("i:" + u)
+		if len(i) == 0 {
+			m.Log(bot.Error, "Failed ID lookup for user '%s'", u)
+			return "", false
+		}
+		ok = true
+	}
+	return
+}
+
+func (m *mmConnector) userName(i string) (user string, found bool) {
+	m.RLock()
+	if i == m.botID {
+		name := m.botName
+		m.RUnlock()
+		return name, true
+	}
+	var ui *mmUser
+	ui, found = m.userIDInfo[i]
+	m.RUnlock()
+	if found {
+		user = ui.Username
+	} else {
+		user = m.updateUserList("u:" + i)
+		if len(user) == 0 {
+			m.Log(bot.Error, "Failed username lookup for ID '%s'", i)
+			return "", false
+		}
+		found = true
+	}
+	return
+}
+
+// dmUserID returns the other user in a DM channel, named "<id>__<id>"
+func (m *mmConnector) dmUserID(ch *mmChannel) string {
+	ids := strings.Split(ch.Name, "__")
+	for _, id := range ids {
+		if id != m.botID {
+			return id
+		}
+	}
+	// a DM to self
+	return m.botID
+}
+
+// updateChannelMaps gets the channels the robot belongs to and updates the
+// channel and DM maps. Like updateUserList, it can be called for a lookup:
+// "di:chanID" returns the user ID for a DM channel, "dc:userID" the DM
+// channel for a user, "ci:name" the ID for a channel name, and "cc:chanID"
+// the name for a channel ID.
+func (m *mmConnector) updateChannelMaps(want string) (ret string) {
+	deadline := time.Now().Add(optimeout)
+	var (
+		err         error
+		channelList []mmChannel
+	)
+	for time.Now().Before(deadline) {
+		channelList, err = m.api.getUserChannels(m.botID, m.teamID)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		m.Log(bot.Error, "Protocol timeout updating channels: %v", err)
+		return ""
+	}
+	userDMMap := make(map[string]string)
+	dmUserMap := make(map[string]string)
+	chanMap := make(map[string]string)
+	chanIDMap := make(map[string]string)
+	chanInfo := make(map[string]*mmChannel)
+	for i, channel := range channelList {
+		chanInfo[channel.ID] = &channelList[i]
+		if channel.Type == directChannel {
+			userID := m.dmUserID(&channel)
+			userDMMap[userID] = channel.ID
+			dmUserMap[channel.ID] = userID
+		} else {
+			chanMap[channel.Name] = channel.ID
+			chanIDMap[channel.ID] = channel.Name
+		}
+	}
+	w := strings.Split(want, ":")
+	var found bool
+	switch w[0] {
+	case "di":
+		ret, found = dmUserMap[w[1]]
+	case "dc":
+		ret, found = userDMMap[w[1]]
+	case "ci":
+		ret, found = chanMap[w[1]]
+	case "cc":
+		ret, found = chanIDMap[w[1]]
+	default:
+		found = true
+	}
+	if !found {
+		// Don't update maps on failed lookup, see above
+		return ""
+	}
+	m.Lock()
+	m.channelInfo = chanInfo
+	m.userIDToDM = userDMMap
+	m.dmToUserID = dmUserMap
+	m.channelToID = chanMap
+	m.idToChannel = chanIDMap
+	m.Unlock()
+	m.Log(bot.Debug, "Channel maps updated")
+	return
+}
+
+func (m *mmConnector) getChannelInfo(i string) (c *mmChannel, ok bool) {
+	m.RLock()
+	c, ok = m.channelInfo[i]
+	m.RUnlock()
+	if !ok {
+		m.updateChannelMaps("")
+		m.RLock()
+		c, ok = m.channelInfo[i]
+		m.RUnlock()
+		if !ok {
+			m.Log(bot.Error, "Failed lookup of channel info from ID: %s", i)
+			return nil, false
+		}
+	}
+	return c, ok
+}
+
+// Get DM channel ID from user ID
+func (m *mmConnector) userDMID(i string) (c string, ok bool) {
+	m.RLock()
+	c, ok = m.userIDToDM[i]
+	m.RUnlock()
+	if !ok {
+		c = m.updateChannelMaps("dc:" + i)
+		ok = len(c) > 0
+	}
+	return
+}
+
+func (m *mmConnector) chanID(c string) (i string, ok bool) {
+	m.RLock()
+	i, ok = m.channelToID[c]
+	m.RUnlock()
+	if !ok {
+		i = m.updateChannelMaps("ci:" + c)
+		if len(i) == 0 {
+			m.Log(bot.Error, "Failed lookup of channel ID for '%s'", c)
+			return "", false
+		}
+		ok = true
+	}
+	return
+}
@@ -0,0 +1,120 @@
+package mattermost
+
+/* websocket.go - the Mattermost event stream; the robot reconnects with
+backoff when the connection drops, and refreshes its user and channel maps
+for anything it missed. */
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// Reconnect backoff and timeouts
+const (
+	minBackoff  = time.Second
+	maxBackoff  = 5 * time.Minute
+	readTimeout = 2 * time.Minute // the server pings more often than this
+	writeWait   = 10 * time.Second
+)
+
+// wsEvent is an event from the websocket
+type wsEvent struct {
+	Event     string                 `json:"event"`
+	Data      map[string]interface{} `json:"data"`
+	Broadcast struct {
+		ChannelID string `json:"channel_id"`
+		UserID    string `json:"user_id"`
+		TeamID    string `json:"team_id"`
+	} `json:"broadcast"`
+	Seq int64 `json:"seq"`
+}
+
+// wsAction is an action sent to the server
+type wsAction struct {
+	Seq    int64             `json:"seq"`
+	Action string            `json:"action"`
+	Data   map[string]string `json:"data"`
+}
+
+func (m *mmConnector) wsConnect() (*websocket.Conn, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+m.api.token)
+	conn, _, err := websocket.DefaultDialer.Dial(m.api.websocketURL(), header)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		m.wsLock.Lock()
+		defer m.wsLock.Unlock()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+	})
+	return conn, nil
+}
+
+// manageConnection reads events from the websocket until the connection
+// drops, then reconnects; connected is closed after the first connection.
+func (m *mmConnector) manageConnection(connected chan<- struct{}) {
+	backoff := minBackoff
+	first := true
+	for {
+		conn, err := m.wsConnect()
+		if err != nil {
+			if first {
+				m.Log(bot.Fatal, "Unable to connect to mattermost event stream: %v", err)
+			}
+			m.Log(bot.Error, "Connecting to mattermost event stream, retrying in %v: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		m.Log(bot.Info, "Connected to mattermost event stream")
+		backoff = minBackoff
+		m.wsLock.Lock()
+		m.ws = conn
+		m.wsLock.Unlock()
+		if first {
+			first = false
+			close(connected)
+		} else {
+			// catch up on changes while disconnected
+			m.updateChannelMaps("")
+			m.updateUserList("")
+		}
+		for {
+			var ev wsEvent
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+			if err = conn.ReadJSON(&ev); err != nil {
+				break
+			}
+			if len(ev.Event) > 0 {
+				m.events <- &ev
+			}
+		}
+		m.wsLock.Lock()
+		m.ws = nil
+		m.wsLock.Unlock()
+		conn.Close()
+		m.Log(bot.Error, "Lost connection to mattermost event stream, reconnecting: %v", err)
+	}
+}
+
+// sendAction sends an action over the websocket, if connected
+func (m *mmConnector) sendAction(action string, data map[string]string) {
+	m.wsLock.Lock()
+	defer m.wsLock.Unlock()
+	if m.ws == nil {
+		return
+	}
+	m.seq++
+	m.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := m.ws.WriteJSON(&wsAction{m.seq, action, data}); err != nil {
+		m.Log(bot.Debug, "Sending mattermost '%s' action: %v", action, err)
+	}
+}
@@ -0,0 +1,78 @@
+package common_testing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/internal/testbot"
+)
+
+// NewHandler returns a fake bot.Handler for testing the connector without
+// a robot
+func NewHandler() bot.Handler {
+	return testbot.NewHandler(nil)
+}
+
+// Server is a fake Rocket.Chat REST API; requests for routes that haven't
+// been set get a 404.
+type Server struct {
+	URL    *url.URL
+	routes map[string]string
+	calls  map[string]int
+	sync.Mutex
+}
+
+// NewServer starts a fake REST API server, stopped when the test ends
+func NewServer(t *testing.T) *Server {
+	s := &Server{
+		routes: make(map[string]string),
+		calls:  make(map[string]int),
+	}
+	hs := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(hs.Close)
+	s.URL, _ = url.Parse(hs.URL)
+	return s
+}
+
+// route is the key for a request, e.g. "/api/v1/users.info?userId=u2"
+func route(r *http.Request) string {
+	if len(r.URL.RawQuery) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + r.URL.RawQuery
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	key := route(r)
+	s.Lock()
+	body, ok := s.routes[key]
+	if ok {
+		s.calls[key]++
+	}
+	s.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(body))
+}
+
+// Handle sets the JSON body returned for a path and query, e.g.
+// "/api/v1/users.info?userId=u2"
+func (s *Server) Handle(path, body string) {
+	s.Lock()
+	s.routes[path] = body
+	s.Unlock()
+}
+
+// Calls returns the number of requests answered for a path and query
+func (s *Server) Calls(path string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.calls[path]
+}
@@ -3,10 +3,12 @@ package rocket
 
 import (
 	"crypto/md5"
+	"strings"
 	"time"
 
 	"github.com/wanghonggao007/gopherbot/bot"
 	models "github.com/wanghonggao007/gopherbot/connectors/rocket/models"
+	api "github.com/wanghonggao007/gopherbot/connectors/rocket/realtime"
 )
 
 var incoming chan models.Message
@@ -20,10 +22,13 @@ func (rc *rocketConnector) Run(stop <-chan struct{}) {
 	}
 	rc.running = true
 	rc.Unlock()
-	rc.updateChannels()
 	if err := rc.rt.SubscribeRoomUpdates("__my_messages__"); err != nil {
 		rc.Log(bot.Error, "failed subscribing to '__my_messages__, won't hear messages: %v", err)
 	}
+	userEvents := make(chan api.UserEvent, 100)
+	if err := rc.rt.SubscribeUserEvents(userID, userEvents); err != nil {
+		rc.Log(bot.Error, "failed subscribing to rocket user events, new rooms won't be noticed: %v", err)
+	}
 
 	// Detect UserName
 	// TODO: is there a better way to get the robot's UserName?
@@ -43,6 +48,8 @@ func (rc *rocketConnector) Run(stop <-chan struct{}) {
 	} else {
 		rc.Log(bot.Error, "failed getting rocket channel subscriptions, can't get username: %v", err)
 	}
+	// after the UserName, for finding the user in direct message rooms
+	rc.updateChannels()
 
 	mstop := make(chan struct{})
 	// duplicate messages loop
@@ -76,6 +83,8 @@ loop:
 		select {
 		case pmsg := <-incoming:
 			rc.processMessage(&pmsg)
+		case ev := <-userEvents:
+			rc.userEvent(ev)
 		case <-stop:
 			rc.Log(bot.Debug, "Received stop in connector")
 			break loop
@@ -95,24 +104,16 @@ func (rc *rocketConnector) processMessage(msg *models.Message) {
 	if msg.User.UserName == userName {
 		return
 	}
-	hearIt := false
-	directMsg := false
-	mapUser := false
+	hearIt, directMsg, known := rc.hears(msg.RoomID)
+	if !known {
+		// e.g. a new direct message, before the user event arrives
+		rc.updateChannels()
+		hearIt, directMsg, _ = rc.hears(msg.RoomID)
+	}
 	rc.RLock()
 	chName := rc.channelNames[msg.RoomID]
-	if _, ok := rc.dmChannels[msg.RoomID]; ok {
-		hearIt = true
-		directMsg = true
-	}
-	if _, ok := rc.privChannels[msg.RoomID]; ok {
-		hearIt = true
-	}
-	if _, ok := rc.joinedChannels[msg.RoomID]; ok {
-		hearIt = true
-	}
-	if _, ok := rc.userNameIDMap[msg.User.UserName]; !ok {
-		mapUser = true
-	}
+	_, mapped := rc.userNameIDMap[msg.User.UserName]
+	mapUser := !mapped
 	rc.RUnlock()
 	if mapUser {
 		// TODO: is there a better way of mapping username to ID?
@@ -139,6 +140,7 @@ func (rc *rocketConnector) processMessage(msg *models.Message) {
 		ChannelID:     msg.RoomID,
 		ChannelName:   chName,
 		MessageText:   msg.Msg,
+		MessageID:     msg.ID,
 		MessageObject: msg,
 		Client:        rc.rt,
 		DirectMessage: directMsg,
@@ -146,6 +148,22 @@ func (rc *rocketConnector) processMessage(msg *models.Message) {
 	rc.IncomingMessage(botMsg)
 }
 
+// hears reports whether the robot hears messages in a room, whether it's a
+// direct message room, and whether the room is known at all
+func (rc *rocketConnector) hears(roomID string) (hearIt, directMsg, known bool) {
+	rc.RLock()
+	defer rc.RUnlock()
+	_, known = rc.channelNames[roomID]
+	if _, ok := rc.dmChannels[roomID]; ok {
+		return true, true, true
+	}
+	if _, ok := rc.privChannels[roomID]; ok {
+		return true, false, true
+	}
+	_, hearIt = rc.joinedChannels[roomID]
+	return hearIt, false, known || hearIt
+}
+
 func (rc *rocketConnector) updateChannels() {
 	inChannels, ierr := rc.rt.GetChannelsIn()
 	if ierr != nil {
@@ -154,40 +172,115 @@ func (rc *rocketConnector) updateChannels() {
 	}
 	rc.Lock()
 	defer rc.Unlock()
-	if len(inChannels) > 0 {
-		for _, ich := range inChannels {
-			if len(ich.Name) > 0 {
-				rc.channelNames[ich.ID] = ich.Name
-				rc.channelIDs[ich.Name] = ich.ID
-			}
-			if ich.Type == "d" {
-				rc.dmChannels[ich.ID] = struct{}{}
+	for _, ich := range inChannels {
+		rc.addChannel(ich)
+	}
+}
+
+// userEvent keeps the channel maps fresh when the robot is added to or
+// removed from a room, or a room changes
+func (rc *rocketConnector) userEvent(ev api.UserEvent) {
+	rc.Log(bot.Debug, "rocket user event %s/%s for room %s", ev.Event, ev.Action, ev.Channel.ID)
+	rc.Lock()
+	defer rc.Unlock()
+	switch ev.Action {
+	case "inserted", "updated":
+		rc.addChannel(ev.Channel)
+	case "removed":
+		rc.removeChannel(ev.Channel.ID, ev.Event == "rooms-changed")
+	}
+}
+
+// addChannel records a room the robot is in; the caller must hold the
+// lock. Subscriptions don't always carry the room type or members, so
+// fields that are missing don't clear what's known.
+func (rc *rocketConnector) addChannel(ch models.Channel) {
+	if ch.Type != "d" {
+		old, ok := rc.channelNames[ch.ID]
+		if len(ch.Name) > 0 {
+			if ok && old != ch.Name {
+				delete(rc.channelIDs, old)
 			}
-			if ich.Type == "p" {
-				rc.privChannels[ich.ID] = struct{}{}
+			rc.channelNames[ch.ID] = ch.Name
+			rc.channelIDs[ch.Name] = ch.ID
+		} else if !ok {
+			// nameless rooms are recorded so they're known
+			rc.channelNames[ch.ID] = ""
+		}
+	}
+	switch ch.Type {
+	case "d":
+		rc.dmChannels[ch.ID] = struct{}{}
+		for _, u := range ch.Usernames {
+			if u != userName {
+				rc.userDM[u] = ch.ID
 			}
 		}
+	case "p":
+		rc.privChannels[ch.ID] = struct{}{}
+	}
+}
+
+// removeChannel forgets the robot's membership of a room, and the room
+// itself when it's been deleted; the caller must hold the lock. Joined
+// channels are remembered, so they're heard again if the robot is added
+// back.
+func (rc *rocketConnector) removeChannel(roomID string, deleted bool) {
+	delete(rc.dmChannels, roomID)
+	delete(rc.privChannels, roomID)
+	for u, dm := range rc.userDM {
+		if dm == roomID {
+			delete(rc.userDM, u)
+		}
+	}
+	if deleted {
+		if name, ok := rc.channelNames[roomID]; ok {
+			delete(rc.channelIDs, name)
+		}
+		delete(rc.channelNames, roomID)
+		delete(rc.joinedChannels, roomID)
 	}
 }
 
+// escapePad surrounds markdown and mention characters in Variable format
+// messages so they're shown as-is; it's a zero-width space. ":" is left
+// alone, since padding it breaks URLs.
+const escapePad = "\u200b"
+
+var variableEscaper = strings.NewReplacer(
+	"*", escapePad+"*"+escapePad,
+	"_", escapePad+"_"+escapePad,
+	"~", escapePad+"~"+escapePad,
+	"`", escapePad+"`"+escapePad,
+	"@", escapePad+"@"+escapePad,
+	"#", escapePad+"#"+escapePad,
+)
+
 func formatMessage(msg string, f bot.MessageFormat) string {
-	if f == bot.Fixed {
+	switch f {
+	case bot.Fixed:
 		msg = "```" + msg + "```"
+	case bot.Variable:
+		msg = variableEscaper.Replace(msg)
 	}
 	return msg
 }
 
+// channelID resolves "channel" or "<chanID>" to a room ID
+func (rc *rocketConnector) channelID(ch string) (chanID string, found bool) {
+	if chanID, found = bot.ExtractID(ch); found {
+		return
+	}
+	rc.RLock()
+	chanID, found = rc.channelIDs[ch]
+	rc.RUnlock()
+	return
+}
+
 // sendMessage takes "channel" or "<chanID>" and sends the pre-formatted
 // message.
 func (rc *rocketConnector) sendMessage(ch, msg string) (ret bot.RetVal) {
-	var chanID string
-	found := false
-	chanID, found = bot.ExtractID(ch)
-	if !found {
-		rc.RLock()
-		chanID, found = rc.channelIDs[ch]
-		rc.RUnlock()
-	}
+	chanID, found := rc.channelID(ch)
 	if !found {
 		return bot.ChannelNotFound
 	}
@@ -1,11 +1,38 @@
 package rocket
 
 import (
+	"time"
+
 	"github.com/wanghonggao007/gopherbot/bot"
+	models "github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 )
 
+// How long the robot shows as typing when it hears a message
+const typingTime = time.Second
+
+// MessageHeard shows the robot typing in the room for a moment
 func (rc *rocketConnector) MessageHeard(u, c string) {
-	return
+	roomID, ok := bot.ExtractID(c)
+	if !ok {
+		if roomID, ok = rc.channelID(c); !ok {
+			if user, ret := rc.rocketUserName(u); ret == bot.Ok {
+				rc.RLock()
+				roomID, ok = rc.userDM[user]
+				rc.RUnlock()
+			}
+		}
+	}
+	if !ok || len(userName) == 0 {
+		return
+	}
+	go func() {
+		if err := rc.rt.StartTyping(roomID, userName); err != nil {
+			rc.Log(bot.Debug, "sending rocket typing notification: %v", err)
+			return
+		}
+		time.Sleep(typingTime)
+		rc.rt.StopTyping(roomID, userName)
+	}()
 }
 
 // SetUserMap lets Gopherbot provide a mapping of usernames to user IDs
@@ -34,6 +61,11 @@ func (rc *rocketConnector) SendProtocolChannelMessage(ch string, msg string, f b
 
 // SendProtocolChannelMessage sends a message to a channel
 func (rc *rocketConnector) SendProtocolUserChannelMessage(uid, uname, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return rc.sendMessage(ch, rc.mention(uid, uname)+formatMessage(msg, f))
+}
+
+// mention returns the "@user " prefix for directing a message to a user
+func (rc *rocketConnector) mention(uid, uname string) string {
 	var user string
 	// We prefer to use @(rocketchat username), looked up from
 	// the user ID.
@@ -49,29 +81,29 @@ func (rc *rocketConnector) SendProtocolUserChannelMessage(uid, uname, ch, msg st
 			user = uname
 		} else {
 			rc.Log(bot.Warn, "Unable to resolve a rocket chat username for %s", uid)
+			return ""
 		}
 	}
-	msg = formatMessage(msg, f)
-	if len(user) > 0 {
-		msg = "@" + uname + " " + msg
-	}
-	return rc.sendMessage(ch, msg)
+	return "@" + user + " "
 }
 
 // SendProtocolUserMessage sends a direct message to a user
 func (rc *rocketConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
-	var uid, dchan, user string
+	var dchan string
+	if dchan, ret = rc.userDMChannel(u); ret != bot.Ok {
+		return
+	}
+	// sendMessage expects internal channels IDs to be bracketed
+	return rc.sendMessage("<"+dchan+">", formatMessage(msg, f))
+}
+
+// userDMChannel finds or creates the direct message room for a user
+func (rc *rocketConnector) userDMChannel(u string) (dchan string, ret bot.RetVal) {
+	var user string
 	var ok bool
 	var err error
-	if uid, ok = bot.ExtractID(u); ok {
-		rc.RLock()
-		user, ok = rc.userIDNameMap[uid]
-		rc.RUnlock()
-		if !ok {
-			return bot.UserNotFound
-		}
-	} else {
-		user = u
+	if user, ret = rc.rocketUserName(u); ret != bot.Ok {
+		return "", ret
 	}
 	rc.RLock()
 	dchan, ok = rc.userDM[user]
@@ -79,14 +111,45 @@ func (rc *rocketConnector) SendProtocolUserMessage(u string, msg string, f bot.M
 	if !ok {
 		if dchan, err = rc.rt.CreateDirectMessage(user); err != nil {
 			rc.Log(bot.Error, "creating direct message for %s: %v", user, err)
-			return bot.FailedMessageSend
+			return "", bot.FailedMessageSend
 		}
 		rc.Lock()
 		rc.userDM[user] = dchan
+		rc.dmChannels[dchan] = struct{}{}
 		rc.Unlock()
 	}
-	// sendMessage expects internal channels IDs to be bracketed
-	return rc.sendMessage("<"+dchan+">", formatMessage(msg, f))
+	return dchan, bot.Ok
+}
+
+// rocketUserName returns the Rocket.Chat username for "<userID>" or a
+// username. Users from the UserRoster are found by ID, and IDs the robot
+// hasn't heard from are looked up with the REST API.
+func (rc *rocketConnector) rocketUserName(u string) (string, bot.RetVal) {
+	uid, ok := bot.ExtractID(u)
+	if !ok {
+		rc.RLock()
+		uid, ok = rc.gbuserNameIDMap[u]
+		rc.RUnlock()
+		if !ok {
+			return u, bot.Ok
+		}
+	}
+	rc.RLock()
+	user, ok := rc.userIDNameMap[uid]
+	rc.RUnlock()
+	if ok {
+		return user, bot.Ok
+	}
+	info, err := rc.rest.GetUserInfo(uid)
+	if err != nil || len(info.UserName) == 0 {
+		rc.Log(bot.Error, "looking up rocket chat user %s: %v", uid, err)
+		return "", bot.UserNotFound
+	}
+	rc.Lock()
+	rc.userNameIDMap[info.UserName] = uid
+	rc.userIDNameMap[uid] = info.UserName
+	rc.Unlock()
+	return info.UserName, bot.Ok
 }
 
 // JoinChannel joins a channel given it's human-readable name, e.g. "general"
@@ -114,3 +177,124 @@ func (rc *rocketConnector) JoinChannel(c string) (ret bot.RetVal) {
 	rc.Unlock()
 	return bot.Ok
 }
+
+// AddProtocolReaction adds an emoji reaction to a message
+func (rc *rocketConnector) AddProtocolReaction(ch, messageID, reaction string) (ret bot.RetVal) {
+	if err := rc.rt.ReactToMessage(&models.Message{ID: messageID}, ":"+reaction+":"); err != nil {
+		rc.Log(bot.Error, "adding reaction '%s' to message %s: %v", reaction, messageID, err)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// RemoveProtocolReaction removes an emoji reaction from a message; Rocket.Chat's
+// setReaction toggles the robot's reaction, so this is the same call as adding it.
+func (rc *rocketConnector) RemoveProtocolReaction(ch, messageID, reaction string) (ret bot.RetVal) {
+	if err := rc.rt.ReactToMessage(&models.Message{ID: messageID}, ":"+reaction+":"); err != nil {
+		rc.Log(bot.Error, "removing reaction '%s' from message %s: %v", reaction, messageID, err)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// SendProtocolChannelMessageHandle sends a message to a channel, or a thread
+// when thread isn't "", and returns it's ID for updating it.
+func (rc *rocketConnector) SendProtocolChannelMessageHandle(ch, thread, msg string, f bot.MessageFormat) (handle string, ret bot.RetVal) {
+	chanID, found := rc.channelID(ch)
+	if !found {
+		return "", bot.ChannelNotFound
+	}
+	m := rc.rt.NewMessage(&models.Channel{ID: chanID}, formatMessage(msg, f))
+	m.ThreadID = thread
+	if _, err := rc.rt.SendMessage(m); err != nil {
+		rc.Log(bot.Error, "sending message to channel '%s': %v", ch, err)
+		return "", bot.FailedMessageSend
+	}
+	return m.ID, bot.Ok
+}
+
+// UpdateProtocolMessage replaces the text of a message sent with
+// SendProtocolChannelMessageHandle
+func (rc *rocketConnector) UpdateProtocolMessage(ch, handle, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	chanID, found := rc.channelID(ch)
+	if !found {
+		return bot.ChannelNotFound
+	}
+	if err := rc.rt.EditMessage(&models.Message{ID: handle, RoomID: chanID, Msg: formatMessage(msg, f)}); err != nil {
+		rc.Log(bot.Error, "updating message %s in channel '%s': %v", handle, ch, err)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// SendProtocolChannelFile uploads a file to a channel
+func (rc *rocketConnector) SendProtocolChannelFile(ch, thread, filename, title string, content []byte) (ret bot.RetVal) {
+	chanID, found := rc.channelID(ch)
+	if !found {
+		return bot.ChannelNotFound
+	}
+	return rc.uploadFile(chanID, thread, filename, title, content)
+}
+
+// SendProtocolUserFile uploads a file to a user's direct message room
+func (rc *rocketConnector) SendProtocolUserFile(u, filename, title string, content []byte) (ret bot.RetVal) {
+	var dchan string
+	if dchan, ret = rc.userDMChannel(u); ret != bot.Ok {
+		return
+	}
+	return rc.uploadFile(dchan, "", filename, title, content)
+}
+
+func (rc *rocketConnector) uploadFile(roomID, thread, filename, title string, content []byte) bot.RetVal {
+	if err := rc.rest.UploadFile(roomID, thread, filename, title, content); err != nil {
+		rc.Log(bot.Error, "uploading file '%s' to room %s: %v", filename, roomID, err)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// SendProtocolUserChannelChoices prompts a user in a channel with action
+// buttons; threads aren't supported yet, so thread is ignored.
+func (rc *rocketConnector) SendProtocolUserChannelChoices(uid, uname, ch, thread, prompt string, choices []string, f bot.MessageFormat) (ret bot.RetVal) {
+	return rc.sendChoices(ch, rc.mention(uid, uname)+formatMessage(prompt, f), choices)
+}
+
+// SendProtocolUserChoices prompts a user in a direct message with action
+// buttons
+func (rc *rocketConnector) SendProtocolUserChoices(u, prompt string, choices []string, f bot.MessageFormat) (ret bot.RetVal) {
+	var dchan string
+	if dchan, ret = rc.userDMChannel(u); ret != bot.Ok {
+		return
+	}
+	return rc.sendChoices("<"+dchan+">", formatMessage(prompt, f), choices)
+}
+
+// sendChoices sends a message with a button for each choice; clicking a
+// button sends the choice to the room as a message from the user, which
+// the engine takes as the reply.
+func (rc *rocketConnector) sendChoices(ch, msg string, choices []string) bot.RetVal {
+	chanID, found := rc.channelID(ch)
+	if !found {
+		return bot.ChannelNotFound
+	}
+	actions := make([]models.AttachmentAction, len(choices))
+	for i, choice := range choices {
+		actions[i] = models.AttachmentAction{
+			Type:              models.AttachmentActionTypeButton,
+			Text:              choice,
+			Msg:               choice,
+			MsgInChatWindow:   true,
+			MsgProcessingType: models.ProcessingTypeSendMessage,
+		}
+	}
+	m := rc.rt.NewMessage(&models.Channel{ID: chanID}, msg)
+	m.Attachments = []models.Attachment{{
+		Actions:                actions,
+		ActionButtonsAlignment: models.ActionButtonAlignHorizontal,
+	}}
+	if _, err := rc.rt.SendMessage(m); err != nil {
+		rc.Log(bot.Error, "sending choices to channel '%s': %v", ch, err)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
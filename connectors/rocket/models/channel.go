@@ -19,6 +19,7 @@ type Channel struct {
 
 	User        *User    `json:"u,omitempty"`
 	LastMessage *Message `json:"lastMessage,omitempty"`
+	Usernames   []string `json:"usernames,omitempty"` // the users in a direct message room
 
 	// Lm          interface{} `json:"lm"`
 	// CustomFields struct {
@@ -6,6 +6,7 @@ type Message struct {
 	ID       string `json:"_id"`
 	RoomID   string `json:"rid"`
 	Msg      string `json:"msg"`
+	ThreadID string `json:"tmid,omitempty"` // the first message of the thread
 	EditedBy string `json:"editedBy,omitempty"`
 
 	Groupable bool `json:"groupable,omitempty"`
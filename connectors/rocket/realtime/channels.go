@@ -37,12 +37,18 @@ func (c *Client) GetChannelsIn() ([]models.Channel, error) {
 	var channels []models.Channel
 
 	for _, i := range chans {
-		channels = append(channels, models.Channel{
+		channel := models.Channel{
 			ID: stringOrZero(i.Path("_id").Data()),
 			//Default: stringOrZero(i.Path("default").Data()),
 			Name: stringOrZero(i.Path("name").Data()),
 			Type: stringOrZero(i.Path("t").Data()),
-		})
+		}
+		if usernames, ok := i.Path("usernames").Data().([]interface{}); ok {
+			for _, u := range usernames {
+				channel.Usernames = append(channel.Usernames, stringOrZero(u))
+			}
+		}
+		channels = append(channels, channel)
 	}
 
 	return channels, nil
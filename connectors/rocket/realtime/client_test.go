@@ -4,9 +4,9 @@ import (
 	"net/url"
 	"testing"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/common_testing"
-	"github.com/lnxjedi/gopherbot/connectors/rocket/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/common_testing"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 )
 
 var (
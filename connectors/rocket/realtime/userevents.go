@@ -0,0 +1,76 @@
+package realtime
+
+import (
+	"strings"
+
+	"github.com/Jeffail/gabs"
+	"github.com/gopackage/ddp"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
+)
+
+// UserEvent is a change to one of the logged-in user's rooms or
+// subscriptions, e.g. being added to a room or a new direct message
+type UserEvent struct {
+	Event   string         // "rooms-changed" or "subscriptions-changed"
+	Action  string         // "inserted", "updated" or "removed"
+	Channel models.Channel // the room; for subscriptions, ID is the room ID
+}
+
+// SubscribeUserEvents subscribes to the rooms-changed and
+// subscriptions-changed events for a user ID. Events are delivered to the
+// events channel.
+//
+// https://rocket.chat/docs/developer-guides/realtime-api/subscriptions/stream-notify-user/
+func (c *Client) SubscribeUserEvents(userID string, events chan UserEvent) error {
+	for _, event := range []string{"rooms-changed", "subscriptions-changed"} {
+		if err := c.ddp.Sub("stream-notify-user", userID+"/"+event, send_added_event); err != nil {
+			return err
+		}
+	}
+
+	c.ddp.CollectionByName("stream-notify-user").AddUpdateListener(userEventExtractor{events, "update"})
+	return nil
+}
+
+func getUserEventFromUpdate(update ddp.Update) (UserEvent, bool) {
+	document, _ := gabs.Consume(map[string]interface{}(update))
+	eventName := stringOrZero(document.Path("eventName").Data())
+	args, err := document.Path("args").Children()
+	if err != nil || len(args) < 2 {
+		return UserEvent{}, false
+	}
+
+	event := UserEvent{
+		Event:  eventName[strings.LastIndex(eventName, "/")+1:],
+		Action: stringOrZero(args[0].Data()),
+	}
+	room := args[1]
+	event.Channel = models.Channel{
+		ID:   stringOrZero(room.Path("_id").Data()),
+		Name: stringOrZero(room.Path("name").Data()),
+		Type: stringOrZero(room.Path("t").Data()),
+	}
+	if event.Event == "subscriptions-changed" {
+		event.Channel.ID = stringOrZero(room.Path("rid").Data())
+	}
+	if usernames, ok := room.Path("usernames").Data().([]interface{}); ok {
+		for _, u := range usernames {
+			event.Channel.Usernames = append(event.Channel.Usernames, stringOrZero(u))
+		}
+	}
+
+	return event, len(event.Channel.ID) > 0
+}
+
+type userEventExtractor struct {
+	events    chan UserEvent
+	operation string
+}
+
+func (u userEventExtractor) CollectionUpdate(collection, operation, id string, doc ddp.Update) {
+	if operation == u.operation {
+		if event, ok := getUserEventFromUpdate(doc); ok {
+			u.events <- event
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gopackage/ddp"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/common_testing"
+)
+
+func TestGetUserEventFromUpdate(t *testing.T) {
+	event, ok := getUserEventFromUpdate(ddp.Update{
+		"eventName": "u1/subscriptions-changed",
+		"args": []interface{}{
+			"inserted",
+			map[string]interface{}{"_id": "s1", "rid": "r1", "name": "alice", "t": "d"},
+		},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "subscriptions-changed", event.Event)
+	assert.Equal(t, "inserted", event.Action)
+	assert.Equal(t, "r1", event.Channel.ID)
+	assert.Equal(t, "d", event.Channel.Type)
+
+	event, ok = getUserEventFromUpdate(ddp.Update{
+		"eventName": "u1/rooms-changed",
+		"args": []interface{}{
+			"updated",
+			map[string]interface{}{"_id": "r1", "t": "d", "usernames": []interface{}{"alice", "floyd"}},
+		},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "r1", event.Channel.ID)
+	assert.Equal(t, []string{"alice", "floyd"}, event.Channel.Usernames)
+
+	_, ok = getUserEventFromUpdate(ddp.Update{"eventName": "u1/rooms-changed", "args": []interface{}{"removed"}})
+	assert.False(t, ok)
+}
+
+func TestClient_SubscribeUserEvents(t *testing.T) {
+	c := getLoggedInClient(t)
+
+	subs, err := c.GetChannelSubscriptions()
+	assert.Nil(t, err)
+	if !assert.NotEmpty(t, subs, "No subscriptions to find the user ID") {
+		return
+	}
+
+	events := make(chan UserEvent, 10)
+	err = c.SubscribeUserEvents(subs[0].User.ID, events)
+	assert.Nil(t, err, "Function returned error")
+
+	name := common_testing.GetRandomString()
+	assert.Nil(t, c.CreateGroup(name, []string{}))
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Channel.Name == name {
+				assert.Equal(t, "inserted", event.Action)
+				assert.Equal(t, "p", event.Channel.Type)
+				return
+			}
+		case <-timeout:
+			t.Error("Timed out waiting for a user event for the new group")
+			return
+		}
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"net/url"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/models"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 )
 
 type ChannelsResponse struct {
@@ -3,8 +3,8 @@ package rest
 import (
 	"testing"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 )
 
 func TestRocket_GetPublicChannels(t *testing.T) {
@@ -133,6 +133,21 @@ func (c *Client) doRequest(method, api string, params url.Values, body io.Reader
 		request.Header.Set("Content-Type", contentType)
 	}
 
+	return c.send(request, response)
+}
+
+// PostMultipart call as multipart form data; contentType includes the
+// boundary
+func (c *Client) PostMultipart(api, contentType string, body io.Reader, response Response) error {
+	request, err := http.NewRequest(http.MethodPost, c.getUrl()+"/"+api, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	return c.send(request, response)
+}
+
+func (c *Client) send(request *http.Request, response Response) error {
 	if c.auth != nil {
 		request.Header.Set("X-Auth-Token", c.auth.token)
 		request.Header.Set("X-User-Id", c.auth.id)
@@ -4,10 +4,10 @@ import (
 	"net/url"
 	"testing"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/common_testing"
-	"github.com/lnxjedi/gopherbot/connectors/rocket/models"
-	"github.com/lnxjedi/gopherbot/connectors/rocket/realtime"
 	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/common_testing"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/realtime"
 )
 
 var (
@@ -3,7 +3,7 @@ package rest
 import (
 	"net/url"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/models"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 )
 
 type InfoResponse struct {
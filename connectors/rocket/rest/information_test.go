@@ -4,8 +4,8 @@ import (
 	"net/url"
 	"testing"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/common_testing"
 	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/common_testing"
 )
 
 func TestRocket_GetServerInfo(t *testing.T) {
@@ -5,10 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"html"
+	"mime/multipart"
 	"net/url"
 	"strconv"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/models"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 )
 
 type MessagesResponse struct {
@@ -65,3 +66,29 @@ func (c *Client) GetMessages(channel *models.Channel, page *models.Pagination) (
 
 	return response.Messages, nil
 }
+
+// UploadFile uploads a file to a room, with an optional description. If
+// threadID isn't empty, the file is posted in the thread.
+//
+// https://rocket.chat/docs/developer-guides/rest-api/rooms/upload
+func (c *Client) UploadFile(roomID, threadID, filename, description string, content []byte) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	if _, err = part.Write(content); err != nil {
+		return err
+	}
+	if len(description) > 0 {
+		w.WriteField("description", description)
+	}
+	if len(threadID) > 0 {
+		w.WriteField("tmid", threadID)
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.PostMultipart("rooms.upload/"+roomID, w.FormDataContentType(), &body, new(MessageResponse))
+}
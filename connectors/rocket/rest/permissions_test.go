@@ -1,9 +1,9 @@
 package rest
 
 import (
-	"github.com/lnxjedi/gopherbot/connectors/rocket/common_testing"
-	"github.com/lnxjedi/gopherbot/connectors/rocket/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/common_testing"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 	"testing"
 )
 
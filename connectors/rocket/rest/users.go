@@ -7,7 +7,7 @@ import (
 	"net/url"
 	"time"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/models"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 )
 
 type logoutResponse struct {
@@ -50,6 +50,11 @@ type CreateUserResponse struct {
 	} `json:"user"`
 }
 
+type UserResponse struct {
+	Status
+	User models.User `json:"user"`
+}
+
 // Login a user. The Email and the Password are mandatory. The auth token of the user is stored in the Client instance.
 //
 // https://rocket.chat/docs/developer-guides/rest-api/authentication/login
@@ -143,3 +148,15 @@ func (c *Client) SetUserAvatar(userID, username, avatarURL string) (*Status, err
 	err := c.Post("users.setAvatar", bytes.NewBufferString(body), response)
 	return response, err
 }
+
+// GetUserInfo gets information about a user, given the user's ID.
+//
+// https://rocket.chat/docs/developer-guides/rest-api/users/info
+func (c *Client) GetUserInfo(userID string) (*models.User, error) {
+	response := new(UserResponse)
+	if err := c.Get("users.info", url.Values{"userId": {userID}}, response); err != nil {
+		return nil, err
+	}
+
+	return &response.User, nil
+}
@@ -3,8 +3,8 @@ package rest
 import (
 	"testing"
 
-	"github.com/lnxjedi/gopherbot/connectors/rocket/common_testing"
 	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/common_testing"
 )
 
 func TestRocket_LoginLogout(t *testing.T) {
@@ -16,3 +16,11 @@ func TestRocket_LoginLogout(t *testing.T) {
 	// assert.Nil(t, channels)
 	// assert.NotNil(t, err)
 }
+
+func TestRocket_GetUserInfo(t *testing.T) {
+	rocket := getDefaultClient(t)
+
+	user, err := rocket.GetUserInfo(rocket.auth.id)
+	assert.Nil(t, err)
+	assert.Equal(t, testUserName, user.UserName)
+}
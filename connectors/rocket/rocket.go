@@ -10,6 +10,7 @@ import (
 	"github.com/wanghonggao007/gopherbot/bot"
 	models "github.com/wanghonggao007/gopherbot/connectors/rocket/models"
 	api "github.com/wanghonggao007/gopherbot/connectors/rocket/realtime"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/rest"
 )
 
 var lock sync.Mutex  // package var lock
@@ -42,6 +43,7 @@ var userName, userID string
 
 type rocketConnector struct {
 	rt      *api.Client
+	rest    *rest.Client // for file uploads
 	running bool
 	bot.Handler
 	sync.RWMutex
@@ -117,6 +119,11 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 		robot.SetBotID(user.ID)
 		//robot.SetBotMention(user.UserName)
 	}
+	// the realtime login fills in the credential token for the REST client
+	rc.rest = rest.NewClient(u, false)
+	if err := rc.rest.Login(cred); err != nil {
+		rc.Log(bot.Error, "unable to log in to the rocket chat REST API, file uploads won't work: %v", err)
+	}
 	incoming = client.GetMessageStreamUpdateChannel()
 	return bot.Connector(rc)
 }
@@ -0,0 +1,126 @@
+package rocket
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/common_testing"
+	models "github.com/wanghonggao007/gopherbot/connectors/rocket/models"
+	api "github.com/wanghonggao007/gopherbot/connectors/rocket/realtime"
+	"github.com/wanghonggao007/gopherbot/connectors/rocket/rest"
+)
+
+func newTestConnector() *rocketConnector {
+	userName = "floyd"
+	return &rocketConnector{
+		Handler:        common_testing.NewHandler(),
+		channelNames:   make(map[string]string),
+		channelIDs:     make(map[string]string),
+		joinedChannels: make(map[string]struct{}),
+		dmChannels:     make(map[string]struct{}),
+		privChannels:   make(map[string]struct{}),
+		userNameIDMap:  make(map[string]string),
+		userIDNameMap:  make(map[string]string),
+		userDM:         make(map[string]string),
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	assert.Equal(t, "*hi*", formatMessage("*hi*", bot.Raw))
+	assert.Equal(t, "```*hi*```", formatMessage("*hi*", bot.Fixed))
+	variable := formatMessage("*hi* @alice #general", bot.Variable)
+	assert.Equal(t, "*hi* @alice #general", strings.Replace(variable, escapePad, "", -1))
+	assert.NotContains(t, variable, "*hi*")
+	assert.NotContains(t, variable, "@alice")
+	// URLs, e.g. links in job start messages, are left alone
+	assert.Equal(t, "(link: https://ci.example.com/build)", formatMessage("(link: https://ci.example.com/build)", bot.Variable))
+}
+
+func TestUserEvents(t *testing.T) {
+	rc := newTestConnector()
+
+	// the robot is added to a private group
+	rc.userEvent(api.UserEvent{Event: "subscriptions-changed", Action: "inserted",
+		Channel: models.Channel{ID: "g1", Name: "ops", Type: "p"}})
+	hearIt, directMsg, known := rc.hears("g1")
+	assert.True(t, hearIt)
+	assert.False(t, directMsg)
+	assert.True(t, known)
+	chanID, ok := rc.channelID("ops")
+	assert.True(t, ok)
+	assert.Equal(t, "g1", chanID)
+
+	// the group is renamed
+	rc.userEvent(api.UserEvent{Event: "rooms-changed", Action: "updated",
+		Channel: models.Channel{ID: "g1", Name: "operations", Type: "p"}})
+	_, ok = rc.channelID("ops")
+	assert.False(t, ok)
+	chanID, ok = rc.channelID("operations")
+	assert.True(t, ok)
+	assert.Equal(t, "g1", chanID)
+
+	// a user opens a direct message
+	rc.userEvent(api.UserEvent{Event: "rooms-changed", Action: "inserted",
+		Channel: models.Channel{ID: "d1", Type: "d", Usernames: []string{"alice", "floyd"}}})
+	hearIt, directMsg, _ = rc.hears("d1")
+	assert.True(t, hearIt)
+	assert.True(t, directMsg)
+	assert.Equal(t, map[string]string{"alice": "d1"}, rc.userDM)
+
+	// a public channel the robot hasn't joined is known, but not heard
+	rc.userEvent(api.UserEvent{Event: "subscriptions-changed", Action: "inserted",
+		Channel: models.Channel{ID: "c1", Name: "random", Type: "c"}})
+	hearIt, _, known = rc.hears("c1")
+	assert.False(t, hearIt)
+	assert.True(t, known)
+
+	// the robot is removed from the group, then the group is deleted
+	rc.userEvent(api.UserEvent{Event: "subscriptions-changed", Action: "removed",
+		Channel: models.Channel{ID: "g1"}})
+	hearIt, _, known = rc.hears("g1")
+	assert.False(t, hearIt)
+	assert.True(t, known)
+	rc.userEvent(api.UserEvent{Event: "rooms-changed", Action: "removed",
+		Channel: models.Channel{ID: "g1"}})
+	_, _, known = rc.hears("g1")
+	assert.False(t, known)
+	_, ok = rc.channelID("operations")
+	assert.False(t, ok)
+
+	rc.userEvent(api.UserEvent{Event: "subscriptions-changed", Action: "removed",
+		Channel: models.Channel{ID: "d1"}})
+	assert.Empty(t, rc.userDM)
+}
+
+func TestRocketUserName(t *testing.T) {
+	const lookup = "/api/v1/users.info?userId=u2"
+	server := common_testing.NewServer(t)
+	server.Handle(lookup, `{"success": true, "user": {"_id": "u2", "username": "bob.smith"}}`)
+
+	rc := newTestConnector()
+	rc.rest = rest.NewClient(server.URL, false)
+	rc.userIDNameMap["u1"] = "alice"
+	rc.SetUserMap(map[string]string{"bob": "u2"})
+
+	user, ret := rc.rocketUserName("<u1>")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "alice", user)
+
+	// bob has never messaged the robot
+	user, ret = rc.rocketUserName("bob")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "bob.smith", user)
+	user, ret = rc.rocketUserName("<u2>")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "bob.smith", user)
+	assert.Equal(t, 1, server.Calls(lookup))
+
+	_, ret = rc.rocketUserName("<u3>")
+	assert.Equal(t, bot.UserNotFound, ret)
+
+	user, ret = rc.rocketUserName("carol")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "carol", user)
+}
@@ -0,0 +1,221 @@
+package slack
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+/* choices.go - prompt choices as interactive message buttons, or a menu for
+more than maxChoiceButtons choices. Slack posts button clicks to the
+robot's interactions request URL, which needs InteractionsPort and the app
+SigningSecret configured; without them the engine falls back to a
+numbered list. */
+
+// choiceCallbackID identifies choice prompts in interaction callbacks
+const choiceCallbackID = "gopherbot-choice"
+
+// Use a menu instead of buttons for more choices than this
+const maxChoiceButtons = 5
+
+// Largest interaction payload accepted
+const maxInteractionBody = 1 << 20
+
+// How long to wait for the engine to accept a choice; Slack wants a
+// response to an interaction within 3 seconds
+const choiceAcceptTimeout = 2 * time.Second
+
+// SendProtocolUserChannelChoices prompts a user in a channel or thread with
+// buttons or a menu
+func (s *slackConnector) SendProtocolUserChannelChoices(uid, u, ch, thread, prompt string, choices []string, f bot.MessageFormat) (ret bot.RetVal) {
+	if len(s.signingSecret) == 0 {
+		return bot.Unsupported
+	}
+	var userID, chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "slack channel ID not found for: %s", ch)
+		return bot.ChannelNotFound
+	}
+	if userID, ok = bot.ExtractID(uid); !ok {
+		userID, ok = s.userID(u)
+	}
+	if !ok {
+		s.Log(bot.Error, "slack user ID not found for: %s", uid)
+		return bot.UserNotFound
+	}
+	return s.sendChoices(chanID, thread, "<@"+userID+">: ", prompt, choices, f)
+}
+
+// SendProtocolUserChoices prompts a user in a DM with buttons or a menu
+func (s *slackConnector) SendProtocolUserChoices(u, prompt string, choices []string, f bot.MessageFormat) (ret bot.RetVal) {
+	if len(s.signingSecret) == 0 {
+		return bot.Unsupported
+	}
+	var userIMchan string
+	if userIMchan, ret = s.userIMChannel(u); ret != bot.Ok {
+		return
+	}
+	return s.sendChoices(userIMchan, "", "", prompt, choices, f)
+}
+
+func (s *slackConnector) sendChoices(chanID, thread, prefix, prompt string, choices []string, f bot.MessageFormat) bot.RetVal {
+	msgs := s.slackifyMessage(prefix, prompt, f)
+	if len(msgs) != 1 {
+		// prompts this long won't work as interactive messages
+		return bot.Unsupported
+	}
+	att := slack.Attachment{
+		Fallback:   prompt,
+		CallbackID: choiceCallbackID,
+	}
+	if len(choices) <= maxChoiceButtons {
+		for _, choice := range choices {
+			att.Actions = append(att.Actions, slack.AttachmentAction{
+				Name:  "choice",
+				Text:  choice,
+				Type:  "button",
+				Value: choice,
+			})
+		}
+	} else {
+		menu := slack.AttachmentAction{
+			Name: "choice",
+			Text: "Choose...",
+			Type: "select",
+		}
+		for _, choice := range choices {
+			menu.Options = append(menu.Options, slack.AttachmentActionOption{
+				Text:  choice,
+				Value: choice,
+			})
+		}
+		att.Actions = []slack.AttachmentAction{menu}
+	}
+	opts := []slack.MsgOption{
+		slack.MsgOptionText(msgs[0], false),
+		slack.MsgOptionAsUser(true),
+		slack.MsgOptionAttachments(att),
+	}
+	if len(thread) > 0 {
+		opts = append(opts, slack.MsgOptionTS(thread))
+	}
+	if _, _, err := s.api.PostMessage(chanID, opts...); err != nil {
+		s.Log(bot.Error, "sending slack choices to channel %s: %v", chanID, err)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// serveInteractions listens for interaction callbacks from Slack
+func (s *slackConnector) serveInteractions(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slack/interactions", s.interactionHandler)
+	s.Log(bot.Info, "Listening for slack interactions on '%s'", addr)
+	s.Log(bot.Error, "error serving slack interactions: %v", http.ListenAndServe(addr, mux))
+}
+
+// interactionHandler turns a choice picked from buttons or a menu in to an
+// incoming message with Choice set. When a prompt was waiting for the
+// choice, the buttons are replaced with the choice; otherwise, e.g. when
+// another user clicks or the prompt timed out, only the clicker is told.
+func (s *slackConnector) interactionHandler(rw http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, maxInteractionBody))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sv, err := slack.NewSecretsVerifier(r.Header, s.signingSecret)
+	if err == nil {
+		sv.Write(body)
+		err = sv.Ensure()
+	}
+	if err != nil {
+		s.Log(bot.Warn, "Invalid slack interaction request from %s: %v", r.RemoteAddr, err)
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var cb slack.InteractionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &cb); err != nil {
+		s.Log(bot.Error, "Unable to decode slack interaction payload: %v", err)
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if cb.CallbackID != choiceCallbackID || len(cb.Actions) == 0 {
+		s.Log(bot.Debug, "Ignoring slack interaction with callback ID '%s'", cb.CallbackID)
+		return
+	}
+	action := cb.Actions[0]
+	choice := action.Value
+	if len(action.SelectedOptions) > 0 {
+		choice = action.SelectedOptions[0].Value
+	}
+	chanID := cb.Channel.ID
+	ci, ok := s.getChannelInfo(chanID)
+	if !ok {
+		s.Log(bot.Error, "Couldn't find channel info for channel ID %s", chanID)
+		return
+	}
+	accepted := make(chan bool, 1)
+	botMsg := &bot.ConnectorMessage{
+		Protocol:      "Slack",
+		UserID:        cb.User.ID,
+		ChannelID:     chanID,
+		DirectMessage: ci.IsIM,
+		MessageText:   choice,
+		ThreadID:      cb.OriginalMessage.ThreadTimestamp,
+		MessageID:     cb.MessageTs,
+		Choice:        true,
+		Accepted:      accepted,
+		MessageObject: &cb,
+		Client:        s.api,
+	}
+	if userName, ok := s.userName(cb.User.ID); ok {
+		botMsg.UserName = userName
+	}
+	if !ci.IsIM {
+		botMsg.ChannelName = ci.Name
+	}
+	s.IncomingMessage(botMsg)
+
+	var taken bool
+	select {
+	case taken = <-accepted:
+	case <-time.After(choiceAcceptTimeout):
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(choiceResponse(cb.OriginalMessage.Text, choice, taken))
+}
+
+// interactionResponse is the reply to an interaction callback
+type interactionResponse struct {
+	Text            string `json:"text"`
+	ResponseType    string `json:"response_type,omitempty"`
+	ReplaceOriginal bool   `json:"replace_original"`
+}
+
+// choiceResponse replaces the original prompt, without the buttons, with
+// the choice if it was accepted; otherwise the prompt is left alone and
+// the user who clicked sees an ephemeral message
+func choiceResponse(prompt, choice string, accepted bool) interactionResponse {
+	if accepted {
+		return interactionResponse{Text: prompt + "\n> " + choice, ReplaceOriginal: true}
+	}
+	return interactionResponse{
+		Text:         "Nothing is waiting for your choice; the prompt may be for someone else, or have timed out",
+		ResponseType: "ephemeral",
+	}
+}
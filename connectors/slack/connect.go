@@ -15,8 +15,10 @@ type botDefinition struct {
 }
 
 type config struct {
-	SlackToken      string // the 'bot token for connecting to Slack
-	MaxMessageSplit int    // the maximum # of ~4000 byte messages to split a large message into
+	SlackToken       string // the 'bot token for connecting to Slack
+	MaxMessageSplit  int    // the maximum # of ~4000 byte messages to split a large message into
+	SigningSecret    string // the app signing secret, for verifying interaction callbacks
+	InteractionsPort string // where to listen for interactions, e.g. ":3001"; needed for prompt buttons
 }
 
 var lock sync.Mutex // package var lock
@@ -102,6 +104,10 @@ Loop:
 	sc.updateUserList("")
 	sc.botFullName, _ = sc.GetProtocolUserAttribute(sc.botName, "realname")
 	go sc.startSendLoop()
+	if len(c.InteractionsPort) > 0 && len(c.SigningSecret) > 0 {
+		sc.signingSecret = c.SigningSecret
+		go sc.serveInteractions(c.InteractionsPort)
+	}
 
 	return bot.Connector(sc)
 }
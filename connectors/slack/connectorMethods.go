@@ -1,6 +1,7 @@
 package slack
 
 import (
+	"bytes"
 	"time"
 
 	"github.com/nlopes/slack"
@@ -56,8 +57,9 @@ func (s *slackConnector) GetProtocolUserAttribute(u, attr string) (value string,
 }
 
 type sendMessage struct {
-	message, channel string
-	format           bot.MessageFormat
+	message, channel, thread string
+	format                   bot.MessageFormat
+	handle                   chan<- string // when set, gets the timestamp of the sent message, or "" if it failed
 }
 
 var messages = make(chan *sendMessage)
@@ -97,7 +99,11 @@ func (s *slackConnector) startSendLoop() {
 			if send.format == bot.Variable {
 				unfurl = slack.MsgOptionDisableLinkUnfurl()
 			}
-			_, _, err := s.api.PostMessage(send.channel, slack.MsgOptionText(send.message, false), slack.MsgOptionAsUser(true), unfurl)
+			opts := []slack.MsgOption{slack.MsgOptionText(send.message, false), slack.MsgOptionAsUser(true), unfurl}
+			if len(send.thread) > 0 {
+				opts = append(opts, slack.MsgOptionTS(send.thread))
+			}
+			_, ts, err := s.api.PostMessage(send.channel, opts...)
 			if err != nil && p == 1 {
 				s.Log(bot.Warn, "sending slack message '%s' initiating backoff: %v", send.message, err)
 			}
@@ -105,12 +111,21 @@ func (s *slackConnector) startSendLoop() {
 				time.Sleep(time.Second * time.Duration(p))
 			} else {
 				sent = true
+				if send.handle != nil {
+					send.handle <- ts
+				}
 				break
 			}
 		}
 		if !sent {
-			s.Log(bot.Error, "failed sending slack message '%s' to channel '%s' after 3 tries, attempting fallback to RTM", send.message, send.channel)
-			s.conn.SendMessage(s.conn.NewOutgoingMessage(send.message, send.channel))
+			if send.handle != nil {
+				// an RTM message has no timestamp for updating it
+				s.Log(bot.Error, "failed sending slack message '%s' to channel '%s' after 3 tries", send.message, send.channel)
+				send.handle <- ""
+			} else {
+				s.Log(bot.Error, "failed sending slack message '%s' to channel '%s' after 3 tries, attempting fallback to RTM", send.message, send.channel)
+				s.conn.SendMessage(s.conn.NewOutgoingMessage(send.message, send.channel))
+			}
 		}
 		timeSinceBurst := msgTime.Sub(burstTime)
 		if msgTime.Sub(mtimes[windowStartMsg]) < burstWindow || timeSinceBurst < coolDown {
@@ -126,10 +141,15 @@ func (s *slackConnector) startSendLoop() {
 }
 
 func (s *slackConnector) sendMessages(msgs []string, chanID string, f bot.MessageFormat) {
+	s.sendThreadMessages(msgs, chanID, "", f)
+}
+
+func (s *slackConnector) sendThreadMessages(msgs []string, chanID, thread string, f bot.MessageFormat) {
 	for _, msg := range msgs {
 		messages <- &sendMessage{
 			message: msg,
 			channel: chanID,
+			thread:  thread,
 			format:  f,
 		}
 	}
@@ -161,6 +181,28 @@ func (s *slackConnector) SendProtocolChannelMessage(ch string, msg string, f bot
 
 // SendProtocolChannelMessage sends a message to a channel
 func (s *slackConnector) SendProtocolUserChannelMessage(uid, u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return s.SendProtocolUserChannelThreadMessage(uid, u, ch, "", msg, f)
+}
+
+// SendProtocolChannelThreadMessage sends a message to a thread in a channel
+func (s *slackConnector) SendProtocolChannelThreadMessage(ch, thread, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "slack channel ID not found for: %s", ch)
+		return bot.ChannelNotFound
+	}
+	msgs := s.slackifyMessage("", msg, f)
+	s.sendThreadMessages(msgs, chanID, thread, f)
+	return
+}
+
+// SendProtocolUserChannelThreadMessage directs a message to a user in a
+// thread
+func (s *slackConnector) SendProtocolUserChannelThreadMessage(uid, u, ch, thread, msg string, f bot.MessageFormat) (ret bot.RetVal) {
 	var userID, chanID string
 	var ok bool
 	if chanID, ok = bot.ExtractID(ch); !ok {
@@ -180,12 +222,23 @@ func (s *slackConnector) SendProtocolUserChannelMessage(uid, u, ch, msg string,
 	// This gets converted to <@userID> in slackifyMessage
 	prefix := "<@" + userID + ">: "
 	msgs := s.slackifyMessage(prefix, msg, f)
-	s.sendMessages(msgs, chanID, f)
+	s.sendThreadMessages(msgs, chanID, thread, f)
 	return
 }
 
 // SendProtocolUserMessage sends a direct message to a user
 func (s *slackConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	var userIMchan string
+	if userIMchan, ret = s.userIMChannel(u); ret != bot.Ok {
+		return
+	}
+	msgs := s.slackifyMessage("", msg, f)
+	s.sendMessages(msgs, userIMchan, f)
+	return bot.Ok
+}
+
+// userIMChannel finds or opens the IM channel for a user
+func (s *slackConnector) userIMChannel(u string) (userIMchan string, ret bot.RetVal) {
 	var userID string
 	var ok bool
 	if userID, ok = bot.ExtractID(u); !ok {
@@ -193,9 +246,8 @@ func (s *slackConnector) SendProtocolUserMessage(u string, msg string, f bot.Mes
 	}
 	if !ok {
 		s.Log(bot.Error, "no slack user ID found for user: %s", u)
-		ret = bot.UserNotFound
+		return "", bot.UserNotFound
 	}
-	var userIMchan string
 	var err error
 	userIMchan, ok = s.userIMID(userID)
 	if !ok {
@@ -203,15 +255,10 @@ func (s *slackConnector) SendProtocolUserMessage(u string, msg string, f bot.Mes
 		_, _, userIMchan, err = s.conn.OpenIMChannel(userID)
 		if err != nil {
 			s.Log(bot.Error, "unable to open a slack IM channel to user: %s, ID: %s", u, userID)
-			ret = bot.FailedMessageSend
+			return "", bot.FailedMessageSend
 		}
 	}
-	if ret != bot.Ok {
-		return
-	}
-	msgs := s.slackifyMessage("", msg, f)
-	s.sendMessages(msgs, userIMchan, f)
-	return bot.Ok
+	return userIMchan, bot.Ok
 }
 
 // JoinChannel joins a channel given it's human-readable name, e.g. "general"
@@ -228,3 +275,131 @@ func (s *slackConnector) JoinChannel(c string) (ret bot.RetVal) {
 	}
 	return bot.Ok
 }
+
+// AddProtocolReaction adds an emoji reaction to a message
+func (s *slackConnector) AddProtocolReaction(ch, messageID, reaction string) (ret bot.RetVal) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "slack channel ID not found for: %s", ch)
+		return bot.ChannelNotFound
+	}
+	if err := s.api.AddReaction(reaction, slack.NewRefToMessage(chanID, messageID)); err != nil {
+		s.Log(bot.Error, "adding slack reaction '%s' to message %s: %v", reaction, messageID, err)
+		return bot.FailedMessageSend
+	}
+	return
+}
+
+// RemoveProtocolReaction removes an emoji reaction from a message
+func (s *slackConnector) RemoveProtocolReaction(ch, messageID, reaction string) (ret bot.RetVal) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "slack channel ID not found for: %s", ch)
+		return bot.ChannelNotFound
+	}
+	if err := s.api.RemoveReaction(reaction, slack.NewRefToMessage(chanID, messageID)); err != nil {
+		s.Log(bot.Error, "removing slack reaction '%s' from message %s: %v", reaction, messageID, err)
+		return bot.FailedMessageSend
+	}
+	return
+}
+
+// SendProtocolChannelMessageHandle sends a message through the send loop,
+// waiting for it to be sent, and returns the message timestamp for updating
+// it. Long messages that need splitting aren't sent.
+func (s *slackConnector) SendProtocolChannelMessageHandle(ch, thread, msg string, f bot.MessageFormat) (handle string, ret bot.RetVal) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "slack channel ID not found for: %s", ch)
+		return "", bot.ChannelNotFound
+	}
+	msgs := s.slackifyMessage("", msg, f)
+	if len(msgs) != 1 {
+		return "", bot.FailedMessageSend
+	}
+	sent := make(chan string, 1)
+	messages <- &sendMessage{
+		message: msgs[0],
+		channel: chanID,
+		thread:  thread,
+		format:  f,
+		handle:  sent,
+	}
+	if ts := <-sent; len(ts) > 0 {
+		return ts, bot.Ok
+	}
+	return "", bot.FailedMessageSend
+}
+
+// UpdateProtocolMessage replaces the text of a message sent with
+// SendProtocolChannelMessageHandle
+func (s *slackConnector) UpdateProtocolMessage(ch, handle, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "slack channel ID not found for: %s", ch)
+		return bot.ChannelNotFound
+	}
+	msgs := s.slackifyMessage("", msg, f)
+	if len(msgs) != 1 {
+		return bot.FailedMessageSend
+	}
+	if _, _, _, err := s.api.UpdateMessage(chanID, handle, slack.MsgOptionText(msgs[0], false)); err != nil {
+		s.Log(bot.Error, "updating slack message %s in channel '%s': %v", handle, ch, err)
+		return bot.FailedMessageSend
+	}
+	return
+}
+
+// SendProtocolChannelFile uploads a file to a channel, or a thread
+func (s *slackConnector) SendProtocolChannelFile(ch, thread, filename, title string, content []byte) (ret bot.RetVal) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "slack channel ID not found for: %s", ch)
+		return bot.ChannelNotFound
+	}
+	return s.uploadFile(chanID, thread, filename, title, content)
+}
+
+// SendProtocolUserFile uploads a file to a user's IM channel
+func (s *slackConnector) SendProtocolUserFile(u, filename, title string, content []byte) (ret bot.RetVal) {
+	var userIMchan string
+	if userIMchan, ret = s.userIMChannel(u); ret != bot.Ok {
+		return
+	}
+	return s.uploadFile(userIMchan, "", filename, title, content)
+}
+
+func (s *slackConnector) uploadFile(chanID, thread, filename, title string, content []byte) bot.RetVal {
+	params := slack.FileUploadParameters{
+		Reader:          bytes.NewReader(content),
+		Filename:        filename,
+		Title:           title,
+		Channels:        []string{chanID},
+		ThreadTimestamp: thread,
+	}
+	if _, err := s.api.UploadFile(params); err != nil {
+		s.Log(bot.Error, "uploading file '%s' to slack channel %s: %v", filename, chanID, err)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
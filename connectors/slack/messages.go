@@ -200,6 +200,8 @@ func (s *slackConnector) processMessage(msg *slack.MessageEvent) {
 		ChannelID:     chanID,
 		DirectMessage: ci.IsIM,
 		MessageText:   text,
+		ThreadID:      message.ThreadTimestamp,
+		MessageID:     message.Timestamp,
 		MessageObject: msg,
 		Client:        s.api,
 	}
@@ -25,6 +25,7 @@ type slackConnector struct {
 	botID           string                    // slack internal bot ID
 	name            string                    // name for this connector
 	teamID          string                    // Slack unique Team ID, for identifying team users
+	signingSecret   string                    // set when interaction callbacks are configured
 	bot.Handler                               // bot API for connectors
 	sync.RWMutex                              // shared mutex for locking connector data structures
 	channelInfo     map[string]*slack.Channel // info about all the channels the robot knows about
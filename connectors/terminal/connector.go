@@ -23,6 +23,7 @@ type termConnector struct {
 	channels       []string           // the channels the robot is in
 	heard          chan string        // when the user speaks
 	reader         *readline.Instance // readline for speaking
+	fileDir        string             // where uploaded files are written
 	bot.Handler                       // bot API for connectors
 	sync.RWMutex                      // shared mutex for locking connector data structures
 }
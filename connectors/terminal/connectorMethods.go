@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/internal/upload"
 )
 
 func (tc *termConnector) MessageHeard(u, c string) {
@@ -92,3 +93,31 @@ func (tc *termConnector) SendProtocolUserMessage(u string, msg string, f bot.Mes
 func (tc *termConnector) JoinChannel(c string) (ret bot.RetVal) {
 	return bot.Ok
 }
+
+// SendProtocolChannelFile writes the file to the FileDir, and reports the
+// path in the channel
+func (tc *termConnector) SendProtocolChannelFile(ch, thread, filename, title string, content []byte) (ret bot.RetVal) {
+	channel := getChannel(ch)
+	path, err := upload.WriteFile(tc.fileDir, filename, content)
+	if err != nil {
+		tc.Log(bot.Error, "Writing uploaded file '%s': %v", filename, err)
+		return bot.FailedMessageSend
+	}
+	return tc.sendMessage(channel, upload.Message(path, title), bot.Raw)
+}
+
+// SendProtocolUserFile writes the file to the FileDir, and reports the
+// path to the user
+func (tc *termConnector) SendProtocolUserFile(u, filename, title string, content []byte) (ret bot.RetVal) {
+	var user *termUser
+	var exists bool
+	if user, exists = tc.getUserInfo(u); !exists {
+		return bot.UserNotFound
+	}
+	path, err := upload.WriteFile(tc.fileDir, filename, content)
+	if err != nil {
+		tc.Log(bot.Error, "Writing uploaded file '%s': %v", filename, err)
+		return bot.FailedMessageSend
+	}
+	return tc.sendMessage(fmt.Sprintf("(dm:%s)", user.Name), upload.Message(path, title), bot.Raw)
+}
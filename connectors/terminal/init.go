@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"sync"
 
 	"github.com/chzyer/readline"
@@ -26,6 +27,7 @@ type config struct {
 	StartUser    string // the initial userid
 	Users        []termUser
 	Channels     []string
+	FileDir      string // where uploaded files are written; defaults to a temporary directory
 }
 
 var lock sync.Mutex // package var lock
@@ -95,6 +97,11 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 		l.SetOutput(rl.Stdout())
 	}
 
+	fileDir := c.FileDir
+	if len(fileDir) == 0 {
+		fileDir = filepath.Join(os.TempDir(), "gopherbot-files")
+	}
+
 	tc := &termConnector{
 		currentChannel: c.StartChannel,
 		currentUser:    c.StartUser,
@@ -104,6 +111,7 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 		users:          c.Users,
 		heard:          make(chan string),
 		reader:         rl,
+		fileDir:        fileDir,
 	}
 
 	tc.Handler = robot
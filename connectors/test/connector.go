@@ -26,6 +26,7 @@ type TestConnector struct {
 	listener     chan *TestMessage // input channel for test functions to send messages from a user
 	speaking     chan *TestMessage // output channel for test functions to get messages from the bot
 	test         *testing.T        // for the connector to log
+	fileDir      string            // where uploaded files are written
 	bot.Handler                    // bot API for connectors
 	sync.RWMutex                   // shared mutex for locking connector data structures
 }
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/lnxjedi/gopherbot/bot"
+	"github.com/lnxjedi/gopherbot/connectors/internal/upload"
 )
 
 // BotMessage is for receiving messages from the robot
@@ -116,3 +117,42 @@ func (tc *TestConnector) SendProtocolUserMessage(u string, mesg string, f bot.Me
 func (tc *TestConnector) JoinChannel(c string) (ret bot.RetVal) {
 	return bot.Ok
 }
+
+// SendProtocolChannelFile writes the file to the FileDir, and reports the
+// path in the channel
+func (tc *TestConnector) SendProtocolChannelFile(ch, thread, filename, title string, content []byte) (ret bot.RetVal) {
+	path, err := upload.WriteFile(tc.fileDir, filename, content)
+	if err != nil {
+		tc.test.Errorf("Writing uploaded file '%s': %v", filename, err)
+		return bot.FailedMessageSend
+	}
+	msg := &BotMessage{
+		User:    "",
+		Channel: getChannel(ch),
+		Message: upload.Message(path, title),
+		Format:  bot.Raw,
+	}
+	return tc.sendMessage(msg)
+}
+
+// SendProtocolUserFile writes the file to the FileDir, and reports the
+// path to the user
+func (tc *TestConnector) SendProtocolUserFile(u, filename, title string, content []byte) (ret bot.RetVal) {
+	var user *testUser
+	var exists bool
+	if user, exists = tc.getUserInfo(u); !exists {
+		return bot.UserNotFound
+	}
+	path, err := upload.WriteFile(tc.fileDir, filename, content)
+	if err != nil {
+		tc.test.Errorf("Writing uploaded file '%s': %v", filename, err)
+		return bot.FailedMessageSend
+	}
+	msg := &BotMessage{
+		User:    user.Name,
+		Channel: "",
+		Message: upload.Message(path, title),
+		Format:  bot.Raw,
+	}
+	return tc.sendMessage(msg)
+}
@@ -2,6 +2,8 @@ package test
 
 import (
 	"log"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
@@ -29,6 +31,7 @@ type config struct {
 	BotFullName string // the full name of the bot
 	Users       []testUser
 	Channels    []string
+	FileDir     string // where uploaded files are written; defaults to a temporary directory
 }
 
 func init() {
@@ -53,6 +56,11 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 	t := ExportTest.Test
 	ExportTest.Unlock()
 
+	fileDir := c.FileDir
+	if len(fileDir) == 0 {
+		fileDir = filepath.Join(os.TempDir(), "gopherbot-test-files")
+	}
+
 	tc := &TestConnector{
 		botName:     c.BotName,
 		botFullName: c.BotFullName,
@@ -62,6 +70,7 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 		listener:    make(chan *TestMessage),
 		speaking:    make(chan *TestMessage),
 		test:        t,
+		fileDir:     fileDir,
 	}
 
 	tc.Handler = robot
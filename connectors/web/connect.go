@@ -0,0 +1,239 @@
+// Package web implements the bot.Connector interface for custom web
+// front-ends and scripts. Users authenticate with a configured token, and
+// each WebSocket connection, or REST polling session, is a session for the
+// user; the robot's messages to a user go to all of the user's sessions,
+// and channel messages go to every session. Messages are JSON events,
+// documented in doc/Web-Connector.md. The robot's channels are just names;
+// any channel it's told to join exists.
+package web
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// webUser is a user allowed to connect
+type webUser struct {
+	Name                                        string // username / handle
+	Token                                       string // secret the user's sessions authenticate with
+	InternalID                                  string // connector internal identifier; defaults to Name
+	Email, FullName, FirstName, LastName, Phone string
+}
+
+type config struct {
+	ListenAddr     string   // address to listen on, e.g. "127.0.0.1:8889"
+	Path           string   // URL prefix for the endpoints, default "/chat"
+	CertFile       string   // serve TLS with CertFile and KeyFile, when set
+	KeyFile        string   //
+	AllowedOrigins []string // origins allowed to open WebSockets; default is the same host
+	SessionTimeout string   // how long an idle polling session lasts, default "5m"
+	BotName        string   // the robot's name in events, default "gopherbot"
+	Channels       []string // channels that exist before the robot joins any
+	Users          []webUser
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+// Limits for sessions
+const (
+	defaultTimeout = 5 * time.Minute
+	maxQueued      = 1000             // events held for a polling session
+	maxPollWait    = 60 * time.Second // longest a poll waits for events
+	sendBuffer     = 256              // events buffered for a WebSocket
+	writeWait      = 10 * time.Second
+	pingInterval   = 30 * time.Second
+	maxEventSize   = 64 * 1024 // largest event read from a client
+)
+
+// webConnector holds all the relevant data about the connector
+type webConnector struct {
+	botName      string
+	path         string
+	timeout      time.Duration
+	origins      map[string]struct{}
+	listener     net.Listener
+	server       *http.Server
+	upgrader     websocket.Upgrader
+	running      bool                       // set on call to Run
+	incoming     chan *bot.ConnectorMessage // messages heard, for Run
+	bot.Handler                             // bot API for connectors
+	sync.RWMutex                            // shared mutex for locking connector data structures
+	users        []*webUser
+	userMap      map[string]*webUser // users by name
+	userIDMap    map[string]*webUser // users by internal ID
+	channels     map[string]struct{}
+	sessions     map[string]*session
+	lastID       int64 // for message and session IDs
+}
+
+func init() {
+	bot.RegisterConnector("web", Initialize)
+}
+
+// Initialize starts listening and returns the connector object
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	return bot.Connector(newConnector(robot))
+}
+
+// newConnector reads the configuration and starts listening; the tests use
+// it directly, for a connector on a new port each time.
+func newConnector(robot bot.Handler) *webConnector {
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, "unable to retrieve web protocol configuration: %v", err)
+	}
+	if len(c.ListenAddr) == 0 {
+		robot.Log(bot.Fatal, "no web ListenAddr found in config")
+	}
+	if len(c.Path) == 0 {
+		c.Path = "/chat"
+	}
+	if len(c.BotName) == 0 {
+		c.BotName = "gopherbot"
+	}
+	timeout := defaultTimeout
+	if len(c.SessionTimeout) > 0 {
+		if timeout, err = time.ParseDuration(c.SessionTimeout); err != nil {
+			robot.Log(bot.Fatal, "invalid web SessionTimeout '%s': %v", c.SessionTimeout, err)
+		}
+	}
+
+	wc := &webConnector{
+		botName:   c.BotName,
+		path:      "/" + strings.Trim(c.Path, "/"),
+		timeout:   timeout,
+		origins:   make(map[string]struct{}),
+		incoming:  make(chan *bot.ConnectorMessage, 100),
+		userMap:   make(map[string]*webUser),
+		userIDMap: make(map[string]*webUser),
+		channels:  make(map[string]struct{}),
+		sessions:  make(map[string]*session),
+	}
+	wc.Handler = robot
+	for i := range c.Users {
+		u := &c.Users[i]
+		if len(u.Name) == 0 || len(u.Token) == 0 {
+			robot.Log(bot.Error, "web user with no Name or Token, ignoring")
+			continue
+		}
+		if len(u.InternalID) == 0 {
+			u.InternalID = u.Name
+		}
+		wc.users = append(wc.users, u)
+		wc.userMap[u.Name] = u
+		wc.userIDMap[u.InternalID] = u
+	}
+	if len(wc.users) == 0 {
+		robot.Log(bot.Fatal, "no web Users with a Name and Token found in config")
+	}
+	for _, ch := range c.Channels {
+		wc.channels[ch] = struct{}{}
+	}
+	for _, o := range c.AllowedOrigins {
+		wc.origins[strings.TrimRight(o, "/")] = struct{}{}
+	}
+	if len(wc.origins) > 0 {
+		wc.upgrader.CheckOrigin = wc.checkOrigin
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(wc.path+"/ws", wc.serveWebSocket)
+	mux.HandleFunc(wc.path+"/session", wc.serveSession)
+	mux.HandleFunc(wc.path+"/poll", wc.servePoll)
+	mux.HandleFunc(wc.path+"/send", wc.serveSend)
+	wc.server = &http.Server{Handler: mux}
+	if wc.listener, err = net.Listen("tcp", c.ListenAddr); err != nil {
+		robot.Log(bot.Fatal, "unable to listen on %s for web connector: %v", c.ListenAddr, err)
+	}
+	go func() {
+		var err error
+		if len(c.CertFile) > 0 {
+			err = wc.server.ServeTLS(wc.listener, c.CertFile, c.KeyFile)
+		} else {
+			err = wc.server.Serve(wc.listener)
+		}
+		if err != http.ErrServerClosed {
+			wc.Log(bot.Fatal, "web connector server failed: %v", err)
+		}
+	}()
+	wc.Log(bot.Info, "web connector listening on %s%s", wc.listener.Addr(), wc.path)
+	go wc.expireSessions()
+
+	wc.SetBotID(wc.botName)
+	wc.SetBotMention(wc.botName)
+	return wc
+}
+
+// Run forwards messages heard to the engine until stopped, then closes all
+// the sessions
+func (wc *webConnector) Run(stop <-chan struct{}) {
+	wc.Lock()
+	// This should never happen, just a bit of defensive coding
+	if wc.running {
+		wc.Unlock()
+		return
+	}
+	wc.running = true
+	wc.Unlock()
+loop:
+	for {
+		select {
+		case <-stop:
+			wc.Log(bot.Debug, "Received stop in connector")
+			break loop
+		case msg := <-wc.incoming:
+			wc.IncomingMessage(msg)
+		}
+	}
+	wc.server.Close()
+	wc.Lock()
+	for _, s := range wc.sessions {
+		s.close()
+	}
+	wc.sessions = make(map[string]*session)
+	wc.Unlock()
+}
+
+// authenticate returns the user for the request's token, from an
+// "Authorization: Bearer <token>" header or, since browsers can't set
+// headers for WebSockets, a "token" query parameter.
+func (wc *webConnector) authenticate(r *http.Request) *webUser {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if len(token) == 0 {
+		return nil
+	}
+	for _, u := range wc.users {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(u.Token)) == 1 {
+			return u
+		}
+	}
+	return nil
+}
+
+// checkOrigin allows WebSockets from the AllowedOrigins
+func (wc *webConnector) checkOrigin(r *http.Request) bool {
+	_, ok := wc.origins[strings.TrimRight(r.Header.Get("Origin"), "/")]
+	return ok
+}
@@ -0,0 +1,190 @@
+package web
+
+import (
+	"strconv"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// getUser returns the configured user for "<internalid>" or a username
+func (wc *webConnector) getUser(u string) (*webUser, bool) {
+	wc.RLock()
+	defer wc.RUnlock()
+	if id, ok := bot.ExtractID(u); ok {
+		user, exists := wc.userIDMap[id]
+		return user, exists
+	}
+	user, exists := wc.userMap[u]
+	return user, exists
+}
+
+// getChannel returns the channel name for "<channel>" or "channel", and
+// whether the channel exists
+func (wc *webConnector) getChannel(c string) (string, bool) {
+	if ch, ok := bot.ExtractID(c); ok {
+		c = ch
+	}
+	wc.RLock()
+	_, ok := wc.channels[c]
+	wc.RUnlock()
+	return c, ok
+}
+
+// SetUserMap is a no-op; users are configured in the ProtocolConfig
+func (wc *webConnector) SetUserMap(map[string]string) {
+	return
+}
+
+// GetProtocolUserAttribute returns a string attribute or "" if the web
+// connector doesn't have that information
+func (wc *webConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	user, exists := wc.getUser(u)
+	if !exists {
+		return "", bot.UserNotFound
+	}
+	switch attr {
+	case "email":
+		return user.Email, bot.Ok
+	case "internalid":
+		return user.InternalID, bot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return user.FullName, bot.Ok
+	case "firstname", "first name":
+		return user.FirstName, bot.Ok
+	case "lastname", "last name":
+		return user.LastName, bot.Ok
+	case "phone":
+		return user.Phone, bot.Ok
+	// that's all the attributes we have for web users
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a "typing" event to the user's sessions
+func (wc *webConnector) MessageHeard(u, c string) {
+	user, exists := wc.getUser(u)
+	if !exists {
+		return
+	}
+	channel, _ := wc.getChannel(c)
+	wc.broadcast(event{Type: "typing", User: wc.botName, Channel: channel}, user, nil)
+}
+
+// JoinChannel adds a channel; web channels exist once the robot joins them
+func (wc *webConnector) JoinChannel(c string) (ret bot.RetVal) {
+	channel, ok := wc.getChannel(c)
+	if ok {
+		return bot.Ok
+	}
+	wc.Lock()
+	wc.channels[channel] = struct{}{}
+	wc.Unlock()
+	wc.broadcast(event{Type: "join", User: wc.botName, Channel: channel}, nil, nil)
+	return bot.Ok
+}
+
+// SendProtocolChannelMessage sends a message to a channel
+func (wc *webConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return wc.SendProtocolChannelThreadMessage(ch, "", msg, f)
+}
+
+// SendProtocolUserChannelMessage sends a message to a channel, addressed to
+// the user
+func (wc *webConnector) SendProtocolUserChannelMessage(uid, uname, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return wc.SendProtocolUserChannelThreadMessage(uid, uname, ch, "", msg, f)
+}
+
+// SendProtocolChannelThreadMessage sends a message to a thread in a channel
+func (wc *webConnector) SendProtocolChannelThreadMessage(ch, thread, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return wc.sendChannel(ch, thread, "", msg, nil, f)
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a user in a thread
+func (wc *webConnector) SendProtocolUserChannelThreadMessage(uid, uname, ch, thread, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	user, exists := wc.getUser(uid)
+	if !exists {
+		if user, exists = wc.getUser(uname); !exists {
+			return bot.UserNotFound
+		}
+	}
+	return wc.sendChannel(ch, thread, user.Name, msg, nil, f)
+}
+
+// SendProtocolUserMessage sends a direct message to all of a user's
+// sessions; it fails if the user isn't connected.
+func (wc *webConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return wc.sendUser(u, msg, nil, f)
+}
+
+// SendProtocolUserChannelChoices prompts a user in a channel; the client
+// shows the choices and answers with a "choice" event
+func (wc *webConnector) SendProtocolUserChannelChoices(uid, uname, ch, thread, prompt string, choices []string, f bot.MessageFormat) bot.RetVal {
+	user, exists := wc.getUser(uid)
+	if !exists {
+		if user, exists = wc.getUser(uname); !exists {
+			return bot.UserNotFound
+		}
+	}
+	return wc.sendChannel(ch, thread, user.Name, prompt, choices, f)
+}
+
+// SendProtocolUserChoices prompts a user in a direct message
+func (wc *webConnector) SendProtocolUserChoices(u, prompt string, choices []string, f bot.MessageFormat) bot.RetVal {
+	return wc.sendUser(u, prompt, choices, f)
+}
+
+// sendChannel sends a message event to every session
+func (wc *webConnector) sendChannel(ch, thread, to, msg string, choices []string, f bot.MessageFormat) bot.RetVal {
+	channel, ok := wc.getChannel(ch)
+	if !ok {
+		wc.Log(bot.Error, "web channel not found: %s", ch)
+		return bot.ChannelNotFound
+	}
+	ev := wc.message(msg, f)
+	ev.To = to
+	ev.Channel = channel
+	ev.Thread = thread
+	ev.Choices = choices
+	wc.broadcast(ev, nil, nil)
+	return bot.Ok
+}
+
+// sendUser sends a direct message event to a user's sessions
+func (wc *webConnector) sendUser(u, msg string, choices []string, f bot.MessageFormat) bot.RetVal {
+	user, exists := wc.getUser(u)
+	if !exists {
+		return bot.UserNotFound
+	}
+	ev := wc.message(msg, f)
+	ev.To = user.Name
+	ev.Choices = choices
+	if wc.broadcast(ev, user, nil) == 0 {
+		wc.Log(bot.Warn, "Unable to send direct message to web user '%s', no sessions", user.Name)
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// message returns a message event from the robot with a new ID
+func (wc *webConnector) message(msg string, f bot.MessageFormat) event {
+	wc.Lock()
+	wc.lastID++
+	id := "m" + strconv.FormatInt(wc.lastID, 10)
+	wc.Unlock()
+	ev := event{
+		Type: "message",
+		ID:   id,
+		User: wc.botName,
+		Text: msg,
+	}
+	switch f {
+	case bot.Fixed:
+		ev.Format = "fixed"
+	case bot.Variable:
+		ev.Format = "variable"
+	default:
+		ev.Format = "raw"
+	}
+	return ev
+}
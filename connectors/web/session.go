@@ -0,0 +1,437 @@
+package web
+
+/* session.go - WebSocket and REST polling sessions. A WebSocket session
+has a writer goroutine, fed by a buffered channel; a client too slow to
+keep up is disconnected. A polling session queues events until the next
+poll, and expires when it isn't polled for SessionTimeout. */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// event is the JSON object exchanged with clients; see
+// doc/Web-Connector.md
+type event struct {
+	Type     string   `json:"type"`
+	ID       string   `json:"id,omitempty"`
+	Session  string   `json:"session,omitempty"`
+	User     string   `json:"user,omitempty"`
+	To       string   `json:"to,omitempty"`
+	Channel  string   `json:"channel,omitempty"`
+	Thread   string   `json:"thread,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Choices  []string `json:"choices,omitempty"`
+	Bot      string   `json:"bot,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// session is one WebSocket connection, or REST polling session, for a user
+type session struct {
+	id   string
+	user *webUser
+	ws   *websocket.Conn // nil for polling sessions
+	send chan event      // for the WebSocket writer
+	sync.Mutex
+	closed   bool
+	queue    []event       // for polling
+	notify   chan struct{} // signalled when events are queued
+	lastPoll time.Time
+}
+
+// deliver sends an event to the session without blocking
+func (s *session) deliver(ev event) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return
+	}
+	if s.ws != nil {
+		select {
+		case s.send <- ev:
+		default:
+			// the writer can't keep up; disconnecting lets the client
+			// reconnect and catch up
+			s.closed = true
+			close(s.send)
+		}
+		return
+	}
+	if len(s.queue) >= maxQueued {
+		s.queue = s.queue[1:]
+	}
+	s.queue = append(s.queue, ev)
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// close ends the session; called with the connector locked
+func (s *session) close() {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.ws != nil {
+		close(s.send)
+	} else {
+		close(s.notify)
+	}
+}
+
+// newSession registers a new session for a user
+func (wc *webConnector) newSession(u *webUser, ws *websocket.Conn) *session {
+	s := &session{
+		user:     u,
+		ws:       ws,
+		lastPoll: time.Now(),
+	}
+	if ws != nil {
+		s.send = make(chan event, sendBuffer)
+	} else {
+		s.notify = make(chan struct{}, 1)
+	}
+	wc.Lock()
+	wc.lastID++
+	s.id = "s" + strconv.FormatInt(wc.lastID, 10)
+	wc.sessions[s.id] = s
+	wc.Unlock()
+	wc.Log(bot.Debug, "New web session %s for user '%s'", s.id, u.Name)
+	return s
+}
+
+// endSession removes a session
+func (wc *webConnector) endSession(s *session) {
+	wc.Lock()
+	if _, ok := wc.sessions[s.id]; ok {
+		delete(wc.sessions, s.id)
+		s.close()
+	}
+	wc.Unlock()
+	wc.Log(bot.Debug, "Ended web session %s for user '%s'", s.id, s.user.Name)
+}
+
+// hello is the first event for a session
+func (wc *webConnector) hello(s *session) event {
+	wc.RLock()
+	channels := make([]string, 0, len(wc.channels))
+	for ch := range wc.channels {
+		channels = append(channels, ch)
+	}
+	wc.RUnlock()
+	return event{
+		Type:     "hello",
+		Session:  s.id,
+		User:     s.user.Name,
+		Bot:      wc.botName,
+		Channels: channels,
+	}
+}
+
+// broadcast sends an event to every session, or just a user's sessions when
+// user isn't nil; the except session is skipped. It returns the number of
+// sessions the event went to.
+func (wc *webConnector) broadcast(ev event, user *webUser, except *session) int {
+	wc.RLock()
+	defer wc.RUnlock()
+	sent := 0
+	for _, s := range wc.sessions {
+		if s == except || (user != nil && s.user != user) {
+			continue
+		}
+		s.deliver(ev)
+		sent++
+	}
+	return sent
+}
+
+// expireSessions ends polling sessions that haven't been polled for the
+// SessionTimeout
+func (wc *webConnector) expireSessions() {
+	for {
+		time.Sleep(wc.timeout / 4)
+		var expired []*session
+		wc.RLock()
+		for _, s := range wc.sessions {
+			s.Lock()
+			if s.ws == nil && time.Since(s.lastPoll) > wc.timeout {
+				expired = append(expired, s)
+			}
+			s.Unlock()
+		}
+		wc.RUnlock()
+		for _, s := range expired {
+			wc.endSession(s)
+		}
+	}
+}
+
+// heard handles an event from a client
+func (wc *webConnector) heard(s *session, u *webUser, ev *event) (string, error) {
+	switch ev.Type {
+	case "message", "choice":
+	default:
+		return "", fmt.Errorf("unknown event type '%s'", ev.Type)
+	}
+	if len(ev.Text) == 0 {
+		return "", fmt.Errorf("no text in message")
+	}
+	if len(ev.Channel) > 0 {
+		wc.RLock()
+		_, ok := wc.channels[ev.Channel]
+		wc.RUnlock()
+		if !ok {
+			return "", fmt.Errorf("no such channel '%s'", ev.Channel)
+		}
+	}
+	wc.Lock()
+	wc.lastID++
+	id := "m" + strconv.FormatInt(wc.lastID, 10)
+	wc.Unlock()
+	// other sessions see the message; direct messages only go to the user's
+	// other sessions
+	echo := event{
+		Type:    "message",
+		ID:      id,
+		User:    u.Name,
+		Channel: ev.Channel,
+		Thread:  ev.Thread,
+		Text:    ev.Text,
+	}
+	if len(ev.Channel) > 0 {
+		wc.broadcast(echo, nil, s)
+	} else {
+		wc.broadcast(echo, u, s)
+	}
+	wc.incoming <- &bot.ConnectorMessage{
+		Protocol:      "web",
+		UserName:      u.Name,
+		UserID:        u.InternalID,
+		ChannelName:   ev.Channel,
+		ChannelID:     ev.Channel,
+		DirectMessage: len(ev.Channel) == 0,
+		MessageText:   ev.Text,
+		ThreadID:      ev.Thread,
+		MessageID:     id,
+		Choice:        ev.Type == "choice",
+		MessageObject: ev,
+	}
+	return id, nil
+}
+
+// serveWebSocket upgrades an authenticated request to a WebSocket session
+func (wc *webConnector) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	u := wc.authenticate(r)
+	if u == nil {
+		http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+		return
+	}
+	ws, err := wc.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		wc.Log(bot.Debug, "web connector WebSocket upgrade failed: %v", err)
+		return
+	}
+	s := wc.newSession(u, ws)
+	s.deliver(wc.hello(s))
+	go wc.writeWebSocket(s)
+	wc.readWebSocket(s)
+}
+
+// readWebSocket reads client events until the connection closes
+func (wc *webConnector) readWebSocket(s *session) {
+	defer wc.endSession(s)
+	s.ws.SetReadLimit(maxEventSize)
+	s.ws.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	s.ws.SetPongHandler(func(string) error {
+		s.ws.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		return nil
+	})
+	for {
+		var ev event
+		if err := s.ws.ReadJSON(&ev); err != nil {
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				s.deliver(event{Type: "error", Error: "invalid JSON"})
+				continue
+			}
+			return
+		}
+		s.ws.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		if ev.Type == "ping" {
+			s.deliver(event{Type: "pong"})
+			continue
+		}
+		if _, err := wc.heard(s, s.user, &ev); err != nil {
+			s.deliver(event{Type: "error", Error: err.Error()})
+		}
+	}
+}
+
+// writeWebSocket writes events and pings until the session closes
+func (wc *webConnector) writeWebSocket(s *session) {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		s.ws.Close()
+	}()
+	for {
+		select {
+		case ev, ok := <-s.send:
+			s.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				s.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := s.ws.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			s.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := s.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// replyJSON writes a JSON response
+func replyJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// pollSession returns the authenticated user's polling session named in
+// the "session" query parameter, or writes an error response
+func (wc *webConnector) pollSession(w http.ResponseWriter, r *http.Request) *session {
+	u := wc.authenticate(r)
+	if u == nil {
+		replyJSON(w, http.StatusUnauthorized, event{Type: "error", Error: "invalid or missing token"})
+		return nil
+	}
+	id := r.URL.Query().Get("session")
+	wc.RLock()
+	s, ok := wc.sessions[id]
+	wc.RUnlock()
+	if !ok || s.user != u || s.ws != nil {
+		replyJSON(w, http.StatusNotFound, event{Type: "error", Error: "no such session"})
+		return nil
+	}
+	return s
+}
+
+// serveSession creates (POST) or ends (DELETE) a polling session
+func (wc *webConnector) serveSession(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		u := wc.authenticate(r)
+		if u == nil {
+			replyJSON(w, http.StatusUnauthorized, event{Type: "error", Error: "invalid or missing token"})
+			return
+		}
+		s := wc.newSession(u, nil)
+		replyJSON(w, http.StatusOK, wc.hello(s))
+	case http.MethodDelete:
+		if s := wc.pollSession(w, r); s != nil {
+			wc.endSession(s)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// servePoll returns the events queued for a polling session, waiting up to
+// "wait" seconds for one to arrive
+func (wc *webConnector) servePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s := wc.pollSession(w, r)
+	if s == nil {
+		return
+	}
+	wait, _ := strconv.Atoi(r.URL.Query().Get("wait"))
+	timeout := time.Duration(wait) * time.Second
+	if timeout > maxPollWait {
+		timeout = maxPollWait
+	}
+	expired := time.After(timeout)
+wait:
+	for {
+		s.Lock()
+		s.lastPoll = time.Now()
+		queued := len(s.queue)
+		s.Unlock()
+		if queued > 0 || timeout == 0 {
+			break
+		}
+		select {
+		case _, ok := <-s.notify:
+			if !ok {
+				break wait
+			}
+		case <-expired:
+			break wait
+		case <-r.Context().Done():
+			break wait
+		}
+	}
+	s.Lock()
+	events := s.queue
+	s.queue = nil
+	s.lastPoll = time.Now()
+	s.Unlock()
+	if events == nil {
+		events = []event{}
+	}
+	replyJSON(w, http.StatusOK, struct {
+		Events []event `json:"events"`
+	}{events})
+}
+
+// serveSend accepts a client event over REST; the "session" query
+// parameter, if given, keeps the message from being echoed to that session
+func (wc *webConnector) serveSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u := wc.authenticate(r)
+	if u == nil {
+		replyJSON(w, http.StatusUnauthorized, event{Type: "error", Error: "invalid or missing token"})
+		return
+	}
+	var s *session
+	if id := r.URL.Query().Get("session"); len(id) > 0 {
+		wc.RLock()
+		if ps, ok := wc.sessions[id]; ok && ps.user == u {
+			s = ps
+		}
+		wc.RUnlock()
+	}
+	var ev event
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEventSize)).Decode(&ev); err != nil {
+		replyJSON(w, http.StatusBadRequest, event{Type: "error", Error: "invalid JSON"})
+		return
+	}
+	id, err := wc.heard(s, u, &ev)
+	if err != nil {
+		replyJSON(w, http.StatusBadRequest, event{Type: "error", Error: err.Error()})
+		return
+	}
+	replyJSON(w, http.StatusOK, event{Type: "sent", ID: id})
+}
@@ -0,0 +1,296 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/internal/testbot"
+)
+
+// readEvent reads the next event from a WebSocket
+func readEvent(t *testing.T, ws *websocket.Conn) event {
+	var ev event
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := ws.ReadJSON(&ev); err != nil {
+		t.Errorf("reading event: %v", err)
+	}
+	return ev
+}
+
+// request makes a REST request with a token, decoding the JSON response
+func request(t *testing.T, method, url, token string, body interface{}, v interface{}) int {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, url, &buf)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, url, err)
+		return 0
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		json.NewDecoder(resp.Body).Decode(v)
+	}
+	return resp.StatusCode
+}
+
+// testConnector is a connector running for one test, with its endpoints
+type testConnector struct {
+	*webConnector
+	h     *testbot.Handler
+	base  string // REST API
+	wsURL string // WebSocket API
+}
+
+// newTestConnector starts a connector listening on a free port, stopped
+// when the test ends
+func newTestConnector(t *testing.T) *testConnector {
+	h := testbot.NewHandler(config{
+		ListenAddr: "127.0.0.1:0",
+		BotName:    "floyd",
+		Channels:   []string{"general"},
+		Users: []webUser{
+			{Name: "alice", Token: "alicetoken", InternalID: "u0001", FullName: "Alice User"},
+			{Name: "bob", Token: "bobtoken"},
+		},
+	})
+	wc := newConnector(h)
+	stop := make(chan struct{})
+	go wc.Run(stop)
+	t.Cleanup(func() { close(stop) })
+	addr := wc.listener.Addr().String()
+	return &testConnector{
+		webConnector: wc,
+		h:            h,
+		base:         "http://" + addr + "/chat",
+		wsURL:        "ws://" + addr + "/chat/ws",
+	}
+}
+
+// aliceSocket opens a WebSocket session for alice, and reads the hello
+func (tc *testConnector) aliceSocket(t *testing.T) *websocket.Conn {
+	ws, _, err := websocket.DefaultDialer.Dial(tc.wsURL+"?token=alicetoken", nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	hello := readEvent(t, ws)
+	assert.Equal(t, "hello", hello.Type)
+	return ws
+}
+
+// bobSession starts a REST session for bob, returning the poll URL and
+// a function to end the session
+func (tc *testConnector) bobSession(t *testing.T) (session, poll string, end func()) {
+	var hello event
+	assert.Equal(t, http.StatusOK, request(t, "POST", tc.base+"/session", "bobtoken", nil, &hello))
+	session = hello.Session
+	end = func() {
+		request(t, "DELETE", tc.base+"/session?session="+session, "bobtoken", nil, nil)
+	}
+	return session, tc.base + "/poll?session=" + session, end
+}
+
+func TestInitialize(t *testing.T) {
+	tc := newTestConnector(t)
+	assert.Equal(t, "floyd", tc.h.BotID())
+	assert.Equal(t, "floyd", tc.h.BotMention())
+}
+
+func TestAuth(t *testing.T) {
+	tc := newTestConnector(t)
+	_, resp, err := websocket.DefaultDialer.Dial(tc.wsURL+"?token=wrong", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	assert.Equal(t, http.StatusUnauthorized, request(t, "POST", tc.base+"/session", "", nil, nil))
+	assert.Equal(t, http.StatusUnauthorized, request(t, "POST", tc.base+"/send", "wrong", event{Type: "message", Text: "hi"}, nil))
+}
+
+func TestHello(t *testing.T) {
+	tc := newTestConnector(t)
+	ws, _, err := websocket.DefaultDialer.Dial(tc.wsURL+"?token=alicetoken", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ws.Close()
+	hello := readEvent(t, ws)
+	assert.Equal(t, "hello", hello.Type)
+	assert.Equal(t, "alice", hello.User)
+	assert.Equal(t, "floyd", hello.Bot)
+	assert.Contains(t, hello.Channels, "general")
+
+	var bobHello event
+	assert.Equal(t, http.StatusOK, request(t, "POST", tc.base+"/session", "bobtoken", nil, &bobHello))
+	assert.Equal(t, "bob", bobHello.User)
+	assert.NotEmpty(t, bobHello.Session)
+	request(t, "DELETE", tc.base+"/session?session="+bobHello.Session, "bobtoken", nil, nil)
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	tc := newTestConnector(t)
+	ws := tc.aliceSocket(t)
+	defer ws.Close()
+	_, poll, end := tc.bobSession(t)
+	defer end()
+
+	ws.WriteJSON(event{Type: "message", Channel: "general", Text: "floyd, ping"})
+	msg := tc.h.WaitMessage(t)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "web", msg.Protocol)
+		assert.Equal(t, "alice", msg.UserName)
+		assert.Equal(t, "u0001", msg.UserID)
+		assert.Equal(t, "general", msg.ChannelID)
+		assert.False(t, msg.DirectMessage)
+		assert.Equal(t, "floyd, ping", msg.MessageText)
+	}
+	// bob's session sees alice's message
+	var polled struct{ Events []event }
+	assert.Equal(t, http.StatusOK, request(t, "GET", poll, "bobtoken", nil, &polled))
+	if assert.Len(t, polled.Events, 1) {
+		assert.Equal(t, "alice", polled.Events[0].User)
+		assert.Equal(t, "floyd, ping", polled.Events[0].Text)
+	}
+
+	assert.Equal(t, bot.Ok, tc.SendProtocolUserChannelMessage("<u0001>", "alice", "general", "PONG", bot.Fixed))
+	ev := readEvent(t, ws)
+	assert.Equal(t, "message", ev.Type)
+	assert.Equal(t, "floyd", ev.User)
+	assert.Equal(t, "alice", ev.To)
+	assert.Equal(t, "general", ev.Channel)
+	assert.Equal(t, "PONG", ev.Text)
+	assert.Equal(t, "fixed", ev.Format)
+}
+
+func TestWebSocketErrors(t *testing.T) {
+	tc := newTestConnector(t)
+	ws := tc.aliceSocket(t)
+	defer ws.Close()
+	ws.WriteJSON(event{Type: "message", Channel: "nowhere", Text: "hi"})
+	ev := readEvent(t, ws)
+	assert.Equal(t, "error", ev.Type)
+	assert.Contains(t, ev.Error, "nowhere")
+	ws.WriteJSON(event{Type: "ping"})
+	assert.Equal(t, "pong", readEvent(t, ws).Type)
+}
+
+func TestPollingMessages(t *testing.T) {
+	tc := newTestConnector(t)
+	session, poll, end := tc.bobSession(t)
+	defer end()
+	var sent event
+	assert.Equal(t, http.StatusOK, request(t, "POST", tc.base+"/send?session="+session, "bobtoken", event{Type: "message", Text: "help"}, &sent))
+	assert.Equal(t, "sent", sent.Type)
+	msg := tc.h.WaitMessage(t)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "bob", msg.UserID)
+		assert.True(t, msg.DirectMessage)
+		assert.Equal(t, sent.ID, msg.MessageID)
+	}
+
+	// a poll waits for the robot's reply
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tc.SendProtocolUserMessage("bob", "Here's some help", bot.Raw)
+	}()
+	var polled struct{ Events []event }
+	assert.Equal(t, http.StatusOK, request(t, "GET", poll+"&wait=5", "bobtoken", nil, &polled))
+	if assert.Len(t, polled.Events, 1) {
+		assert.Equal(t, "bob", polled.Events[0].To)
+		assert.Equal(t, "", polled.Events[0].Channel)
+		assert.Equal(t, "Here's some help", polled.Events[0].Text)
+	}
+	// alice can't use bob's session
+	assert.Equal(t, http.StatusNotFound, request(t, "GET", poll, "alicetoken", nil, nil))
+}
+
+func TestEventTooLarge(t *testing.T) {
+	tc := newTestConnector(t)
+	session, _, end := tc.bobSession(t)
+	defer end()
+	big := event{Type: "message", Text: strings.Repeat("x", maxEventSize)}
+	var reply event
+	assert.Equal(t, http.StatusBadRequest, request(t, "POST", tc.base+"/send?session="+session, "bobtoken", big, &reply))
+	assert.Equal(t, "error", reply.Type)
+	tc.h.NoMessage(t, 100*time.Millisecond)
+
+	// the WebSocket is closed
+	ws := tc.aliceSocket(t)
+	defer ws.Close()
+	ws.WriteJSON(big)
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := ws.ReadMessage()
+	assert.Error(t, err)
+}
+
+func TestChoices(t *testing.T) {
+	tc := newTestConnector(t)
+	ws := tc.aliceSocket(t)
+	defer ws.Close()
+	assert.Equal(t, bot.Ok, tc.SendProtocolUserChoices("alice", "Pick one", []string{"red", "blue"}, bot.Raw))
+	ev := readEvent(t, ws)
+	assert.Equal(t, "Pick one", ev.Text)
+	assert.Equal(t, []string{"red", "blue"}, ev.Choices)
+	ws.WriteJSON(event{Type: "choice", Text: "blue"})
+	msg := tc.h.WaitMessage(t)
+	if assert.NotNil(t, msg) {
+		assert.True(t, msg.Choice)
+		assert.Equal(t, "blue", msg.MessageText)
+	}
+}
+
+func TestUserAttributes(t *testing.T) {
+	tc := newTestConnector(t)
+	name, ret := tc.GetProtocolUserAttribute("<u0001>", "fullname")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "Alice User", name)
+	_, ret = tc.GetProtocolUserAttribute("carol", "email")
+	assert.Equal(t, bot.UserNotFound, ret)
+	assert.Equal(t, bot.UserNotFound, tc.SendProtocolUserMessage("carol", "hi", bot.Raw))
+}
+
+func TestJoinChannel(t *testing.T) {
+	tc := newTestConnector(t)
+	ws := tc.aliceSocket(t)
+	defer ws.Close()
+	assert.Equal(t, bot.ChannelNotFound, tc.SendProtocolChannelMessage("random", "hi", bot.Raw))
+	assert.Equal(t, bot.Ok, tc.JoinChannel("random"))
+	ev := readEvent(t, ws)
+	assert.Equal(t, "join", ev.Type)
+	assert.Equal(t, "random", ev.Channel)
+	assert.Equal(t, bot.Ok, tc.SendProtocolChannelMessage("<random>", "hi", bot.Raw))
+	assert.Equal(t, "random", readEvent(t, ws).Channel)
+}
+
+func TestEndSession(t *testing.T) {
+	tc := newTestConnector(t)
+	ws := tc.aliceSocket(t)
+	session, _, _ := tc.bobSession(t)
+	assert.Equal(t, http.StatusNoContent, request(t, "DELETE", tc.base+"/session?session="+session, "bobtoken", nil, nil))
+	assert.Equal(t, bot.FailedMessageSend, tc.SendProtocolUserMessage("bob", "anyone there?", bot.Raw))
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	ws.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		tc.RLock()
+		n := len(tc.sessions)
+		tc.RUnlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, bot.FailedMessageSend, tc.SendProtocolUserMessage("alice", "anyone there?", bot.Raw))
+}
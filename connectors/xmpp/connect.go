@@ -0,0 +1,187 @@
+// Package xmpp implements a connector for XMPP servers like Prosody and
+// ejabberd, supporting one-to-one chats and multi-user chat (MUC) rooms.
+// Bare JIDs like "alice@example.com" are the UserIDs for the UserRoster,
+// and rooms on the MUC service are named by their local part, e.g.
+// "general@conference.example.com" is the "general" channel.
+package xmpp
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+type config struct {
+	JID           string // the robot's JID, e.g. gopher@example.com
+	Password      string // password for SASL PLAIN authentication
+	Server        string // host:port to connect to; defaults to the JID's domain, port 5222
+	Resource      string // resource to bind; defaults to "gopherbot"
+	DirectTLS     bool   // connect with TLS directly, e.g. port 5223, instead of STARTTLS
+	TLSSkipVerify bool   // don't verify the server's certificate
+	NoTLS         bool   // allow connecting without TLS, e.g. to a local test server
+	MUCService    string // the multi-user chat service; defaults to "conference.<domain>"
+	Nick          string // the robot's nick in rooms; defaults to the JID's local part
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+// Reconnect backoff and keepalive
+const (
+	minBackoff     = time.Second
+	maxBackoff     = 5 * time.Minute
+	dialTimeout    = 30 * time.Second
+	keepaliveDelay = time.Minute
+)
+
+// xmppConnector holds all the relevant data about a connection
+type xmppConnector struct {
+	cfg          config
+	jid          string                       // the robot's bare JID
+	domain       string                       // the robot's domain, e.g. example.com
+	running      bool                         // set on call to Run
+	stopping     bool                         // set when Run is stopped, so the robot doesn't reconnect
+	conn         *xmppConn                    // the current connection, nil when disconnected
+	incoming     chan *bot.ConnectorMessage   // messages heard, for Run
+	bot.Handler                               // bot API for connectors
+	sync.RWMutex                              // shared mutex for locking connector data structures
+	rooms        map[string]struct{}          // JIDs of rooms to join; re-joined on reconnect
+	occupants    map[string]map[string]string // room JID to nick to real bare JID, when the room shows it
+	roster       map[string]rosterItem        // the robot's roster, by bare JID
+	userMap      map[string]string            // configured username to JID, from SetUserMap
+}
+
+func init() {
+	bot.RegisterConnector("xmpp", Initialize)
+}
+
+// Initialize connects and logs in to the server, and returns the connector
+// object
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	return bot.Connector(newConnector(robot))
+}
+
+// newConnector does the work of Initialize without the started guard, so
+// each test can connect its own connector to a fake server.
+func newConnector(robot bot.Handler) *xmppConnector {
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, "unable to retrieve xmpp protocol configuration: %v", err)
+	}
+	if !strings.Contains(c.JID, "@") {
+		robot.Log(bot.Fatal, "no valid xmpp JID found in config: '%s'", c.JID)
+	}
+	if len(c.Password) == 0 {
+		robot.Log(bot.Fatal, "no xmpp Password found in config")
+	}
+	jid := bareJID(c.JID)
+	domain := domainpart(jid)
+	if len(c.Server) == 0 {
+		c.Server = domain + ":5222"
+	}
+	if len(c.Resource) == 0 {
+		c.Resource = "gopherbot"
+	}
+	if len(c.MUCService) == 0 {
+		c.MUCService = "conference." + domain
+	}
+	if len(c.Nick) == 0 {
+		c.Nick = localpart(jid)
+	}
+
+	xc := &xmppConnector{
+		cfg:       c,
+		jid:       jid,
+		domain:    domain,
+		incoming:  make(chan *bot.ConnectorMessage, 100),
+		rooms:     make(map[string]struct{}),
+		occupants: make(map[string]map[string]string),
+		roster:    make(map[string]rosterItem),
+		userMap:   make(map[string]string),
+	}
+	xc.Handler = robot
+
+	connected := make(chan struct{})
+	go xc.manageConnection(connected)
+	<-connected
+
+	robot.SetBotID(jid)
+	robot.SetBotMention(c.Nick)
+	return xc
+}
+
+// Run forwards messages heard to the engine until stopped
+func (xc *xmppConnector) Run(stop <-chan struct{}) {
+	xc.Lock()
+	// This should never happen, just a bit of defensive coding
+	if xc.running {
+		xc.Unlock()
+		return
+	}
+	xc.running = true
+	xc.Unlock()
+loop:
+	for {
+		select {
+		case <-stop:
+			xc.Log(bot.Debug, "Received stop in connector")
+			break loop
+		case msg := <-xc.incoming:
+			xc.IncomingMessage(msg)
+		}
+	}
+	xc.Lock()
+	xc.stopping = true
+	conn := xc.conn
+	xc.Unlock()
+	if conn != nil {
+		conn.close()
+	}
+}
+
+// bareJID strips the resource from a JID
+func bareJID(jid string) string {
+	if i := strings.IndexByte(jid, '/'); i >= 0 {
+		return jid[:i]
+	}
+	return jid
+}
+
+// resourcepart returns the resource of a JID, or the nick of a room
+// occupant
+func resourcepart(jid string) string {
+	if i := strings.IndexByte(jid, '/'); i >= 0 {
+		return jid[i+1:]
+	}
+	return ""
+}
+
+// localpart returns "alice" for "alice@example.com/phone"
+func localpart(jid string) string {
+	if i := strings.IndexByte(jid, '@'); i >= 0 {
+		return jid[:i]
+	}
+	return ""
+}
+
+// domainpart returns "example.com" for "alice@example.com/phone"
+func domainpart(jid string) string {
+	jid = bareJID(jid)
+	if i := strings.IndexByte(jid, '@'); i >= 0 {
+		return jid[i+1:]
+	}
+	return jid
+}
@@ -0,0 +1,185 @@
+package xmpp
+
+import (
+	"strings"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// GetProtocolUserAttribute returns a string attribute or "" if the robot's
+// roster doesn't have that information
+func (xc *xmppConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	jid := xc.userJID(u)
+	switch attr {
+	case "internalid", "jid":
+		return jid, bot.Ok
+	case "realname", "fullname", "real name", "full name", "name", "groups":
+		xc.RLock()
+		item, ok := xc.roster[jid]
+		xc.RUnlock()
+		if !ok {
+			return "", bot.UserNotFound
+		}
+		if attr == "groups" {
+			return strings.Join(item.Groups, ","), bot.Ok
+		}
+		if len(item.Name) == 0 {
+			return "", bot.AttributeNotFound
+		}
+		return item.Name, bot.Ok
+	// that's all the attributes we can currently get from xmpp
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a "composing" chat state letting the user know the
+// message has been heard by the robot.
+func (xc *xmppConnector) MessageHeard(user, channel string) {
+	var to, msgType string
+	if room, ok := bot.ExtractID(channel); ok && len(room) > 0 {
+		to, msgType = room, "groupchat"
+	} else if jid, ok := bot.ExtractID(user); ok && len(jid) > 0 {
+		to, msgType = jid, "chat"
+	} else {
+		return
+	}
+	xc.RLock()
+	c := xc.conn
+	xc.RUnlock()
+	if c == nil {
+		return
+	}
+	c.write("<message to='%s' type='%s'><composing xmlns='http://jabber.org/protocol/chatstates'/></message>",
+		escape(to), msgType)
+}
+
+// SetUserMap takes a map of username to JID mappings, built from the
+// UserRoster of gopherbot.yaml
+func (xc *xmppConnector) SetUserMap(umap map[string]string) {
+	xc.Lock()
+	xc.userMap = umap
+	xc.Unlock()
+}
+
+// userJID returns the JID for "<alice@example.com>", a username from the
+// UserRoster, a JID, or "alice" on the robot's domain.
+func (xc *xmppConnector) userJID(u string) string {
+	if jid, ok := bot.ExtractID(u); ok {
+		return jid
+	}
+	xc.RLock()
+	jid, ok := xc.userMap[u]
+	xc.RUnlock()
+	if ok {
+		return jid
+	}
+	if strings.Contains(u, "@") {
+		return u
+	}
+	return u + "@" + xc.domain
+}
+
+// roomJID returns the room JID for "<general@conference.example.com>", a
+// room JID, or "general" on the robot's MUC service.
+func (xc *xmppConnector) roomJID(ch string) string {
+	if jid, ok := bot.ExtractID(ch); ok {
+		return jid
+	}
+	if strings.Contains(ch, "@") {
+		return ch
+	}
+	return ch + "@" + xc.cfg.MUCService
+}
+
+// roomNick returns the user's nick in a room; occupant JIDs
+// "room@service/nick" carry the nick, otherwise the robot looks for the
+// user's real JID among the occupants.
+func (xc *xmppConnector) roomNick(room, uid, uname string) string {
+	jid := xc.userJID(uid)
+	if bareJID(jid) == room {
+		return resourcepart(jid)
+	}
+	xc.RLock()
+	defer xc.RUnlock()
+	for nick, realJID := range xc.occupants[room] {
+		if realJID == jid {
+			return nick
+		}
+	}
+	return uname
+}
+
+// SendProtocolChannelMessage sends a message to a room
+func (xc *xmppConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return xc.sendRoomMessage(xc.roomJID(ch), "", msg, f)
+}
+
+// SendProtocolUserChannelMessage sends a message to a room, addressed to
+// the user
+func (xc *xmppConnector) SendProtocolUserChannelMessage(uid, uname, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	room := xc.roomJID(ch)
+	return xc.sendRoomMessage(room, xc.roomNick(room, uid, uname)+": ", msg, f)
+}
+
+// SendProtocolUserMessage sends a one-to-one chat message to a user
+func (xc *xmppConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return xc.sendMessage(xc.userJID(u), "chat", "", msg, f)
+}
+
+// sendRoomMessage sends a message to a room the robot has joined
+func (xc *xmppConnector) sendRoomMessage(room, prefix, msg string, f bot.MessageFormat) bot.RetVal {
+	xc.RLock()
+	_, joined := xc.rooms[room]
+	xc.RUnlock()
+	if !joined {
+		xc.Log(bot.Error, "Not sending to xmpp room %s, which the robot hasn't joined", room)
+		return bot.ChannelNotFound
+	}
+	return xc.sendMessage(room, "groupchat", prefix, msg, f)
+}
+
+// sendMessage sends a message; Fixed messages use a preformatted block
+// from XEP-0393 message styling, which most clients render.
+func (xc *xmppConnector) sendMessage(to, msgType, prefix, msg string, f bot.MessageFormat) bot.RetVal {
+	xc.RLock()
+	c := xc.conn
+	xc.RUnlock()
+	if c == nil {
+		xc.Log(bot.Error, "Not connected to xmpp server, dropping message to %s", to)
+		return bot.FailedMessageSend
+	}
+	if f == bot.Fixed {
+		msg = "```\n" + msg + "\n```"
+		if len(prefix) > 0 {
+			prefix += "\n"
+		}
+	}
+	err := c.write("<message to='%s' type='%s' id='%s'><body>%s</body></message>",
+		escape(to), msgType, c.nextID(), escape(prefix+msg))
+	if err != nil {
+		xc.Log(bot.Error, "Sending xmpp message to %s: %v", to, err)
+		c.close()
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// JoinChannel joins a room given its name on the robot's MUC service, e.g.
+// "general" for general@conference.example.com; full room JIDs also work.
+// The robot re-joins rooms when it reconnects.
+func (xc *xmppConnector) JoinChannel(ch string) (ret bot.RetVal) {
+	room := xc.roomJID(ch)
+	xc.Lock()
+	xc.rooms[room] = struct{}{}
+	c := xc.conn
+	xc.Unlock()
+	if c == nil {
+		return bot.Ok
+	}
+	if err := xc.joinRoom(c, room); err != nil {
+		xc.Log(bot.Error, "Joining xmpp room %s: %v", room, err)
+		return bot.FailedChannelJoin
+	}
+	return bot.Ok
+}
@@ -0,0 +1,246 @@
+package xmpp
+
+/* stanza.go - handling messages, presence and iqs from the server. */
+
+import (
+	"encoding/xml"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// stanzaError is the error element of a stanza with type='error'
+type stanzaError struct {
+	Type       string     `xml:"type,attr"`
+	Conditions []xml.Name `xml:",any"`
+}
+
+// condition returns the defined condition, e.g. "conflict"
+func (e *stanzaError) condition() string {
+	if e == nil {
+		return "no error given"
+	}
+	for _, c := range e.Conditions {
+		if c.Space == nsStanzas && c.Local != "text" {
+			return c.Local
+		}
+	}
+	return "unknown error"
+}
+
+type stanzaMessage struct {
+	From  string       `xml:"from,attr"`
+	To    string       `xml:"to,attr"`
+	Type  string       `xml:"type,attr"`
+	ID    string       `xml:"id,attr"`
+	Body  string       `xml:"body"`
+	Delay *struct{}    `xml:"urn:xmpp:delay delay"`
+	Error *stanzaError `xml:"error"`
+}
+
+// mucItem is the affiliation and role of a room occupant; JID is only
+// sent by non-anonymous rooms.
+type mucItem struct {
+	JID  string `xml:"jid,attr"`
+	Nick string `xml:"nick,attr"`
+	Role string `xml:"role,attr"`
+}
+
+type stanzaPresence struct {
+	From    string `xml:"from,attr"`
+	Type    string `xml:"type,attr"`
+	MUCUser *struct {
+		Item   mucItem `xml:"item"`
+		Status []struct {
+			Code string `xml:"code,attr"`
+		} `xml:"status"`
+	} `xml:"http://jabber.org/protocol/muc#user x"`
+	Error *stanzaError `xml:"error"`
+}
+
+type rosterItem struct {
+	JID          string   `xml:"jid,attr"`
+	Name         string   `xml:"name,attr"`
+	Subscription string   `xml:"subscription,attr"`
+	Groups       []string `xml:"group"`
+}
+
+type stanzaIQ struct {
+	From   string `xml:"from,attr"`
+	Type   string `xml:"type,attr"`
+	ID     string `xml:"id,attr"`
+	Roster *struct {
+		Items []rosterItem `xml:"item"`
+	} `xml:"jabber:iq:roster query"`
+	Bind *struct {
+		JID string `xml:"jid"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Ping  *struct{}    `xml:"urn:xmpp:ping ping"`
+	Error *stanzaError `xml:"error"`
+}
+
+// heard queues a message for the engine. Messages in rooms come from
+// "room@service/nick"; when the room shows real JIDs the robot uses those,
+// otherwise the occupant JID is the user ID.
+func (xc *xmppConnector) heard(m *stanzaMessage) {
+	if m.Type == "error" {
+		xc.Log(bot.Error, "Error sending xmpp message to %s: %s", m.From, m.Error.condition())
+		return
+	}
+	// Chat state notifications and room subjects have no body, and
+	// delayed messages are history or offline messages
+	if len(m.Body) == 0 || m.Delay != nil {
+		return
+	}
+	bare := bareJID(m.From)
+	nick := resourcepart(m.From)
+	xc.RLock()
+	_, isRoom := xc.rooms[bare]
+	realJID := xc.occupants[bare][nick]
+	xc.RUnlock()
+	botMsg := &bot.ConnectorMessage{
+		Protocol:      "XMPP",
+		MessageText:   m.Body,
+		MessageID:     m.ID,
+		MessageObject: m,
+	}
+	switch {
+	case isRoom:
+		if len(nick) == 0 || nick == xc.cfg.Nick {
+			return
+		}
+		if len(realJID) > 0 {
+			botMsg.UserID = realJID
+			botMsg.UserName = xc.userName(realJID)
+		} else {
+			botMsg.UserID = m.From
+			botMsg.UserName = nick
+		}
+		if m.Type == "groupchat" {
+			botMsg.ChannelID = bare
+			botMsg.ChannelName = xc.channelName(bare)
+		} else {
+			// a private message from a room occupant
+			botMsg.DirectMessage = true
+		}
+	case bare == xc.jid:
+		return
+	default:
+		botMsg.UserID = bare
+		botMsg.UserName = xc.userName(bare)
+		botMsg.DirectMessage = true
+	}
+	select {
+	case xc.incoming <- botMsg:
+	default:
+		xc.Log(bot.Warn, "xmpp incoming queue full, dropping message from %s", m.From)
+	}
+}
+
+// presence tracks room occupants, and approves subscription requests so
+// users can add the robot to their roster
+func (xc *xmppConnector) presence(c *xmppConn, p *stanzaPresence) {
+	bare := bareJID(p.From)
+	xc.Lock()
+	defer xc.Unlock()
+	if _, isRoom := xc.rooms[bare]; isRoom {
+		nick := resourcepart(p.From)
+		switch p.Type {
+		case "error":
+			xc.Log(bot.Error, "Unable to join xmpp room %s as '%s': %s", bare, xc.cfg.Nick, p.Error.condition())
+		case "unavailable":
+			delete(xc.occupants[bare], nick)
+		case "":
+			occupants, ok := xc.occupants[bare]
+			if !ok {
+				occupants = make(map[string]string)
+				xc.occupants[bare] = occupants
+			}
+			var realJID string
+			if p.MUCUser != nil {
+				realJID = bareJID(p.MUCUser.Item.JID)
+			}
+			occupants[nick] = realJID
+		}
+		return
+	}
+	switch p.Type {
+	case "subscribe":
+		xc.Log(bot.Info, "Approving xmpp presence subscription from %s", bare)
+		c.write("<presence to='%s' type='subscribed'/>", escape(bare))
+	case "error":
+		xc.Log(bot.Debug, "xmpp presence error from %s: %s", p.From, p.Error.condition())
+	}
+}
+
+// iq handles roster results and pushes, and answers pings
+func (xc *xmppConnector) iq(c *xmppConn, iq *stanzaIQ) {
+	switch iq.Type {
+	case "result":
+		if iq.Roster != nil {
+			xc.Lock()
+			roster := make(map[string]rosterItem)
+			for _, item := range iq.Roster.Items {
+				roster[item.JID] = item
+			}
+			xc.roster = roster
+			xc.Unlock()
+		}
+	case "set":
+		// roster pushes must come from the robot's own account
+		if iq.Roster != nil && (len(iq.From) == 0 || bareJID(iq.From) == xc.jid) {
+			xc.Lock()
+			for _, item := range iq.Roster.Items {
+				if item.Subscription == "remove" {
+					delete(xc.roster, item.JID)
+				} else {
+					xc.roster[item.JID] = item
+				}
+			}
+			xc.Unlock()
+			c.write("<iq type='result' id='%s'/>", escape(iq.ID))
+			return
+		}
+		xc.unsupported(c, iq)
+	case "get":
+		if iq.Ping != nil {
+			c.write("<iq type='result' id='%s'%s/>", escape(iq.ID), toAttr(iq.From))
+			return
+		}
+		xc.unsupported(c, iq)
+	case "error":
+		xc.Log(bot.Debug, "xmpp iq error from %s: %s", iq.From, iq.Error.condition())
+	}
+}
+
+// unsupported answers requests the robot doesn't handle
+func (xc *xmppConnector) unsupported(c *xmppConn, iq *stanzaIQ) {
+	c.write("<iq type='error' id='%s'%s><error type='cancel'><service-unavailable xmlns='%s'/></error></iq>",
+		escape(iq.ID), toAttr(iq.From), nsStanzas)
+}
+
+// toAttr addresses a reply; stanzas from the server itself may have no
+// 'from'
+func toAttr(from string) string {
+	if len(from) == 0 {
+		return ""
+	}
+	return " to='" + escape(from) + "'"
+}
+
+// userName returns the local part for users on the robot's domain, or the
+// bare JID for other domains
+func (xc *xmppConnector) userName(jid string) string {
+	if domainpart(jid) == xc.domain {
+		return localpart(jid)
+	}
+	return jid
+}
+
+// channelName returns "general" for general@conference.example.com on the
+// robot's MUC service, or the room JID for other services
+func (xc *xmppConnector) channelName(room string) string {
+	if domainpart(room) == xc.cfg.MUCService {
+		return localpart(room)
+	}
+	return room
+}
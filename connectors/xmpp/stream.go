@@ -0,0 +1,468 @@
+package xmpp
+
+/* stream.go - connecting to the server, negotiating TLS, authenticating and
+binding a resource, reading stanzas, and reconnecting with backoff when the
+connection drops. */
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wanghonggao007/gopherbot/bot"
+)
+
+// Namespaces for stream negotiation
+const (
+	nsStreams = "http://etherx.jabber.org/streams"
+	nsTLS     = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsSASL    = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsBind    = "urn:ietf:params:xml:ns:xmpp-bind"
+	nsSession = "urn:ietf:params:xml:ns:xmpp-session"
+	nsStanzas = "urn:ietf:params:xml:ns:xmpp-stanzas"
+)
+
+// streamFeatures are the features the server offers after opening a stream
+type streamFeatures struct {
+	StartTLS *struct {
+		Required *struct{} `xml:"required"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms *struct {
+		Mechanism []string `xml:"mechanism"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms"`
+	Bind    *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Session *struct {
+		Optional *struct{} `xml:"optional"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-session session"`
+}
+
+// streamError is a fatal error for the stream, e.g. conflict when another
+// client binds the same resource
+type streamError struct {
+	Conditions []xml.Name `xml:",any"`
+	Text       string     `xml:"urn:ietf:params:xml:ns:xmpp-streams text"`
+}
+
+func (e *streamError) Error() string {
+	var conds []string
+	for _, c := range e.Conditions {
+		if c.Local != "text" {
+			conds = append(conds, c.Local)
+		}
+	}
+	msg := "stream error: " + strings.Join(conds, ", ")
+	if len(e.Text) > 0 {
+		msg += " (" + e.Text + ")"
+	}
+	return msg
+}
+
+// xmppConn is a single connection to the server
+type xmppConn struct {
+	net.Conn
+	dec       *xml.Decoder
+	writeLock sync.Mutex
+	done      chan struct{} // closed when the connection is finished
+	closing   sync.Once
+	id        int // counter for stanza IDs
+}
+
+// newConn dials the server
+func (xc *xmppConnector) newConn() (*xmppConn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var nc net.Conn
+	var err error
+	if xc.cfg.DirectTLS {
+		nc, err = tls.DialWithDialer(dialer, "tcp", xc.cfg.Server, xc.tlsConfig())
+	} else {
+		nc, err = dialer.Dial("tcp", xc.cfg.Server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &xmppConn{
+		Conn: nc,
+		dec:  xml.NewDecoder(nc),
+		done: make(chan struct{}),
+	}, nil
+}
+
+func (xc *xmppConnector) tlsConfig() *tls.Config {
+	host, _, err := net.SplitHostPort(xc.cfg.Server)
+	if err != nil {
+		host = xc.cfg.Server
+	}
+	return &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: xc.cfg.TLSSkipVerify,
+	}
+}
+
+// close shuts down the connection, ending the read loop and keepalives
+func (c *xmppConn) close() {
+	c.closing.Do(func() {
+		close(c.done)
+		c.writeLock.Lock()
+		c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(c.Conn, "</stream:stream>")
+		c.writeLock.Unlock()
+		c.Conn.Close()
+	})
+}
+
+// write sends raw XML to the server
+func (c *xmppConn) write(format string, v ...interface{}) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := fmt.Fprintf(c.Conn, format, v...)
+	return err
+}
+
+// nextID returns a new stanza ID
+func (c *xmppConn) nextID() string {
+	c.writeLock.Lock()
+	c.id++
+	id := c.id
+	c.writeLock.Unlock()
+	return fmt.Sprintf("gb%d", id)
+}
+
+// next returns the next top-level element from the server
+func (c *xmppConn) next() (xml.StartElement, error) {
+	for {
+		t, err := c.dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			if t.Name.Space == nsStreams && t.Name.Local == "stream" {
+				return xml.StartElement{}, errors.New("server closed the stream")
+			}
+		}
+	}
+}
+
+// openStream opens a new stream and returns the features offered; the
+// decoder is reset, since the server starts a new XML document.
+func (c *xmppConn) openStream(domain string) (*streamFeatures, error) {
+	c.dec = xml.NewDecoder(c.Conn)
+	err := c.write("<?xml version='1.0'?><stream:stream to='%s' version='1.0' xmlns='jabber:client' xmlns:stream='%s'>",
+		escape(domain), nsStreams)
+	if err != nil {
+		return nil, err
+	}
+	se, err := c.next()
+	if err != nil {
+		return nil, err
+	}
+	if se.Name.Space != nsStreams || se.Name.Local != "stream" {
+		return nil, fmt.Errorf("expected stream header, got <%s>", se.Name.Local)
+	}
+	se, err = c.next()
+	if err != nil {
+		return nil, err
+	}
+	if se.Name.Space == nsStreams && se.Name.Local == "error" {
+		return nil, c.streamError(&se)
+	}
+	if se.Name.Local != "features" {
+		return nil, fmt.Errorf("expected stream features, got <%s>", se.Name.Local)
+	}
+	f := &streamFeatures{}
+	if err := c.dec.DecodeElement(f, &se); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// streamError decodes a <stream:error/>
+func (c *xmppConn) streamError(se *xml.StartElement) error {
+	e := &streamError{}
+	if err := c.dec.DecodeElement(e, se); err != nil {
+		return err
+	}
+	return e
+}
+
+// startTLS upgrades the connection to TLS
+func (c *xmppConn) startTLS(cfg *tls.Config) error {
+	if err := c.write("<starttls xmlns='%s'/>", nsTLS); err != nil {
+		return err
+	}
+	se, err := c.next()
+	if err != nil {
+		return err
+	}
+	c.dec.Skip()
+	if se.Name.Local != "proceed" {
+		return errors.New("server refused STARTTLS")
+	}
+	tc := tls.Client(c.Conn, cfg)
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	c.SetDeadline(time.Time{})
+	c.Conn = tc
+	return nil
+}
+
+// authenticate logs in with SASL PLAIN
+func (c *xmppConn) authenticate(f *streamFeatures, user, password string) error {
+	plain := false
+	if f.Mechanisms != nil {
+		for _, m := range f.Mechanisms.Mechanism {
+			if m == "PLAIN" {
+				plain = true
+			}
+		}
+	}
+	if !plain {
+		return errors.New("server doesn't offer SASL PLAIN authentication")
+	}
+	creds := "\x00" + user + "\x00" + password
+	err := c.write("<auth xmlns='%s' mechanism='PLAIN'>%s</auth>",
+		nsSASL, base64.StdEncoding.EncodeToString([]byte(creds)))
+	if err != nil {
+		return err
+	}
+	se, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch se.Name.Local {
+	case "success":
+		return c.dec.Skip()
+	case "failure":
+		var failure struct {
+			Conditions []xml.Name `xml:",any"`
+		}
+		c.dec.DecodeElement(&failure, &se)
+		reason := "unknown"
+		if len(failure.Conditions) > 0 {
+			reason = failure.Conditions[0].Local
+		}
+		return fmt.Errorf("authentication failed: %s", reason)
+	default:
+		return fmt.Errorf("unexpected <%s> during authentication", se.Name.Local)
+	}
+}
+
+// bind binds a resource, returning the full JID assigned by the server
+func (c *xmppConn) bind(resource string) (string, error) {
+	err := c.write("<iq type='set' id='bind'><bind xmlns='%s'><resource>%s</resource></bind></iq>",
+		nsBind, escape(resource))
+	if err != nil {
+		return "", err
+	}
+	iq, err := c.readIQ()
+	if err != nil {
+		return "", err
+	}
+	if iq.Type != "result" || iq.Bind == nil {
+		return "", fmt.Errorf("resource binding failed: %s", iq.Error.condition())
+	}
+	return iq.Bind.JID, nil
+}
+
+// startSession starts a session, for older servers that require it
+func (c *xmppConn) startSession() error {
+	if err := c.write("<iq type='set' id='session'><session xmlns='%s'/></iq>", nsSession); err != nil {
+		return err
+	}
+	iq, err := c.readIQ()
+	if err != nil {
+		return err
+	}
+	if iq.Type != "result" {
+		return fmt.Errorf("session establishment failed: %s", iq.Error.condition())
+	}
+	return nil
+}
+
+// readIQ reads the reply to an iq sent during negotiation
+func (c *xmppConn) readIQ() (*stanzaIQ, error) {
+	se, err := c.next()
+	if err != nil {
+		return nil, err
+	}
+	if se.Name.Local != "iq" {
+		return nil, fmt.Errorf("expected <iq>, got <%s>", se.Name.Local)
+	}
+	iq := &stanzaIQ{}
+	if err := c.dec.DecodeElement(iq, &se); err != nil {
+		return nil, err
+	}
+	return iq, nil
+}
+
+// keepalive pings the server so a dead connection is noticed; the server
+// always answers, so the read loop times out if the connection is gone.
+func (xc *xmppConnector) keepalive(c *xmppConn) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(keepaliveDelay):
+			if err := c.write("<iq type='get' id='%s' to='%s'><ping xmlns='urn:xmpp:ping'/></iq>", c.nextID(), escape(xc.domain)); err != nil {
+				xc.Log(bot.Error, "Writing xmpp keepalive: %v", err)
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// manageConnection keeps the robot connected, reconnecting with exponential
+// backoff; connected is closed after the first successful login.
+func (xc *xmppConnector) manageConnection(connected chan<- struct{}) {
+	backoff := minBackoff
+	first := true
+	for {
+		wasConnected, err := xc.session(func() {
+			if first {
+				first = false
+				close(connected)
+			}
+		})
+		xc.Lock()
+		xc.conn = nil
+		xc.occupants = make(map[string]map[string]string)
+		stopping := xc.stopping
+		xc.Unlock()
+		if stopping {
+			return
+		}
+		if wasConnected {
+			backoff = minBackoff
+		}
+		xc.Log(bot.Error, "Disconnected from xmpp server %s: %v; reconnecting in %v", xc.cfg.Server, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session connects, logs in and handles stanzas until the connection fails,
+// returning whether the login succeeded
+func (xc *xmppConnector) session(onConnected func()) (connected bool, err error) {
+	c, err := xc.newConn()
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+	xc.Log(bot.Info, "Connected to xmpp server %s", xc.cfg.Server)
+
+	f, err := c.openStream(xc.domain)
+	if err != nil {
+		return false, err
+	}
+	if !xc.cfg.DirectTLS {
+		switch {
+		case f.StartTLS != nil:
+			if err := c.startTLS(xc.tlsConfig()); err != nil {
+				return false, err
+			}
+			if f, err = c.openStream(xc.domain); err != nil {
+				return false, err
+			}
+		case !xc.cfg.NoTLS:
+			return false, errors.New("server doesn't offer STARTTLS; set NoTLS to connect without TLS")
+		}
+	}
+	if err := c.authenticate(f, localpart(xc.jid), xc.cfg.Password); err != nil {
+		return false, err
+	}
+	if f, err = c.openStream(xc.domain); err != nil {
+		return false, err
+	}
+	if f.Bind == nil {
+		return false, errors.New("server doesn't offer resource binding")
+	}
+	full, err := c.bind(xc.cfg.Resource)
+	if err != nil {
+		return false, err
+	}
+	if f.Session != nil && f.Session.Optional == nil {
+		if err := c.startSession(); err != nil {
+			return false, err
+		}
+	}
+	xc.Log(bot.Info, "Logged in to xmpp server %s as '%s'", xc.cfg.Server, full)
+
+	c.write("<iq type='get' id='%s'><query xmlns='jabber:iq:roster'/></iq>", c.nextID())
+	c.write("<presence/>")
+	xc.Lock()
+	xc.conn = c
+	rooms := make([]string, 0, len(xc.rooms))
+	for room := range xc.rooms {
+		rooms = append(rooms, room)
+	}
+	xc.Unlock()
+	for _, room := range rooms {
+		xc.joinRoom(c, room)
+	}
+	go xc.keepalive(c)
+	onConnected()
+
+	for {
+		c.SetReadDeadline(time.Now().Add(2 * keepaliveDelay))
+		se, err := c.next()
+		if err != nil {
+			return true, err
+		}
+		switch se.Name.Local {
+		case "message":
+			m := &stanzaMessage{}
+			if err := c.dec.DecodeElement(m, &se); err != nil {
+				return true, err
+			}
+			xc.heard(m)
+		case "presence":
+			p := &stanzaPresence{}
+			if err := c.dec.DecodeElement(p, &se); err != nil {
+				return true, err
+			}
+			xc.presence(c, p)
+		case "iq":
+			iq := &stanzaIQ{}
+			if err := c.dec.DecodeElement(iq, &se); err != nil {
+				return true, err
+			}
+			xc.iq(c, iq)
+		case "error":
+			return true, c.streamError(&se)
+		default:
+			if err := c.dec.Skip(); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+// joinRoom sends presence to a room, asking for no history so old commands
+// aren't run again
+func (xc *xmppConnector) joinRoom(c *xmppConn, room string) error {
+	return c.write("<presence to='%s/%s'><x xmlns='http://jabber.org/protocol/muc'><history maxstanzas='0'/></x></presence>",
+		escape(room), escape(xc.cfg.Nick))
+}
+
+// escape escapes text for XML character data and attributes
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
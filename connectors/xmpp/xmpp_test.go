@@ -0,0 +1,365 @@
+package xmpp
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanghonggao007/gopherbot/bot"
+	"github.com/wanghonggao007/gopherbot/connectors/internal/testbot"
+)
+
+// fakeServer is a minimal XMPP server without TLS; it logs in the robot,
+// serves the roster, and records the stanzas the robot sends.
+type fakeServer struct {
+	net.Listener
+	sync.Mutex
+	conn     net.Conn
+	stanzas  chan string // messages and presence sent by the robot
+	sessions int
+}
+
+// rawStanza captures any stanza sent by the robot
+type rawStanza struct {
+	XMLName xml.Name
+	Type    string `xml:"type,attr"`
+	ID      string `xml:"id,attr"`
+	To      string `xml:"to,attr"`
+	Inner   string `xml:",innerxml"`
+}
+
+const rosterXML = `<query xmlns='jabber:iq:roster'>` +
+	`<item jid='alice@example.com' name='Alice User' subscription='both'><group>Admins</group><group>Ops</group></item>` +
+	`<item jid='bob@example.com' subscription='both'/>` +
+	`</query>`
+
+func newFakeServer() (*fakeServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	fs := &fakeServer{Listener: l, stanzas: make(chan string, 20)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fs.Lock()
+			fs.conn = c
+			fs.sessions++
+			fs.Unlock()
+			fs.serve(c)
+		}
+	}()
+	return fs, nil
+}
+
+func (fs *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	dec := xml.NewDecoder(c)
+	authed := false
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "stream":
+			fmt.Fprintf(c, "<?xml version='1.0'?><stream:stream from='example.com' id='s1' version='1.0' xmlns='jabber:client' xmlns:stream='%s'>", nsStreams)
+			if authed {
+				fmt.Fprintf(c, "<stream:features><bind xmlns='%s'/><session xmlns='%s'><optional/></session></stream:features>", nsBind, nsSession)
+			} else {
+				fmt.Fprintf(c, "<stream:features><mechanisms xmlns='%s'><mechanism>SCRAM-SHA-1</mechanism><mechanism>PLAIN</mechanism></mechanisms></stream:features>", nsSASL)
+			}
+		case "auth":
+			var auth struct {
+				Mechanism string `xml:"mechanism,attr"`
+				Creds     string `xml:",chardata"`
+			}
+			dec.DecodeElement(&auth, &se)
+			creds, _ := base64.StdEncoding.DecodeString(auth.Creds)
+			if auth.Mechanism != "PLAIN" || string(creds) != "\x00gopher\x00secret" {
+				fmt.Fprintf(c, "<failure xmlns='%s'><not-authorized/></failure>", nsSASL)
+				return
+			}
+			authed = true
+			fmt.Fprintf(c, "<success xmlns='%s'/>", nsSASL)
+			// the robot opens a new stream
+			dec = xml.NewDecoder(c)
+		default:
+			var s rawStanza
+			if err := dec.DecodeElement(&s, &se); err != nil {
+				return
+			}
+			fs.stanza(c, &s)
+		}
+	}
+}
+
+func (fs *fakeServer) stanza(c net.Conn, s *rawStanza) {
+	if s.XMLName.Local != "iq" {
+		fs.stanzas <- fmt.Sprintf("%s to=%s type=%s %s", s.XMLName.Local, s.To, s.Type, s.Inner)
+		return
+	}
+	switch {
+	case strings.Contains(s.Inner, nsBind):
+		if !strings.Contains(s.Inner, "<resource>gopherbot</resource>") {
+			fmt.Fprintf(c, "<iq type='error' id='%s'><error type='modify'><bad-request xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error></iq>", s.ID)
+			return
+		}
+		fmt.Fprintf(c, "<iq type='result' id='%s'><bind xmlns='%s'><jid>gopher@example.com/gopherbot</jid></bind></iq>", s.ID, nsBind)
+	case strings.Contains(s.Inner, "jabber:iq:roster"):
+		fmt.Fprintf(c, "<iq type='result' id='%s'>%s</iq>", s.ID, rosterXML)
+	case strings.Contains(s.Inner, "urn:xmpp:ping"):
+		fmt.Fprintf(c, "<iq type='result' id='%s' from='example.com'/>", s.ID)
+	default:
+		fs.stanzas <- "iq type=" + s.Type + " " + s.Inner
+	}
+}
+
+// send sends raw XML to the robot
+func (fs *fakeServer) send(x string) {
+	fs.Lock()
+	c := fs.conn
+	fs.Unlock()
+	c.Write([]byte(x))
+}
+
+// disconnect drops the robot's connection
+func (fs *fakeServer) disconnect() {
+	fs.Lock()
+	c := fs.conn
+	fs.Unlock()
+	c.Close()
+}
+
+func (fs *fakeServer) waitStanza(t *testing.T) string {
+	select {
+	case s := <-fs.stanzas:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for stanza")
+		return ""
+	}
+}
+
+// joinRoom joins a room, and has alice, an anonymous user and the robot
+// enter it; alice's greeting makes sure the connector has seen them
+func joinRoom(t *testing.T, fs *fakeServer, h *testbot.Handler, xc *xmppConnector, room string) {
+	assert.Equal(t, bot.Ok, xc.JoinChannel(room))
+	assert.Equal(t, "presence to="+room+"@conference.example.com/gopher type= <x xmlns='http://jabber.org/protocol/muc'><history maxstanzas='0'/></x>", fs.waitStanza(t))
+	from := room + "@conference.example.com/"
+	fs.send(`<presence from='` + from + `Ally'><x xmlns='http://jabber.org/protocol/muc#user'><item jid='alice@example.com/phone' role='participant'/></x></presence>`)
+	fs.send(`<presence from='` + from + `anon'><x xmlns='http://jabber.org/protocol/muc#user'><item role='participant'/></x></presence>`)
+	fs.send(`<presence from='` + from + `gopher'><x xmlns='http://jabber.org/protocol/muc#user'><item jid='gopher@example.com/gopherbot' role='participant'/><status code='110'/></x></presence>`)
+	fs.send(`<message from='` + from + `Ally' type='groupchat' id='hi'><body>hi</body></message>`)
+	if msg := h.WaitMessage(t); msg != nil {
+		assert.Equal(t, "alice@example.com", msg.UserID)
+	}
+}
+
+// newTestConnector returns a running connector logged in to a new fake
+// server, with the roster loaded; the connection and server are closed when
+// the test ends
+func newTestConnector(t *testing.T) (*fakeServer, *testbot.Handler, *xmppConnector) {
+	fs, err := newFakeServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testbot.NewHandler(config{
+		JID:      "gopher@example.com",
+		Password: "secret",
+		Server:   fs.Addr().String(),
+		NoTLS:    true,
+	})
+	xc := newConnector(h)
+	stop := make(chan struct{})
+	go xc.Run(stop)
+	t.Cleanup(func() {
+		close(stop)
+		fs.Close()
+	})
+	// initial presence
+	fs.waitStanza(t)
+	for i := 0; i < 50; i++ {
+		xc.RLock()
+		loaded := len(xc.roster) > 0
+		xc.RUnlock()
+		if loaded {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fs, h, xc
+}
+
+func TestInitialize(t *testing.T) {
+	_, h, _ := newTestConnector(t)
+	assert.Equal(t, "gopher@example.com", h.BotID())
+	assert.Equal(t, "gopher", h.BotMention())
+}
+
+func TestDirectMessage(t *testing.T) {
+	fs, h, _ := newTestConnector(t)
+	fs.send(`<message from='bob@example.com/laptop' type='chat' id='m1'><composing xmlns='http://jabber.org/protocol/chatstates'/></message>`)
+	fs.send(`<message from='bob@example.com/laptop' type='chat' id='m2'><body>offline</body><delay xmlns='urn:xmpp:delay' stamp='2020-01-01T00:00:00Z'/></message>`)
+	fs.send(`<message from='bob@example.com/laptop' type='chat' id='m3'><body>help &amp; more</body></message>`)
+	msg := h.WaitMessage(t)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, "m3", msg.MessageID)
+	assert.Equal(t, "bob@example.com", msg.UserID)
+	assert.Equal(t, "bob", msg.UserName)
+	assert.Equal(t, "help & more", msg.MessageText)
+	assert.True(t, msg.DirectMessage)
+	assert.Equal(t, "", msg.ChannelID)
+}
+
+func TestSendUserMessage(t *testing.T) {
+	fs, _, xc := newTestConnector(t)
+	assert.Equal(t, bot.Ok, xc.SendProtocolUserMessage("<bob@example.com>", "a < b", bot.Variable))
+	assert.Equal(t, "message to=bob@example.com type=chat <body>a &lt; b</body>", fs.waitStanza(t))
+	xc.SetUserMap(map[string]string{"carol": "carol@other.org"})
+	assert.Equal(t, bot.Ok, xc.SendProtocolUserMessage("carol", "hi", bot.Variable))
+	assert.Equal(t, "message to=carol@other.org type=chat <body>hi</body>", fs.waitStanza(t))
+}
+
+func TestMessageHeard(t *testing.T) {
+	fs, h, xc := newTestConnector(t)
+	xc.MessageHeard("<bob@example.com>", "<>")
+	assert.Equal(t, "message to=bob@example.com type=chat <composing xmlns='http://jabber.org/protocol/chatstates'/>", fs.waitStanza(t))
+	joinRoom(t, fs, h, xc, "heard")
+	xc.MessageHeard("<alice@example.com>", "<heard@conference.example.com>")
+	assert.Equal(t, "message to=heard@conference.example.com type=groupchat <composing xmlns='http://jabber.org/protocol/chatstates'/>", fs.waitStanza(t))
+}
+
+func TestRoomMessages(t *testing.T) {
+	fs, h, xc := newTestConnector(t)
+	assert.Equal(t, bot.ChannelNotFound, xc.SendProtocolChannelMessage("general", "hello", bot.Variable))
+	joinRoom(t, fs, h, xc, "general")
+	fs.send(`<message from='general@conference.example.com' type='groupchat' id='r0'><subject>Welcome</subject></message>`)
+	fs.send(`<message from='general@conference.example.com/gopher' type='groupchat' id='r1'><body>my own echo</body></message>`)
+	fs.send(`<message from='general@conference.example.com/Ally' type='groupchat' id='r2'><body>gopher: ping</body></message>`)
+	fs.send(`<message from='general@conference.example.com/anon' type='groupchat' id='r3'><body>who am i</body></message>`)
+	fs.send(`<message from='general@conference.example.com/anon' type='chat' id='r4'><body>psst</body></message>`)
+
+	msg := h.WaitMessage(t)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, "r2", msg.MessageID)
+	assert.Equal(t, "alice@example.com", msg.UserID)
+	assert.Equal(t, "alice", msg.UserName)
+	assert.Equal(t, "general@conference.example.com", msg.ChannelID)
+	assert.Equal(t, "general", msg.ChannelName)
+	assert.False(t, msg.DirectMessage)
+
+	msg = h.WaitMessage(t)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, "r3", msg.MessageID)
+	assert.Equal(t, "general@conference.example.com/anon", msg.UserID)
+	assert.Equal(t, "anon", msg.UserName)
+
+	msg = h.WaitMessage(t)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, "r4", msg.MessageID)
+	assert.True(t, msg.DirectMessage)
+	assert.Equal(t, "general@conference.example.com/anon", msg.UserID)
+}
+
+func TestSendRoomMessages(t *testing.T) {
+	fs, h, xc := newTestConnector(t)
+	joinRoom(t, fs, h, xc, "dev")
+	assert.Equal(t, bot.Ok, xc.SendProtocolChannelMessage("dev", "hello", bot.Variable))
+	assert.Equal(t, "message to=dev@conference.example.com type=groupchat <body>hello</body>", fs.waitStanza(t))
+	assert.Equal(t, bot.Ok, xc.SendProtocolUserChannelMessage("<alice@example.com>", "alice", "<dev@conference.example.com>", "pong", bot.Variable))
+	assert.Equal(t, "message to=dev@conference.example.com type=groupchat <body>Ally: pong</body>", fs.waitStanza(t))
+	assert.Equal(t, bot.Ok, xc.SendProtocolUserChannelMessage("<dev@conference.example.com/anon>", "anon", "dev", "ls", bot.Fixed))
+	assert.Equal(t, "message to=dev@conference.example.com type=groupchat <body>anon: &#xA;```&#xA;ls&#xA;```</body>", fs.waitStanza(t))
+	assert.Equal(t, bot.Ok, xc.SendProtocolUserMessage("<dev@conference.example.com/anon>", "private", bot.Variable))
+	assert.Equal(t, "message to=dev@conference.example.com/anon type=chat <body>private</body>", fs.waitStanza(t))
+}
+
+func TestOccupantLeaves(t *testing.T) {
+	fs, h, xc := newTestConnector(t)
+	joinRoom(t, fs, h, xc, "support")
+	fs.send(`<presence from='support@conference.example.com/Ally' type='unavailable'/>`)
+	fs.send(`<message from='support@conference.example.com/Ally' type='groupchat' id='r5'><body>bye</body></message>`)
+	msg := h.WaitMessage(t)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, "support@conference.example.com/Ally", msg.UserID)
+}
+
+func TestUserAttributes(t *testing.T) {
+	_, _, xc := newTestConnector(t)
+	name, ret := xc.GetProtocolUserAttribute("<alice@example.com>", "realname")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "Alice User", name)
+	groups, ret := xc.GetProtocolUserAttribute("alice", "groups")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "Admins,Ops", groups)
+	_, ret = xc.GetProtocolUserAttribute("bob", "realname")
+	assert.Equal(t, bot.AttributeNotFound, ret)
+	_, ret = xc.GetProtocolUserAttribute("erin", "realname")
+	assert.Equal(t, bot.UserNotFound, ret)
+	id, ret := xc.GetProtocolUserAttribute("bob", "internalid")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "bob@example.com", id)
+}
+
+func TestRosterPush(t *testing.T) {
+	fs, _, xc := newTestConnector(t)
+	fs.send(`<iq type='set' id='push1'><query xmlns='jabber:iq:roster'><item jid='dave@example.com' name='Dave' subscription='to'/></query></iq>`)
+	fs.send(`<iq type='get' id='v1' from='alice@example.com/phone'><query xmlns='jabber:iq:version'/></iq>`)
+	assert.Equal(t, "iq type=result ", fs.waitStanza(t))
+	assert.Contains(t, fs.waitStanza(t), "service-unavailable")
+	name, ret := xc.GetProtocolUserAttribute("dave", "realname")
+	assert.Equal(t, bot.Ok, ret)
+	assert.Equal(t, "Dave", name)
+}
+
+func TestReconnect(t *testing.T) {
+	fs, h, xc := newTestConnector(t)
+	joinRoom(t, fs, h, xc, "ops")
+	fs.Lock()
+	sessions := fs.sessions
+	fs.Unlock()
+	xc.RLock()
+	rooms := len(xc.rooms)
+	xc.RUnlock()
+	fs.disconnect()
+	assert.Equal(t, "presence to= type= ", fs.waitStanza(t))
+	// every room is joined again
+	joined := make(map[string]bool)
+	for i := 0; i < rooms; i++ {
+		joined[fs.waitStanza(t)] = true
+	}
+	assert.True(t, joined["presence to=ops@conference.example.com/gopher type= <x xmlns='http://jabber.org/protocol/muc'><history maxstanzas='0'/></x>"])
+	fs.Lock()
+	assert.Equal(t, sessions+1, fs.sessions)
+	fs.Unlock()
+	fs.send(`<message from='bob@example.com/laptop' type='chat' id='m4'><body>back</body></message>`)
+	msg := h.WaitMessage(t)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, "back", msg.MessageText)
+}
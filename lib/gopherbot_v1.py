@@ -1,3 +1,4 @@
+import base64
 import os
 import json
 import random
@@ -31,6 +32,7 @@ class Memory:
         self.lock_token = ret["LockToken"]
         self.exists = ret["Exists"]
         self.datum = ret["Datum"]
+        self.version = ret.get("Version", "")
         self.ret = ret["RetVal"]
 
 class Robot:
@@ -63,6 +65,11 @@ class Robot:
     TaskNotFound = 23
     MissingArguments = 24
     InvalidStage = 25
+    InvalidTaskType = 26
+    CommandNotMatched = 27
+    TaskDisabled = 28
+    DatumChanged = 29
+    Unsupported = 30
 
     # Plugin return values / exit codes
     Normal = 0
@@ -119,8 +126,8 @@ class Robot:
     def GetTaskConfig(self):
         return self.Call("GetTaskConfig", {})
 
-    def CheckoutDatum(self, key, rw):
-        ret = self.Call("CheckoutDatum", { "Key": key, "RW": rw })
+    def CheckoutDatum(self, key, rw, timeout=0):
+        ret = self.Call("CheckoutDatum", { "Key": key, "RW": rw, "Timeout": timeout })
         return Memory(key, ret)
 
     def SpawnJob(self, name, args):
@@ -168,8 +175,13 @@ class Robot:
     def CheckinDatum(self, m):
         self.Call("CheckinDatum", { "Key": m.key, "Token": m.lock_token })
 
-    def UpdateDatum(self, m):
+    def UpdateDatum(self, m, ttl=None):
         ret = self.Call("UpdateDatum", { "Key": m.key, "Token": m.lock_token,
+        "Datum": m.datum, "TTL": ttl })
+        return ret["RetVal"]
+
+    def UpdateDatumVersion(self, m):
+        ret = self.Call("UpdateDatumVersion", { "Key": m.key, "Version": m.version,
         "Datum": m.datum })
         return ret["RetVal"]
 
@@ -209,6 +221,22 @@ class Robot:
             rep["RetVal"] = self.Interrupted
         return Reply(rep)
 
+    def PromptForChoice(self, prompt, choices, format=""):
+        return self.PromptUserChannelForChoice(self.user, self.channel, prompt, choices, format)
+
+    def PromptUserForChoice(self, user, prompt, choices, format=""):
+        return self.PromptUserChannelForChoice(user, "", prompt, choices, format)
+
+    def PromptUserChannelForChoice(self, user, channel, prompt, choices, format=""):
+        for i in range(0, 3):
+            rep = self.Call("PromptUserChannelForChoice", { "User": user, "Channel": channel, "Prompt": prompt, "Choices": choices }, format)
+            if rep["RetVal"] == self.RetryPrompt:
+                continue
+            return Reply(rep)
+        if rep["RetVal"] == self.RetryPrompt:
+            rep["RetVal"] = self.Interrupted
+        return Reply(rep)
+
     def SendChannelMessage(self, channel, message, format=""):
         ret = self.Call("SendChannelMessage", { "Channel": channel,
         "Message": message }, format)
@@ -224,6 +252,24 @@ class Robot:
         "Channel": channel, "Message": message }, format)
         return ret["RetVal"]
 
+    def SendChannelFile(self, channel, filename, content, title=""):
+        ret = self.Call("SendChannelFile", { "Channel": channel,
+        "Filename": filename, "Title": title,
+        "Content": base64.b64encode(content) })
+        return ret["RetVal"]
+
+    def SendUserFile(self, user, filename, content, title=""):
+        ret = self.Call("SendUserFile", { "User": user,
+        "Filename": filename, "Title": title,
+        "Content": base64.b64encode(content) })
+        return ret["RetVal"]
+
+    def SendFile(self, filename, content, title=""):
+        if self.channel == '':
+            return self.SendUserFile(self.user, filename, content, title)
+        else:
+            return self.SendChannelFile(self.channel, filename, content, title)
+
     def Say(self, message, format=""):
         if self.channel == '':
             return self.SendUserMessage(self.user, message, format)
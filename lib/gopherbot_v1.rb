@@ -1,3 +1,4 @@
+require 'base64'
 require 'json'
 require 'net/http'
 require 'uri'
@@ -29,15 +30,16 @@ class Reply
 end
 
 class Memory
-	def initialize(key, lt, exists, datum, ret)
+	def initialize(key, lt, exists, datum, ret, version="")
 		@key = key
 		@lock_token = lt
+		@version = version
 		@exists = exists
 		@datum = datum
 		@ret = ret
 	end
 
-	attr_reader :key, :lock_token, :exists, :ret
+	attr_reader :key, :lock_token, :version, :exists, :ret
 	attr :datum, true
 end
 
@@ -69,6 +71,11 @@ class BaseBot
 	TaskNotFound = 23
 	MissingArguments = 24
 	InvalidStage = 25
+	InvalidTaskType = 26
+	CommandNotMatched = 27
+	TaskDisabled = 28
+	DatumChanged = 29
+	Unsupported = 30
 
 	# Plugin return values / exit codes
 	Normal = 0
@@ -150,10 +157,10 @@ class BaseBot
 		return callBotFunc("GetRepoData", {})
 	end
 
-	def CheckoutDatum(key, rw)
-		args = { "Key" => key, "RW" => rw }
+	def CheckoutDatum(key, rw, timeout=0)
+		args = { "Key" => key, "RW" => rw, "Timeout" => timeout }
 		ret = callBotFunc("CheckoutDatum", args)
-		return Memory.new(key, ret["LockToken"], ret["Exists"], ret["Datum"], ret["RetVal"])
+		return Memory.new(key, ret["LockToken"], ret["Exists"], ret["Datum"], ret["RetVal"], ret["Version"])
 	end
 
 	def CheckinDatum(m)
@@ -162,12 +169,18 @@ class BaseBot
 		return 0
 	end
 
-	def UpdateDatum(m)
-		args = { "Key" => m.key, "Token" => m.lock_token, "Datum" => m.datum }
+	def UpdateDatum(m, ttl=nil)
+		args = { "Key" => m.key, "Token" => m.lock_token, "Datum" => m.datum, "TTL" => ttl }
 		ret = callBotFunc("UpdateDatum", args)
 		return ret["RetVal"]
 	end
 
+	def UpdateDatumVersion(m)
+		args = { "Key" => m.key, "Version" => m.version, "Datum" => m.datum }
+		ret = callBotFunc("UpdateDatumVersion", args)
+		return ret["RetVal"]
+	end
+
 	def Remember(k, v)
 		args = { "Key" => k, "Value" => v }
 		ret = callBotFunc("Remember", args)
@@ -238,6 +251,26 @@ class BaseBot
 		return ret["RetVal"]
 	end
 
+	def SendChannelFile(channel, filename, content, title="")
+		args = { "Channel" => channel, "Filename" => filename, "Title" => title, "Content" => Base64.strict_encode64(content) }
+		ret = callBotFunc("SendChannelFile", args)
+		return ret["RetVal"]
+	end
+
+	def SendUserFile(user, filename, content, title="")
+		args = { "User" => user, "Filename" => filename, "Title" => title, "Content" => Base64.strict_encode64(content) }
+		ret = callBotFunc("SendUserFile", args)
+		return ret["RetVal"]
+	end
+
+	def SendFile(filename, content, title="")
+		if @channel.empty?
+			return SendUserFile(@user, filename, content, title)
+		else
+			return SendChannelFile(@channel, filename, content, title)
+		end
+	end
+
 	def Say(message, format="")
 		format = format.to_s if format.class == Symbol
 		if @channel.empty?
@@ -282,6 +315,28 @@ class BaseBot
 		end
 	end
 
+	def PromptForChoice(prompt, choices)
+		return PromptUserChannelForChoice(@user, @channel, prompt, choices)
+	end
+
+	def PromptUserForChoice(user, prompt, choices)
+		return PromptUserChannelForChoice(user, "", prompt, choices)
+	end
+
+	def PromptUserChannelForChoice(user, channel, prompt, choices)
+		args = { "User" => user, "Channel" => channel, "Prompt" => prompt, "Choices" => choices }
+		for i in 1..3
+			ret = callBotFunc("PromptUserChannelForChoice", args)
+			next if ret["RetVal"] == RetryPrompt
+			return Reply.new(ret["Reply"], ret["RetVal"])
+		end
+		if ret["RetVal"] == RetryPrompt
+			return Reply.new(ret["Reply"], Interrupted)
+		else
+			return Reply.new(ret["Reply"], ret["RetVal"])
+		end
+	end
+
 	def callBotFunc(funcname, args, format="")
 		if format.size == 0
 			format = @format
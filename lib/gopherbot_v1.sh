@@ -27,6 +27,11 @@ GBRET_MailError=22
 GBRET_TaskNotFound=23
 GBRET_MissingArguments=24
 GBRET_InvalidStage=25
+GBRET_InvalidTaskType=26
+GBRET_CommandNotMatched=27
+GBRET_TaskDisabled=28
+GBRET_DatumChanged=29
+GBRET_Unsupported=30
 
 # Plugin return values / exit codes
 PLUGRET_Normal=0
@@ -37,7 +42,7 @@ PLUGRET_Success=7
 
 base64_encode(){
 	local MESSAGE
-	MESSAGE=$(echo -n "$@" | base64)
+	MESSAGE=$(echo -n "$@" | base64 | tr -d '\n')
 	MESSAGE=$(echo -n "$MESSAGE")
 	echo -n "$MESSAGE"
 }
@@ -476,6 +481,63 @@ PromptUserForReply(){
 	PromptUserChannelForReply "$REGEX" "$PUSER" "" "$*"
 }
 
+# PromptUserChannelForChoice user channel prompt choice...
+PromptUserChannelForChoice(){
+	local FORMAT
+	if [[ $1 = -? ]]; then FORMAT=$(getFormat $1); shift; fi
+	local GB_FUNCARGS GB_RET
+	local GB_FUNCNAME="PromptUserChannelForChoice"
+	local PUSER="$1"
+	local PCHANNEL="$2"
+	local PROMPT=$(base64_encode "$3")
+	shift 3
+	local CHOICES CHOICE
+	for CHOICE in "$@"
+	do
+		CHOICES="${CHOICES:+$CHOICES, }\"$(base64_encode "$CHOICE")\""
+	done
+	GB_FUNCARGS=$(cat <<EOF
+{
+	"User": "$PUSER",
+	"Channel": "$PCHANNEL",
+	"Prompt": "$PROMPT",
+	"Choices": [ $CHOICES ],
+	"Base64" : true
+}
+EOF
+)
+	local RETVAL
+	for TRY in 0 1 2
+	do
+		GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
+		gbBotRet "$GB_RET"
+		RETVAL=$?
+		if [ $RETVAL -eq $GBRET_RetryPrompt ]
+		then
+			continue
+		fi
+		gbExtract "$GB_RET" Reply
+		return $RETVAL
+	done
+	return $GBRET_Interrupted
+}
+
+# PromptForChoice prompt choice...
+PromptForChoice(){
+	local FORMAT
+	if [[ $1 = -? ]]; then FORMAT=$1; shift; fi
+	PromptUserChannelForChoice $FORMAT "$GOPHER_USER" "$GOPHER_CHANNEL" "$@"
+}
+
+# PromptUserForChoice user prompt choice...
+PromptUserForChoice(){
+	local FORMAT
+	if [[ $1 = -? ]]; then FORMAT=$1; shift; fi
+	local PUSER=$1
+	shift
+	PromptUserChannelForChoice $FORMAT "$PUSER" "" "$@"
+}
+
 MessageFormat(){
 	if [ -n "$1" ]
 	then
@@ -543,6 +605,68 @@ EOF
 	gbBotRet "$GB_RET"
 }
 
+# SendChannelFile channel path [title] - upload a file to a channel
+SendChannelFile(){
+	local GB_FUNCARGS GB_RET
+	local GB_FUNCNAME="SendChannelFile"
+	local SCF_CHANNEL=$1
+	local SCF_PATH=$2
+	local SCF_TITLE=$3
+	local SCF_CONTENT SCF_FILENAME
+	if [ ! -f "$SCF_PATH" ] || [ ! -r "$SCF_PATH" ]
+	then
+		echo "$GB_FUNCNAME: unable to read '$SCF_PATH'" >&2
+		return $GBRET_FailedMessageSend
+	fi
+	SCF_CONTENT=$(base64 < "$SCF_PATH" | tr -d '\n')
+	SCF_FILENAME=$(base64_encode "$(basename "$SCF_PATH")")
+	SCF_TITLE=$(base64_encode "$SCF_TITLE")
+
+	GB_FUNCARGS=$(cat <<EOF
+{
+	"Channel": "$SCF_CHANNEL",
+	"Filename": "$SCF_FILENAME",
+	"Title": "$SCF_TITLE",
+	"Content": "$SCF_CONTENT",
+	"Base64": true
+}
+EOF
+)
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS")
+	gbBotRet "$GB_RET"
+}
+
+# SendUserFile user path [title] - upload a file to a user
+SendUserFile(){
+	local GB_FUNCARGS GB_RET
+	local GB_FUNCNAME="SendUserFile"
+	local SUF_USER=$1
+	local SUF_PATH=$2
+	local SUF_TITLE=$3
+	local SUF_CONTENT SUF_FILENAME
+	if [ ! -f "$SUF_PATH" ] || [ ! -r "$SUF_PATH" ]
+	then
+		echo "$GB_FUNCNAME: unable to read '$SUF_PATH'" >&2
+		return $GBRET_FailedMessageSend
+	fi
+	SUF_CONTENT=$(base64 < "$SUF_PATH" | tr -d '\n')
+	SUF_FILENAME=$(base64_encode "$(basename "$SUF_PATH")")
+	SUF_TITLE=$(base64_encode "$SUF_TITLE")
+
+	GB_FUNCARGS=$(cat <<EOF
+{
+	"User": "$SUF_USER",
+	"Filename": "$SUF_FILENAME",
+	"Title": "$SUF_TITLE",
+	"Content": "$SUF_CONTENT",
+	"Base64": true
+}
+EOF
+)
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS")
+	gbBotRet "$GB_RET"
+}
+
 SendChannelMessage(){
 	local FORMAT
 	if [[ $1 = -? ]]; then FORMAT=$(getFormat $1); shift; fi
@@ -577,6 +701,16 @@ Say(){
 	fi
 }
 
+# SendFile path [title] - upload a file to the current channel, or the user
+SendFile(){
+	if [ -n "$GOPHER_CHANNEL" ]
+	then
+		SendChannelFile "$GOPHER_CHANNEL" "$@"
+	else
+		SendUserFile "$GOPHER_USER" "$@"
+	fi
+}
+
 Reply(){
 	local FARG
 	[[ $1 == -? ]] && { FARG=$1; shift; }
@@ -10,8 +10,14 @@ import (
 
 	// *** Included connectors
 
+	_ "github.com/wanghonggao007/gopherbot/connectors/email"
+	_ "github.com/wanghonggao007/gopherbot/connectors/irc"
+	_ "github.com/wanghonggao007/gopherbot/connectors/matrix"
+	_ "github.com/wanghonggao007/gopherbot/connectors/mattermost"
 	_ "github.com/wanghonggao007/gopherbot/connectors/rocket"
 	_ "github.com/wanghonggao007/gopherbot/connectors/slack"
+	_ "github.com/wanghonggao007/gopherbot/connectors/web"
+	_ "github.com/wanghonggao007/gopherbot/connectors/xmpp"
 
 	// NOTE: if you build with '-tags test', the terminal connector will also
 	// show emitted events.